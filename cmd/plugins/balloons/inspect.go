@@ -0,0 +1,110 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	policy "github.com/containers/nri-plugins/cmd/plugins/balloons/policy"
+)
+
+const (
+	// defaultInspectEndpoint is the default instrumentation HTTP endpoint.
+	defaultInspectEndpoint = "http://localhost:8891"
+)
+
+// inspect fetches balloons from a running plugin and prints them.
+func inspect(args []string) error {
+	var (
+		flags    = flag.NewFlagSet("inspect", flag.ContinueOnError)
+		endpoint = flags.String("endpoint", defaultInspectEndpoint, "instrumentation HTTP endpoint of the plugin")
+		output   = flags.String("o", "table", "output format, table or json")
+	)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", *output)
+	}
+
+	state, err := fetchInspectState(*endpoint)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(state)
+	}
+	return printInspectState(os.Stdout, state)
+}
+
+// fetchInspectState fetches balloons from the instrumentation endpoint.
+func fetchInspectState(endpoint string) (*policy.InspectState, error) {
+	url := strings.TrimSuffix(endpoint, "/") + policy.InspectPath
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	rpl, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch balloons: %w", err)
+	}
+	defer rpl.Body.Close()
+
+	if rpl.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(rpl.Body)
+		return nil, fmt.Errorf("failed to fetch balloons from %s: %s: %s",
+			url, rpl.Status, strings.TrimSpace(string(msg)))
+	}
+
+	state := &policy.InspectState{}
+	if err := json.NewDecoder(rpl.Body).Decode(state); err != nil {
+		return nil, fmt.Errorf("failed to decode balloons from %s: %w", url, err)
+	}
+	return state, nil
+}
+
+// printInspectState prints balloons as a human-readable table.
+func printInspectState(w io.Writer, state *policy.InspectState) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "BALLOON\tTYPE\tCPUS\tSHARED-IDLE\tMEMS\tREQ-mCPU\tCONTAINERS")
+	for _, bln := range state.Balloons {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			bln.Name, bln.Type, orNone(bln.Cpus), orNone(bln.SharedIdleCpus),
+			orNone(bln.Mems), bln.RequestedMilliCpus, orNone(strings.Join(bln.Containers, ",")))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nfree CPUs: %s (%d)\n", orNone(state.FreeCpus), state.FreeCpuCount)
+	return err
+}
+
+// orNone returns s, or "-" if s is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
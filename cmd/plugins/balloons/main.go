@@ -16,6 +16,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	policy "github.com/containers/nri-plugins/cmd/plugins/balloons/policy"
 	agent "github.com/containers/nri-plugins/pkg/agent"
@@ -28,6 +30,14 @@ var log = logger.Default()
 func main() {
 	flag.Parse()
 
+	if args := flag.Args(); len(args) > 0 && args[0] == "inspect" {
+		if err := inspect(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	agt, err := agent.New(agent.BalloonsConfigInterface())
 	if err != nil {
 		log.Fatal("%v", err)
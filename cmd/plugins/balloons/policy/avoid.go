@@ -0,0 +1,73 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+// avoidedSocketCpus returns the CPUs of sockets which a balloon of the
+// given type with the given current CPUs should avoid, that is sockets
+// hosting balloons of the types listed in AvoidSameSocketAs. Balloons
+// sharing CPUs with current CPUs, like the balloon itself, are ignored.
+func (p *balloons) avoidedSocketCpus(blnDef *BalloonDef, current cpuset.CPUSet) cpuset.CPUSet {
+	avoid := cpuset.New()
+	if len(blnDef.AvoidSameSocketAs) == 0 {
+		return avoid
+	}
+
+	used := cpuset.New()
+	for _, bln := range p.balloons {
+		if !slices.Contains(blnDef.AvoidSameSocketAs, bln.Def.Name) {
+			continue
+		}
+		if !bln.Cpus.Intersection(current).IsEmpty() {
+			continue
+		}
+		used = used.Union(bln.Cpus)
+	}
+	if used.IsEmpty() {
+		return avoid
+	}
+
+	sys := p.options.System
+	for _, id := range sys.PackageIDs() {
+		if cpus := sys.Package(id).CPUSet(); !cpus.Intersection(used).IsEmpty() {
+			avoid = avoid.Union(cpus)
+		}
+	}
+	return avoid
+}
+
+// validateAvoidSameSocketAs checks that all balloon types referred to by
+// AvoidSameSocketAs exist.
+func validateAvoidSameSocketAs(userDefs, blnDefs []*BalloonDef) error {
+	names := map[string]struct{}{}
+	for _, blnDef := range blnDefs {
+		names[blnDef.Name] = struct{}{}
+	}
+	for _, blnDef := range blnDefs {
+		for i, name := range blnDef.AvoidSameSocketAs {
+			if _, ok := names[name]; !ok {
+				return configError(fmt.Sprintf("%s.avoidSameSocketAs[%d]", balloonTypePath(userDefs, blnDef), i),
+					"unknown balloon type %q in avoidSameSocketAs of balloon type %q", name, blnDef.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -15,10 +15,14 @@
 package balloons
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	cfgapi "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/resmgr/policy/balloons"
 	"github.com/containers/nri-plugins/pkg/cpuallocator"
@@ -29,6 +33,7 @@ import (
 	"github.com/containers/nri-plugins/pkg/resmgr/events"
 	libmem "github.com/containers/nri-plugins/pkg/resmgr/lib/memory"
 	policy "github.com/containers/nri-plugins/pkg/resmgr/policy"
+	system "github.com/containers/nri-plugins/pkg/sysfs"
 	"github.com/containers/nri-plugins/pkg/utils"
 	"github.com/containers/nri-plugins/pkg/utils/cpuset"
 	idset "github.com/intel/goresctrl/pkg/utils"
@@ -81,6 +86,40 @@ type balloons struct {
 
 	cpuAllocator cpuallocator.CPUAllocator // CPU allocator used by the policy
 	memAllocator *libmem.Allocator         // memory allocator used by the policy
+	allowedMems  libmem.NodeMask           // memory nodes we're allowed to use, 0 for any
+	reservedMems libmem.NodeMask           // memory nodes used only by the reserved balloon
+
+	memAllocFailures map[string]error // container ID -> failed memory allocation to fail
+
+	rebalanceStop chan struct{} // stops the periodic rebalancing timer
+	reconcileStop chan struct{} // stops the periodic reconciliation timer
+	prewarmStop   chan struct{} // stops the timer deflating idle pre-inflated balloons
+	inflateStop   chan struct{} // stops the timer for inflation steps
+	verifyStop    chan struct{} // stops the timer for verifying pinning
+
+	stickyCpus map[string]string // container ID -> CPUs of its balloon
+	stickyHint cpuset.CPUSet     // CPUs to prefer for the container being allocated
+	adoptHint  cpuset.CPUSet     // kubelet-pinned CPUs of the container being synchronized
+
+	cpuPriorityHint *cpuallocator.CPUPriority // CPU priority for the container being allocated, if annotated
+
+	pinnedCpus map[string]cpuset.CPUSet // container ID -> CPUs forced by annotation
+	verifyCpus map[string]cpuset.CPUSet // container ID -> CPUs to verify once pinning is applied
+
+	irqs *irqAffinity // IRQ affinity manager, if IRQs are moved away from balloons
+
+	exclusive   *exclusiveCpus // kernel-enforced exclusive CPUs of containers
+	noExclusive bool           // exclusive CPUs not supported by the kernel
+
+	memBandwidth   *memBandwidth // memory bandwidth reservations of balloons
+	noMemBandwidth bool          // memory bandwidth allocation not supported
+
+	unsharedIdle cpuset.CPUSet // idle CPUs released by balloons that do not lend their CPUs
+
+	sharedPool cpuset.CPUSet // CPUs shared pool only balloons were last pinned to
+	overlay    cpuset.CPUSet // CPUs overlay balloons were last pinned to
+
+	opStats *balloonOpStats // counts and latencies of balloon operations
 }
 
 // Balloon contains attributes of a balloon instance
@@ -106,9 +145,13 @@ type Balloon struct {
 	PodIDs map[string][]string
 	// Groups is a multiset (group-by-value -> appearance-count)
 	// of evaluated GroupBy expressions on containers in the balloon.
-	Groups       map[string]int
-	cpuTreeAlloc *cpuTreeAllocator
-	memTypeMask  libmem.TypeMask
+	Groups           map[string]int
+	cpuTreeAlloc     *cpuTreeAllocator
+	memTypeMask      libmem.TypeMask
+	prewarmCpus      int                      // CPUs kept in a pre-inflated balloon, 0 if none
+	prewarmedAt      time.Time                // time of the latest pre-inflation
+	inflateMilliCpus int                      // mCPUs the balloon is being inflated to in steps, 0 if none
+	qosCpus          map[string]cpuset.CPUSet // cores of Guaranteed containers with QoS-aware pinning
 }
 
 var log logger.Logger = logger.NewLogger("policy")
@@ -147,6 +190,14 @@ func (bln Balloon) AvailMilliCpus() int {
 }
 
 func (bln Balloon) MaxAvailMilliCpus(freeCpus cpuset.CPUSet) int {
+	if bln.Def.SharedPoolOnly || bln.Def.Overlay {
+		// Shared pool and overlay balloons never run out of capacity.
+		return math.MaxInt32
+	}
+	if bln.Def.WholeNumaNodes > 0 {
+		// Whole NUMA node balloons are never resized.
+		return bln.Cpus.Size() * 1000
+	}
 	if bln.Def.MaxCpus == NoLimit {
 		return (bln.Cpus.Size() + freeCpus.Size()) * 1000
 	}
@@ -155,7 +206,9 @@ func (bln Balloon) MaxAvailMilliCpus(freeCpus cpuset.CPUSet) int {
 
 // New creates a new uninitialized balloons policy instance.
 func New() policy.Backend {
-	return &balloons{}
+	return &balloons{
+		opStats: newBalloonOpStats(),
+	}
 }
 
 // Setup initializes the balloons policy instance.
@@ -171,13 +224,22 @@ func (p *balloons) Setup(policyOptions *policy.BackendOptions) error {
 
 	p.options = policyOptions
 	p.cch = policyOptions.Cache
+	p.restoreStickyCpus()
 	p.cpuAllocator = cpuallocator.NewCPUAllocator(policyOptions.System)
 
-	malloc, err := libmem.NewAllocator(libmem.WithSystemNodes(policyOptions.System))
+	reservedMems, err := reservedMemNodes(bpoptions)
+	if err != nil {
+		return balloonsError("invalid configuration: %w", err)
+	}
+	malloc, err := libmem.NewAllocator(
+		libmem.WithSystemNodes(policyOptions.System),
+		libmem.WithReservedNodes(reservedMems),
+	)
 	if err != nil {
 		return balloonsError("failed to create memory allocator: %w", err)
 	}
 	p.memAllocator = malloc
+	p.allowedMems = allowedMemNodes(policyOptions.System, malloc.Masks().AvailableNodes())
 
 	log.Info("setting up %s policy...", PolicyName)
 	if p.cpuTree, err = NewCpuTreeFromSystem(); err != nil {
@@ -185,12 +247,15 @@ func (p *balloons) Setup(policyOptions *policy.BackendOptions) error {
 	}
 	log.Debug("CPU topology: %s", p.cpuTree)
 
+	// Remove resctrl groups left behind by earlier instances.
+	p.removeMemBandwidth()
+
 	// Handle policy-specific options
 	log.Debug("creating %s configuration", PolicyName)
 	if err := p.setConfig(bpoptions); err != nil {
 		return balloonsError("failed to create %s policy: %v", PolicyName, err)
 	}
-	log.Debug("first effective configuration:\n%s\n", utils.DumpJSON(p.bpoptions))
+	log.Debug("first effective configuration:\n%s\n", p.dumpEffectiveConfig())
 
 	return nil
 }
@@ -207,15 +272,32 @@ func (p *balloons) Description() string {
 
 // Start prepares this policy for accepting allocation/release requests.
 func (p *balloons) Start() error {
+	p.startRebalancer()
+	p.startReconciler()
+	p.registerInspectHandler()
 	log.Info("%s policy started", PolicyName)
 	return nil
 }
 
+// Stop cleans up changes made by this policy when shutting down.
+func (p *balloons) Stop() {
+	p.stopRebalancer()
+	p.stopReconciler()
+	p.stopPrewarmTimer()
+	p.stopInflationTimer()
+	p.stopVerifier()
+	p.removeMemBandwidth()
+	log.Info("%s policy stopped", PolicyName)
+}
+
 // Sync synchronizes the active policy state.
 func (p *balloons) Sync(add []cache.Container, del []cache.Container) error {
 	log.Debug("synchronizing state...")
 	for _, c := range del {
-		if err := p.ReleaseResources(c); err != nil {
+		// Containers are released and allocated again on restart and
+		// reconfiguration, keep their previously used CPUs. Entries of
+		// containers which are gone are dropped on the next save.
+		if err := p.releaseResources(c); err != nil {
 			log.Warnf("releasing resources for Sync produced an error: %v", err)
 		}
 	}
@@ -223,20 +305,30 @@ func (p *balloons) Sync(add []cache.Container, del []cache.Container) error {
 	cache.SortContainers(add, cache.ComparePodCtime, cache.CompareContainerCtime)
 
 	for _, c := range add {
+		resetHint := p.setAdoptHint(c)
 		if err := p.AllocateResources(c); err != nil {
 			log.Warnf("allocating resources for Sync produced an error: %v", err)
 		}
+		resetHint()
 	}
 	return nil
 }
 
 // AllocateResources is a resource allocation request for this policy.
 func (p *balloons) AllocateResources(c cache.Container) error {
+	p.flushExclusive()
+
 	if c.PreserveCpuResources() {
 		log.Infof("not handling resources of container %s, preserving CPUs %q and memory %q", c.PrettyName(), c.GetCpusetCpus(), c.GetCpusetMems())
 		return nil
 	}
 
+	if p.isBypassed(c) {
+		log.Infof("bypassing container %s, pod matches bypassSelector, keeping CPUs %q and memory %q",
+			c.PrettyName(), c.GetCpusetCpus(), c.GetCpusetMems())
+		return nil
+	}
+
 	if p.bpoptions.Preserve != nil {
 		rule, err := p.bpoptions.Preserve.MatchContainer(c)
 		if err != nil {
@@ -247,6 +339,24 @@ func (p *balloons) AllocateResources(c cache.Container) error {
 		}
 	}
 
+	if cpus, ok, err := pinnedCpusOf(c); ok {
+		if err != nil {
+			return err
+		}
+		return p.allocatePinnedCpus(c, cpus)
+	}
+
+	if _, err := containerMemTypes(c); err != nil {
+		return err
+	}
+	if _, _, err := p.containerSpreadNodes(c); err != nil {
+		return err
+	}
+
+	defer p.setStickyHint(c)()
+	defer p.setCpuPriorityHint(c)()
+
+	start := time.Now()
 	log.Debug("allocating resources for container %s (request %d mCPU, limit %d mCPU)...",
 		c.PrettyName(),
 		p.containerRequestedMilliCpus(c.GetID()),
@@ -261,28 +371,88 @@ func (p *balloons) AllocateResources(c cache.Container) error {
 	// Resize selected balloon to fit the new container, unless it
 	// uses the ReservedResources CPUs, which is a fixed set.
 	reqMilliCpus := p.containerRequestedMilliCpus(c.GetID()) + p.requestedMilliCpus(bln)
+	if bln.Def.AggregatePodCpus {
+		reqMilliCpus = p.aggregatePodMilliCpus(bln, c)
+	}
+	// Pre-inflate the balloon up front for pods expecting bursts.
+	prewarmCpus := p.prewarmCpuCount(c, bln)
 	// Even if all containers in a balloon request is 0 mCPU in
 	// total (all are BestEffort, for example), force the size of
 	// the balloon to be enough for at least 1 mCPU
 	// request. Otherwise balloon's cpuset becomes empty, which in
 	// would mean no CPU pinning and balloon's containers would
 	// run on any CPUs.
-	if bln.AvailMilliCpus() < max(1, reqMilliCpus) {
-		if err := p.resizeBalloon(bln, max(1, reqMilliCpus)); err != nil {
+	resize := bln.AvailMilliCpus() < max(1, reqMilliCpus) || bln.Cpus.Size() < prewarmCpus
+	newMilliCpus := max(max(1, reqMilliCpus), 1000*prewarmCpus)
+	newCpuCount := bln.Cpus.Size()
+	if resize {
+		newCpuCount = p.resizedCpuCount(bln, newMilliCpus)
+	}
+	if err := p.checkNamespaceCpuQuota(c, bln, newCpuCount); err != nil {
+		if bln.ContainerCount() == 0 {
+			p.freeBalloon(bln)
+		}
+		return balloonsError("balloon allocation for container %s failed: %w", c.PrettyName(), err)
+	}
+	if resize {
+		if err := p.resizeBalloon(bln, newMilliCpus); err != nil {
 			return balloonsError("resizing balloon %s failed: %w", bln.PrettyName(), err)
 		}
 	}
+	if prewarmCpus > 0 {
+		p.prewarmBalloon(bln, prewarmCpus)
+	}
 	p.assignContainer(c, bln)
+	if err := p.memAllocFailed(c); err != nil {
+		if relErr := p.ReleaseResources(c); relErr != nil {
+			log.Warnf("failed to release resources of container %s: %v", c.PrettyName(), relErr)
+		}
+		return balloonsError("memory allocation for container %s failed: %w", c.PrettyName(), err)
+	}
+	p.opStats.observe(bln.Def.Name, opAllocate, start)
 	if log.DebugEnabled() {
 		log.Debug(p.dumpBalloon(bln))
 	}
 	return nil
 }
 
+// isBypassed returns true if the pod of a container matches BypassSelector.
+func (p *balloons) isBypassed(c cache.Container) bool {
+	if p.bpoptions.BypassSelector == nil {
+		return false
+	}
+	pod, ok := c.GetPod()
+	if !ok {
+		return false
+	}
+	bypass, err := p.bpoptions.BypassSelector.MatchPod(pod)
+	if err != nil {
+		log.Errorf("error in matching pod of container %s to bypassSelector: %v", c.PrettyName(), err)
+		return false
+	}
+	return bypass
+}
+
 // ReleaseResources is a resource release request for this policy.
 func (p *balloons) ReleaseResources(c cache.Container) error {
+	if p.forgetCpus(c) {
+		defer p.saveStickyCpus()
+	}
+	return p.releaseResources(c)
+}
+
+// releaseResources releases the resources of a container without
+// forgetting the CPUs it used.
+func (p *balloons) releaseResources(c cache.Container) error {
 	log.Debug("releasing container %s...", c.PrettyName())
+	p.flushExclusive()
+	delete(p.memAllocFailures, c.GetID())
+	p.releaseExclusive(c)
+	if p.releasePinnedCpus(c) {
+		return nil
+	}
 	if bln := p.balloonByContainer(c); bln != nil {
+		defer p.opStats.observe(bln.Def.Name, opRelease, time.Now())
 		p.dismissContainer(c, bln)
 		if log.DebugEnabled() {
 			log.Debug(p.dumpBalloon(bln))
@@ -315,8 +485,25 @@ func (p *balloons) UpdateResources(c cache.Container) error {
 }
 
 // HandleEvent handles policy-specific events.
-func (p *balloons) HandleEvent(*events.Policy) (bool, error) {
-	log.Debug("(not) handling event...")
+func (p *balloons) HandleEvent(e *events.Policy) (bool, error) {
+	p.flushExclusive()
+	switch e.Type {
+	case rebalanceEvent:
+		return p.rebalance(), nil
+	case reconcileEvent:
+		return p.reconcile(), nil
+	case prewarmEvent:
+		return p.deflateIdleBalloons(), nil
+	case inflateEvent:
+		return p.inflateBalloons(), nil
+	case verifyEvent:
+		p.verifyPinnings()
+		return false, nil
+	case inspectEvent:
+		p.handleInspectEvent(e)
+		return false, nil
+	}
+	log.Debug("(not) handling event %s...", e.Type)
 	return false, nil
 }
 
@@ -327,7 +514,7 @@ func (p *balloons) ExportResourceData(c cache.Container) map[string]string {
 
 // GetTopologyZones returns the policy/pool data for 'topology zone' CRDs.
 func (b *balloons) GetTopologyZones() []*policy.TopologyZone {
-	return nil
+	return b.exclusiveLLCZones()
 }
 
 // balloonByContainer returns a balloon that contains a container.
@@ -411,8 +598,24 @@ func (p *balloons) chooseBalloonDef(c cache.Container) (*BalloonDef, error) {
 		return blnDef, nil
 	}
 
+	reqMilliCpus := p.containerRequestedMilliCpus(c.GetID())
 	for _, blnDef := range p.bpoptions.BalloonDefs {
-		// Case 2: BalloonDef is defined by a match expression.
+		if blnDef.HasCpuRequestRange() {
+			if !blnDef.MatchCpuRequest(reqMilliCpus) {
+				log.Debugf("- CPU request %d mCPU out of range of balloon type %q",
+					reqMilliCpus, blnDef.Name)
+				continue
+			}
+			// Case 2: BalloonDef is defined by the CPU request range only.
+			if len(blnDef.MatchExpressions) == 0 && len(blnDef.Namespaces) == 0 &&
+				len(blnDef.ContainerNamePatterns) == 0 {
+				log.Debugf("- CPU request %d mCPU matches balloon type %q",
+					reqMilliCpus, blnDef.Name)
+				return blnDef, nil
+			}
+		}
+
+		// Case 3: BalloonDef is defined by a match expression.
 		for _, expr := range blnDef.MatchExpressions {
 			log.Debugf("- checking expression %s of balloon type %q against container %s...",
 				expr.String(), blnDef.Name, c.PrettyName())
@@ -422,7 +625,14 @@ func (p *balloons) chooseBalloonDef(c cache.Container) (*BalloonDef, error) {
 			}
 		}
 
-		// Case 3: BalloonDef is defined by the namespace.
+		// Case 4: BalloonDef is defined by the container name.
+		if containerNameMatches(c.GetName(), blnDef.ContainerNamePatterns) {
+			log.Debugf("- container name %q matches container name patterns of balloon type %q",
+				c.GetName(), blnDef.Name)
+			return blnDef, nil
+		}
+
+		// Case 5: BalloonDef is defined by the namespace.
 		if namespaceMatches(c.GetNamespace(), blnDef.Namespaces) {
 			log.Debugf("- namespace %q matches namespaces of balloon type %q", c.GetNamespace(), blnDef.Name)
 			return blnDef, nil
@@ -430,7 +640,7 @@ func (p *balloons) chooseBalloonDef(c cache.Container) (*BalloonDef, error) {
 	}
 
 	log.Debugf("- no match found, using default balloon type %q", defaultBalloonDefName)
-	// Case 4: Fallback to the default balloon.
+	// Case 6: Fallback to the default balloon.
 	return p.defaultBalloonDef, nil
 }
 
@@ -471,6 +681,9 @@ func (p *balloons) requestedMilliCpus(bln *Balloon) int {
 // freeMilliCpus returns free CPU resources in a balloon without
 // inflating the balloon.
 func (p *balloons) freeMilliCpus(bln *Balloon) int {
+	if bln.Def.SharedPoolOnly || bln.Def.Overlay {
+		return bln.MaxAvailMilliCpus(p.freeCpus) - p.requestedMilliCpus(bln)
+	}
 	return bln.AvailMilliCpus() - p.requestedMilliCpus(bln)
 }
 
@@ -480,6 +693,45 @@ func (p *balloons) maxFreeMilliCpus(bln *Balloon) int {
 	return bln.MaxAvailMilliCpus(p.freeCpus) - p.requestedMilliCpus(bln)
 }
 
+// maxMemory returns the maximum total memory limit of containers in a
+// balloon, or -1 if the amount of memory in the balloon is not limited.
+func (p *balloons) maxMemory(bln *Balloon) int64 {
+	if bln.Def.MaxMemory == nil {
+		return -1
+	}
+	limit := bln.Def.MaxMemory.Value()
+	if bln.Mems.Size() > 0 {
+		zone := libmem.NodeMaskFromIDSet(bln.Mems)
+		limit = min(limit, p.memAllocator.ZoneCapacity(zone))
+	}
+	return limit
+}
+
+// usedMemory returns the sum of memory limits of containers in a
+// balloon, excluding the given container.
+func (p *balloons) usedMemory(bln *Balloon, exclude cache.Container) int64 {
+	used := int64(0)
+	for _, ctrID := range bln.ContainerIDs() {
+		if ctrID == exclude.GetID() {
+			continue
+		}
+		if c, ok := p.cch.LookupContainer(ctrID); ok {
+			used += getMemoryLimit(c)
+		}
+	}
+	return used
+}
+
+// memoryFits returns true if the memory limit of a container fits
+// into the memory left in a balloon.
+func (p *balloons) memoryFits(bln *Balloon, c cache.Container) bool {
+	limit := p.maxMemory(bln)
+	if limit < 0 {
+		return true
+	}
+	return p.usedMemory(bln, c)+getMemoryLimit(c) <= limit
+}
+
 // largest helps finding largest elements and the largest value in a
 // slice. Input the length of a slice and a function that returns the
 // magnitude of given element in the slice as int.
@@ -548,11 +800,17 @@ func (p *balloons) useCpuClass(bln *Balloon) error {
 	// - User-defined CPU AllocatorPriority: bln.Def.AllocatorPriority.
 	// - All existing balloon instances: p.balloons.
 	// - CPU configurations by user: bln.Def.CpuClass (for bln in p.balloons)
-	if err := cpucontrol.Assign(p.cch, bln.Def.CpuClass, bln.Cpus.UnsortedList()...); err != nil {
-		log.Warnf("failed to apply class %q on CPUs %q: %v", bln.Def.CpuClass, bln.Cpus, err)
+	// - CPU profile of the balloon: bln.Def.CpuProfile, whose CPU
+	//   class overrides the CPU class of the balloon.
+	// - Memory bandwidth reserved for the balloon:
+	//   bln.Def.MinMemBandwidthPct, applied after the CPU class.
+	class := p.cpuClassOf(bln.Def)
+	if err := cpucontrol.Assign(p.cch, class, bln.Cpus.UnsortedList()...); err != nil {
+		log.Warnf("failed to apply class %q on CPUs %q: %v", class, bln.Cpus, err)
 	} else {
-		log.Debugf("apply class %q on CPUs %q", bln.Def.CpuClass, bln.Cpus)
+		log.Debugf("apply class %q on CPUs %q", class, bln.Cpus)
 	}
+	p.reserveMemBandwidth(bln)
 	return nil
 }
 
@@ -560,10 +818,12 @@ func (p *balloons) useCpuClass(bln *Balloon) error {
 func (p *balloons) forgetCpuClass(bln *Balloon) {
 	// Use p.IdleCpuClass for bln.Cpus.
 	// Usual inputs: see useCpuClass
+	p.releaseMemBandwidth(bln)
+	class := p.cpuClassOf(bln.Def)
 	if err := cpucontrol.Assign(p.cch, p.bpoptions.IdleCpuClass, bln.Cpus.UnsortedList()...); err != nil {
-		log.Warnf("failed to forget class %q of cpus %q: %v", bln.Def.CpuClass, bln.Cpus, err)
+		log.Warnf("failed to forget class %q of cpus %q: %v", class, bln.Cpus, err)
 	} else {
-		log.Debugf("forget class %q of cpus %q", bln.Def.CpuClass, bln.Cpus)
+		log.Debugf("forget class %q of cpus %q", class, bln.Cpus)
 	}
 }
 
@@ -613,14 +873,22 @@ func (p *balloons) newBalloon(blnDef *BalloonDef, confCpus bool) (*Balloon, erro
 	}
 	cpuTreeAlloc := p.cpuTree.NewAllocator(allocatorOptions)
 
-	// Allocate CPUs
-	addFromCpus, _, err := cpuTreeAlloc.ResizeCpus(cpuset.New(), p.freeCpus, blnDef.MinCpus)
-	if err != nil {
-		return nil, balloonsError("failed to choose a cpuset for allocating MinCpus: %d from free cpus %q", blnDef.MinCpus, p.freeCpus)
+	// Allocate CPUs, preferring CPUs previously used by the
+	// container being allocated, if any.
+	if blnDef.WholeNumaNodes > 0 {
+		if cpus, err = p.allocateNumaNodes(blnDef.WholeNumaNodes); err != nil {
+			return nil, balloonsError("could not allocate wholeNumaNodes (%d) for balloon %s[%d]: %w", blnDef.WholeNumaNodes, blnDef.Name, freeInstance, err)
+		}
+	} else {
+		cpus = p.takeStickyCpus(blnDef, blnDef.MinCpus)
 	}
-	cpus, err = p.cpuAllocator.AllocateCpus(&addFromCpus, blnDef.MinCpus, blnDef.AllocatorPriority.Value().Option())
-	if err != nil {
-		return nil, balloonsError("could not allocate minCpus (%d) for balloon %s[%d]: %w", blnDef.MinCpus, blnDef.Name, freeInstance, err)
+	if more := blnDef.MinCpus - cpus.Size(); more > 0 {
+		freeCpus := p.freeCpus.Difference(cpus)
+		moreCpus, err := p.allocateCpus(blnDef, cpuTreeAlloc, cpus, freeCpus, more)
+		if err != nil {
+			return nil, balloonsError("could not allocate minCpus (%d) for balloon %s[%d]: %w", blnDef.MinCpus, blnDef.Name, freeInstance, err)
+		}
+		cpus = cpus.Union(moreCpus)
 	}
 	p.freeCpus = p.freeCpus.Difference(cpus)
 	memTypeMask, _ := memTypeMaskFromStringList(blnDef.MemoryTypes)
@@ -631,7 +899,7 @@ func (p *balloons) newBalloon(blnDef *BalloonDef, confCpus bool) (*Balloon, erro
 		PodIDs:         make(map[string][]string),
 		Cpus:           cpus,
 		SharedIdleCpus: cpuset.New(),
-		Mems:           p.closestMems(cpus),
+		Mems:           p.closestMemsOfTypes(cpus, memTypeMask),
 		cpuTreeAlloc:   cpuTreeAlloc,
 		memTypeMask:    memTypeMask,
 	}
@@ -655,10 +923,14 @@ func (p *balloons) deleteBalloon(bln *Balloon) {
 	}
 	p.balloons = remainingBalloons
 	p.forgetCpuClass(bln)
+	p.keepUnshared(bln, bln.Cpus)
 	p.freeCpus = p.freeCpus.Union(bln.Cpus)
 	if _, err := p.cpuAllocator.ReleaseCpus(&bln.Cpus, bln.Cpus.Size(), bln.Def.AllocatorPriority.Value().Option()); err != nil {
 		log.Warnf("failed to release CPUs %q of balloon %s[%d]: %v", bln.Cpus, bln.Def.Name, bln.Instance, err)
 	}
+	p.updateIrqAffinity()
+	p.updateSharedPool()
+	p.updateOverlays()
 }
 
 // freeBalloon clears a balloon and deletes it if allowed.
@@ -684,7 +956,7 @@ func (p *balloons) fillableBalloonInstances(blnDef *BalloonDef, fm FillMethod, c
 		// Creating a new balloon and placing a container
 		// (even a best effort one) to it always requires at
 		// least one CPU. Make sure this is doable.
-		if p.freeCpus.Size() == 0 || p.freeCpus.Size() < blnDef.MinCpus {
+		if !blnDef.SharedPoolOnly && !blnDef.Overlay && (p.freeCpus.Size() == 0 || p.freeCpus.Size() < blnDef.MinCpus) {
 			if fm == FillNewBalloonMust {
 				return nil, balloonsError("not enough CPUs to create new balloon for container %s requesting %s mCPU. free CPUs: %s",
 					c.PrettyName(), reqMilliCpus, p.freeCpus.Size())
@@ -724,6 +996,14 @@ func (p *balloons) fillableBalloonInstances(blnDef *BalloonDef, fm FillMethod, c
 				return nil, nil
 			}
 		}
+		if !p.memoryFits(newBln, c) {
+			undo()
+			if fm == FillNewBalloonMust {
+				return nil, balloonsError("not enough memory to run container %s with memory limit %d in a new %s balloon (max %d)",
+					c.PrettyName(), getMemoryLimit(c), blnDef.Name, p.maxMemory(newBln))
+			}
+			return nil, nil
+		}
 		// Make the existence of the new balloon official by
 		// adding it to the balloons slice.
 		p.balloons = append(p.balloons, newBln)
@@ -757,12 +1037,13 @@ func (p *balloons) fillableBalloonInstances(blnDef *BalloonDef, fm FillMethod, c
 			func(bln *Balloon) bool {
 				return bln.Groups[group] > 0 &&
 					bln.Def == blnDef &&
+					hasRoomForPod(bln, c) &&
 					p.maxFreeMilliCpus(bln) >= reqMilliCpus
 			}), nil
 	case FillSameNamespace:
 		return balloonsByFunc(p.balloonsByNamespace(c.GetNamespace()),
 			func(bln *Balloon) bool {
-				return bln.Def == blnDef && p.maxFreeMilliCpus(bln) >= reqMilliCpus
+				return bln.Def == blnDef && hasRoomForPod(bln, c) && p.maxFreeMilliCpus(bln) >= reqMilliCpus
 			}), nil
 	case FillSamePod:
 		if pod, ok := c.GetPod(); ok {
@@ -785,13 +1066,13 @@ func (p *balloons) fillableBalloonInstances(blnDef *BalloonDef, fm FillMethod, c
 		// Are there balloons where the container would fit
 		// without inflating the balloon?
 		return balloonsByFunc(balloons, func(bln *Balloon) bool {
-			return p.freeMilliCpus(bln) >= reqMilliCpus
+			return hasRoomForPod(bln, c) && p.freeMilliCpus(bln) >= reqMilliCpus
 		}), nil
 	case FillBalancedInflate:
 		// Are there balloons where the container would fit
 		// after inflating the balloon?
 		return balloonsByFunc(balloons, func(bln *Balloon) bool {
-			return p.maxFreeMilliCpus(bln) >= reqMilliCpus
+			return hasRoomForPod(bln, c) && p.maxFreeMilliCpus(bln) >= reqMilliCpus
 		}), nil
 	default:
 		break
@@ -799,6 +1080,19 @@ func (p *balloons) fillableBalloonInstances(blnDef *BalloonDef, fm FillMethod, c
 	return nil, balloonsError("balloon type fill method not implemented: %s", fm)
 }
 
+// hasRoomForPod returns true if a container can be assigned to a balloon
+// without exceeding MaxPods of the balloon. Containers of pods already in
+// the balloon always fit.
+func hasRoomForPod(bln *Balloon, c cache.Container) bool {
+	if bln.Def.MaxPods <= 0 {
+		return true
+	}
+	if _, ok := bln.PodIDs[c.GetPodID()]; ok {
+		return true
+	}
+	return len(bln.PodIDs) < bln.Def.MaxPods
+}
+
 func namespaceMatches(namespace string, patterns []string) bool {
 	for _, pattern := range patterns {
 		ret, err := filepath.Match(pattern, namespace)
@@ -809,7 +1103,20 @@ func namespaceMatches(namespace string, patterns []string) bool {
 	return false
 }
 
-// allocateBalloon returns a balloon allocated for a container.
+// containerNameMatches returns true if a container name matches any
+// of the patterns.
+func containerNameMatches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, err := filepath.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allocateBalloon returns a balloon allocated for a container. If the
+// container does not fit in a balloon of its type, fallbacks of the
+// type are tried in order.
 func (p *balloons) allocateBalloon(c cache.Container) (*Balloon, error) {
 	blnDef, err := p.chooseBalloonDef(c)
 	if err != nil {
@@ -819,24 +1126,38 @@ func (p *balloons) allocateBalloon(c cache.Container) (*Balloon, error) {
 		return nil, balloonsError("no applicable balloon type found")
 	}
 
-	bln, err := p.allocateBalloonOfDef(blnDef, c)
-	if err != nil {
-		return nil, err
-	}
-	if bln == nil {
-		return nil, balloonsError("no suitable balloon instance available")
+	var lastErr error
+	for _, def := range p.balloonDefChain(blnDef) {
+		bln, err := p.allocateBalloonOfDef(def, c)
+		if err == nil && bln != nil {
+			if def != blnDef {
+				log.Infof("container %s does not fit in balloon type %q, assigned to fallback balloon type %q",
+					c.PrettyName(), blnDef.Name, def.Name)
+			}
+			return bln, nil
+		}
+		if err == nil {
+			err = balloonsError("no suitable balloon instance available")
+		}
+		if len(blnDef.Fallbacks) > 0 {
+			log.Debugf("container %s does not fit in balloon type %q: %v", c.PrettyName(), def.Name, err)
+		}
+		lastErr = err
 	}
-	return bln, nil
+	return nil, lastErr
 }
 
-// allocateBalloonOfDef returns a balloon instantiated from a
-// definition for a container.
-func (p *balloons) allocateBalloonOfDef(blnDef *BalloonDef, c cache.Container) (*Balloon, error) {
+// fillChain returns the fill methods tried in order when allocating a
+// balloon of a definition for a container.
+func fillChain(blnDef *BalloonDef) []FillMethod {
 	fillChain := []FillMethod{}
+	if blnDef.AggregatePodCpus {
+		fillChain = append(fillChain, FillSamePod)
+	}
 	if blnDef.GroupBy != "" {
 		fillChain = append(fillChain, FillSameGroup)
 	}
-	if !blnDef.PreferSpreadingPods {
+	if !blnDef.PreferSpreadingPods && !blnDef.AggregatePodCpus {
 		fillChain = append(fillChain, FillSamePod)
 	}
 	if blnDef.PreferPerNamespaceBalloon {
@@ -847,16 +1168,44 @@ func (p *balloons) allocateBalloonOfDef(blnDef *BalloonDef, c cache.Container) (
 	} else {
 		fillChain = append(fillChain, FillBalanced, FillBalancedInflate, FillNewBalloon)
 	}
-	for _, fillMethod := range fillChain {
+	return fillChain
+}
+
+// allocateBalloonOfDef returns a balloon instantiated from a
+// definition for a container.
+func (p *balloons) allocateBalloonOfDef(blnDef *BalloonDef, c cache.Container) (*Balloon, error) {
+	if bln := p.zeroRequestBalloon(blnDef, c); bln != nil {
+		log.Debugf("container %s without CPU request joins balloon %s of its pod", c.PrettyName(), bln.PrettyName())
+		return bln, nil
+	}
+	if bln := p.adoptedBalloon(blnDef, c); bln != nil {
+		return bln, nil
+	}
+	memoryFull := false
+	for _, fillMethod := range fillChain(blnDef) {
 		blns, err := p.fillableBalloonInstances(blnDef, fillMethod, c)
 		if err != nil {
 			log.Debugf("fill method %q prevents allocation: %w", fillMethod, err)
 			return nil, err
 		}
+		if blnDef.MaxMemory != nil {
+			fits := balloonsByFunc(blns, func(bln *Balloon) bool {
+				return p.memoryFits(bln, c)
+			})
+			if len(fits) < len(blns) {
+				log.Debugf("fill method %q: not enough memory in balloons %v", fillMethod, blns)
+				memoryFull = true
+			}
+			blns = fits
+		}
+		blns = balloonsByFunc(blns, func(bln *Balloon) bool {
+			return p.allowsNamespaceMix(bln, c)
+		})
 		if len(blns) == 0 {
 			log.Debugf("fill method %q not applicable", fillMethod)
 			continue
 		}
+		blns = p.preferSameNuma(blns, c)
 		log.Debugf("fill method %q suggests any of balloon instances %v", fillMethod, blns)
 
 		// TODO: Consider: in case of a best effort container,
@@ -881,6 +1230,10 @@ func (p *balloons) allocateBalloonOfDef(blnDef *BalloonDef, c cache.Container) (
 		bestBln := blns[mostRoom[leastContainers[0]]]
 		return bestBln, nil
 	}
+	if memoryFull {
+		return nil, balloonsError("memory limit %d of container %s would exceed the memory of all suitable %s balloons",
+			getMemoryLimit(c), c.PrettyName(), blnDef.Name)
+	}
 	return nil, nil
 }
 
@@ -928,13 +1281,33 @@ func changesBalloons(opts0, opts1 *BalloonsOptions) bool {
 	}
 	o0 := opts0.DeepCopy()
 	o1 := opts1.DeepCopy()
-	// Ignore differences in CPU class names. Every other change
-	// potentially changes balloons or workloads.
+	// Ignore differences in CPU class names, profiles and memory
+	// bandwidth reservations. Every other change potentially changes
+	// balloons or workloads.
 	o0.IdleCpuClass = ""
 	o1.IdleCpuClass = ""
+	o0.CpuProfiles, o1.CpuProfiles = nil, nil
+	// Rebalancing, reconciliation and sticky CPU parameters do not
+	// change balloons either.
+	o0.RebalanceInterval, o0.RebalanceThreshold, o0.RebalanceMaxCpus = nil, 0, 0
+	o1.RebalanceInterval, o1.RebalanceThreshold, o1.RebalanceMaxCpus = nil, 0, 0
+	o0.ReconcileInterval, o1.ReconcileInterval = nil, nil
+	o0.PrewarmIdlePeriod, o1.PrewarmIdlePeriod = nil, nil
+	o0.MaxInflationStep, o1.MaxInflationStep = 0, 0
+	o0.StickyCpus, o1.StickyCpus = false, false
+	o0.OnMemoryAllocFailure, o1.OnMemoryAllocFailure = "", ""
+	// Namespace CPU quotas only affect placing new containers.
+	o0.NamespaceCpuQuotas, o1.NamespaceCpuQuotas = nil, nil
+	o0.MixedNamespaceQuota, o1.MixedNamespaceQuota = "", ""
 	for i := range o0.BalloonDefs {
 		o0.BalloonDefs[i].CpuClass = ""
 		o1.BalloonDefs[i].CpuClass = ""
+		o0.BalloonDefs[i].CpuProfile = ""
+		o1.BalloonDefs[i].CpuProfile = ""
+		o0.BalloonDefs[i].MinMemBandwidthPct = 0
+		o1.BalloonDefs[i].MinMemBandwidthPct = 0
+		o0.BalloonDefs[i].LogLevel = ""
+		o1.BalloonDefs[i].LogLevel = ""
 	}
 	return utils.DumpJSON(o0) != utils.DumpJSON(o1)
 }
@@ -960,8 +1333,30 @@ func changesCpuClasses(opts0, opts1 *BalloonsOptions) bool {
 		if opts0.BalloonDefs[i].CpuClass != opts1.BalloonDefs[i].CpuClass {
 			return true
 		}
+		if opts0.BalloonDefs[i].CpuProfile != opts1.BalloonDefs[i].CpuProfile {
+			return true
+		}
+		if opts0.BalloonDefs[i].MinMemBandwidthPct != opts1.BalloonDefs[i].MinMemBandwidthPct {
+			return true
+		}
 	}
-	return false
+	return utils.DumpJSON(opts0.CpuProfiles) != utils.DumpJSON(opts1.CpuProfiles)
+}
+
+// dumpEffectiveConfig dumps the effective configuration together with
+// the fill chain resolved for each balloon type.
+func (p *balloons) dumpEffectiveConfig() string {
+	fillChains := map[string][]FillMethod{}
+	for _, blnDef := range p.bpoptions.BalloonDefs {
+		fillChains[blnDef.Name] = fillChain(blnDef)
+	}
+	return utils.DumpJSON(struct {
+		*BalloonsOptions
+		FillChains map[string][]FillMethod `json:"fillChains,omitempty"`
+	}{
+		BalloonsOptions: p.bpoptions,
+		FillChains:      fillChains,
+	})
 }
 
 func (p *balloons) Reconfigure(newCfg interface{}) error {
@@ -972,10 +1367,35 @@ func (p *balloons) Reconfigure(newCfg interface{}) error {
 
 	log.Info("configuration update")
 	defer func() {
-		log.Debug("effective configuration:\n%s\n", utils.DumpJSON(p.bpoptions))
+		log.Debug("effective configuration:\n%s\n", p.dumpEffectiveConfig())
 	}()
 	newBalloonsOptions := balloonsOptions.DeepCopy()
+	if err := resolveBalloonDefs(newBalloonsOptions.BalloonDefs); err != nil {
+		return balloonsError("invalid configuration: %w", err)
+	}
+	// Reject invalid configuration before applying any of it, also
+	// when only settings not affecting balloons are changed.
+	if err := p.checkConfig(newBalloonsOptions); err != nil {
+		log.Error("config update rejected: %v", err)
+		return err
+	}
 	if !changesBalloons(p.bpoptions, newBalloonsOptions) {
+		p.bpoptions.RebalanceInterval = newBalloonsOptions.RebalanceInterval
+		p.bpoptions.RebalanceThreshold = newBalloonsOptions.RebalanceThreshold
+		p.bpoptions.RebalanceMaxCpus = newBalloonsOptions.RebalanceMaxCpus
+		p.bpoptions.ReconcileInterval = newBalloonsOptions.ReconcileInterval
+		p.bpoptions.PrewarmIdlePeriod = newBalloonsOptions.PrewarmIdlePeriod
+		p.bpoptions.MaxInflationStep = newBalloonsOptions.MaxInflationStep
+		p.bpoptions.StickyCpus = newBalloonsOptions.StickyCpus
+		p.bpoptions.OnMemoryAllocFailure = newBalloonsOptions.OnMemoryAllocFailure
+		p.bpoptions.NamespaceCpuQuotas = newBalloonsOptions.NamespaceCpuQuotas
+		p.bpoptions.MixedNamespaceQuota = newBalloonsOptions.MixedNamespaceQuota
+		for i := range p.bpoptions.BalloonDefs {
+			p.bpoptions.BalloonDefs[i].LogLevel = newBalloonsOptions.BalloonDefs[i].LogLevel
+		}
+		p.startRebalancer()
+		p.startReconciler()
+		p.updatePrewarmTimer()
 		if !changesCpuClasses(p.bpoptions, newBalloonsOptions) {
 			log.Info("no configuration changes")
 		} else {
@@ -984,11 +1404,19 @@ func (p *balloons) Reconfigure(newCfg interface{}) error {
 			// definitions. The same BalloonDef instances
 			// must be kept in use, because each Balloon
 			// instance holds a direct reference to its
-			// BalloonDef.
+			// BalloonDef. Settings of the old definitions are
+			// released first.
+			for _, bln := range p.balloons {
+				p.forgetCpuClass(bln)
+			}
 			for i := range p.bpoptions.BalloonDefs {
 				p.bpoptions.BalloonDefs[i].CpuClass = newBalloonsOptions.BalloonDefs[i].CpuClass
+				p.bpoptions.BalloonDefs[i].CpuProfile = newBalloonsOptions.BalloonDefs[i].CpuProfile
+				p.bpoptions.BalloonDefs[i].MinMemBandwidthPct = newBalloonsOptions.BalloonDefs[i].MinMemBandwidthPct
 			}
+			p.bpoptions.CpuProfiles = newBalloonsOptions.CpuProfiles
 			// (Re)configures all CPUs in balloons.
+			p.setCpuProfiles()
 			if err := p.resetCpuClass(); err != nil {
 				log.Warnf("failed to reset CPU class: %v", err)
 			}
@@ -1000,14 +1428,26 @@ func (p *balloons) Reconfigure(newCfg interface{}) error {
 		}
 		return nil
 	}
+	// Balloons are recreated, do not let timers act on them meanwhile.
+	p.stopRebalancer()
+	p.stopReconciler()
+	p.stopPrewarmTimer()
+	p.stopInflationTimer()
 	if err := p.setConfig(newBalloonsOptions); err != nil {
 		log.Error("config update failed: %v", err)
+		p.startRebalancer()
+		p.startReconciler()
+		p.updatePrewarmTimer()
+		p.updateInflationTimer()
 		return err
 	}
 	log.Info("config updated successfully")
+	p.startRebalancer()
+	p.startReconciler()
 	if err := p.Sync(p.cch.GetContainers(), p.cch.GetContainers()); err != nil {
 		log.Warnf("failed to sync containers: %v", err)
 	}
+	p.updatePrewarmTimer()
 	return nil
 }
 
@@ -1028,46 +1468,263 @@ func (p *balloons) applyBalloonDef(balloons *[]*Balloon, blnDef *BalloonDef, fre
 	return nil
 }
 
-func (p *balloons) validateConfig(bpoptions *BalloonsOptions) error {
+func (p *balloons) validateConfig(bpoptions *BalloonsOptions, userDefs []*BalloonDef) error {
+	if bpoptions.RebalanceThreshold < 0 {
+		return configError("rebalanceThreshold", "negative RebalanceThreshold (%d)", bpoptions.RebalanceThreshold)
+	}
+	if bpoptions.RebalanceMaxCpus < 0 {
+		return configError("rebalanceMaxCPUs", "negative RebalanceMaxCpus (%d)", bpoptions.RebalanceMaxCpus)
+	}
+	if bpoptions.MaxInflationStep < 0 {
+		return configError("maxInflationStep", "negative MaxInflationStep (%d)", bpoptions.MaxInflationStep)
+	}
+	switch bpoptions.OnMemoryAllocFailure {
+	case "", MemoryAllocFailureFallback, MemoryAllocFailureWiden, MemoryAllocFailureFail:
+	default:
+		return configError("onMemoryAllocFailure", "invalid OnMemoryAllocFailure %q", bpoptions.OnMemoryAllocFailure)
+	}
+	if err := validateCpuProfiles(bpoptions, userDefs); err != nil {
+		return err
+	}
+	if err := validateMemBandwidth(bpoptions, userDefs); err != nil {
+		return err
+	}
+	if err := validateNamespaceCpuQuotas(bpoptions); err != nil {
+		return err
+	}
+	if bpoptions.BypassSelector != nil {
+		if err := bpoptions.BypassSelector.Validate(); err != nil {
+			return configError("bypassSelector", "%v", err)
+		}
+	}
 	seenNames := map[string]struct{}{}
 	for _, blnDef := range bpoptions.BalloonDefs {
+		path := balloonTypePath(userDefs, blnDef)
 		if blnDef.Name == "" {
-			return balloonsError("missing or empty name in a balloon type")
+			return configError(path+".name", "missing or empty name in a balloon type")
 		}
 		if _, ok := seenNames[blnDef.Name]; ok {
-			return balloonsError("two balloon types with the same name: %q", blnDef.Name)
+			return configError(path+".name", "two balloon types with the same name: %q", blnDef.Name)
 		}
 		seenNames[blnDef.Name] = struct{}{}
 		if blnDef.MaxCpus != NoLimit && blnDef.MinCpus > blnDef.MaxCpus {
-			return balloonsError("MinCpus (%d) > MaxCpus (%d) in balloon type %q",
+			return configError(path+".minCPUs", "MinCpus (%d) > MaxCpus (%d) in balloon type %q",
 				blnDef.MinCpus, blnDef.MaxCpus, blnDef.Name)
 		}
 		if blnDef.MaxBalloons != NoLimit && blnDef.MinBalloons > blnDef.MaxBalloons {
-			return balloonsError("MinBalloons (%d) > MaxBalloons (%d) in balloon type %q",
-				blnDef.MinCpus, blnDef.MaxCpus, blnDef.Name)
+			return configError(path+".minBalloons", "MinBalloons (%d) > MaxBalloons (%d) in balloon type %q",
+				blnDef.MinBalloons, blnDef.MaxBalloons, blnDef.Name)
+		}
+		if blnDef.MaxPods < 0 {
+			return configError(path+".maxPods", "negative MaxPods (%d) in balloon type %q",
+				blnDef.MaxPods, blnDef.Name)
+		}
+		if blnDef.MinCpuRequest != nil && blnDef.MinCpuRequest.Sign() < 0 {
+			return configError(path+".minCPURequest", "negative MinCpuRequest (%s) in balloon type %q",
+				blnDef.MinCpuRequest, blnDef.Name)
+		}
+		if blnDef.MaxCpuRequest != nil && blnDef.MaxCpuRequest.Sign() < 0 {
+			return configError(path+".maxCPURequest", "negative MaxCpuRequest (%s) in balloon type %q",
+				blnDef.MaxCpuRequest, blnDef.Name)
+		}
+		if blnDef.MaxMemory != nil && blnDef.MaxMemory.Sign() <= 0 {
+			return configError(path+".maxMemory", "non-positive MaxMemory (%s) in balloon type %q",
+				blnDef.MaxMemory, blnDef.Name)
+		}
+		if blnDef.MinCpuRequest != nil && blnDef.MaxCpuRequest != nil &&
+			blnDef.MinCpuRequest.Cmp(*blnDef.MaxCpuRequest) > 0 {
+			return configError(path+".minCPURequest", "MinCpuRequest (%s) > MaxCpuRequest (%s) in balloon type %q",
+				blnDef.MinCpuRequest, blnDef.MaxCpuRequest, blnDef.Name)
 		}
 		if _, err := memTypeMaskFromStringList(blnDef.MemoryTypes); err != nil {
-			return balloonsError("invalid memoryTypes: %w", err)
+			return configError(path+".memoryTypes", "invalid memoryTypes: %w", err)
+		}
+		switch blnDef.LogLevel {
+		case "", BalloonLogLevelDebug, BalloonLogLevelInfo:
+		default:
+			return configError(path+".logLevel", "invalid LogLevel %q in balloon type %q",
+				blnDef.LogLevel, blnDef.Name)
 		}
 		if blnDef.Name == reservedBalloonDefName {
 			if blnDef.MinBalloons < 0 || blnDef.MinBalloons > 1 {
-				return balloonsError("invalid configuration: exactly one %q balloon expected but MinBalloons=%d",
+				return configError(path+".minBalloons", "invalid configuration: exactly one %q balloon expected but MinBalloons=%d",
 					blnDef.Name, blnDef.MinBalloons)
 			}
 			if blnDef.MaxBalloons < 0 || blnDef.MaxBalloons > 1 {
-				return balloonsError("invalid configuration: exactly one %q balloon expected but MaxBalloons=%d",
+				return configError(path+".maxBalloons", "invalid configuration: exactly one %q balloon expected but MaxBalloons=%d",
 					blnDef.Name, blnDef.MaxBalloons)
 			}
 		}
+		if blnDef.SharedPoolOnly && (blnDef.MinCpus > 0 || blnDef.MaxCpus > 0) {
+			return configError(path+".sharedPoolOnly", "sharedPoolOnly balloon type %q cannot have MinCpus or MaxCpus",
+				blnDef.Name)
+		}
+		if blnDef.WholeNumaNodes < 0 {
+			return configError(path+".wholeNumaNodes", "negative WholeNumaNodes (%d) in balloon type %q",
+				blnDef.WholeNumaNodes, blnDef.Name)
+		}
+		if blnDef.WholeNumaNodes > 0 && (blnDef.MinCpus > 0 || blnDef.MaxCpus > 0 || blnDef.SharedPoolOnly) {
+			return configError(path+".wholeNumaNodes", "wholeNumaNodes balloon type %q cannot have MinCpus, MaxCpus or SharedPoolOnly",
+				blnDef.Name)
+		}
+		if blnDef.WholeNumaNodes > 0 && blnDef.Name == reservedBalloonDefName {
+			return configError(path+".wholeNumaNodes", "%q balloon type cannot have WholeNumaNodes", blnDef.Name)
+		}
+		if blnDef.SharedPoolOnly && blnDef.Name == reservedBalloonDefName {
+			return configError(path+".sharedPoolOnly", "%q balloon type cannot be sharedPoolOnly", blnDef.Name)
+		}
+		if blnDef.Overlay && (blnDef.MinCpus > 0 || blnDef.MaxCpus > 0 || blnDef.WholeNumaNodes > 0 || blnDef.SharedPoolOnly) {
+			return configError(path+".overlay", "overlay balloon type %q cannot have MinCpus, MaxCpus, WholeNumaNodes or SharedPoolOnly",
+				blnDef.Name)
+		}
+		if blnDef.Overlay && (blnDef.Name == reservedBalloonDefName || blnDef.Name == defaultBalloonDefName) {
+			return configError(path+".overlay", "%q balloon type cannot be overlay", blnDef.Name)
+		}
+		if blnDef.ExclusiveLLC && (blnDef.WholeNumaNodes > 0 || blnDef.SharedPoolOnly || blnDef.Overlay) {
+			return configError(path+".exclusiveLLC", "exclusiveLLC balloon type %q cannot have WholeNumaNodes, SharedPoolOnly or Overlay",
+				blnDef.Name)
+		}
+		if blnDef.AggregatePodCpus && blnDef.PreferSpreadingPods {
+			return configError(path+".aggregatePodCPUs", "aggregatePodCPUs balloon type %q cannot have PreferSpreadingPods",
+				blnDef.Name)
+		}
 		if blnDef.PreferIsolCpus && blnDef.ShareIdleCpusInSame != "" {
 			log.Warn("WARNING: using PreferIsolCpus with ShareIdleCpusInSame is highly discouraged")
 		}
+		for i, pattern := range blnDef.ContainerNamePatterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return configError(fmt.Sprintf("%s.containerNamePatterns[%d]", path, i),
+					"invalid container name pattern %q in balloon type %q: %w",
+					pattern, blnDef.Name, err)
+			}
+		}
+		for i, constraint := range blnDef.RelaxOnFailure {
+			switch constraint {
+			case relaxCoreType, relaxSpreadOnPhysicalCores, relaxIsolCpus, relaxExclusiveLLC:
+			default:
+				return configError(fmt.Sprintf("%s.relaxOnFailure[%d]", path, i),
+					"invalid relaxOnFailure in balloon type %q: unknown preference %q",
+					blnDef.Name, constraint)
+			}
+		}
+	}
+	if err := validateFallbacks(userDefs, bpoptions.BalloonDefs); err != nil {
+		return err
+	}
+	return validateAvoidSameSocketAs(userDefs, bpoptions.BalloonDefs)
+}
+
+// balloonTypePath returns the path of a balloon type in the configuration.
+// Implicitly added built-in balloon types are referred to by name.
+func balloonTypePath(userDefs []*BalloonDef, blnDef *BalloonDef) string {
+	if i := slices.Index(userDefs, blnDef); i >= 0 {
+		return fmt.Sprintf("balloonTypes[%d]", i)
+	}
+	return fmt.Sprintf("balloonTypes[implicit %q]", blnDef.Name)
+}
+
+// configError returns a configuration error for the given path.
+func configError(path, format string, args ...interface{}) error {
+	return balloonsError(format+" (at %s)", append(args, path)...)
+}
+
+// resolveBalloonDefs replaces balloon definitions which are based on
+// other definitions with fully resolved ones. Resolving is idempotent.
+func resolveBalloonDefs(blnDefs []*BalloonDef) error {
+	byName := map[string]*BalloonDef{}
+	for _, blnDef := range blnDefs {
+		if _, ok := byName[blnDef.Name]; !ok {
+			byName[blnDef.Name] = blnDef
+		}
+	}
+
+	resolved := map[*BalloonDef]*BalloonDef{}
+	var resolve func(*BalloonDef, []string) (*BalloonDef, error)
+	resolve = func(blnDef *BalloonDef, chain []string) (*BalloonDef, error) {
+		if r, ok := resolved[blnDef]; ok {
+			return r, nil
+		}
+		if blnDef.BasedOn == "" {
+			resolved[blnDef] = blnDef
+			return blnDef, nil
+		}
+		chain = append(chain, blnDef.Name)
+		path := fmt.Sprintf("balloonTypes[%d].basedOn", slices.Index(blnDefs, blnDef))
+		if slices.Contains(chain, blnDef.BasedOn) {
+			return nil, configError(path, "balloon type inheritance cycle: %s -> %s",
+				strings.Join(chain, " -> "), blnDef.BasedOn)
+		}
+		base, ok := byName[blnDef.BasedOn]
+		if !ok {
+			return nil, configError(path, "balloon type %q is based on undefined balloon type %q",
+				blnDef.Name, blnDef.BasedOn)
+		}
+		base, err := resolve(base, chain)
+		if err != nil {
+			return nil, err
+		}
+		r, err := mergeBalloonDefs(base, blnDef)
+		if err != nil {
+			return nil, configError(path, "failed to inherit balloon type %q from %q: %w",
+				blnDef.Name, blnDef.BasedOn, err)
+		}
+		resolved[blnDef] = r
+		return r, nil
+	}
+
+	for i, blnDef := range blnDefs {
+		r, err := resolve(blnDef, nil)
+		if err != nil {
+			return err
+		}
+		blnDefs[i] = r
 	}
+
 	return nil
 }
 
-// setConfig takes new balloon configuration into use.
-func (p *balloons) setConfig(bpoptions *BalloonsOptions) error {
+// mergeBalloonDefs returns a new balloon definition with all settings
+// of base overridden by the ones present in blnDef. Settings present in
+// the JSON encoding of blnDef are decoded on top of a copy of base.
+// Settings hidden from JSON are overridden explicitly.
+func mergeBalloonDefs(base, blnDef *BalloonDef) (*BalloonDef, error) {
+	merged := base.DeepCopy()
+
+	data, err := json.Marshal(blnDef)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, merged); err != nil {
+		return nil, err
+	}
+
+	if blnDef.PreferFarFromDevices != nil {
+		merged.PreferFarFromDevices = slices.Clone(blnDef.PreferFarFromDevices)
+	}
+
+	return merged, nil
+}
+
+// preparedConfig is a validated configuration completed with defaults
+// and built-in balloon types.
+type preparedConfig struct {
+	bpoptions          *BalloonsOptions
+	reservedBalloonDef *BalloonDef
+	defaultBalloonDef  *BalloonDef
+	reservedMems       libmem.NodeMask
+}
+
+// checkConfig validates a configuration without taking it into use.
+func (p *balloons) checkConfig(bpoptions *BalloonsOptions) error {
+	// Preparing sets allowed and reserved CPUs, use a copy of the policy.
+	scratch := *p
+	_, err := scratch.prepareConfig(bpoptions)
+	return err
+}
+
+// prepareConfig completes and validates a configuration. Only allowed
+// and reserved CPUs of the policy are set.
+func (p *balloons) prepareConfig(bpoptions *BalloonsOptions) (*preparedConfig, error) {
 	bpoptions = bpoptions.DeepCopy()
 
 	// Handle AvailableResources.cpus, if defined.
@@ -1078,37 +1735,101 @@ func (p *balloons) setConfig(bpoptions *BalloonsOptions) error {
 	case cfgapi.AmountCPUSet:
 		cset, err := amount.ParseCPUSet()
 		if err != nil {
-			return balloonsError("failed to parse available CPU cpuset '%s': %w", amount, err)
+			return nil, balloonsError("failed to parse available CPU cpuset '%s': %w", amount, err)
 		}
 		availableCpus = cset
 	case cfgapi.AmountQuantity:
-		return balloonsError("can't handle CPU resources given as resource.Quantity (%v)", amount)
+		return nil, balloonsError("can't handle CPU resources given as resource.Quantity (%v)", amount)
 	case cfgapi.AmountAbsent:
 		// Available CPUs not specified, default to all on-line CPUs.
 		availableCpus = p.options.System.CPUSet().Difference(p.options.System.Offlined())
 	}
+	// Do not use CPUs the kernel would not let us pin containers to.
+	if effective := p.options.System.EffectiveAllowedCPUs(); !effective.IsEmpty() {
+		if outside := availableCpus.Difference(effective); !outside.IsEmpty() {
+			log.Warnf("ignoring available CPUs %q outside effective allowed CPUs %q", outside, effective)
+			availableCpus = availableCpus.Intersection(effective)
+		}
+	}
 	p.allowed = availableCpus
 
+	if err := resolveBalloonDefs(bpoptions.BalloonDefs); err != nil {
+		return nil, balloonsError("invalid configuration: %w", err)
+	}
+
 	setOmittedDefaults(bpoptions)
+	resolveCpuPercentages(bpoptions.BalloonDefs, p.allowed.Size())
 
+	userDefs := slices.Clone(bpoptions.BalloonDefs)
 	reservedBalloonDef, defaultBalloonDef, err := p.fillBuiltinBalloonDefs(bpoptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err = p.validateConfig(bpoptions); err != nil {
-		return balloonsError("invalid configuration: %w", err)
+	if err = p.validateConfig(bpoptions, userDefs); err != nil {
+		return nil, balloonsError("invalid configuration: %w", err)
+	}
+	if err = p.validateWholeNumaNodes(bpoptions); err != nil {
+		return nil, balloonsError("invalid configuration: %w", err)
+	}
+	reservedMems, err := reservedMemNodes(bpoptions)
+	if err != nil {
+		return nil, balloonsError("invalid configuration: %w", err)
+	}
+	if err = p.validateReservedMems(reservedMems); err != nil {
+		return nil, balloonsError("invalid configuration: %w", err)
+	}
+	if err = p.validateMemorySpreadNodes(userDefs, bpoptions.BalloonDefs); err != nil {
+		return nil, balloonsError("invalid configuration: %w", err)
 	}
 	p.fillCloseToDevices(bpoptions.BalloonDefs)
 	p.fillFarFromDevices(bpoptions.BalloonDefs)
 
+	return &preparedConfig{
+		bpoptions:          bpoptions,
+		reservedBalloonDef: reservedBalloonDef,
+		defaultBalloonDef:  defaultBalloonDef,
+		reservedMems:       reservedMems,
+	}, nil
+}
+
+// setConfig takes new balloon configuration into use.
+func (p *balloons) setConfig(bpoptions *BalloonsOptions) error {
+	cfg, err := p.prepareConfig(bpoptions)
+	if err != nil {
+		return err
+	}
+	bpoptions = cfg.bpoptions
+	reservedMems := cfg.reservedMems
+
 	// Preparation and configuration validation is now done
 	// without touching the state of the policy.
 	// Next apply the configuration.
-	p.reservedBalloonDef = reservedBalloonDef
-	p.defaultBalloonDef = defaultBalloonDef
+	p.reservedBalloonDef = cfg.reservedBalloonDef
+	p.defaultBalloonDef = cfg.defaultBalloonDef
 	p.balloons = []*Balloon{}
 	p.freeCpus = p.allowed.Clone()
+	p.pinnedCpus = map[string]cpuset.CPUSet{}
+	p.memAllocFailures = map[string]error{}
+	// Balloons are rebuilt from scratch, and so are memory allocations
+	// of their containers.
+	if released := p.memAllocator.ReleaseAll(); len(released) > 0 {
+		log.Info("released memory of %d containers for reallocation", len(released))
+	}
+	if err := p.memAllocator.SetReservedNodes(reservedMems); err != nil {
+		return balloonsError("failed to reserve memory nodes %s: %w", reservedMems, err)
+	}
+	p.reservedMems = reservedMems
+	if reservedMems != 0 {
+		log.Info("reserved memory nodes %s for the reserved balloon, %s of memory left for other balloons",
+			reservedMems, libmem.HumanReadableSize(p.memAllocator.UnreservedCapacity()))
+	}
+	p.sharedPool = cpuset.New()
+	p.overlay = cpuset.New()
+	p.unsharedIdle = cpuset.New()
 	p.bpoptions = bpoptions
+	p.checkNumaBalancing()
+	p.probeExclusiveCpus()
+	p.opStats.setTypes(bpoptions.BalloonDefs)
 
 	// Create balloon instances in the order of AllocatorPriority.
 	for allocPrio := cpuallocator.CPUPriority(0); allocPrio <= cpuallocator.NumCPUPriorities; allocPrio++ {
@@ -1129,6 +1850,7 @@ func (p *balloons) setConfig(bpoptions *BalloonsOptions) error {
 	}
 	p.updatePinning(p.shareIdleCpus(p.freeCpus, cpuset.New())...)
 	// (Re)configures all CPUs in balloons.
+	p.setCpuProfiles()
 	if err := p.resetCpuClass(); err != nil {
 		log.Warnf("failed to reset CPU class: %v", err)
 	}
@@ -1252,6 +1974,27 @@ func (p *balloons) fillBuiltinBalloonDefs(bpoptions *BalloonsOptions) (*BalloonD
 				reservedBalloonDef.MinCpus, qty.MilliValue())
 		}
 		p.reserved = cpuset.New()
+	case cfgapi.AmountAbsent:
+		// ReservedResources.cpus is not defined. Unless the
+		// reserved balloon type defines its MinCpus, reserve
+		// the default number of CPUs for it.
+		if reservedBalloonDef.MinCpus == 0 {
+			packages := 0
+			for _, id := range p.options.System.PackageIDs() {
+				if !p.options.System.Package(id).CPUSet().Intersection(p.allowed).IsEmpty() {
+					packages++
+				}
+			}
+			reserveCnt, err := defaultReservedCpuCount(bpoptions.DefaultReservedCpus, packages, p.allowed.Size())
+			if err != nil {
+				return nil, nil, balloonsError("invalid defaultReservedCPUs: %w", err)
+			}
+			if reservedBalloonDef.MaxCpus != 0 && reservedBalloonDef.MaxCpus < reserveCnt {
+				reserveCnt = reservedBalloonDef.MaxCpus
+			}
+			log.Debugf("reserving %d CPUs by default (%q) for the reserved balloon", reserveCnt, bpoptions.DefaultReservedCpus)
+			reservedBalloonDef.MinCpus = reserveCnt
+		}
 	}
 
 	reservedBalloonDef.MinBalloons = 1
@@ -1262,6 +2005,31 @@ func (p *balloons) fillBuiltinBalloonDefs(bpoptions *BalloonsOptions) (*BalloonD
 	return reservedBalloonDef, defaultBalloonDef, nil
 }
 
+// defaultReservedCpuCount returns the number of CPUs to reserve for
+// the reserved balloon when ReservedResources does not define CPUs.
+// On larger systems a single reserved CPU is often not enough to run
+// kube-system and other system workloads. Therefore by default one
+// CPU is reserved per CPU package, or one CPU per 64 available CPUs
+// if that is more.
+func defaultReservedCpuCount(value string, packages, cpus int) (int, error) {
+	var cnt int
+	switch value {
+	case "", "auto":
+		cnt = max(packages, cpus/64)
+	case "per-socket":
+		cnt = packages
+	case "none":
+		return 0, nil
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("expected auto, per-socket, none or a number of CPUs, got %q", value)
+		}
+		return min(n, cpus), nil
+	}
+	return min(max(cnt, 1), cpus), nil
+}
+
 func (p *balloons) fillCloseToDevices(blnDefs []*BalloonDef) {
 	for _, blnDef := range blnDefs {
 		if blnDef.PreferIsolCpus {
@@ -1329,26 +2097,52 @@ func memTypeMaskFromStringList(memTypes []string) (libmem.TypeMask, error) {
 // closestMems returns memory node IDs good for pinning containers
 // that run on given CPUs
 func (p *balloons) closestMems(cpus cpuset.CPUSet) idset.IDSet {
-	return idset.NewIDSet(p.memAllocator.CPUSetAffinity(cpus).Slice()...)
+	return p.memAllocator.CPUSetAffinity(cpus).IDSet()
+}
+
+// closestMemsOfTypes returns closestMems of given CPUs, extended with
+// the closest memory nodes of each given type that have free capacity,
+// in case the closest memory nodes do not.
+func (p *balloons) closestMemsOfTypes(cpus cpuset.CPUSet, types libmem.TypeMask) idset.IDSet {
+	nodes := p.memAllocator.CPUSetAffinity(cpus)
+	types.Foreach(func(t libmem.Type) bool {
+		nodes |= p.memAllocator.CPUSetAffinityWithType(cpus, t)
+		return libmem.ForeachMore
+	})
+	return nodes.IDSet()
 }
 
 // resizeBalloon changes the CPUs allocated for a balloon, if allowed.
 func (p *balloons) resizeBalloon(bln *Balloon, newMilliCpus int) error {
-	oldCpuCount := bln.Cpus.Size()
-	newCpuCount := (newMilliCpus + 999) / 1000
-	if bln.Def.MaxCpus > NoLimit && newCpuCount > bln.Def.MaxCpus {
-		newCpuCount = bln.Def.MaxCpus
+	blog := blnLog(bln)
+	if bln.Def.SharedPoolOnly {
+		blog.Debugf("not resizing shared pool balloon %s", bln)
+		return nil
 	}
-	if bln.Def.MinCpus > 0 && newCpuCount < bln.Def.MinCpus {
-		newCpuCount = bln.Def.MinCpus
+	if bln.Def.Overlay {
+		blog.Debugf("not resizing overlay balloon %s", bln)
+		return nil
 	}
-	log.Debugf("resize %s to fit %d mCPU", bln, newMilliCpus)
-	log.Debugf("- change size from %d to %d full cpus", oldCpuCount, newCpuCount)
-	log.Debugf("- free cpus: %q", p.freeCpus)
+	if bln.Def.WholeNumaNodes > 0 {
+		blog.Debugf("not resizing whole NUMA node balloon %s", bln)
+		return nil
+	}
+	oldCpuCount := bln.Cpus.Size()
+	newCpuCount := p.resizedCpuCount(bln, newMilliCpus)
+	// Inflation steps are tracked again once the resize succeeds.
+	bln.inflateMilliCpus = 0
+	blog.Debugf("resize %s to fit %d mCPU", bln, newMilliCpus)
+	blog.Debugf("- change size from %d to %d full cpus", oldCpuCount, newCpuCount)
+	blog.Debugf("- free cpus: %q", p.freeCpus)
 	if oldCpuCount == newCpuCount {
 		return nil
 	}
 	cpuCountDelta := newCpuCount - oldCpuCount
+	if cpuCountDelta > 0 {
+		defer p.opStats.observe(bln.Def.Name, opInflate, time.Now())
+	} else {
+		defer p.opStats.observe(bln.Def.Name, opDeflate, time.Now())
+	}
 	p.forgetCpuClass(bln)
 	defer func() {
 		if err := p.useCpuClass(bln); err != nil {
@@ -1356,54 +2150,143 @@ func (p *balloons) resizeBalloon(bln *Balloon, newMilliCpus int) error {
 		}
 	}()
 	if cpuCountDelta > 0 {
-		// Inflate the balloon.
-		addFromCpus, _, err := bln.cpuTreeAlloc.ResizeCpus(bln.Cpus, p.freeCpus, cpuCountDelta)
-		if err != nil {
-			return balloonsError("resize/inflate: failed to choose a cpuset for allocating additional %d CPUs: %w", cpuCountDelta, err)
-		}
-		log.Debugf("- allocating %d CPUs from %q", cpuCountDelta, addFromCpus)
-		newCpus, err := p.cpuAllocator.AllocateCpus(&addFromCpus, newCpuCount-oldCpuCount, bln.Def.AllocatorPriority.Value().Option())
-		if err != nil {
-			return balloonsError("resize/inflate: allocating %d CPUs for %s failed: %w", cpuCountDelta, bln, err)
+		// Inflate the balloon, preferring CPUs previously used
+		// by the container being allocated, if any.
+		newCpus := p.takeStickyCpus(bln.Def, cpuCountDelta)
+		if newCpus.Size() > 0 {
+			blog.Debugf("- reallocating previously used CPUs %q", newCpus)
+		}
+		if more := cpuCountDelta - newCpus.Size(); more > 0 {
+			freeCpus := p.freeCpus.Difference(newCpus)
+			moreCpus, err := p.allocateCpus(bln.Def, bln.cpuTreeAlloc, bln.Cpus.Union(newCpus), freeCpus, more)
+			if err != nil {
+				return balloonsError("resize/inflate: allocating %d CPUs for %s failed: %w", more, bln, err)
+			}
+			newCpus = newCpus.Union(moreCpus)
 		}
 		oldBlnCpus := bln.Cpus
 		oldFreeCpus := p.freeCpus
 		p.freeCpus = p.freeCpus.Difference(newCpus)
 		bln.Cpus = bln.Cpus.Union(newCpus)
-		log.Debugf("- allocated, changed cpus: balloon from %q to %q, free from %q to %q", oldBlnCpus, bln.Cpus, oldFreeCpus, p.freeCpus)
+		blog.Debugf("- allocated, changed cpus: balloon from %q to %q, free from %q to %q", oldBlnCpus, bln.Cpus, oldFreeCpus, p.freeCpus)
 		p.updatePinning(p.shareIdleCpus(p.freeCpus, newCpus)...)
 	} else {
 		// Deflate the balloon.
-		_, removeFromCpus, err := bln.cpuTreeAlloc.ResizeCpus(bln.Cpus, p.freeCpus, cpuCountDelta)
-		if err != nil {
-			return balloonsError("resize/deflate: failed to choose a cpuset for releasing %d CPUs: %w", -cpuCountDelta, err)
+		var removeFromCpus cpuset.CPUSet
+		if bln.Def.ExclusiveLLC {
+			// Release only whole cache groups.
+			removeFromCpus = p.cacheGroupCpusToRelease(bln, -cpuCountDelta)
+			if removeFromCpus.IsEmpty() {
+				blog.Debugf("- no whole cache groups to release")
+				return nil
+			}
+			cpuCountDelta = -removeFromCpus.Size()
+		} else {
+			var err error
+			_, removeFromCpus, err = bln.cpuTreeAlloc.ResizeCpus(bln.Cpus, p.freeCpus, cpuCountDelta)
+			if err != nil {
+				return balloonsError("resize/deflate: failed to choose a cpuset for releasing %d CPUs: %w", -cpuCountDelta, err)
+			}
 		}
-		log.Debugf("- releasing %d CPUs from cpuset %q", -cpuCountDelta, removeFromCpus)
-		_, err = p.cpuAllocator.ReleaseCpus(&removeFromCpus, -cpuCountDelta, bln.Def.AllocatorPriority.Value().Option())
+		blog.Debugf("- releasing %d CPUs from cpuset %q", -cpuCountDelta, removeFromCpus)
+		_, err := p.cpuAllocator.ReleaseCpus(&removeFromCpus, -cpuCountDelta, bln.Def.AllocatorPriority.Value().Option())
 		if err != nil {
 			return balloonsError("resize/deflate: releasing %d CPUs from %s failed: %w", -cpuCountDelta, bln, err)
 		}
 		oldBlnCpus := bln.Cpus
 		oldFreeCpus := p.freeCpus
+		p.keepUnshared(bln, removeFromCpus)
 		p.freeCpus = p.freeCpus.Union(removeFromCpus)
 		bln.Cpus = bln.Cpus.Difference(removeFromCpus)
-		log.Debugf("- released, changed cpus: balloon from %q to %q, free from %q to %q", oldBlnCpus, bln.Cpus, oldFreeCpus, p.freeCpus)
+		blog.Debugf("- released, changed cpus: balloon from %q to %q, free from %q to %q", oldBlnCpus, bln.Cpus, oldFreeCpus, p.freeCpus)
 		p.updatePinning(p.shareIdleCpus(removeFromCpus, cpuset.New())...)
 	}
-	log.Debugf("- resize successful: %s, freecpus: %#s", bln, p.freeCpus)
+	blog.Debugf("- resize successful: %s, freecpus: %#s", bln, p.freeCpus)
+	p.trackInflation(bln, newMilliCpus, newCpuCount)
 	p.updatePinning(bln)
 	return nil
 }
 
+// targetCpuCount returns the number of CPUs a resizable balloon needs
+// to fit newMilliCpus, regardless of the MaxInflationStep limit.
+func (p *balloons) targetCpuCount(bln *Balloon, newMilliCpus int) int {
+	newCpuCount := max((newMilliCpus+999)/1000, bln.prewarmCpus)
+	if bln.Def.MaxCpus > NoLimit && newCpuCount > bln.Def.MaxCpus {
+		newCpuCount = bln.Def.MaxCpus
+	}
+	if bln.Def.MinCpus > 0 && newCpuCount < bln.Def.MinCpus {
+		newCpuCount = bln.Def.MinCpus
+	}
+	return newCpuCount
+}
+
+// resizedCpuCount returns the number of CPUs a resizable balloon gets
+// when resized to fit newMilliCpus.
+func (p *balloons) resizedCpuCount(bln *Balloon, newMilliCpus int) int {
+	oldCpuCount := bln.Cpus.Size()
+	newCpuCount := p.targetCpuCount(bln, newMilliCpus)
+	if limited := inflationStepCpuCount(oldCpuCount, newCpuCount, p.bpoptions.MaxInflationStep); limited != newCpuCount {
+		blnLog(bln).Debugf("- inflating %s by at most %d CPUs at once", bln, p.bpoptions.MaxInflationStep)
+		newCpuCount = limited
+	}
+	return newCpuCount
+}
+
 func (p *balloons) updatePinning(blns ...*Balloon) {
+	defer p.updateIrqAffinity()
+	defer p.updateSharedPool()
+	defer p.updateOverlays()
+	remembered := false
+	defer func() {
+		if remembered {
+			p.saveStickyCpus()
+		}
+	}()
 	for _, bln := range blns {
-		var cpusNoHt cpuset.CPUSet
+		var cpusNoHt, qosCpus, qosCpusNoHt cpuset.CPUSet
 		var allowedCpus cpuset.CPUSet
 		pinnableCpus := bln.Cpus.Union(bln.SharedIdleCpus)
-		bln.Mems = p.closestMems(pinnableCpus)
+		if bln.Def.SharedPoolOnly {
+			pinnableCpus = p.sharedPoolCpus()
+		}
+		if bln.Def.Overlay {
+			pinnableCpus = p.overlayCpus()
+		}
+		if bln.Def.WholeNumaNodes > 0 {
+			bln.Mems = p.numaNodesOf(bln.Cpus)
+		} else {
+			bln.Mems = p.closestMemsOfTypes(pinnableCpus, bln.memTypeMask)
+		}
+		// Keep other containers off the cores of Guaranteed containers.
+		assignedCpus := p.assignQoSCpus(bln)
+		if otherCpus := pinnableCpus.Difference(assignedCpus); !otherCpus.IsEmpty() {
+			pinnableCpus = otherCpus
+		}
 		for _, cID := range bln.ContainerIDs() {
 			if c, ok := p.cch.LookupContainer(cID); ok {
-				if runWithoutHyperthreads(c, bln) {
+				exclusiveQoS := p.useExclusiveQoS(c, bln)
+				if ownCpus, ok := bln.qosCpus[cID]; ok && exclusiveQoS {
+					allowedCpus = ownCpus
+					if runWithoutHyperthreads(c, bln) {
+						allowedCpus = p.cpuTree.system().SingleThreadForCPUs(ownCpus)
+					}
+				} else if exclusiveQoS || p.useLatencyCritical(c, bln) {
+					if qosCpus.Size() == 0 {
+						qosCpus = p.exclusiveQoSCpus(bln)
+						if sharedCpus := qosCpus.Difference(assignedCpus); !sharedCpus.IsEmpty() {
+							qosCpus = sharedCpus
+						}
+					}
+					allowedCpus = qosCpus
+					if runWithoutHyperthreads(c, bln) {
+						if qosCpusNoHt.Size() == 0 {
+							qosCpusNoHt = p.cpuTree.system().SingleThreadForCPUs(qosCpus)
+						}
+						allowedCpus = qosCpusNoHt
+					}
+				} else if idleCpus, ok := p.zeroRequestCpus(c, bln); ok {
+					allowedCpus = idleCpus
+				} else if runWithoutHyperthreads(c, bln) {
 					if cpusNoHt.Size() == 0 {
 						cpusNoHt = p.cpuTree.system().SingleThreadForCPUs(pinnableCpus)
 					}
@@ -1411,7 +2294,13 @@ func (p *balloons) updatePinning(blns ...*Balloon) {
 				} else {
 					allowedCpus = pinnableCpus
 				}
-				p.pinCpuMem(c, allowedCpus, bln.Mems, bln.memTypeMask, bln.Def.PinMemory)
+				p.pinCpuMem(c, allowedCpus, p.spreadMems(c, bln, bln.Mems), bln.memTypeMask, bln.Def.PinMemory)
+				p.expectPinning(c, allowedCpus)
+				p.pinExclusive(c, bln, allowedCpus)
+				p.setCpuShares(c, bln, exclusiveQoS)
+				if p.rememberCpus(c, bln) {
+					remembered = true
+				}
 			}
 		}
 	}
@@ -1442,14 +2331,18 @@ func (p *balloons) shareIdleCpus(addCpus, removeCpus cpuset.CPUSet) []*Balloon {
 			}
 		}
 	}
-	addCpus = addCpus.Difference(p.options.System.Isolated())
-	if addCpus.Size() > 0 {
+	isolated := p.options.System.Isolated()
+	unshared := p.unsharedCpus()
+	addCpus = addCpus.Difference(isolated).Difference(unshared)
+	if addCpus.Size() > 0 || removeCpus.Size() > 0 {
 		for blnIdx, bln := range p.balloons {
 			topoLevel := bln.Def.ShareIdleCpusInSame
 			if topoLevel == cfgapi.CPUTopologyLevelUndefined {
 				continue
 			}
 			idleCpusInTopoLevel := cpuset.New()
+			cpusInTopoLevel := cpuset.New()
+			crossing := false
 			if err := p.cpuTree.DepthFirstWalk(func(t *cpuTreeNode) error {
 				// Dive in correct topology level.
 				if t.level != topoLevel {
@@ -1459,18 +2352,41 @@ func (p *balloons) shareIdleCpus(addCpus, removeCpus cpuset.CPUSet) []*Balloon {
 				if t.cpus.Intersection(bln.Cpus).Size() > 0 {
 					// Share idle CPUs on this level to this balloon.
 					idleCpusInTopoLevel = idleCpusInTopoLevel.Union(t.cpus.Intersection(addCpus))
+					cpusInTopoLevel = cpusInTopoLevel.Union(t.cpus)
 				}
 				// Do not walk deeper than the correct level.
 				return WalkSkipChildren
 			}); err != WalkSkipChildren && err != WalkStop {
 				log.Warnf("failed to walk CPU tree: %v", err)
 			}
+			if bln.Def.ShareIdleCpusCrossNuma && bln.Cpus.Size() > 0 {
+				localIdleCpus := p.freeCpus.Intersection(cpusInTopoLevel).Difference(isolated).Difference(unshared)
+				crossedCpus := bln.SharedIdleCpus.Difference(cpusInTopoLevel)
+				switch {
+				case localIdleCpus.Size() == 0:
+					// No idle CPUs in the topology level, share any.
+					idleCpusInTopoLevel = p.freeCpus.Difference(isolated).Difference(unshared)
+					crossing = true
+				case crossedCpus.Size() > 0:
+					// Idle CPUs available again in the topology
+					// level, stop sharing CPUs outside of it.
+					log.Infof("balloon %s stops sharing idle CPUs %s outside its %s(s)",
+						bln.PrettyName(), crossedCpus, topoLevel)
+					bln.SharedIdleCpus = bln.SharedIdleCpus.Difference(crossedCpus)
+					idleCpusInTopoLevel = idleCpusInTopoLevel.Union(localIdleCpus)
+					updateBalloons[blnIdx] = struct{}{}
+				}
+			}
 			if idleCpusInTopoLevel.Size() == 0 {
 				continue
 			}
 			sharedBefore := bln.SharedIdleCpus.Size()
 			bln.SharedIdleCpus = bln.SharedIdleCpus.Union(idleCpusInTopoLevel)
 			sharedNow := bln.SharedIdleCpus.Size()
+			if sharedBefore != sharedNow && crossing {
+				log.Infof("balloon %s shares idle CPUs %s across NUMA nodes, no idle CPUs in its %s(s)",
+					bln.PrettyName(), idleCpusInTopoLevel, topoLevel)
+			}
 			if sharedBefore != sharedNow {
 				log.Debugf("balloon %s shares %d new idle CPU(s) in %s(s), %d in total (%s)",
 					bln.PrettyName(), sharedNow-sharedBefore,
@@ -1486,6 +2402,23 @@ func (p *balloons) shareIdleCpus(addCpus, removeCpus cpuset.CPUSet) []*Balloon {
 	return updatedBalloons
 }
 
+// unsharedCpus returns idle CPUs released by balloons whose type
+// forbids lending their CPUs to other balloons, and all hyperthreads
+// of the cores of latency-critical balloons.
+func (p *balloons) unsharedCpus() cpuset.CPUSet {
+	return p.latencyCriticalCpus().Union(p.unsharedIdle.Intersection(p.freeCpus))
+}
+
+// keepUnshared keeps CPUs released by a balloon out of the idle CPUs
+// shared to other balloons, if the balloon type forbids lending its
+// CPUs. The CPUs are shared again once allocated to any balloon.
+func (p *balloons) keepUnshared(bln *Balloon, cpus cpuset.CPUSet) {
+	p.unsharedIdle = p.unsharedIdle.Intersection(p.freeCpus)
+	if bln.Def.NoShareExclusiveIdle {
+		p.unsharedIdle = p.unsharedIdle.Union(cpus)
+	}
+}
+
 // updateGroups updates the number of groups present in the balloon.
 func (bln *Balloon) updateGroups(c cache.Container, delta int) {
 	if bln.Def.GroupBy != "" {
@@ -1500,6 +2433,12 @@ func (p *balloons) assignContainer(c cache.Container, bln *Balloon) {
 	podID := c.GetPodID()
 	bln.PodIDs[podID] = append(bln.PodIDs[podID], c.GetID())
 	bln.updateGroups(c, 1)
+	blnLog(bln).Debug("- balloon %s now has containers %v on cpus %q, mems %s",
+		bln.PrettyName(), bln.ContainerIDs(), bln.Cpus, bln.Mems)
+	if isLatencyCritical(c) {
+		// Stop sharing idle hyperthreads of the balloon's cores.
+		p.updatePinning(p.shareIdleCpus(cpuset.New(), p.latencyCriticalCpus())...)
+	}
 	p.updatePinning(bln)
 }
 
@@ -1514,6 +2453,10 @@ func (p *balloons) dismissContainer(c cache.Container, bln *Balloon) {
 		delete(bln.PodIDs, podID)
 	}
 	bln.updateGroups(c, -1)
+	if isLatencyCritical(c) && !p.hasLatencyCritical(bln) {
+		// Resume sharing idle hyperthreads of the balloon's cores.
+		p.updatePinning(p.shareIdleCpus(p.coreCpus(bln.Cpus).Intersection(p.freeCpus), cpuset.New())...)
+	}
 }
 
 // pinCpuMem pins container to CPUs and memory nodes if flagged
@@ -1544,12 +2487,12 @@ func (p *balloons) pinCpuMem(c cache.Container, cpus cpuset.CPUSet, mems idset.I
 				c.SetCpusetMems(zone.MemsetString())
 			}
 		} else {
-			effMemTypeMask, err := c.MemoryTypes()
+			effMemTypeMask, err := containerMemTypes(c)
 			if err != nil {
 				log.Error("%v", err)
 			}
 			if effMemTypeMask != 0 {
-				// memory-type pod/container-specific
+				// memory-type(s) pod/container-specific
 				// annotation overrides balloon's
 				// memory options that are the default
 				// to all containers in the balloon.
@@ -1568,14 +2511,28 @@ func (p *balloons) pinCpuMem(c cache.Container, cpus cpuset.CPUSet, mems idset.I
 func (p *balloons) allocMem(c cache.Container, mems idset.IDSet, types libmem.TypeMask, preserve bool) libmem.NodeMask {
 	var (
 		amount  = getMemoryLimit(c)
-		nodes   = libmem.NewNodeMask(mems.Members()...)
+		nodes   = libmem.NodeMaskFromIDSet(mems)
 		req     *libmem.Request
 		zone    libmem.NodeMask
 		updates map[string]libmem.NodeMask
 		err     error
+
+		reserved    = p.isReservedContainer(c)
+		allowedMems = p.allowedMemsOf(reserved)
 	)
 
-	if _, ok := p.memAllocator.AssignedZone(c.GetID()); !ok {
+	if allowedMems != 0 {
+		if allowed := nodes & allowedMems; allowed != 0 {
+			nodes = allowed
+		} else {
+			log.Warn("allocMem: no allowed memory nodes among %s for %s, using %s",
+				nodes, c.PrettyName(), allowedMems)
+			nodes = allowedMems
+		}
+	}
+
+	_, realloc := p.memAllocator.AssignedZone(c.GetID())
+	if !realloc {
 		if preserve {
 			req = libmem.PreservedContainer(
 				c.GetID(),
@@ -1584,14 +2541,25 @@ func (p *balloons) allocMem(c cache.Container, mems idset.IDSet, types libmem.Ty
 				nodes,
 			)
 		} else {
-			req = libmem.ContainerWithTypes(
-				c.GetID(),
-				c.PrettyName(),
-				string(c.GetQOSClass()),
-				amount,
-				nodes,
-				types,
-			)
+			opts := []libmem.RequestOption{
+				libmem.WithName(c.PrettyName()),
+				libmem.WithQosClass(string(c.GetQOSClass())),
+				libmem.WithPreferredTypes(types),
+				libmem.WithAllowedNodes(allowedMems),
+			}
+			if reserved {
+				opts = append(opts, libmem.WithReservedNodeAccess())
+			}
+			// Never move memory of guaranteed containers to
+			// resolve overcommit caused by other containers.
+			if c.GetQOSClass() == corev1.PodQOSGuaranteed {
+				opts = append(opts, libmem.WithPinned())
+			}
+			// Never let latency-critical containers use remote memory.
+			if isLatencyCritical(c) {
+				opts = append(opts, libmem.RequireLocalNode())
+			}
+			req = libmem.NewRequest(c.GetID(), amount, nodes, opts...)
 		}
 		zone, updates, err = p.memAllocator.Allocate(req)
 	} else {
@@ -1600,9 +2568,7 @@ func (p *balloons) allocMem(c cache.Container, mems idset.IDSet, types libmem.Ty
 	}
 
 	if err != nil {
-		log.Error("allocMem: falling back to %s, failed to allocate memory for %s: %v",
-			nodes, c.PrettyName(), err)
-		return nodes
+		return p.memAllocFailure(c, nodes, allowedMems, realloc, err)
 	}
 
 	for oID, oz := range updates {
@@ -1614,6 +2580,111 @@ func (p *balloons) allocMem(c cache.Container, mems idset.IDSet, types libmem.Ty
 	return zone
 }
 
+// memAllocFailure returns the memory nodes to pin a container to after
+// failing to allocate memory for it, according to OnMemoryAllocFailure.
+// Only the initial allocation of a container can fail the container.
+// Once the container has been created, failures always fall back.
+func (p *balloons) memAllocFailure(c cache.Container, nodes, allowedMems libmem.NodeMask, realloc bool, err error) libmem.NodeMask {
+	switch p.bpoptions.OnMemoryAllocFailure {
+	case MemoryAllocFailureWiden:
+		widened := allowedMems
+		if widened == 0 {
+			widened = p.memAllocator.Masks().AvailableNodes()
+		}
+		log.Error("allocMem: widening %s to %s, failed to allocate memory for %s: %v",
+			nodes, widened, c.PrettyName(), err)
+		return widened
+	case MemoryAllocFailureFail:
+		if !realloc {
+			log.Error("allocMem: failing container %s, failed to allocate memory from %s: %v",
+				c.PrettyName(), nodes, err)
+			p.memAllocFailures[c.GetID()] = err
+			return nodes
+		}
+	}
+	log.Error("allocMem: falling back to %s, failed to allocate memory for %s: %v",
+		nodes, c.PrettyName(), err)
+	return nodes
+}
+
+// isReservedContainer returns true if a container is in the reserved balloon
+// and reserved memory nodes are configured.
+func (p *balloons) isReservedContainer(c cache.Container) bool {
+	if p.reservedMems == 0 {
+		return false
+	}
+	bln := p.balloonByContainer(c)
+	return bln != nil && bln.Def == p.reservedBalloonDef
+}
+
+// allowedMemNodes returns the available memory nodes the kernel lets us
+// pin containers to. These are the effective memory nodes of the cgroup
+// of all pods, not of our own cgroup, which is restricted to the memory
+// nodes of the reserved balloon once we get pinned there ourselves.
+func allowedMemNodes(sys system.System, available libmem.NodeMask) libmem.NodeMask {
+	allowed := libmem.NodeMaskFromCPUSet(sys.EffectiveAllowedMems()) & available
+	if outside := available &^ allowed; outside != 0 && allowed != 0 {
+		log.Warnf("ignoring memory nodes %s outside effective allowed memory nodes %s", outside, allowed)
+	}
+	return allowed
+}
+
+// allowedMemsOf returns the memory nodes containers in the reserved
+// balloon, or in other balloons, are allowed to use. Reserved memory
+// nodes are used only by the reserved balloon. 0 means any node.
+func (p *balloons) allowedMemsOf(reserved bool) libmem.NodeMask {
+	if reserved || p.reservedMems == 0 {
+		return p.allowedMems
+	}
+	allowed := p.allowedMems
+	if allowed == 0 {
+		allowed = p.memAllocator.Masks().AvailableNodes()
+	}
+	return allowed &^ p.reservedMems
+}
+
+// reservedMemNodes returns the memory nodes in ReservedResources.
+func reservedMemNodes(bpoptions *BalloonsOptions) (libmem.NodeMask, error) {
+	amount, kind := bpoptions.ReservedResources.Get(cfgapi.Memory)
+	if kind == cfgapi.AmountAbsent {
+		return 0, nil
+	}
+	nodes, err := amount.ParseCPUSet()
+	if err != nil {
+		return 0, configError("reservedResources.memory", "invalid reserved memory nodes: %v", err)
+	}
+	return libmem.NodeMaskFromCPUSet(nodes), nil
+}
+
+// validateReservedMems checks that reserved memory nodes exist, and
+// that they leave memory nodes for other balloons.
+func (p *balloons) validateReservedMems(reservedMems libmem.NodeMask) error {
+	if unknown := reservedMems &^ p.memAllocator.Masks().AvailableNodes(); unknown != 0 {
+		return configError("reservedResources.memory", "unknown reserved memory nodes %s", unknown)
+	}
+	allowed := p.allowedMems
+	if allowed == 0 {
+		allowed = p.memAllocator.Masks().AvailableNodes()
+	}
+	if reservedMems != 0 && allowed&^reservedMems == 0 {
+		return configError("reservedResources.memory",
+			"reserved memory nodes %s leave no allowed memory nodes (%s) for other balloons",
+			reservedMems, allowed)
+	}
+	return nil
+}
+
+// memAllocFailed returns the error of a failed memory allocation which
+// should fail the creation of a container.
+func (p *balloons) memAllocFailed(c cache.Container) error {
+	err, ok := p.memAllocFailures[c.GetID()]
+	if !ok {
+		return nil
+	}
+	delete(p.memAllocFailures, c.GetID())
+	return err
+}
+
 func parseIDSet(mems string) (idset.IDSet, error) {
 	cset, err := cpuset.Parse(mems)
 	if err != nil {
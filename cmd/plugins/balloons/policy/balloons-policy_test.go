@@ -15,7 +15,32 @@
 package balloons
 
 import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	cfgapi "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/resmgr/policy/balloons"
+	"github.com/containers/nri-plugins/pkg/cpuallocator"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	cpucontrol "github.com/containers/nri-plugins/pkg/resmgr/control/cpu"
+	libmem "github.com/containers/nri-plugins/pkg/resmgr/lib/memory"
+	"github.com/containers/nri-plugins/pkg/resmgr/policy"
+	"github.com/containers/nri-plugins/pkg/sysfs"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+	idset "github.com/intel/goresctrl/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestChangesBalloons(t *testing.T) {
@@ -84,6 +109,53 @@ func TestChangesBalloons(t *testing.T) {
 			},
 			expectedValue: false,
 		},
+		{
+			name: "rebalancing, reconciliation and sticky CPU parameters differ",
+			opts1: &BalloonsOptions{
+				IdleCpuClass: "icc0",
+			},
+			opts2: &BalloonsOptions{
+				IdleCpuClass:       "icc0",
+				RebalanceInterval:  &metav1.Duration{Duration: time.Minute},
+				RebalanceThreshold: 2,
+				RebalanceMaxCpus:   4,
+				ReconcileInterval:  &metav1.Duration{Duration: time.Minute},
+				StickyCpus:         true,
+				MaxInflationStep:   2,
+			},
+			expectedValue: false,
+		},
+		{
+			name: "memory allocation failure behavior differs",
+			opts1: &BalloonsOptions{
+				OnMemoryAllocFailure: MemoryAllocFailureFallback,
+			},
+			opts2: &BalloonsOptions{
+				OnMemoryAllocFailure: MemoryAllocFailureFail,
+			},
+			expectedValue: false,
+		},
+		{
+			name: "namespace CPU quotas differ",
+			opts1: &BalloonsOptions{
+				NamespaceCpuQuotas: map[string]int{"tenant": 4},
+			},
+			opts2: &BalloonsOptions{
+				NamespaceCpuQuotas:  map[string]int{"tenant": 2},
+				MixedNamespaceQuota: MixedNamespaceQuotaReject,
+			},
+			expectedValue: false,
+		},
+		{
+			name: "balloon log levels differ",
+			opts1: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{{Name: "b0"}},
+			},
+			opts2: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{{Name: "b0", LogLevel: BalloonLogLevelDebug}},
+			},
+			expectedValue: false,
+		},
 	}
 	for _, tc := range tcases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -94,3 +166,3835 @@ func TestChangesBalloons(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveBalloonDefs(t *testing.T) {
+	pinMemory := true
+	tcases := []struct {
+		name          string
+		blnDefs       []*BalloonDef
+		expectedError string
+		check         func(*testing.T, []*BalloonDef)
+	}{
+		{
+			name: "inherit and override",
+			blnDefs: []*BalloonDef{
+				{
+					Name:       "base",
+					Namespaces: []string{"ns0"},
+					MaxCpus:    4,
+					PinMemory:  &pinMemory,
+					CpuClass:   "fast",
+				},
+				{
+					Name:    "derived",
+					BasedOn: "base",
+					MaxCpus: 8,
+				},
+				{
+					Name:     "derived2",
+					BasedOn:  "derived",
+					CpuClass: "slow",
+				},
+			},
+			check: func(t *testing.T, blnDefs []*BalloonDef) {
+				d := blnDefs[1]
+				if d.Name != "derived" || d.MaxCpus != 8 || d.CpuClass != "fast" ||
+					d.PinMemory == nil || !*d.PinMemory || len(d.Namespaces) != 1 {
+					t.Errorf("unexpected resolved balloon type %+v", d)
+				}
+				d2 := blnDefs[2]
+				if d2.Name != "derived2" || d2.MaxCpus != 8 || d2.CpuClass != "slow" {
+					t.Errorf("unexpected resolved balloon type %+v", d2)
+				}
+				if d.PinMemory == blnDefs[0].PinMemory {
+					t.Errorf("resolved balloon type shares data with its base")
+				}
+			},
+		},
+		{
+			name: "inherit settings hidden from JSON",
+			blnDefs: []*BalloonDef{
+				{
+					Name:                 "base",
+					MaxCpusPercent:       25,
+					MinCpus:              2,
+					PreferFarFromDevices: []string{"/sys/class/net/eth0"},
+				},
+				{
+					Name:    "derived",
+					BasedOn: "base",
+				},
+				{
+					Name:                 "derived2",
+					BasedOn:              "base",
+					MaxCpus:              4,
+					PreferFarFromDevices: []string{"/sys/class/net/eth1"},
+				},
+			},
+			check: func(t *testing.T, blnDefs []*BalloonDef) {
+				d := blnDefs[1]
+				if d.MaxCpusPercent != 25 || d.MinCpus != 2 ||
+					!slices.Equal(d.PreferFarFromDevices, []string{"/sys/class/net/eth0"}) {
+					t.Errorf("unexpected resolved balloon type %+v", d)
+				}
+				d2 := blnDefs[2]
+				if d2.MaxCpusPercent != 0 || d2.MaxCpus != 4 || d2.MinCpus != 2 ||
+					!slices.Equal(d2.PreferFarFromDevices, []string{"/sys/class/net/eth1"}) {
+					t.Errorf("unexpected resolved balloon type %+v", d2)
+				}
+				if &d.PreferFarFromDevices[0] == &blnDefs[0].PreferFarFromDevices[0] {
+					t.Errorf("resolved balloon type shares data with its base")
+				}
+			},
+		},
+		{
+			name: "undefined base",
+			blnDefs: []*BalloonDef{
+				{
+					Name:    "derived",
+					BasedOn: "missing",
+				},
+			},
+			expectedError: "undefined balloon type",
+		},
+		{
+			name: "inheritance cycle",
+			blnDefs: []*BalloonDef{
+				{
+					Name:    "a",
+					BasedOn: "b",
+				},
+				{
+					Name:    "b",
+					BasedOn: "a",
+				},
+			},
+			expectedError: "inheritance cycle",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := resolveBalloonDefs(tc.blnDefs)
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tc.check(t, tc.blnDefs)
+			// resolving again must not change anything
+			again := make([]*BalloonDef, len(tc.blnDefs))
+			copy(again, tc.blnDefs)
+			if err := resolveBalloonDefs(again); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tc.check(t, again)
+		})
+	}
+}
+
+func TestValidateConfigPaths(t *testing.T) {
+	p := &balloons{}
+	reserved := &BalloonDef{Name: reservedBalloonDefName, MinBalloons: 1}
+	tcases := []struct {
+		name          string
+		bpoptions     *BalloonsOptions
+		userDefs      int
+		expectedError string
+	}{
+		{
+			name: "policy level option",
+			bpoptions: &BalloonsOptions{
+				RebalanceThreshold: -1,
+			},
+			expectedError: "(at rebalanceThreshold)",
+		},
+		{
+			name: "negative inflation step",
+			bpoptions: &BalloonsOptions{
+				MaxInflationStep: -1,
+			},
+			expectedError: "(at maxInflationStep)",
+		},
+		{
+			name: "negative max pods",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{reserved, {Name: "tenant", MaxPods: -1}},
+			},
+			userDefs:      2,
+			expectedError: "(at balloonTypes[1].maxPods)",
+		},
+		{
+			name: "negative namespace CPU quota",
+			bpoptions: &BalloonsOptions{
+				NamespaceCpuQuotas: map[string]int{"tenant": -1},
+			},
+			expectedError: "(at namespaceCPUQuotas.tenant)",
+		},
+		{
+			name: "balloon type option",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "ok"},
+					{Name: "bad", MinCpus: 4, MaxCpus: 2},
+				},
+			},
+			userDefs:      2,
+			expectedError: "(at balloonTypes[1].minCPUs)",
+		},
+		{
+			name: "balloon type list item",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", RelaxOnFailure: []string{relaxCoreType, "nonsense"}},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].relaxOnFailure[1])",
+		},
+		{
+			name: "unknown fallback",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bigcore", Fallbacks: []string{"burst", "missing"}},
+					{Name: "burst"},
+				},
+			},
+			userDefs:      2,
+			expectedError: "(at balloonTypes[0].fallbacks[1])",
+		},
+		{
+			name: "fallback cycle",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bigcore", Fallbacks: []string{"burst"}},
+					{Name: "burst", Fallbacks: []string{"bigcore"}},
+				},
+			},
+			userDefs:      2,
+			expectedError: "(at balloonTypes[1].fallbacks[0])",
+		},
+		{
+			name: "whole numa nodes with cpu counts",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", WholeNumaNodes: 1, MinCpus: 2},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].wholeNumaNodes)",
+		},
+		{
+			name: "exclusive LLC with shared pool only",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", ExclusiveLLC: true, SharedPoolOnly: true},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].exclusiveLLC)",
+		},
+		{
+			name: "aggregate pod cpus with spreading pods",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", AggregatePodCpus: true, PreferSpreadingPods: true},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].aggregatePodCPUs)",
+		},
+		{
+			name: "undefined cpu profile",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", CpuProfile: "turbo"},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].cpuProfile)",
+		},
+		{
+			name: "overlay balloon type with minCPUs",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", Overlay: true, MinCpus: 2},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].overlay)",
+		},
+		{
+			name: "bypass selector without namespaces or labels",
+			bpoptions: &BalloonsOptions{
+				BypassSelector: &PodSelector{},
+			},
+			expectedError: "(at bypassSelector)",
+		},
+		{
+			name: "bypass selector with invalid label selector",
+			bpoptions: &BalloonsOptions{
+				BypassSelector: &PodSelector{
+					LabelSelector: &metav1.LabelSelector{
+						MatchExpressions: []metav1.LabelSelectorRequirement{
+							{Key: "app", Operator: "Near"},
+						},
+					},
+				},
+			},
+			expectedError: "(at bypassSelector)",
+		},
+		{
+			name: "too large memory bandwidth reservation",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "bad", MinMemBandwidthPct: 120},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].minMemBandwidthPct)",
+		},
+		{
+			name: "index skips implicit balloon types",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					reserved,
+					{Name: "dup"},
+					{Name: "dup"},
+				},
+			},
+			userDefs:      2,
+			expectedError: "(at balloonTypes[1].name)",
+		},
+		{
+			name: "invalid container name pattern",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "proxy", ContainerNamePatterns: []string{"*-proxy", "["}},
+				},
+			},
+			userDefs:      1,
+			expectedError: "(at balloonTypes[0].containerNamePatterns[1])",
+		},
+		{
+			name: "unknown balloon type to avoid",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: "noisy"},
+					{Name: "loud", AvoidSameSocketAs: []string{"noisy", "silent"}},
+				},
+			},
+			userDefs:      2,
+			expectedError: "(at balloonTypes[1].avoidSameSocketAs[1])",
+		},
+		{
+			name: "implicit balloon type",
+			bpoptions: &BalloonsOptions{
+				BalloonDefs: []*BalloonDef{
+					{Name: reservedBalloonDefName, MinBalloons: 2},
+				},
+			},
+			expectedError: `(at balloonTypes[implicit "reserved"].minBalloons)`,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			defs := tc.bpoptions.BalloonDefs
+			userDefs := defs[len(defs)-tc.userDefs:]
+			err := p.validateConfig(tc.bpoptions, userDefs)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectedError) {
+				t.Errorf("expected error ending with %q, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestLocalityScore(t *testing.T) {
+	p := newTestPolicy(t, [5]int{2, 2, 2, 4, 2})
+	tcases := []struct {
+		name          string
+		cpus          cpuset.CPUSet
+		expectedScore int
+	}{
+		{
+			name:          "no cpus",
+			cpus:          cpuset.New(),
+			expectedScore: 0,
+		},
+		{
+			name:          "single numa node",
+			cpus:          cpuset.New(0, 1, 2, 3),
+			expectedScore: 3,
+		},
+		{
+			name:          "two numa nodes on the same die",
+			cpus:          cpuset.New(0, 8),
+			expectedScore: 4,
+		},
+		{
+			name:          "two packages",
+			cpus:          cpuset.New(0, 32),
+			expectedScore: 6,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			score := p.localityScore(tc.cpus)
+			if score != tc.expectedScore {
+				t.Errorf("expected score %d, got %d", tc.expectedScore, score)
+			}
+		})
+	}
+}
+
+// fakeContainer implements the parts of cache.Container used in tests.
+// Unless set explicitly, its pod is a pod with the given pod ID, if any.
+type fakeContainer struct {
+	cache.Container
+	id          string
+	name        string
+	namespace   string
+	podID       string
+	pod         *fakePod
+	qos         corev1.PodQOSClass
+	cpuRequest  string
+	memLimit    string
+	annotations map[string]string
+	state       cache.ContainerState
+	pending     bool
+	cgroupDir   string
+	cpusetCpus  string
+	cpusetMems  string
+	cpuShares   int64
+	unified     map[string]string
+}
+
+func (c *fakeContainer) GetID() string {
+	if c.id == "" {
+		return c.name
+	}
+	return c.id
+}
+
+func (c *fakeContainer) PrettyName() string {
+	prefix := c.podID
+	if prefix == "" {
+		prefix = c.namespace
+	}
+	if prefix == "" {
+		return c.GetID()
+	}
+	return prefix + "/" + c.GetID()
+}
+
+func (c *fakeContainer) GetPod() (cache.Pod, bool) {
+	if c.pod != nil {
+		return c.pod, true
+	}
+	if c.podID != "" {
+		return &fakePod{id: c.podID, namespace: c.namespace}, true
+	}
+	return nil, false
+}
+
+func (c *fakeContainer) GetQOSClass() corev1.PodQOSClass {
+	if c.qos == "" {
+		return corev1.PodQOSBurstable
+	}
+	return c.qos
+}
+
+func (c *fakeContainer) GetResourceRequirements() corev1.ResourceRequirements {
+	r := corev1.ResourceRequirements{}
+	if c.cpuRequest != "" {
+		r.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(c.cpuRequest)}
+	}
+	if c.memLimit != "" {
+		r.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(c.memLimit)}
+	}
+	return r
+}
+
+func (c *fakeContainer) GetEffectiveAnnotation(key string) (string, bool) {
+	value, ok := c.annotations[key]
+	return value, ok
+}
+
+func (c *fakeContainer) GetName() string                { return c.name }
+func (c *fakeContainer) GetNamespace() string           { return c.namespace }
+func (c *fakeContainer) GetPodID() string               { return c.podID }
+func (c *fakeContainer) GetState() cache.ContainerState { return c.state }
+func (c *fakeContainer) HasPending(string) bool         { return c.pending }
+func (c *fakeContainer) GetCgroupDir() string           { return c.cgroupDir }
+func (c *fakeContainer) GetCpusetCpus() string          { return c.cpusetCpus }
+func (c *fakeContainer) GetCpusetMems() string          { return c.cpusetMems }
+func (c *fakeContainer) SetUnified(key, value string) {
+	if c.unified == nil {
+		c.unified = map[string]string{}
+	}
+	c.unified[key] = value
+}
+func (c *fakeContainer) GetCPUShares() int64           { return c.cpuShares }
+func (c *fakeContainer) SetCPUShares(value int64)      { c.cpuShares = value }
+func (c *fakeContainer) SetCpusetCpus(value string)    { c.cpusetCpus = value }
+func (c *fakeContainer) SetCpusetMems(value string)    { c.cpusetMems = value }
+func (c *fakeContainer) PreserveCpuResources() bool    { return false }
+func (c *fakeContainer) PreserveMemoryResources() bool { return false }
+func (c *fakeContainer) MemoryTypes() (libmem.TypeMask, error) {
+	return 0, nil
+}
+func (c *fakeContainer) GetResourceUpdates() (corev1.ResourceRequirements, bool) {
+	return corev1.ResourceRequirements{}, false
+}
+
+// fakePod implements the parts of cache.Pod used in tests.
+type fakePod struct {
+	cache.Pod
+	id         string
+	namespace  string
+	labels     map[string]string
+	qos        corev1.PodQOSClass
+	containers []cache.Container
+	cgroup     string
+}
+
+func (p *fakePod) GetID() string                    { return p.id }
+func (p *fakePod) GetNamespace() string             { return p.namespace }
+func (p *fakePod) GetQOSClass() corev1.PodQOSClass  { return p.qos }
+func (p *fakePod) GetContainers() []cache.Container { return p.containers }
+func (p *fakePod) GetCgroupParent() string          { return p.cgroup }
+func (p *fakePod) GetLabel(key string) (string, bool) {
+	value, ok := p.labels[key]
+	return value, ok
+}
+
+// fakeCache is a cache of the given pods and containers. Policy
+// entries are stored in memory.
+type fakeCache struct {
+	cache.Cache
+	pods       map[string]cache.Pod
+	containers map[string]cache.Container
+	entries    map[string]interface{}
+	saves      int
+}
+
+func (c *fakeCache) Save() error {
+	c.saves++
+	return nil
+}
+
+func (c *fakeCache) LookupPod(id string) (cache.Pod, bool) {
+	pod, ok := c.pods[id]
+	return pod, ok
+}
+
+func (c *fakeCache) LookupContainer(id string) (cache.Container, bool) {
+	ctr, ok := c.containers[id]
+	return ctr, ok
+}
+
+func (c *fakeCache) SetPolicyEntry(key string, obj interface{}) {
+	if c.entries == nil {
+		c.entries = map[string]interface{}{}
+	}
+	c.entries[key] = obj
+}
+
+func (c *fakeCache) GetPolicyEntry(key string, ptr interface{}) bool {
+	obj, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	ptr.(cache.Cacheable).Set(obj.(cache.Cacheable).Get())
+	return true
+}
+
+// fakeSystem implements the parts of sysfs.System used in tests. CPU
+// packages and NUMA nodes are given by their CPUs.
+type fakeSystem struct {
+	sysfs.System
+	cpus        cpuset.CPUSet
+	packages    []cpuset.CPUSet
+	nodes       []cpuset.CPUSet
+	allowedMems cpuset.CPUSet
+}
+
+type fakePackage struct {
+	sysfs.CPUPackage
+	cpus  cpuset.CPUSet
+	nodes []idset.ID
+}
+
+type fakeNode struct {
+	sysfs.Node
+	cpus cpuset.CPUSet
+}
+
+func (s *fakeSystem) CPUSet() cpuset.CPUSet               { return s.cpus }
+func (s *fakeSystem) Offlined() cpuset.CPUSet             { return cpuset.New() }
+func (s *fakeSystem) Isolated() cpuset.CPUSet             { return cpuset.New() }
+func (s *fakeSystem) EffectiveAllowedCPUs() cpuset.CPUSet { return cpuset.New() }
+func (s *fakeSystem) EffectiveAllowedMems() cpuset.CPUSet { return s.allowedMems }
+
+func (s *fakeSystem) PackageIDs() []idset.ID {
+	ids := []idset.ID{}
+	for id := range s.packages {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s *fakeSystem) Package(id idset.ID) sysfs.CPUPackage {
+	pkg := &fakePackage{cpus: s.packages[id]}
+	for nodeID, cpus := range s.nodes {
+		if cpus.IsSubsetOf(pkg.cpus) {
+			pkg.nodes = append(pkg.nodes, nodeID)
+		}
+	}
+	return pkg
+}
+
+func (s *fakeSystem) NodeIDs() []idset.ID {
+	ids := []idset.ID{}
+	for id := range s.nodes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s *fakeSystem) Node(id idset.ID) sysfs.Node {
+	return &fakeNode{cpus: s.nodes[id]}
+}
+
+func (p *fakePackage) CPUSet() cpuset.CPUSet { return p.cpus }
+func (p *fakePackage) NodeIDs() []idset.ID   { return p.nodes }
+func (n *fakeNode) CPUSet() cpuset.CPUSet    { return n.cpus }
+
+// fakeCpuAllocator allocates the lowest free CPUs.
+type fakeCpuAllocator struct {
+	priorities  map[cpuallocator.CPUPriority]cpuset.CPUSet
+	cacheGroups []cpuset.CPUSet
+}
+
+func (a *fakeCpuAllocator) AllocateCpus(from *cpuset.CPUSet, cnt int, _ ...cpuallocator.Option) (cpuset.CPUSet, error) {
+	if from.Size() < cnt {
+		return cpuset.New(), fmt.Errorf("cannot allocate %d CPUs from %q", cnt, *from)
+	}
+	cpus := cpuset.New(from.List()[:cnt]...)
+	*from = from.Difference(cpus)
+	return cpus, nil
+}
+
+func (a *fakeCpuAllocator) ReleaseCpus(from *cpuset.CPUSet, cnt int, _ ...cpuallocator.Option) (cpuset.CPUSet, error) {
+	return cpuset.New(), nil
+}
+
+func (a *fakeCpuAllocator) GetCPUPriorities() map[cpuallocator.CPUPriority]cpuset.CPUSet {
+	return a.priorities
+}
+
+func (a *fakeCpuAllocator) CacheGroups() []cpuset.CPUSet {
+	return a.cacheGroups
+}
+
+func (a *fakeCpuAllocator) RefreshTopology() {}
+
+// newTestPolicy returns a policy for the CPU topology of newCpuTreeFromInt5
+// with all CPUs allowed and free. Each nodeCpus adds a NUMA node with 1G of
+// DRAM close to the given CPUs.
+func newTestPolicy(t *testing.T, topology [5]int, nodeCpus ...string) *balloons {
+	t.Helper()
+	tree, _ := newCpuTreeFromInt5(topology)
+	sys := &fakeSystem{cpus: tree.cpus}
+	p := &balloons{
+		options:          &policy.BackendOptions{System: sys},
+		bpoptions:        &BalloonsOptions{},
+		cch:              &fakeCache{pods: map[string]cache.Pod{}, containers: map[string]cache.Container{}},
+		allowed:          tree.cpus,
+		reserved:         cpuset.New(),
+		freeCpus:         tree.cpus,
+		cpuTree:          tree,
+		cpuAllocator:     &fakeCpuAllocator{},
+		memAllocFailures: map[string]error{},
+		stickyCpus:       map[string]string{},
+		pinnedCpus:       map[string]cpuset.CPUSet{},
+		verifyCpus:       map[string]cpuset.CPUSet{},
+		unsharedIdle:     cpuset.New(),
+	}
+	if len(nodeCpus) == 0 {
+		return p
+	}
+	nodes := []*libmem.Node{}
+	for id, cpus := range nodeCpus {
+		distance := make([]int, len(nodeCpus))
+		for i := range distance {
+			distance[i] = 20
+		}
+		distance[id] = 10
+		node, err := libmem.NewNode(libmem.ID(id), libmem.TypeDRAM, 1<<30, true, cpuset.MustParse(cpus), distance)
+		if err != nil {
+			t.Fatalf("failed to create memory node %d: %v", id, err)
+		}
+		nodes = append(nodes, node)
+		sys.nodes = append(sys.nodes, cpuset.MustParse(cpus))
+	}
+	malloc, err := libmem.NewAllocator(libmem.WithNodes(nodes))
+	if err != nil {
+		t.Fatalf("failed to create memory allocator: %v", err)
+	}
+	p.memAllocator = malloc
+	return p
+}
+
+// newTestBalloon returns an empty balloon of blnDef on the given CPUs.
+func newTestBalloon(p *balloons, blnDef *BalloonDef, cpus string) *Balloon {
+	return &Balloon{
+		Def:            blnDef,
+		Cpus:           cpuset.MustParse(cpus),
+		SharedIdleCpus: cpuset.New(),
+		PodIDs:         map[string][]string{},
+		cpuTreeAlloc:   p.cpuTree.NewAllocator(cpuTreeAllocatorOptions{}),
+	}
+}
+
+func TestRelaxOnFailure(t *testing.T) {
+	pCores := cpuset.MustParse("0-3")
+	eCores := cpuset.MustParse("4-15")
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 2})
+	p.cpuAllocator = &fakeCpuAllocator{
+		priorities: map[cpuallocator.CPUPriority]cpuset.CPUSet{
+			cpuallocator.PriorityHigh: pCores,
+			cpuallocator.PriorityLow:  eCores,
+		},
+	}
+	blnDef := &BalloonDef{
+		Name:           "perf",
+		PreferCoreType: "performance",
+		RelaxOnFailure: []string{relaxCoreType},
+	}
+
+	tcases := []struct {
+		name        string
+		free        cpuset.CPUSet
+		cnt         int
+		expectPCore bool
+	}{
+		{
+			name:        "enough performance cores",
+			free:        cpuset.MustParse("0-15"),
+			cnt:         4,
+			expectPCore: true,
+		},
+		{
+			name:        "too few performance cores, relax core type",
+			free:        cpuset.MustParse("2-15"),
+			cnt:         4,
+			expectPCore: false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ta := p.cpuTree.NewAllocator(cpuTreeAllocatorOptions{})
+			cpus, err := p.allocateCpus(blnDef, ta, cpuset.New(), tc.free, tc.cnt)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cpus.Size() != tc.cnt {
+				t.Fatalf("expected %d CPUs, got %q", tc.cnt, cpus)
+			}
+			if !cpus.IsSubsetOf(tc.free) {
+				t.Errorf("allocated CPUs %q are not free (%q)", cpus, tc.free)
+			}
+			if onlyP := cpus.IsSubsetOf(pCores); onlyP != tc.expectPCore {
+				t.Errorf("expected only performance cores %v, got %q", tc.expectPCore, cpus)
+			}
+		})
+	}
+}
+
+func TestExclusiveLLC(t *testing.T) {
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 2})
+	p.cpuAllocator = &fakeCpuAllocator{
+		cacheGroups: []cpuset.CPUSet{
+			cpuset.MustParse("0-3"),
+			cpuset.MustParse("4-7"),
+			cpuset.MustParse("8-11"),
+			cpuset.MustParse("12-15"),
+		},
+	}
+
+	tcases := []struct {
+		name        string
+		relax       []string
+		current     cpuset.CPUSet
+		free        cpuset.CPUSet
+		cnt         int
+		expected    cpuset.CPUSet
+		expectError bool
+	}{
+		{
+			name:     "round up to a whole group",
+			free:     cpuset.MustParse("0-15"),
+			cnt:      2,
+			expected: cpuset.MustParse("0-3"),
+		},
+		{
+			name:     "skip partially used groups",
+			free:     cpuset.MustParse("1-14"),
+			cnt:      6,
+			expected: cpuset.MustParse("4-11"),
+		},
+		{
+			name:     "complete a group of the balloon first",
+			current:  cpuset.MustParse("8-9"),
+			free:     cpuset.MustParse("0-7,10-15"),
+			cnt:      2,
+			expected: cpuset.MustParse("10-11"),
+		},
+		{
+			name:        "no free groups",
+			free:        cpuset.MustParse("1,2,5,9,13"),
+			cnt:         2,
+			expectError: true,
+		},
+		{
+			name:     "no free groups, relax exclusive LLC",
+			relax:    []string{relaxExclusiveLLC},
+			free:     cpuset.MustParse("1,2,5,9,13"),
+			cnt:      2,
+			expected: cpuset.MustParse("1,2"),
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			blnDef := &BalloonDef{Name: "llc", ExclusiveLLC: true, RelaxOnFailure: tc.relax}
+			ta := p.cpuTree.NewAllocator(cpuTreeAllocatorOptions{})
+			cpus, err := p.allocateCpus(blnDef, ta, tc.current, tc.free, tc.cnt)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got CPUs %q", cpus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cpus.Equals(tc.expected) {
+				t.Errorf("expected CPUs %q, got %q", tc.expected, cpus)
+			}
+		})
+	}
+
+	bln := &Balloon{
+		Def:  &BalloonDef{Name: "llc", ExclusiveLLC: true},
+		Cpus: cpuset.MustParse("0-7,9"),
+	}
+	for _, tc := range []struct {
+		cnt      int
+		expected cpuset.CPUSet
+	}{
+		{cnt: 1, expected: cpuset.MustParse("9")},
+		{cnt: 3, expected: cpuset.MustParse("9")},
+		{cnt: 5, expected: cpuset.MustParse("4-7,9")},
+		{cnt: 9, expected: cpuset.MustParse("0-7,9")},
+	} {
+		if cpus := p.cacheGroupCpusToRelease(bln, tc.cnt); !cpus.Equals(tc.expected) {
+			t.Errorf("releasing %d CPUs: expected %q, got %q", tc.cnt, tc.expected, cpus)
+		}
+	}
+}
+
+func TestAvoidSameSocketAs(t *testing.T) {
+	noisyDef := &BalloonDef{Name: "noisy"}
+	loudDef := &BalloonDef{Name: "loud", AvoidSameSocketAs: []string{"noisy", "loud"}}
+
+	tcases := []struct {
+		name     string
+		balloons []*Balloon
+		current  cpuset.CPUSet
+		free     cpuset.CPUSet
+		cnt      int
+		expected cpuset.CPUSet
+	}{
+		{
+			name:     "no balloons to avoid",
+			free:     cpuset.MustParse("0-15"),
+			cnt:      4,
+			expected: cpuset.MustParse("0-3"),
+		},
+		{
+			name: "avoid socket of another balloon type",
+			balloons: []*Balloon{
+				{Def: noisyDef, Cpus: cpuset.MustParse("0-1")},
+			},
+			free:     cpuset.MustParse("2-15"),
+			cnt:      4,
+			expected: cpuset.MustParse("8-11"),
+		},
+		{
+			name: "ignore own socket",
+			balloons: []*Balloon{
+				{Def: loudDef, Cpus: cpuset.MustParse("0-1")},
+			},
+			current:  cpuset.MustParse("0-1"),
+			free:     cpuset.MustParse("2-15"),
+			cnt:      2,
+			expected: cpuset.MustParse("2-3"),
+		},
+		{
+			name: "cannot avoid, allocate from any socket",
+			balloons: []*Balloon{
+				{Def: noisyDef, Cpus: cpuset.MustParse("0-1")},
+				{Def: noisyDef, Cpus: cpuset.MustParse("8-13")},
+			},
+			free:     cpuset.MustParse("2-7,14-15"),
+			cnt:      4,
+			expected: cpuset.MustParse("2-5"),
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPolicy(t, [5]int{2, 1, 1, 4, 2})
+			p.options.System.(*fakeSystem).packages = []cpuset.CPUSet{
+				cpuset.MustParse("0-7"),
+				cpuset.MustParse("8-15"),
+			}
+			p.balloons = tc.balloons
+			ta := p.cpuTree.NewAllocator(cpuTreeAllocatorOptions{})
+			cpus, err := p.allocateCpus(loudDef, ta, tc.current, tc.free, tc.cnt)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cpus.Equals(tc.expected) {
+				t.Errorf("expected CPUs %q, got %q", tc.expected, cpus)
+			}
+		})
+	}
+}
+
+func TestIrqAffinity(t *testing.T) {
+	dir := t.TempDir()
+	orig := map[int]string{
+		1: "0-7",
+		2: "2-3",
+		3: "4",
+	}
+	for irq, cpus := range orig {
+		irqDir := filepath.Join(dir, strconv.Itoa(irq))
+		if err := os.Mkdir(irqDir, 0755); err != nil {
+			t.Fatalf("failed to create IRQ directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(irqDir, irqAffinityEntry), []byte(cpus+"\n"), 0644); err != nil {
+			t.Fatalf("failed to create IRQ affinity: %v", err)
+		}
+	}
+	affinity := func(irq int) string {
+		data, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(irq), irqAffinityEntry))
+		if err != nil {
+			t.Fatalf("failed to read IRQ affinity: %v", err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	m := newIrqAffinity(dir)
+
+	m.moveAway(cpuset.MustParse("2-3"), cpuset.MustParse("0"))
+	for irq, expected := range map[int]string{1: "0-1,4-7", 2: "0", 3: "4"} {
+		if got := affinity(irq); got != expected {
+			t.Errorf("IRQ %d: expected affinity %q, got %q", irq, expected, got)
+		}
+	}
+
+	m.moveAway(cpuset.MustParse("4"), cpuset.MustParse("0"))
+	for irq, expected := range map[int]string{1: "0-3,5-7", 2: "2-3", 3: "0"} {
+		if got := affinity(irq); got != expected {
+			t.Errorf("IRQ %d: expected affinity %q, got %q", irq, expected, got)
+		}
+	}
+
+	m.restore()
+	for irq, expected := range orig {
+		if got := affinity(irq); got != expected {
+			t.Errorf("IRQ %d: expected restored affinity %q, got %q", irq, expected, got)
+		}
+	}
+}
+
+func TestSharedPoolCpus(t *testing.T) {
+	reservedDef := &BalloonDef{Name: reservedBalloonDefName}
+	sharedDef := &BalloonDef{Name: "batch", SharedPoolOnly: true}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+	p.reservedBalloonDef = reservedDef
+	p.reserved = cpuset.New(0)
+	p.freeCpus = cpuset.MustParse("4-7")
+	p.balloons = []*Balloon{
+		{Def: reservedDef, Cpus: cpuset.MustParse("0-1")},
+		{Def: sharedDef, Cpus: cpuset.New()},
+	}
+	if cpus := p.sharedPoolCpus(); !cpus.Equals(cpuset.MustParse("4-7")) {
+		t.Errorf("expected shared pool of free CPUs, got %q", cpus)
+	}
+	p.freeCpus = cpuset.New()
+	if cpus := p.sharedPoolCpus(); !cpus.Equals(cpuset.MustParse("0-1")) {
+		t.Errorf("expected shared pool of reserved balloon CPUs, got %q", cpus)
+	}
+	if avail := p.freeMilliCpus(p.balloons[1]); avail <= 0 {
+		t.Errorf("expected shared pool balloon to have free capacity, got %d", avail)
+	}
+}
+
+func TestOverlayCpus(t *testing.T) {
+	reservedDef := &BalloonDef{Name: reservedBalloonDefName}
+	appDef := &BalloonDef{Name: "app"}
+	overlayDef := &BalloonDef{Name: "monitoring", Overlay: true}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+	p.reservedBalloonDef = reservedDef
+	p.reserved = cpuset.New(0)
+	p.freeCpus = cpuset.MustParse("6-7")
+	p.balloons = []*Balloon{
+		{Def: reservedDef, Cpus: cpuset.MustParse("0-1")},
+		{Def: overlayDef, Cpus: cpuset.New()},
+	}
+	if cpus := p.overlayCpus(); !cpus.Equals(cpuset.MustParse("0-1")) {
+		t.Errorf("expected overlay of reserved balloon CPUs, got %q", cpus)
+	}
+	p.balloons = append(p.balloons,
+		&Balloon{Def: appDef, Cpus: cpuset.MustParse("2-3")},
+		&Balloon{Def: appDef, Instance: 1, Cpus: cpuset.MustParse("4-5")},
+	)
+	if cpus := p.overlayCpus(); !cpus.Equals(cpuset.MustParse("0-5")) {
+		t.Errorf("expected overlay of all balloon CPUs, got %q", cpus)
+	}
+	if cpus := p.overlayCpus(); cpus.Intersection(p.freeCpus).Size() > 0 {
+		t.Errorf("expected overlay not to include free CPUs, got %q", cpus)
+	}
+	if avail := p.freeMilliCpus(p.balloons[1]); avail <= 0 {
+		t.Errorf("expected overlay balloon to have free capacity, got %d", avail)
+	}
+}
+
+func TestNoShareExclusiveIdle(t *testing.T) {
+	latencyDef := &BalloonDef{Name: "latency", NoShareExclusiveIdle: true}
+	burstDef := &BalloonDef{Name: "burst"}
+	batchDef := &BalloonDef{Name: "batch", ShareIdleCpusInSame: CPUTopologyLevelSystem}
+	overlayDef := &BalloonDef{Name: "monitoring", Overlay: true}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1}, "0-7")
+	latency := newTestBalloon(p, latencyDef, "0-3")
+	burst := newTestBalloon(p, burstDef, "4-5")
+	batch := newTestBalloon(p, batchDef, "6")
+	p.freeCpus = cpuset.MustParse("7")
+	p.balloons = []*Balloon{latency, burst, batch, newTestBalloon(p, overlayDef, "")}
+	p.shareIdleCpus(p.freeCpus, cpuset.New())
+	if !batch.SharedIdleCpus.Equals(cpuset.New(7)) {
+		t.Fatalf("expected batch balloon to share idle CPU 7, got %q", batch.SharedIdleCpus)
+	}
+
+	// CPUs released by the latency balloon must not be shared.
+	if err := p.resizeBalloon(latency, 1000); err != nil {
+		t.Fatalf("failed to deflate latency balloon: %v", err)
+	}
+	released := cpuset.MustParse("0-3").Difference(latency.Cpus)
+	if released.Size() != 3 || !released.IsSubsetOf(p.freeCpus) {
+		t.Fatalf("expected 3 CPUs released to free CPUs %q, got %q", p.freeCpus, released)
+	}
+	if shared := batch.SharedIdleCpus.Intersection(released); !shared.IsEmpty() {
+		t.Errorf("batch balloon got CPUs %q released by latency balloon", shared)
+	}
+	p.updatePinning(p.shareIdleCpus(p.freeCpus, cpuset.New())...)
+	if shared := batch.SharedIdleCpus.Intersection(released); !shared.IsEmpty() {
+		t.Errorf("batch balloon got CPUs %q released by latency balloon on resharing", shared)
+	}
+	if cpus := p.overlayCpus(); cpus.Intersection(latency.Cpus).Size() > 0 {
+		t.Errorf("expected overlay not to run on latency balloon CPUs %q, got %q", latency.Cpus, cpus)
+	}
+
+	// CPUs released by other balloons are shared as usual.
+	p.deleteBalloon(burst)
+	p.updatePinning(p.shareIdleCpus(cpuset.MustParse("4-5"), cpuset.New())...)
+	if !batch.SharedIdleCpus.Equals(cpuset.MustParse("4,5,7")) {
+		t.Errorf("expected batch balloon to share idle CPUs 4,5,7, got %q", batch.SharedIdleCpus)
+	}
+
+	// Once allocated again, CPUs released by the latency balloon are
+	// shared like any other CPUs when released by other balloons.
+	if err := p.resizeBalloon(batch, 4000); err != nil {
+		t.Fatalf("failed to inflate batch balloon: %v", err)
+	}
+	if err := p.resizeBalloon(batch, 1000); err != nil {
+		t.Fatalf("failed to deflate batch balloon: %v", err)
+	}
+	if !p.unsharedCpus().IsSubsetOf(cpuset.MustParse("1-3")) {
+		t.Errorf("expected unshared CPUs within 1-3, got %q", p.unsharedCpus())
+	}
+	if !batch.SharedIdleCpus.Equals(p.freeCpus.Difference(p.unsharedCpus())) {
+		t.Errorf("expected batch balloon to share idle CPUs %q, got %q",
+			p.freeCpus.Difference(p.unsharedCpus()), batch.SharedIdleCpus)
+	}
+
+	// The latency balloon can still borrow idle CPUs of others.
+	latencyDef.ShareIdleCpusInSame = CPUTopologyLevelSystem
+	p.shareIdleCpus(p.freeCpus, cpuset.New())
+	if latency.SharedIdleCpus.IsEmpty() {
+		t.Errorf("expected latency balloon to share idle CPUs")
+	}
+}
+
+func TestIsLatencyCritical(t *testing.T) {
+	tcases := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:     "no annotation",
+			expected: false,
+		},
+		{
+			name:        "latency-critical",
+			annotations: map[string]string{latencyCriticalKey: "true"},
+			expected:    true,
+		},
+		{
+			name:        "explicitly not latency-critical",
+			annotations: map[string]string{latencyCriticalKey: "false"},
+			expected:    false,
+		},
+		{
+			name:        "invalid value",
+			annotations: map[string]string{latencyCriticalKey: "very"},
+			expected:    false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeContainer{id: "ctr", annotations: tc.annotations}
+			if got := isLatencyCritical(c); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestBypassSelector(t *testing.T) {
+	selector := &PodSelector{
+		Namespaces: []string{"kube-system", "monitoring"},
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"tier": "system"},
+		},
+	}
+	tcases := []struct {
+		name     string
+		selector *PodSelector
+		pod      *fakePod
+		bypassed bool
+	}{
+		{
+			name:     "no selector",
+			pod:      &fakePod{namespace: "kube-system", labels: map[string]string{"tier": "system"}},
+			bypassed: false,
+		},
+		{
+			name:     "matching namespace and labels",
+			selector: selector,
+			pod:      &fakePod{namespace: "monitoring", labels: map[string]string{"tier": "system"}},
+			bypassed: true,
+		},
+		{
+			name:     "other namespace",
+			selector: selector,
+			pod:      &fakePod{namespace: "default", labels: map[string]string{"tier": "system"}},
+			bypassed: false,
+		},
+		{
+			name:     "other labels",
+			selector: selector,
+			pod:      &fakePod{namespace: "kube-system", labels: map[string]string{"tier": "app"}},
+			bypassed: false,
+		},
+		{
+			name:     "namespace only",
+			selector: &PodSelector{Namespaces: []string{"kube-system"}},
+			pod:      &fakePod{namespace: "kube-system"},
+			bypassed: true,
+		},
+		{
+			name: "label expression only",
+			selector: &PodSelector{
+				LabelSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "kubelet-cpuset", Operator: metav1.LabelSelectorOpExists},
+					},
+				},
+			},
+			pod:      &fakePod{namespace: "default", labels: map[string]string{"kubelet-cpuset": ""}},
+			bypassed: true,
+		},
+		{
+			name:     "container without pod",
+			selector: selector,
+			bypassed: false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &balloons{bpoptions: &BalloonsOptions{BypassSelector: tc.selector}}
+			if bypassed := p.isBypassed(&fakeContainer{pod: tc.pod}); bypassed != tc.bypassed {
+				t.Errorf("expected bypassed %v, got %v", tc.bypassed, bypassed)
+			}
+		})
+	}
+}
+
+func TestContainerNamePatterns(t *testing.T) {
+	proxy := &BalloonDef{Name: "proxy", ContainerNamePatterns: []string{"*-proxy"}}
+	system := &BalloonDef{Name: "system", Namespaces: []string{"kube-system"}}
+	dflt := &BalloonDef{Name: defaultBalloonDefName}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 4, 1})
+	p.defaultBalloonDef = dflt
+	p.bpoptions.BalloonDefs = []*BalloonDef{system, proxy, dflt}
+	tcases := []struct {
+		name      string
+		container *fakeContainer
+		expected  *BalloonDef
+	}{
+		{
+			name:      "matching container name",
+			container: &fakeContainer{name: "envoy-proxy", namespace: "default"},
+			expected:  proxy,
+		},
+		{
+			name:      "other container name",
+			container: &fakeContainer{name: "envoy", namespace: "default"},
+			expected:  dflt,
+		},
+		{
+			name:      "earlier balloon type matches namespace",
+			container: &fakeContainer{name: "envoy-proxy", namespace: "kube-system"},
+			expected:  system,
+		},
+		{
+			name: "annotation overrides container name",
+			container: &fakeContainer{
+				name:        "envoy-proxy",
+				namespace:   "default",
+				annotations: map[string]string{balloonKey: "system"},
+			},
+			expected: system,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			blnDef, err := p.chooseBalloonDef(tc.container)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if blnDef != tc.expected {
+				t.Errorf("expected balloon type %q, got %q", tc.expected.Name, blnDef.Name)
+			}
+		})
+	}
+}
+
+func TestContainerMemTypes(t *testing.T) {
+	newAllocator := func() *libmem.Allocator {
+		dram, err := libmem.NewNode(0, libmem.TypeDRAM, 4<<30, true, cpuset.MustParse("0-3"), []int{10, 20})
+		if err != nil {
+			t.Fatalf("failed to create DRAM node: %v", err)
+		}
+		hbm, err := libmem.NewNode(1, libmem.TypeHBM, 1<<30, true, cpuset.New(), []int{20, 10})
+		if err != nil {
+			t.Fatalf("failed to create HBM node: %v", err)
+		}
+		a, err := libmem.NewAllocator(libmem.WithNodes([]*libmem.Node{dram, hbm}))
+		if err != nil {
+			t.Fatalf("failed to create memory allocator: %v", err)
+		}
+		return a
+	}
+
+	tcases := []struct {
+		name        string
+		annotations map[string]string
+		expectMask  libmem.TypeMask
+		expectZone  libmem.NodeMask
+		expectError bool
+	}{
+		{
+			name:       "balloon default",
+			expectZone: libmem.NewNodeMask(0),
+		},
+		{
+			name:        "override to HBM",
+			annotations: map[string]string{memoryTypesKey: "HBM"},
+			expectMask:  libmem.TypeMaskHBM,
+			expectZone:  libmem.NewNodeMask(1),
+		},
+		{
+			name:        "override to HBM and DRAM",
+			annotations: map[string]string{memoryTypesKey: "HBM, DRAM"},
+			expectMask:  libmem.TypeMaskHBM | libmem.TypeMaskDRAM,
+			expectZone:  libmem.NewNodeMask(0, 1),
+		},
+		{
+			name:        "invalid type",
+			annotations: map[string]string{memoryTypesKey: "HBM,FOO"},
+			expectError: true,
+		},
+		{
+			name:        "no types",
+			annotations: map[string]string{memoryTypesKey: " , "},
+			expectError: true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeContainer{id: "ctr", annotations: tc.annotations}
+			mask, err := containerMemTypes(c)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got mask %s", mask)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mask != tc.expectMask {
+				t.Fatalf("expected mask %s, got %s", tc.expectMask, mask)
+			}
+
+			if mask == 0 {
+				mask = libmem.TypeMaskDRAM
+			}
+			p := &balloons{memAllocator: newAllocator()}
+			zone := p.allocMem(c, idset.NewIDSet(0, 1), mask, false)
+			if zone != tc.expectZone {
+				t.Errorf("expected zone %s, got %s", tc.expectZone, zone)
+			}
+		})
+	}
+}
+
+func TestMemAllocFailure(t *testing.T) {
+	tcases := []struct {
+		name       string
+		onFailure  MemoryAllocFailure
+		expectZone libmem.NodeMask
+		expectFail bool
+	}{
+		{
+			name:       "default falls back",
+			expectZone: libmem.NewNodeMask(0),
+		},
+		{
+			name:       "fall back",
+			onFailure:  MemoryAllocFailureFallback,
+			expectZone: libmem.NewNodeMask(0),
+		},
+		{
+			name:       "widen",
+			onFailure:  MemoryAllocFailureWiden,
+			expectZone: libmem.NewNodeMask(0, 1),
+		},
+		{
+			name:       "fail",
+			onFailure:  MemoryAllocFailureFail,
+			expectZone: libmem.NewNodeMask(0),
+			expectFail: true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+			p.bpoptions.OnMemoryAllocFailure = tc.onFailure
+			// 3G does not fit in the 2G of memory in the system.
+			c := &fakeContainer{id: "ctr", memLimit: "3G"}
+			zone := p.allocMem(c, idset.NewIDSet(0), libmem.TypeMaskDRAM, false)
+			if zone != tc.expectZone {
+				t.Errorf("expected zone %s, got %s", tc.expectZone, zone)
+			}
+			err := p.memAllocFailed(c)
+			if tc.expectFail != (err != nil) {
+				t.Errorf("expected failure %v, got error %v", tc.expectFail, err)
+			}
+			if err := p.memAllocFailed(c); err != nil {
+				t.Errorf("expected failure to be consumed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLatencyCriticalLocalMem(t *testing.T) {
+	tcases := []struct {
+		name       string
+		critical   bool
+		expectZone libmem.NodeMask
+	}{
+		{
+			name:       "expand to remote memory",
+			expectZone: libmem.NewNodeMask(0, 1),
+		},
+		{
+			name:       "latency-critical, local memory only",
+			critical:   true,
+			expectZone: libmem.NewNodeMask(0),
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+			c := &fakeContainer{
+				id:          "ctr",
+				annotations: map[string]string{},
+				memLimit:    "1536M",
+			}
+			if tc.critical {
+				c.annotations[latencyCriticalKey] = "true"
+			}
+			// 1.5G does not fit in the 1G of local memory.
+			zone := p.allocMem(c, idset.NewIDSet(0), libmem.TypeMaskDRAM, false)
+			if zone != tc.expectZone {
+				t.Errorf("expected zone %s, got %s", tc.expectZone, zone)
+			}
+		})
+	}
+}
+
+func TestReservedMems(t *testing.T) {
+	p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+
+	tcases := []struct {
+		name          string
+		memory        string
+		expected      libmem.NodeMask
+		expectedError string
+	}{
+		{
+			name: "no reserved memory",
+		},
+		{
+			name:     "reserved node",
+			memory:   "1",
+			expected: libmem.NewNodeMask(1),
+		},
+		{
+			name:     "reserved cpuset style node",
+			memory:   "cpuset:0",
+			expected: libmem.NewNodeMask(0),
+		},
+		{
+			name:          "invalid node set",
+			memory:        "2G",
+			expectedError: "(at reservedResources.memory)",
+		},
+		{
+			name:          "unknown node",
+			memory:        "1-2",
+			expectedError: "(at reservedResources.memory)",
+		},
+		{
+			name:          "all nodes reserved",
+			memory:        "0,1",
+			expectedError: "(at reservedResources.memory)",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			bpoptions := &BalloonsOptions{}
+			if tc.memory != "" {
+				bpoptions.ReservedResources = cfgapi.Constraints{
+					cfgapi.Memory: cfgapi.Amount(tc.memory),
+				}
+			}
+			nodes, err := reservedMemNodes(bpoptions)
+			if err == nil {
+				err = p.validateReservedMems(nodes)
+			}
+			if tc.expectedError != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tc.expectedError) {
+					t.Errorf("expected error ending with %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if nodes != tc.expected {
+				t.Errorf("expected reserved nodes %s, got %s", tc.expected, nodes)
+			}
+		})
+	}
+
+	p.reservedMems = libmem.NewNodeMask(1)
+	if allowed := p.allowedMemsOf(false); allowed != libmem.NewNodeMask(0) {
+		t.Errorf("expected other balloons to be allowed nodes %s, got %s", libmem.NewNodeMask(0), allowed)
+	}
+	if allowed := p.allowedMemsOf(true); allowed != 0 {
+		t.Errorf("expected the reserved balloon to be allowed any nodes, got %s", allowed)
+	}
+}
+
+func TestAllowedMemNodes(t *testing.T) {
+	available := libmem.NewNodeMask(0, 1, 2, 3)
+	tcases := []struct {
+		name     string
+		allowed  string
+		expected libmem.NodeMask
+	}{
+		{
+			name:     "all nodes allowed for pods",
+			allowed:  "0-7",
+			expected: available,
+		},
+		{
+			name:     "some nodes allowed for pods",
+			allowed:  "1,3",
+			expected: libmem.NewNodeMask(1, 3),
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			sys := &fakeSystem{allowedMems: cpuset.MustParse(tc.allowed)}
+			if got := allowedMemNodes(sys, available); got != tc.expected {
+				t.Errorf("expected allowed memory nodes %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestBalloonLogLevel(t *testing.T) {
+	tcases := []struct {
+		name          string
+		level         BalloonLogLevel
+		policyDebug   bool
+		expectEnabled bool
+	}{
+		{
+			name:          "default level, policy debug enabled",
+			policyDebug:   true,
+			expectEnabled: true,
+		},
+		{
+			name:          "default level, policy debug disabled",
+			expectEnabled: false,
+		},
+		{
+			name:          "debug level, policy debug disabled",
+			level:         BalloonLogLevelDebug,
+			expectEnabled: true,
+		},
+		{
+			name:          "info level, policy debug enabled",
+			level:         BalloonLogLevelInfo,
+			policyDebug:   true,
+			expectEnabled: false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			bln := &Balloon{Def: &BalloonDef{Name: "b0", LogLevel: tc.level}}
+			if enabled := detailedLogsEnabled(blnLog(bln).level, tc.policyDebug); enabled != tc.expectEnabled {
+				t.Errorf("expected detailed logs enabled %v, got %v", tc.expectEnabled, enabled)
+			}
+		})
+	}
+}
+
+func TestPinsMemory(t *testing.T) {
+	yes, no := true, false
+	tcases := []struct {
+		name      string
+		pinMemory *bool
+		defs      []*BalloonDef
+		expected  bool
+	}{
+		{
+			name:     "default policy pinning",
+			defs:     []*BalloonDef{{Name: "b0"}},
+			expected: true,
+		},
+		{
+			name:      "policy pinning disabled",
+			pinMemory: &no,
+			defs:      []*BalloonDef{{Name: "b0"}},
+			expected:  false,
+		},
+		{
+			name:      "policy pinning disabled, enabled in a balloon type",
+			pinMemory: &no,
+			defs:      []*BalloonDef{{Name: "b0"}, {Name: "b1", PinMemory: &yes}},
+			expected:  true,
+		},
+		{
+			name:      "policy pinning enabled, disabled in all balloon types",
+			pinMemory: &yes,
+			defs:      []*BalloonDef{{Name: "b0", PinMemory: &no}},
+			expected:  false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &balloons{
+				bpoptions: &BalloonsOptions{PinMemory: tc.pinMemory, BalloonDefs: tc.defs},
+			}
+			if pins := p.pinsMemory(); pins != tc.expected {
+				t.Errorf("expected pinsMemory %v, got %v", tc.expected, pins)
+			}
+		})
+	}
+}
+
+func TestBalloonOpStats(t *testing.T) {
+	count := func(s *balloonOpStats, blnType, op string) float64 {
+		m := &dto.Metric{}
+		if err := s.count.WithLabelValues(blnType, op).Write(m); err != nil {
+			t.Fatalf("failed to read counter: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	series := func(s *balloonOpStats) int {
+		ch := make(chan prometheus.Metric, 64)
+		s.count.Collect(ch)
+		close(ch)
+		return len(ch)
+	}
+
+	s := newBalloonOpStats()
+	s.setTypes([]*BalloonDef{{Name: "default"}, {Name: "batch"}})
+	if n := series(s); n != 2*len(balloonOps) {
+		t.Errorf("expected %d pre-created series, got %d", 2*len(balloonOps), n)
+	}
+
+	start := time.Now()
+	s.observe("batch", opInflate, start)
+	s.observe("batch", opInflate, start)
+	s.observe("batch", opAllocate, start)
+	s.observe("unknown", opAllocate, start)
+	if v := count(s, "batch", opInflate); v != 2 {
+		t.Errorf("expected 2 inflations, got %v", v)
+	}
+	if v := count(s, "batch", opAllocate); v != 1 {
+		t.Errorf("expected 1 allocation, got %v", v)
+	}
+
+	s.setTypes([]*BalloonDef{{Name: "default"}})
+	if n := series(s); n != len(balloonOps) {
+		t.Errorf("expected %d series after removing a balloon type, got %d", len(balloonOps), n)
+	}
+
+	var nilStats *balloonOpStats
+	nilStats.observe("batch", opInflate, start)
+}
+
+func TestInflationStepCpuCount(t *testing.T) {
+	tcases := []struct {
+		name     string
+		maxStep  int
+		expected []int
+	}{
+		{
+			name:     "step larger than need",
+			maxStep:  32,
+			expected: []int{16},
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Inflate an empty balloon for a 16-CPU request until it fits.
+			sizes := []int{}
+			for count := 0; count < 16; {
+				count = inflationStepCpuCount(count, 16, tc.maxStep)
+				sizes = append(sizes, count)
+			}
+			if !slices.Equal(sizes, tc.expected) {
+				t.Errorf("expected sizes %v, got %v", tc.expected, sizes)
+			}
+		})
+	}
+	if count := inflationStepCpuCount(16, 4, 4); count != 4 {
+		t.Errorf("expected deflating to be unlimited, got %d CPUs", count)
+	}
+}
+
+func TestResizeBalloonInflationSteps(t *testing.T) {
+	tcases := []struct {
+		name      string
+		maxStep   int
+		freeCpus  string
+		expected  []int
+		remaining int
+	}{
+		{
+			name:      "unlimited",
+			freeCpus:  "0-19",
+			expected:  []int{16},
+			remaining: 4,
+		},
+		{
+			name:      "step of 4",
+			maxStep:   4,
+			freeCpus:  "0-19",
+			expected:  []int{4, 8, 12, 16},
+			remaining: 4,
+		},
+		{
+			name:      "free CPUs run out",
+			maxStep:   4,
+			freeCpus:  "0-9",
+			expected:  []int{4, 8},
+			remaining: 2,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPolicy(t, [5]int{1, 1, 1, 20, 1}, "0-19")
+			p.bpoptions.MaxInflationStep = tc.maxStep
+			p.freeCpus = cpuset.MustParse(tc.freeCpus)
+			bln := newTestBalloon(p, &BalloonDef{Name: "bt"}, "")
+			p.balloons = []*Balloon{bln}
+			defer p.stopInflationTimer()
+
+			// Resize for a 16-CPU request, then run inflation steps
+			// like the timer does until the balloon stops growing.
+			if err := p.resizeBalloon(bln, 16000); err != nil {
+				t.Fatalf("failed to resize balloon: %v", err)
+			}
+			sizes := []int{bln.Cpus.Size()}
+			for p.hasInflatingBalloons() {
+				if p.inflateStop == nil {
+					t.Fatalf("expected inflation timer running for %d more CPUs", 16-bln.Cpus.Size())
+				}
+				if !p.inflateBalloons() {
+					break
+				}
+				sizes = append(sizes, bln.Cpus.Size())
+			}
+			if !slices.Equal(sizes, tc.expected) {
+				t.Errorf("expected sizes %v, got %v", tc.expected, sizes)
+			}
+			if p.hasInflatingBalloons() || p.inflateStop != nil {
+				t.Errorf("expected inflation to stop, balloon still inflating to %d mCPU", bln.inflateMilliCpus)
+			}
+			if p.freeCpus.Size() != tc.remaining {
+				t.Errorf("expected %d free CPUs left, got %q", tc.remaining, p.freeCpus)
+			}
+		})
+	}
+}
+
+func TestFairShareMilliCpus(t *testing.T) {
+	tcases := []struct {
+		name         string
+		blnMilliCpus int
+		ctrRequest   int
+		blnRequests  int
+		ctrCount     int
+		expected     int
+	}{
+		{
+			name:         "no containers",
+			blnMilliCpus: 4000,
+			expected:     0,
+		},
+		{
+			name:         "single container gets all",
+			blnMilliCpus: 4000,
+			ctrRequest:   500,
+			blnRequests:  500,
+			ctrCount:     1,
+			expected:     4000,
+		},
+		{
+			name:         "divided by requests",
+			blnMilliCpus: 4000,
+			ctrRequest:   1000,
+			blnRequests:  4000,
+			ctrCount:     3,
+			expected:     1000,
+		},
+		{
+			name:         "container without request gets minimum",
+			blnMilliCpus: 4000,
+			ctrRequest:   0,
+			blnRequests:  4000,
+			ctrCount:     3,
+			expected:     fairShareMinMilliCpus,
+		},
+		{
+			name:         "small request raised to minimum",
+			blnMilliCpus: 1000,
+			ctrRequest:   10,
+			blnRequests:  1000,
+			ctrCount:     2,
+			expected:     fairShareMinMilliCpus,
+		},
+		{
+			name:         "divided evenly without requests",
+			blnMilliCpus: 4000,
+			ctrCount:     8,
+			expected:     500,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mCpu := fairShareMilliCpus(tc.blnMilliCpus, tc.ctrRequest, tc.blnRequests, tc.ctrCount)
+			if mCpu != tc.expected {
+				t.Errorf("expected %d mCPU, got %d", tc.expected, mCpu)
+			}
+		})
+	}
+}
+
+func TestStickyCpus(t *testing.T) {
+	ctr := &fakeContainer{name: "ctr"}
+	other := &fakeContainer{name: "other"}
+	cch := &fakeCache{containers: map[string]cache.Container{"ctr": ctr, "other": other}}
+	blnDef := &BalloonDef{Name: "sticky"}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1}, "0-7")
+	p.cch = cch
+	p.bpoptions.StickyCpus = true
+	p.freeCpus = cpuset.MustParse("1-7")
+	p.stickyCpus["ctr"] = "0,5-6"
+	bln := newTestBalloon(p, blnDef, "0")
+	p.balloons = []*Balloon{bln}
+
+	// Only a container with recorded CPUs gets a hint.
+	p.setStickyHint(other)()
+	if cpus := p.takeStickyCpus(blnDef, 2); !cpus.IsEmpty() {
+		t.Errorf("expected no previously used CPUs without a hint, got %q", cpus)
+	}
+
+	// Inflating a balloon reuses the recorded CPUs that are free.
+	clearHint := p.setStickyHint(ctr)
+	if cpus := p.takeStickyCpus(blnDef, 1); !cpus.Equals(cpuset.New(5)) {
+		t.Errorf("expected 1 previously used CPU 5, got %q", cpus)
+	}
+	if cpus := p.takeStickyCpus(&BalloonDef{Name: "llc", ExclusiveLLC: true}, 2); !cpus.IsEmpty() {
+		t.Errorf("expected no previously used CPUs for exclusive LLC, got %q", cpus)
+	}
+	if err := p.resizeBalloon(bln, 4000); err != nil {
+		t.Fatalf("failed to inflate balloon: %v", err)
+	}
+	clearHint()
+	if expected := cpuset.New(0, 1, 5, 6); !bln.Cpus.Equals(expected) {
+		t.Errorf("expected balloon CPUs %q, got %q", expected, bln.Cpus)
+	}
+	if cpus := p.takeStickyCpus(blnDef, 2); !cpus.IsEmpty() {
+		t.Errorf("expected no previously used CPUs after clearing the hint, got %q", cpus)
+	}
+
+	// The cache is saved only when recorded CPUs change.
+	if !p.rememberCpus(ctr, bln) {
+		t.Errorf("expected changed CPUs of %s to be remembered", ctr.name)
+	}
+	if p.rememberCpus(ctr, bln) {
+		t.Errorf("expected unchanged CPUs of %s not to be remembered again", ctr.name)
+	}
+	p.saveStickyCpus()
+	if cch.saves != 1 {
+		t.Errorf("expected cache to be saved once, saved %d times", cch.saves)
+	}
+	if err := p.ReleaseResources(other); err != nil {
+		t.Fatalf("failed to release %s: %v", other.name, err)
+	}
+	if cch.saves != 1 {
+		t.Errorf("expected no cache save when releasing a container without recorded CPUs")
+	}
+	if err := p.ReleaseResources(ctr); err != nil {
+		t.Fatalf("failed to release %s: %v", ctr.name, err)
+	}
+	if _, ok := p.stickyCpus[ctr.name]; ok || cch.saves != 2 {
+		t.Errorf("expected recorded CPUs of %s to be dropped and saved, saved %d times", ctr.name, cch.saves)
+	}
+}
+
+func TestStickyCpusOverSync(t *testing.T) {
+	ctr := &fakeContainer{id: "ctr", podID: "pod", state: cache.ContainerStateRunning, cpuRequest: "2"}
+	blnDef := &BalloonDef{Name: defaultBalloonDefName}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1}, "0-7")
+	p.cch = &fakeCache{containers: map[string]cache.Container{"ctr": ctr}}
+	p.bpoptions.StickyCpus = true
+	p.bpoptions.BalloonDefs = []*BalloonDef{blnDef}
+	p.defaultBalloonDef = blnDef
+
+	// The container runs on CPUs 4-5, recorded before a restart or a
+	// reconfiguration. Running containers are both released and
+	// allocated again when synchronizing.
+	p.stickyCpus["ctr"] = "4-5"
+	if err := p.Sync([]cache.Container{ctr}, []cache.Container{ctr}); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if ctr.cpusetCpus != "4-5" {
+		t.Errorf("expected container back on its previous CPUs %q, got %q", "4-5", ctr.cpusetCpus)
+	}
+	if cpus := p.stickyCpus["ctr"]; cpus != "4-5" {
+		t.Errorf("expected previously used CPUs %q kept, got %q", "4-5", cpus)
+	}
+}
+
+func TestFairCpuShares(t *testing.T) {
+	small := &fakeContainer{name: "small", cpuRequest: "500m"}
+	large := &fakeContainer{name: "large", cpuRequest: "1500m"}
+	idle := &fakeContainer{name: "idle"}
+	bln := &Balloon{
+		Def:            &BalloonDef{Name: "shared"},
+		Cpus:           cpuset.New(0, 1, 2, 3),
+		SharedIdleCpus: cpuset.New(4, 5),
+		PodIDs:         map[string][]string{"pod0": {"small", "large", "idle"}},
+	}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+	p.cch = &fakeCache{containers: map[string]cache.Container{
+		"small": small, "large": large, "idle": idle,
+	}}
+	p.bpoptions.FairShareIdleCpus = true
+
+	setShares := func() {
+		for _, c := range []*fakeContainer{small, large, idle} {
+			p.setCpuShares(c, bln, false)
+		}
+	}
+	check := func(c *fakeContainer, mCpu int64) {
+		t.Helper()
+		if expected := int64(cache.MilliCPUToShares(mCpu)); c.cpuShares != expected {
+			t.Errorf("%s: expected CPU shares %d, got %d", c.name, expected, c.cpuShares)
+		}
+	}
+
+	setShares()
+	check(small, 1000)
+	check(large, 3000)
+	check(idle, fairShareMinMilliCpus)
+
+	p.setCpuShares(large, bln, true)
+	check(large, 1500)
+
+	bln.SharedIdleCpus = cpuset.New()
+	setShares()
+	check(small, 500)
+	check(large, 1500)
+	check(idle, 0)
+
+	bln.SharedIdleCpus = cpuset.New(4, 5)
+	setShares()
+	p.bpoptions.FairShareIdleCpus = false
+	setShares()
+	check(small, 500)
+	check(large, 1500)
+	check(idle, 0)
+}
+
+func TestCgroupCpusetCpus(t *testing.T) {
+	v1 := t.TempDir()
+	v2 := t.TempDir()
+	saved := cpusetRoots
+	defer func() { cpusetRoots = saved }()
+	cpusetRoots = func() []string { return []string{v1, v2} }
+
+	dir := filepath.Join("kubepods.slice", "cri-containerd-1234.scope")
+	if err := os.MkdirAll(filepath.Join(v2, dir), 0755); err != nil {
+		t.Fatalf("failed to create cgroup dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(v2, dir, "cpuset.cpus"), []byte("2-3,6\n"), 0644); err != nil {
+		t.Fatalf("failed to write cpuset: %v", err)
+	}
+
+	cpus, err := cgroupCpusetCpus(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cpus.Equals(cpuset.MustParse("2-3,6")) {
+		t.Errorf("expected CPUs %q, got %q", "2-3,6", cpus)
+	}
+
+	if _, err := cgroupCpusetCpus("missing"); err == nil {
+		t.Errorf("expected error for missing cgroup")
+	}
+	if _, err := cgroupCpusetCpus(""); err == nil {
+		t.Errorf("expected error for unknown cgroup")
+	}
+}
+
+func TestVerifyPinnings(t *testing.T) {
+	root := t.TempDir()
+	saved := cpusetRoots
+	defer func() { cpusetRoots = saved }()
+	cpusetRoots = func() []string { return []string{root} }
+
+	newCtr := func(name, cgroupCpus string) *fakeContainer {
+		if err := os.MkdirAll(filepath.Join(root, name), 0755); err != nil {
+			t.Fatalf("failed to create cgroup dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name, "cpuset.cpus"), []byte(cgroupCpus+"\n"), 0644); err != nil {
+			t.Fatalf("failed to write cpuset: %v", err)
+		}
+		return &fakeContainer{name: name, cgroupDir: name, state: cache.ContainerStateRunning}
+	}
+	ok := newCtr("ok", "0-1")
+	fought := newCtr("fought", "0-7")
+	pending := newCtr("pending", "0-7")
+	pending.pending = true
+	created := newCtr("created", "0-7")
+	created.state = cache.ContainerStateCreated
+
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+	p.bpoptions.VerifyPinning = true
+	p.cch = &fakeCache{containers: map[string]cache.Container{
+		"ok": ok, "fought": fought, "pending": pending, "created": created,
+	}}
+	defer p.stopVerifier()
+
+	for _, c := range []*fakeContainer{ok, fought, pending, created} {
+		p.expectPinning(c, cpuset.New(0, 1))
+	}
+	p.expectPinning(&fakeContainer{name: "gone"}, cpuset.New(0, 1))
+	if p.verifyStop == nil {
+		t.Fatalf("expected verification timer to be started")
+	}
+
+	if mismatch := p.verifyPinnings(); !slices.Equal(mismatch, []string{"fought"}) {
+		t.Errorf("expected pinning mismatch of %q, got %v", "fought", mismatch)
+	}
+	if len(p.verifyCpus) != 2 {
+		t.Errorf("expected pending and created containers to await verification, got %v", p.verifyCpus)
+	}
+
+	// Once the update is applied, pinning that does not stick is detected.
+	pending.pending = false
+	created.state = cache.ContainerStateRunning
+	mismatch := p.verifyPinnings()
+	slices.Sort(mismatch)
+	if !slices.Equal(mismatch, []string{"created", "pending"}) {
+		t.Errorf("expected pinning mismatch of created and pending containers, got %v", mismatch)
+	}
+	if len(p.verifyCpus) != 0 || p.verifyStop != nil {
+		t.Errorf("expected verification to be finished, got %v", p.verifyCpus)
+	}
+}
+
+func TestPinningDrift(t *testing.T) {
+	root := t.TempDir()
+	saved := cpusetRoots
+	defer func() { cpusetRoots = saved }()
+	cpusetRoots = func() []string { return []string{root} }
+
+	dir := filepath.Join(root, "ctr.scope")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create cgroup dir: %v", err)
+	}
+	for entry, value := range map[string]string{"cpuset.cpus": "2-3\n", "cpuset.mems": "0\n"} {
+		if err := os.WriteFile(filepath.Join(dir, entry), []byte(value), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", entry, err)
+		}
+	}
+
+	tcases := []struct {
+		name    string
+		ctr     *fakeContainer
+		drifted bool
+	}{
+		{
+			name:    "pinning matches",
+			ctr:     &fakeContainer{cgroupDir: "ctr.scope", state: cache.ContainerStateRunning, cpusetCpus: "2-3", cpusetMems: "0"},
+			drifted: false,
+		},
+		{
+			name:    "CPUs drifted",
+			ctr:     &fakeContainer{cgroupDir: "ctr.scope", state: cache.ContainerStateRunning, cpusetCpus: "4-5", cpusetMems: "0"},
+			drifted: true,
+		},
+		{
+			name:    "memory nodes drifted",
+			ctr:     &fakeContainer{cgroupDir: "ctr.scope", state: cache.ContainerStateRunning, cpusetCpus: "2-3", cpusetMems: "1"},
+			drifted: true,
+		},
+		{
+			name:    "no intended pinning",
+			ctr:     &fakeContainer{cgroupDir: "ctr.scope", state: cache.ContainerStateRunning},
+			drifted: false,
+		},
+		{
+			name:    "update pending",
+			ctr:     &fakeContainer{cgroupDir: "ctr.scope", state: cache.ContainerStateRunning, pending: true, cpusetCpus: "4-5"},
+			drifted: false,
+		},
+		{
+			name:    "not running",
+			ctr:     &fakeContainer{cgroupDir: "ctr.scope", state: cache.ContainerStateCreated, cpusetCpus: "4-5"},
+			drifted: false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			drift := pinningDrift(tc.ctr)
+			if (drift != "") != tc.drifted {
+				t.Errorf("expected drifted %v, got drift %q", tc.drifted, drift)
+			}
+		})
+	}
+}
+
+func TestDefaultReservedCpuCount(t *testing.T) {
+	tcases := []struct {
+		name          string
+		value         string
+		packages      int
+		cpus          int
+		expectedCount int
+		expectedError bool
+	}{
+		{
+			name:          "default on a small system",
+			packages:      1,
+			cpus:          16,
+			expectedCount: 1,
+		},
+		{
+			name:          "auto on a two socket system",
+			value:         "auto",
+			packages:      2,
+			cpus:          64,
+			expectedCount: 2,
+		},
+		{
+			name:          "auto on a large system",
+			value:         "auto",
+			packages:      2,
+			cpus:          384,
+			expectedCount: 6,
+		},
+		{
+			name:          "per-socket",
+			value:         "per-socket",
+			packages:      4,
+			cpus:          384,
+			expectedCount: 4,
+		},
+		{
+			name:          "none",
+			value:         "none",
+			packages:      2,
+			cpus:          384,
+			expectedCount: 0,
+		},
+		{
+			name:          "number of CPUs",
+			value:         "3",
+			packages:      2,
+			cpus:          384,
+			expectedCount: 3,
+		},
+		{
+			name:          "number of CPUs larger than available",
+			value:         "8",
+			packages:      1,
+			cpus:          4,
+			expectedCount: 4,
+		},
+		{
+			name:          "invalid value",
+			value:         "many",
+			expectedError: true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cnt, err := defaultReservedCpuCount(tc.value, tc.packages, tc.cpus)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got count %d", cnt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cnt != tc.expectedCount {
+				t.Errorf("expected %d reserved CPUs, got %d", tc.expectedCount, cnt)
+			}
+		})
+	}
+}
+
+func TestExclusiveCpus(t *testing.T) {
+	root := t.TempDir()
+	pod1 := "/kubepods.slice/kubepods-pod1.slice"
+	pod2 := "/kubepods.slice/kubepods-pod2.slice"
+	for _, dir := range []string{"/kubepods.slice", pod1, pod2} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("failed to create cgroup %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, cpusetCpusExclusive), nil, 0644); err != nil {
+			t.Fatalf("failed to create %s of %s: %v", cpusetCpusExclusive, dir, err)
+		}
+	}
+	orig := cpusetV2Roots
+	defer func() { cpusetV2Roots = orig }()
+	cpusetV2Roots = func() []string { return []string{filepath.Join(root, "missing"), root} }
+
+	exclusive := func(dir string) string {
+		data, err := os.ReadFile(filepath.Join(root, dir, cpusetCpusExclusive))
+		if err != nil {
+			t.Fatalf("failed to read %s of %s: %v", cpusetCpusExclusive, dir, err)
+		}
+		return string(data)
+	}
+
+	cpusetV2Roots = func() []string { return []string{filepath.Join(root, "missing")} }
+	if e := newExclusiveCpus(); e != nil {
+		t.Fatalf("unexpected exclusive CPU support without %s", cpusetCpusExclusive)
+	}
+	cpusetV2Roots = func() []string { return []string{filepath.Join(root, "missing"), root} }
+	e := newExclusiveCpus()
+	if e == nil {
+		t.Fatalf("exclusive CPU support not detected")
+	}
+
+	if err := e.claim("ctr1", pod1, cpuset.New(2, 3)); err != nil {
+		t.Fatalf("unexpected claim error: %v", err)
+	}
+	if err := e.claim("ctr2", pod2, cpuset.New(4)); err != nil {
+		t.Fatalf("unexpected claim error: %v", err)
+	}
+	if got := exclusive("/kubepods.slice"); got != "2-4" {
+		t.Errorf("expected top-level exclusive CPUs %q, got %q", "2-4", got)
+	}
+	if got := exclusive(pod1); got != "2-3" {
+		t.Errorf("expected pod exclusive CPUs %q, got %q", "2-3", got)
+	}
+
+	if released, err := e.release("ctr1", ""); !released || err != nil {
+		t.Fatalf("failed to release exclusive CPUs: %v, %v", released, err)
+	}
+	if got := exclusive(pod1); got != "" {
+		t.Errorf("expected no pod exclusive CPUs, got %q", got)
+	}
+	if got := exclusive("/kubepods.slice"); got != "4" {
+		t.Errorf("expected top-level exclusive CPUs %q, got %q", "4", got)
+	}
+	if released, _ := e.release("ctr1", ""); released {
+		t.Errorf("released exclusive CPUs twice")
+	}
+
+	// The cgroup of the container is still a partition root until the
+	// NRI update turning it back into a member has been applied. The
+	// pod cgroup and its ancestors must keep the CPUs until then.
+	ctr2 := pod2 + "/cri-containerd-ctr2.scope"
+	partition := filepath.Join(root, ctr2, cpusetCpusPartition)
+	if err := os.MkdirAll(filepath.Join(root, ctr2), 0755); err != nil {
+		t.Fatalf("failed to create cgroup %s: %v", ctr2, err)
+	}
+	if err := os.WriteFile(partition, []byte("root\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", partition, err)
+	}
+	if released, err := e.release("ctr2", ctr2); !released || err != nil {
+		t.Fatalf("failed to release exclusive CPUs: %v, %v", released, err)
+	}
+	if err := e.flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+	if got := exclusive(pod2); got != "4" {
+		t.Errorf("expected pod exclusive CPUs %q while the container is a partition, got %q", "4", got)
+	}
+	if got := exclusive("/kubepods.slice"); got != "4" {
+		t.Errorf("expected top-level exclusive CPUs %q while the container is a partition, got %q", "4", got)
+	}
+
+	if err := os.WriteFile(partition, []byte("member\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", partition, err)
+	}
+	if err := e.flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+	if got := exclusive(pod2); got != "" {
+		t.Errorf("expected no pod exclusive CPUs, got %q", got)
+	}
+	if got := exclusive("/kubepods.slice"); got != "" {
+		t.Errorf("expected no top-level exclusive CPUs, got %q", got)
+	}
+}
+
+func TestPinExclusive(t *testing.T) {
+	root := t.TempDir()
+	pod1 := "/kubepods.slice/kubepods-pod1.slice"
+	for _, dir := range []string{"/kubepods.slice", pod1} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("failed to create cgroup %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, cpusetCpusExclusive), nil, 0644); err != nil {
+			t.Fatalf("failed to create %s of %s: %v", cpusetCpusExclusive, dir, err)
+		}
+	}
+	orig := cpusetV2Roots
+	defer func() { cpusetV2Roots = orig }()
+	cpusetV2Roots = func() []string { return []string{root} }
+
+	blnDef := &BalloonDef{Name: "exclusive"}
+	p := &balloons{
+		bpoptions:          &BalloonsOptions{BalloonDefs: []*BalloonDef{blnDef}},
+		reservedBalloonDef: &BalloonDef{Name: reservedBalloonDefName},
+	}
+	p.probeExclusiveCpus()
+	if p.exclusive != nil {
+		t.Fatalf("unexpected exclusive CPU manager with the option disabled")
+	}
+	p.bpoptions.UseCpusetExclusive = true
+	p.probeExclusiveCpus()
+	if p.exclusive == nil {
+		t.Fatalf("exclusive CPU support not detected")
+	}
+
+	// Neither the container nor its pod has a known cgroup yet. This
+	// must not disable exclusive CPUs for containers created later.
+	unknown := &fakeContainer{id: "unknown", podID: "pod0"}
+	bln0 := &Balloon{Def: blnDef, Cpus: cpuset.New(0, 1), PodIDs: map[string][]string{"pod0": {"unknown"}}}
+	p.pinExclusive(unknown, bln0, bln0.Cpus)
+	if _, ok := unknown.unified[cpusetCpusExclusive]; ok {
+		t.Errorf("unexpected exclusive CPUs for a container in an unknown cgroup")
+	}
+
+	// At creation the container has no cgroup, only its pod does.
+	pod := &fakePod{id: "pod1", cgroup: pod1}
+	c := &fakeContainer{id: "ctr1", podID: "pod1", pod: pod}
+	bln1 := &Balloon{Def: blnDef, Instance: 1, Cpus: cpuset.New(2, 3), PodIDs: map[string][]string{"pod1": {"ctr1"}}}
+	p.pinExclusive(c, bln1, bln1.Cpus)
+	if got := c.unified[cpusetCpusExclusive]; got != "2-3" {
+		t.Errorf("expected exclusive CPUs %q for the container, got %q", "2-3", got)
+	}
+	data, err := os.ReadFile(filepath.Join(root, pod1, cpusetCpusExclusive))
+	if err != nil || string(data) != "2-3" {
+		t.Errorf("expected exclusive CPUs %q for the pod, got %q (%v)", "2-3", string(data), err)
+	}
+
+	// Unpinning turns the container back into a member first. The pod
+	// keeps the CPUs until the container cgroup is seen as a member.
+	c.cgroupDir = pod1 + "/cri-containerd-ctr1.scope"
+	partition := filepath.Join(root, c.cgroupDir, cpusetCpusPartition)
+	if err := os.MkdirAll(filepath.Dir(partition), 0755); err != nil {
+		t.Fatalf("failed to create cgroup %s: %v", c.cgroupDir, err)
+	}
+	if err := os.WriteFile(partition, []byte("root\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", partition, err)
+	}
+	p.unpinExclusive(c)
+	if got := c.unified[cpusetCpusPartition]; got != "member" {
+		t.Errorf("expected container partition %q, got %q", "member", got)
+	}
+	data, err = os.ReadFile(filepath.Join(root, pod1, cpusetCpusExclusive))
+	if err != nil || string(data) != "2-3" {
+		t.Errorf("expected exclusive CPUs %q for the pod before update, got %q (%v)", "2-3", string(data), err)
+	}
+	if err := os.WriteFile(partition, []byte("member\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", partition, err)
+	}
+	p.flushExclusive()
+	data, err = os.ReadFile(filepath.Join(root, pod1, cpusetCpusExclusive))
+	if err != nil || string(data) != "" {
+		t.Errorf("expected no exclusive CPUs for the pod after update, got %q (%v)", string(data), err)
+	}
+}
+
+func TestCpuProfiles(t *testing.T) {
+	p := &balloons{
+		bpoptions: &BalloonsOptions{
+			CpuProfiles: map[string]CpuProfile{
+				"turbo": {
+					CpuClass:     "fast",
+					MinFreq:      3000000,
+					FreqGovernor: "performance",
+				},
+			},
+		},
+	}
+	if class := p.cpuClassOf(&BalloonDef{Name: "bt", CpuClass: "slow", CpuProfile: "turbo"}); class != cpuProfileClass("turbo") {
+		t.Errorf("expected CPU class %q of profile, got %q", cpuProfileClass("turbo"), class)
+	}
+	if class := p.cpuClassOf(&BalloonDef{Name: "bt", CpuClass: "slow"}); class != "slow" {
+		t.Errorf("expected CPU class %q of balloon type, got %q", "slow", class)
+	}
+
+	classes := cpuProfileClasses(p.bpoptions.CpuProfiles)
+	expected := cpucontrol.PolicyClass{
+		Base: "fast",
+		Class: cpucontrol.Class{
+			MinFreq:      3000000,
+			FreqGovernor: "performance",
+		},
+	}
+	if len(classes) != 1 || classes[cpuProfileClass("turbo")] != expected {
+		t.Errorf("expected CPU profile classes %v, got %v",
+			map[string]cpucontrol.PolicyClass{cpuProfileClass("turbo"): expected}, classes)
+	}
+}
+
+func TestMemBandwidth(t *testing.T) {
+	dir := t.TempDir()
+	rootSchemata := "    L3:0=7ff;1=7ff\n    MB:0=100;1=100\n"
+	for entry, value := range map[string]string{
+		"info/MB/min_bandwidth":  "10",
+		"info/MB/bandwidth_gran": "10",
+		"schemata":               rootSchemata,
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, entry)), 0755); err != nil {
+			t.Fatalf("failed to create resctrl directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry), []byte(value), 0644); err != nil {
+			t.Fatalf("failed to create resctrl entry: %v", err)
+		}
+	}
+	for _, group := range []string{resctrlGroupPrefix + "stale-0", "other"} {
+		if err := os.Mkdir(filepath.Join(dir, group), 0755); err != nil {
+			t.Fatalf("failed to create resctrl group: %v", err)
+		}
+	}
+	entry := func(group, entry string) string {
+		data, err := os.ReadFile(filepath.Join(dir, group, entry))
+		if err != nil {
+			t.Fatalf("failed to read resctrl entry: %v", err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	exists := func(group string) bool {
+		_, err := os.Stat(filepath.Join(dir, group))
+		return err == nil
+	}
+	// The kernel lets resctrl groups be removed with their entries.
+	clearGroups := func(groups ...string) {
+		for _, group := range groups {
+			for _, e := range []string{"cpus_list", "schemata"} {
+				if err := os.Remove(filepath.Join(dir, group, e)); err != nil && !os.IsNotExist(err) {
+					t.Fatalf("failed to remove resctrl entry: %v", err)
+				}
+			}
+		}
+	}
+
+	orig := sysResctrlDir
+	sysResctrlDir = dir
+	defer func() { sysResctrlDir = orig }()
+
+	bln0 := &Balloon{
+		Def:  &BalloonDef{Name: "bt", MinMemBandwidthPct: 30},
+		Cpus: cpuset.New(0, 1),
+	}
+	bln1 := &Balloon{
+		Def:      bln0.Def,
+		Instance: 1,
+		Cpus:     cpuset.New(2, 3),
+	}
+	other := &Balloon{
+		Def:  &BalloonDef{Name: "other"},
+		Cpus: cpuset.New(4, 5),
+	}
+	p := &balloons{balloons: []*Balloon{bln0, bln1, other}}
+
+	// Stale groups are removed at startup, groups of others are kept.
+	p.removeMemBandwidth()
+	if exists(resctrlGroupPrefix+"stale-0") || !exists("other") {
+		t.Errorf("expected only the stale resctrl group to be removed")
+	}
+
+	for _, bln := range p.balloons {
+		p.reserveMemBandwidth(bln)
+	}
+	for _, bln := range []*Balloon{bln0, bln1} {
+		group := resctrlGroupOf(bln)
+		if got := entry(group, "cpus_list"); got != bln.Cpus.String() {
+			t.Errorf("%s: expected CPUs %q, got %q", group, bln.Cpus, got)
+		}
+		if got := entry(group, "schemata"); got != "MB:0=100;1=100" {
+			t.Errorf("%s: expected unthrottled bandwidth, got %q", group, got)
+		}
+	}
+	if got := entry(resctrlThrottledGroup, "cpus_list"); got != "4-5" {
+		t.Errorf("expected CPUs of balloons without reservations throttled, got %q", got)
+	}
+	if got := entry(resctrlThrottledGroup, "schemata"); got != "MB:0=40;1=40" {
+		t.Errorf("expected throttled group throttled to 40%%, got %q", got)
+	}
+	if got := entry("", "schemata"); got != strings.TrimSpace(rootSchemata) {
+		t.Errorf("expected default group untouched, got %q", got)
+	}
+
+	clearGroups(resctrlGroupOf(bln0))
+	p.releaseMemBandwidth(bln0)
+	if exists(resctrlGroupOf(bln0)) {
+		t.Errorf("expected resctrl group %s to be removed", resctrlGroupOf(bln0))
+	}
+	if got := entry(resctrlThrottledGroup, "schemata"); got != "MB:0=70;1=70" {
+		t.Errorf("expected throttled group throttled to 70%%, got %q", got)
+	}
+
+	// Other balloons are not throttled without reservations.
+	clearGroups(resctrlGroupOf(bln1), resctrlThrottledGroup)
+	p.releaseMemBandwidth(bln1)
+	if exists(resctrlGroupOf(bln1)) || exists(resctrlThrottledGroup) {
+		t.Errorf("expected all resctrl groups of balloons to be removed")
+	}
+
+	// All groups are removed on shutdown.
+	p.reserveMemBandwidth(bln0)
+	clearGroups(resctrlGroupOf(bln0), resctrlThrottledGroup)
+	p.Stop()
+	if exists(resctrlGroupOf(bln0)) || exists(resctrlThrottledGroup) || !exists("other") {
+		t.Errorf("expected resctrl groups of balloons to be removed on shutdown")
+	}
+
+	if _, err := newMemBandwidth(t.TempDir()); err == nil {
+		t.Errorf("expected error without memory bandwidth allocation support")
+	}
+}
+
+func TestSumMilliCpus(t *testing.T) {
+	tcases := []struct {
+		name         string
+		requests     []int
+		expectedCpus int
+	}{
+		{
+			name:         "only best-effort containers",
+			requests:     []int{0, 0, 0},
+			expectedCpus: 1,
+		},
+		{
+			name:         "best-effort sidecars need no extra CPUs",
+			requests:     []int{1500, 0, 0, 0, 0, 0},
+			expectedCpus: 2,
+		},
+		{
+			name:         "small sidecars are rounded up once",
+			requests:     []int{1000, 100, 100, 100, 100, 100, 100, 100, 100},
+			expectedCpus: 2,
+		},
+		{
+			name:         "whole CPUs are not rounded up",
+			requests:     []int{1000, 500, 500},
+			expectedCpus: 2,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mCpu := sumMilliCpus(tc.requests)
+			if cpus := (mCpu + 999) / 1000; cpus != tc.expectedCpus {
+				t.Errorf("expected %d CPUs for %d mCPU, got %d", tc.expectedCpus, mCpu, cpus)
+			}
+		})
+	}
+}
+
+func TestPinnedCpusAllocation(t *testing.T) {
+	first := &fakeContainer{name: "first", annotations: map[string]string{pinCpusKey: "4-5"}}
+	second := &fakeContainer{name: "second", annotations: map[string]string{pinCpusKey: "5-6"}}
+	p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+	p.cch = &fakeCache{containers: map[string]cache.Container{"first": first, "second": second}}
+	p.freeCpus = cpuset.MustParse("2-7")
+	p.balloons = []*Balloon{newTestBalloon(p, &BalloonDef{Name: "default"}, "0-1")}
+
+	pin := func(c *fakeContainer) {
+		t.Helper()
+		cpus, ok, err := pinnedCpusOf(c)
+		if !ok || err != nil {
+			t.Fatalf("failed to get pinned CPUs of %s: %v", c.name, err)
+		}
+		if err := p.allocatePinnedCpus(c, cpus); err != nil {
+			t.Fatalf("failed to pin %s: %v", c.name, err)
+		}
+	}
+	check := func(c *fakeContainer, cpus, mems, free string) {
+		t.Helper()
+		if c.cpusetCpus != cpus || c.cpusetMems != mems {
+			t.Errorf("expected %s pinned to CPUs %q and memory nodes %q, got %q and %q",
+				c.name, cpus, mems, c.cpusetCpus, c.cpusetMems)
+		}
+		if !p.freeCpus.Equals(cpuset.MustParse(free)) {
+			t.Errorf("expected free CPUs %q, got %q", free, p.freeCpus)
+		}
+	}
+
+	pin(first)
+	check(first, "4-5", "1", "2-3,6-7")
+	pin(second)
+	check(second, "5-6", "1", "2-3,7")
+
+	for _, cpus := range []string{"1-2", "7-8"} {
+		c := &fakeContainer{name: "bad"}
+		if err := p.allocatePinnedCpus(c, cpuset.MustParse(cpus)); err == nil {
+			t.Errorf("expected error pinning to CPUs %q", cpus)
+		}
+	}
+	if _, ok, err := pinnedCpusOf(&fakeContainer{annotations: map[string]string{pinCpusKey: "x"}}); !ok || err == nil {
+		t.Errorf("expected error for invalid %s annotation", pinCpusKey)
+	}
+
+	// CPUs still pinned by another container stay allocated.
+	if !p.releasePinnedCpus(first) {
+		t.Fatalf("expected pinned CPUs of %s to be released", first.name)
+	}
+	if expected := cpuset.MustParse("2-4,7"); !p.freeCpus.Equals(expected) {
+		t.Errorf("expected free CPUs %q, got %q", expected, p.freeCpus)
+	}
+	if !p.releasePinnedCpus(second) || p.releasePinnedCpus(second) {
+		t.Errorf("expected pinned CPUs of %s to be released once", second.name)
+	}
+	if expected := cpuset.MustParse("2-7"); !p.freeCpus.Equals(expected) {
+		t.Errorf("expected free CPUs %q, got %q", expected, p.freeCpus)
+	}
+}
+
+func TestMaxMemoryPlacement(t *testing.T) {
+	maxMemory := resource.MustParse("1Gi")
+	blnDef := &BalloonDef{Name: "mem", MaxMemory: &maxMemory}
+	large := &fakeContainer{name: "large", podID: "pod0", memLimit: "768Mi"}
+	small := &fakeContainer{name: "small", podID: "pod1", memLimit: "256Mi"}
+	bln0 := &Balloon{
+		Def:      blnDef,
+		Instance: 0,
+		Cpus:     cpuset.New(0, 1),
+		Mems:     idset.NewIDSet(0),
+		PodIDs:   map[string][]string{"pod0": {"large"}},
+	}
+	bln1 := &Balloon{
+		Def:      blnDef,
+		Instance: 1,
+		Cpus:     cpuset.New(4, 5),
+		Mems:     idset.NewIDSet(1),
+		PodIDs:   map[string][]string{"pod1": {"small"}},
+	}
+	cch := &fakeCache{containers: map[string]cache.Container{"large": large, "small": small}}
+	p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+	p.cch = cch
+	p.bpoptions.BalloonDefs = []*BalloonDef{blnDef}
+	p.balloons = []*Balloon{bln0, bln1}
+	p.freeCpus = cpuset.New()
+
+	tcases := []struct {
+		name         string
+		ctr          *fakeContainer
+		maxMemory    string
+		expectedCpus cpuset.CPUSet
+		expectedMems idset.IDSet
+		fail         bool
+	}{
+		{
+			name:         "container fits in first balloon",
+			ctr:          &fakeContainer{name: "fits", podID: "pod2", memLimit: "256Mi"},
+			expectedCpus: cpuset.New(0, 1),
+			expectedMems: idset.NewIDSet(0),
+		},
+		{
+			name:         "container placed in balloon with memory left",
+			ctr:          &fakeContainer{name: "moved", podID: "pod2", memLimit: "512Mi"},
+			expectedCpus: cpuset.New(4, 5),
+			expectedMems: idset.NewIDSet(1),
+		},
+		{
+			name: "container exceeds memory of all balloons",
+			ctr:  &fakeContainer{name: "huge", podID: "pod2", memLimit: "1Gi"},
+			fail: true,
+		},
+		{
+			name:      "maxMemory limited by memory node capacity",
+			ctr:       &fakeContainer{name: "capped", podID: "pod2", memLimit: "1280Mi"},
+			maxMemory: "4Gi",
+			fail:      true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.maxMemory != "" {
+				limit := resource.MustParse(tc.maxMemory)
+				blnDef.MaxMemory = &limit
+				defer func() { blnDef.MaxMemory = &maxMemory }()
+			}
+			cch.containers[tc.ctr.name] = tc.ctr
+			defer delete(cch.containers, tc.ctr.name)
+			bln, err := p.allocateBalloonOfDef(blnDef, tc.ctr)
+			if tc.fail {
+				if err == nil {
+					t.Errorf("expected error, got balloon %v", bln)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bln == nil || !bln.Cpus.Equals(tc.expectedCpus) || bln.Mems.String() != tc.expectedMems.String() {
+				t.Errorf("expected balloon with CPUs %q and memory nodes %s, got %v",
+					tc.expectedCpus, tc.expectedMems, bln)
+			}
+		})
+	}
+}
+
+func TestAggregatePodCpusPlacement(t *testing.T) {
+	blnDef := &BalloonDef{Name: "agg", AggregatePodCpus: true, MaxCpus: 2}
+	main := &fakeContainer{name: "main", podID: "pod0", cpuRequest: "1500m"}
+	other := &fakeContainer{name: "other", podID: "pod1"}
+	mainBln := &Balloon{
+		Def:      blnDef,
+		Instance: 0,
+		Cpus:     cpuset.New(0, 1),
+		PodIDs:   map[string][]string{"pod0": {"main"}},
+	}
+	otherBln := &Balloon{
+		Def:      blnDef,
+		Instance: 1,
+		Cpus:     cpuset.New(2, 3),
+		PodIDs:   map[string][]string{"pod1": {"other"}},
+	}
+	cch := &fakeCache{containers: map[string]cache.Container{"main": main, "other": other}}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 4, 1})
+	p.cch = cch
+	p.bpoptions.BalloonDefs = []*BalloonDef{blnDef}
+	p.balloons = []*Balloon{mainBln, otherBln}
+	p.freeCpus = cpuset.New()
+
+	tcases := []struct {
+		name        string
+		ctr         *fakeContainer
+		expectedBln *Balloon
+	}{
+		{
+			name:        "sidecar joins the balloon of its pod",
+			ctr:         &fakeContainer{name: "sidecar", podID: "pod0", cpuRequest: "200m"},
+			expectedBln: mainBln,
+		},
+		{
+			name:        "best-effort sidecar joins the balloon of its pod",
+			ctr:         &fakeContainer{name: "besteffort", podID: "pod0"},
+			expectedBln: mainBln,
+		},
+		{
+			name:        "container exceeding capacity goes elsewhere",
+			ctr:         &fakeContainer{name: "big", podID: "pod0", cpuRequest: "1"},
+			expectedBln: otherBln,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cch.containers[tc.ctr.name] = tc.ctr
+			defer delete(cch.containers, tc.ctr.name)
+			bln, err := p.allocateBalloonOfDef(blnDef, tc.ctr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bln != tc.expectedBln {
+				t.Errorf("expected balloon %s, got %v", tc.expectedBln.PrettyName(), bln)
+			}
+		})
+	}
+}
+
+func TestAggregatePodCpusFillChain(t *testing.T) {
+	blnDef := &BalloonDef{Name: "bt", GroupBy: "${pod/labels/app}", AggregatePodCpus: true}
+	chain := fillChain(blnDef)
+	if len(chain) == 0 || chain[0] != FillSamePod {
+		t.Errorf("expected %s first in fill chain, got %v", FillSamePod, chain)
+	}
+	if n := slices.Index(chain[1:], FillSamePod); n >= 0 {
+		t.Errorf("unexpected %s twice in fill chain %v", FillSamePod, chain)
+	}
+}
+
+func TestExclusiveQoS(t *testing.T) {
+	tcases := []struct {
+		name     string
+		qos      corev1.PodQOSClass
+		request  string
+		expected bool
+	}{
+		{
+			name:     "guaranteed with integer CPUs",
+			qos:      corev1.PodQOSGuaranteed,
+			request:  "2",
+			expected: true,
+		},
+		{
+			name:    "guaranteed with fractional CPUs",
+			qos:     corev1.PodQOSGuaranteed,
+			request: "1500m",
+		},
+		{
+			name: "guaranteed without CPU request",
+			qos:  corev1.PodQOSGuaranteed,
+		},
+		{
+			name:    "burstable with integer CPUs",
+			qos:     corev1.PodQOSBurstable,
+			request: "2",
+		},
+		{
+			name: "besteffort",
+			qos:  corev1.PodQOSBestEffort,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeContainer{qos: tc.qos, cpuRequest: tc.request}
+			if got := hasExclusiveQoS(c); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestWholeCoreCpus(t *testing.T) {
+	// CPUs n and n+4 are hyperthreads of the same core.
+	siblings := func(cpu int) cpuset.CPUSet {
+		return cpuset.New(cpu%4, cpu%4+4)
+	}
+	tcases := []struct {
+		name     string
+		cpus     cpuset.CPUSet
+		expected cpuset.CPUSet
+	}{
+		{
+			name:     "whole cores",
+			cpus:     cpuset.New(0, 1, 4, 5),
+			expected: cpuset.New(0, 1, 4, 5),
+		},
+		{
+			name:     "partial core dropped",
+			cpus:     cpuset.New(0, 1, 4),
+			expected: cpuset.New(0, 4),
+		},
+		{
+			name:     "no whole cores",
+			cpus:     cpuset.New(0, 1, 2),
+			expected: cpuset.New(),
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := wholeCoreCpus(tc.cpus, siblings); !got.Equals(tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestAssignQoSCores(t *testing.T) {
+	// CPUs n and n+4 are hyperthreads of the same core.
+	siblings := func(cpu int) cpuset.CPUSet {
+		return cpuset.New(cpu%4, cpu%4+4)
+	}
+	cores := wholeCores(cpuset.New(0, 1, 2, 4, 5, 6), siblings)
+	if len(cores) != 3 || !cores[0].Equals(cpuset.New(0, 4)) {
+		t.Fatalf("unexpected whole cores %v", cores)
+	}
+	tcases := []struct {
+		name     string
+		requests map[string]int
+		previous map[string]cpuset.CPUSet
+		expected map[string]cpuset.CPUSet
+	}{
+		{
+			name:     "disjoint cores",
+			requests: map[string]int{"a": 1, "b": 1},
+			expected: map[string]cpuset.CPUSet{
+				"a": cpuset.New(0, 4),
+				"b": cpuset.New(1, 5),
+			},
+		},
+		{
+			name:     "cores cover request",
+			requests: map[string]int{"a": 3, "b": 2},
+			expected: map[string]cpuset.CPUSet{
+				"a": cpuset.New(0, 1, 4, 5),
+				"b": cpuset.New(2, 6),
+			},
+		},
+		{
+			name:     "previous cores are kept",
+			requests: map[string]int{"a": 1, "b": 1},
+			previous: map[string]cpuset.CPUSet{"b": cpuset.New(0, 4)},
+			expected: map[string]cpuset.CPUSet{
+				"a": cpuset.New(1, 5),
+				"b": cpuset.New(0, 4),
+			},
+		},
+		{
+			name:     "previous cores too few for request",
+			requests: map[string]int{"a": 4},
+			previous: map[string]cpuset.CPUSet{"a": cpuset.New(2, 6)},
+			expected: map[string]cpuset.CPUSet{
+				"a": cpuset.New(0, 1, 4, 5),
+			},
+		},
+		{
+			name:     "out of cores",
+			requests: map[string]int{"a": 4, "b": 2, "c": 1},
+			expected: map[string]cpuset.CPUSet{
+				"a": cpuset.New(0, 1, 4, 5),
+				"b": cpuset.New(2, 6),
+			},
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assigned := assignQoSCores(cores, tc.requests, tc.previous)
+			if len(assigned) != len(tc.expected) {
+				t.Errorf("expected %d assignments, got %v", len(tc.expected), assigned)
+			}
+			for cID, cpus := range tc.expected {
+				if !assigned[cID].Equals(cpus) {
+					t.Errorf("expected CPUs %q for %s, got %q", cpus, cID, assigned[cID])
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceShares(t *testing.T) {
+	tcases := []struct {
+		name       string
+		milliCpus  map[string]int
+		containers map[string]int
+		expected   map[string]float64
+	}{
+		{
+			name:       "by CPU requests",
+			milliCpus:  map[string]int{"a": 3000, "b": 1000},
+			containers: map[string]int{"a": 1, "b": 3},
+			expected:   map[string]float64{"a": 0.75, "b": 0.25},
+		},
+		{
+			name:       "by containers without CPU requests",
+			milliCpus:  map[string]int{"a": 0, "b": 0},
+			containers: map[string]int{"a": 1, "b": 3},
+			expected:   map[string]float64{"a": 0.25, "b": 0.75},
+		},
+		{
+			name:     "empty balloon",
+			expected: map[string]float64{},
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := namespaceShares(tc.milliCpus, tc.containers); !maps.Equal(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNamespaceCpuQuota(t *testing.T) {
+	blnDef := &BalloonDef{Name: "tenants"}
+	cch := &fakeCache{
+		pods: map[string]cache.Pod{
+			"a": &fakePod{id: "a", namespace: "a"},
+			"b": &fakePod{id: "b", namespace: "b"},
+		},
+		containers: map[string]cache.Container{
+			"a0": &fakeContainer{id: "a0", namespace: "a", cpuRequest: "2"},
+			"a1": &fakeContainer{id: "a1", namespace: "a", cpuRequest: "2"},
+			"b0": &fakeContainer{id: "b0", namespace: "b", cpuRequest: "2"},
+			"b1": &fakeContainer{id: "b1", namespace: "b", cpuRequest: "2"},
+		},
+	}
+	newBalloons := func() (*Balloon, *Balloon) {
+		onlyA := &Balloon{Def: blnDef, Cpus: cpuset.New(0, 1), PodIDs: map[string][]string{"a": {"a0"}}}
+		mixed := &Balloon{Def: blnDef, Instance: 1, Cpus: cpuset.New(2, 3, 4, 5), PodIDs: map[string][]string{"a": {"a1"}, "b": {"b0"}}}
+		return onlyA, mixed
+	}
+	tcases := []struct {
+		name          string
+		quotas        map[string]int
+		mixed         MixedNamespaceQuota
+		container     string
+		intoMixed     bool
+		cpus          int
+		expectedError string
+		expectedMix   bool
+	}{
+		{
+			name:        "no quotas",
+			container:   "a1",
+			cpus:        8,
+			expectedMix: true,
+		},
+		{
+			name:        "within quota",
+			quotas:      map[string]int{"a": 6},
+			container:   "a1",
+			cpus:        4,
+			expectedMix: true,
+		},
+		{
+			name:          "exceeds quota",
+			quotas:        map[string]int{"a": 5},
+			container:     "a1",
+			cpus:          4,
+			expectedError: `CPU quota of namespace "a" exceeded`,
+			expectedMix:   true,
+		},
+		{
+			name:          "proportional share of mixed balloon exceeds quota",
+			quotas:        map[string]int{"a": 4},
+			container:     "b1",
+			intoMixed:     true,
+			cpus:          9,
+			expectedError: `CPU quota of namespace "a" exceeded`,
+			expectedMix:   true,
+		},
+		{
+			name:        "proportional share of mixed balloon within quota",
+			quotas:      map[string]int{"a": 4, "b": 4},
+			container:   "b1",
+			intoMixed:   true,
+			cpus:        6,
+			expectedMix: true,
+		},
+		{
+			name:        "mixing rejected",
+			quotas:      map[string]int{"b": 4},
+			mixed:       MixedNamespaceQuotaReject,
+			container:   "b1",
+			intoMixed:   true,
+			cpus:        6,
+			expectedMix: false,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			onlyA, mixed := newBalloons()
+			p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+			p.cch = cch
+			p.balloons = []*Balloon{onlyA, mixed}
+			p.bpoptions.NamespaceCpuQuotas = tc.quotas
+			p.bpoptions.MixedNamespaceQuota = tc.mixed
+			bln := onlyA
+			if tc.intoMixed {
+				bln = mixed
+			}
+			c := cch.containers[tc.container]
+			err := p.checkNamespaceCpuQuota(c, bln, tc.cpus)
+			if tc.expectedError == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.expectedError != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedError)) {
+				t.Errorf("expected error %q, got %v", tc.expectedError, err)
+			}
+			if got := p.allowsNamespaceMix(bln, c); got != tc.expectedMix {
+				t.Errorf("expected allowsNamespaceMix %v, got %v", tc.expectedMix, got)
+			}
+		})
+	}
+}
+
+func TestMaxPods(t *testing.T) {
+	tcases := []struct {
+		name      string
+		maxPods   int
+		podID     string
+		expectNew bool
+	}{
+		{
+			name:    "no limit",
+			podID:   "pod2",
+			maxPods: 0,
+		},
+		{
+			name:    "pod already in the balloon",
+			podID:   "pod1",
+			maxPods: 2,
+		},
+		{
+			name:    "room for another pod",
+			podID:   "pod2",
+			maxPods: 3,
+		},
+		{
+			name:      "limit forces a new balloon",
+			podID:     "pod2",
+			maxPods:   2,
+			expectNew: true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			blnDef := &BalloonDef{Name: "tenant", MaxPods: tc.maxPods}
+			existing := &Balloon{
+				Def:    blnDef,
+				Cpus:   cpuset.MustParse("0-1"),
+				PodIDs: map[string][]string{"pod0": {"ctr0"}, "pod1": {"ctr1"}},
+				Groups: map[string]int{},
+			}
+			p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1}, "0-7")
+			p.bpoptions.BalloonDefs = []*BalloonDef{blnDef}
+			p.balloons = []*Balloon{existing}
+			p.freeCpus = cpuset.MustParse("2-7")
+			c := &fakeContainer{id: "ctr2", podID: tc.podID}
+			bln, err := p.allocateBalloonOfDef(blnDef, c)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bln == nil {
+				t.Fatalf("no balloon allocated")
+			}
+			if tc.expectNew {
+				if bln == existing || len(p.balloons) != 2 {
+					t.Errorf("expected a new balloon, got %s of %d balloons", bln.PrettyName(), len(p.balloons))
+				}
+			} else if bln != existing {
+				t.Errorf("expected the existing balloon, got %s", bln.PrettyName())
+			}
+		})
+	}
+}
+
+func TestCpuPercentages(t *testing.T) {
+	tcases := []struct {
+		name        string
+		json        string
+		cpuCount    int
+		expectedMin int
+		expectedMax int
+		expectedErr string
+	}{
+		{
+			name:        "percentage and count",
+			json:        `{"name":"x","minCPUs":"25%","maxCPUs":8}`,
+			cpuCount:    6,
+			expectedMin: 2,
+			expectedMax: 8,
+		},
+		{
+			name:        "percentages round up",
+			json:        `{"name":"x","minCPUs":"10%","maxCPUs":"50%"}`,
+			cpuCount:    7,
+			expectedMin: 1,
+			expectedMax: 4,
+		},
+		{
+			name:        "exact percentage",
+			json:        `{"name":"x","maxCPUs":"12.5%"}`,
+			cpuCount:    16,
+			expectedMax: 2,
+		},
+		{
+			name:        "count as string",
+			json:        `{"name":"x","minCPUs":"3"}`,
+			cpuCount:    16,
+			expectedMin: 3,
+		},
+		{
+			name:        "too large percentage",
+			json:        `{"name":"x","minCPUs":"150%"}`,
+			expectedErr: "invalid minCPUs",
+		},
+		{
+			name:        "zero percentage",
+			json:        `{"name":"x","maxCPUs":"0%"}`,
+			expectedErr: "invalid maxCPUs",
+		},
+		{
+			name:        "garbage",
+			json:        `{"name":"x","maxCPUs":"many"}`,
+			expectedErr: "invalid maxCPUs",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			blnDef := &BalloonDef{}
+			err := json.Unmarshal([]byte(tc.json), blnDef)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data, err := json.Marshal(blnDef)
+			if err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+			again := &BalloonDef{}
+			if err = json.Unmarshal(data, again); err != nil {
+				t.Fatalf("unmarshal of %s failed: %v", data, err)
+			}
+			if again.MinCpus != blnDef.MinCpus || again.MinCpusPercent != blnDef.MinCpusPercent ||
+				again.MaxCpus != blnDef.MaxCpus || again.MaxCpusPercent != blnDef.MaxCpusPercent {
+				t.Errorf("round trip through %s changed minCPUs/maxCPUs", data)
+			}
+
+			resolveCpuPercentages([]*BalloonDef{blnDef}, tc.cpuCount)
+			if blnDef.MinCpus != tc.expectedMin || blnDef.MaxCpus != tc.expectedMax {
+				t.Errorf("expected minCPUs %d, maxCPUs %d, got %d, %d",
+					tc.expectedMin, tc.expectedMax, blnDef.MinCpus, blnDef.MaxCpus)
+			}
+		})
+	}
+}
+
+func TestPrewarmCpuCount(t *testing.T) {
+	tcases := []struct {
+		name     string
+		value    string
+		blnDef   *BalloonDef
+		expected int
+	}{
+		{
+			name:     "no annotation",
+			blnDef:   &BalloonDef{Name: "bursty"},
+			expected: 0,
+		},
+		{
+			name:     "valid count",
+			value:    "4",
+			blnDef:   &BalloonDef{Name: "bursty"},
+			expected: 4,
+		},
+		{
+			name:     "invalid count",
+			value:    "many",
+			blnDef:   &BalloonDef{Name: "bursty"},
+			expected: 0,
+		},
+		{
+			name:     "negative count",
+			value:    "-2",
+			blnDef:   &BalloonDef{Name: "bursty"},
+			expected: 0,
+		},
+		{
+			name:     "limited by maxCPUs",
+			value:    "12",
+			blnDef:   &BalloonDef{Name: "bursty", MaxCpus: 6},
+			expected: 6,
+		},
+		{
+			name:     "limited by free CPUs",
+			value:    "12",
+			blnDef:   &BalloonDef{Name: "bursty"},
+			expected: 8,
+		},
+		{
+			name:     "shared pool balloon",
+			value:    "4",
+			blnDef:   &BalloonDef{Name: "shared", SharedPoolOnly: true},
+			expected: 0,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+			p.freeCpus = cpuset.MustParse("2-7")
+			bln := &Balloon{Def: tc.blnDef, Cpus: cpuset.MustParse("0-1")}
+			c := &fakeContainer{id: "ctr", annotations: map[string]string{}}
+			if tc.value != "" {
+				c.annotations[prewarmCpusKey] = tc.value
+			}
+			if got := p.prewarmCpuCount(c, bln); got != tc.expected {
+				t.Errorf("expected %d CPUs, got %d", tc.expected, got)
+			}
+		})
+	}
+
+	p := &balloons{bpoptions: &BalloonsOptions{}}
+	bln := &Balloon{Def: &BalloonDef{Name: "bursty", MinCpus: 1}, Cpus: cpuset.MustParse("0-3")}
+	if got := p.resizedCpuCount(bln, 1000); got != 1 {
+		t.Errorf("expected deflating to 1 CPU, got %d", got)
+	}
+	p.prewarmBalloon(bln, 4)
+	defer p.stopPrewarmTimer()
+	if got := p.resizedCpuCount(bln, 1000); got != 4 {
+		t.Errorf("expected pre-inflated balloon to keep 4 CPUs, got %d", got)
+	}
+	if got := p.resizedCpuCount(bln, 6000); got != 6 {
+		t.Errorf("expected pre-inflated balloon to inflate to 6 CPUs, got %d", got)
+	}
+}
+
+func TestPreferSameNuma(t *testing.T) {
+	blnDef := &BalloonDef{Name: "app"}
+	newBalloons := func() []*Balloon {
+		return []*Balloon{
+			{Def: blnDef, Instance: 0, Cpus: cpuset.MustParse("0-1"), PodIDs: map[string][]string{}},
+			{Def: blnDef, Instance: 1, Cpus: cpuset.MustParse("4-5"), PodIDs: map[string][]string{}},
+			{Def: blnDef, Instance: 2, Cpus: cpuset.MustParse("2,6"), PodIDs: map[string][]string{}},
+			{Def: blnDef, Instance: 3, Cpus: cpuset.New(), PodIDs: map[string][]string{}},
+		}
+	}
+	tcases := []struct {
+		name     string
+		value    string
+		podIn    int
+		expected []int
+	}{
+		{
+			name:     "no annotation",
+			podIn:    1,
+			expected: []int{0, 1, 2, 3},
+		},
+		{
+			name:     "invalid annotation",
+			value:    "maybe",
+			podIn:    1,
+			expected: []int{0, 1, 2, 3},
+		},
+		{
+			name:     "no other containers of the pod",
+			value:    "true",
+			podIn:    -1,
+			expected: []int{0, 1, 2, 3},
+		},
+		{
+			name:     "prefer the node of the pod",
+			value:    "true",
+			podIn:    1,
+			expected: []int{1},
+		},
+		{
+			name:     "pod spanning nodes",
+			value:    "true",
+			podIn:    2,
+			expected: []int{0, 1, 2},
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			blns := newBalloons()
+			p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+			p.balloons = blns
+			if tc.podIn >= 0 {
+				blns[tc.podIn].PodIDs["pod"] = []string{"sidecar"}
+			}
+			c := &fakeContainer{id: "ctr", podID: "pod", annotations: map[string]string{}}
+			if tc.value != "" {
+				c.annotations[sameNumaKey] = tc.value
+			}
+			got := []int{}
+			for _, bln := range p.preferSameNuma(blns, c) {
+				got = append(got, bln.Instance)
+			}
+			if !slices.Equal(got, tc.expected) {
+				t.Errorf("expected balloons %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestZeroRequestUsesSharedIdle(t *testing.T) {
+	// A pod with a sidecar without CPU request is Burstable, even if
+	// its main container would be Guaranteed alone.
+	pod := &fakePod{id: "pod", qos: corev1.PodQOSBurstable}
+	main := &fakeContainer{id: "main", podID: "pod", pod: pod, qos: corev1.PodQOSGuaranteed, cpuRequest: "2"}
+	sidecar := &fakeContainer{id: "sidecar", podID: "pod", pod: pod, qos: corev1.PodQOSBestEffort}
+	pod.containers = []cache.Container{main, sidecar}
+	// A pod with only containers without CPU requests has no CPUs to
+	// protect from them.
+	other := &fakePod{id: "other", qos: corev1.PodQOSBurstable}
+	noRequests := &fakeContainer{id: "noreq", podID: "other", pod: other, memLimit: "1G"}
+	other.containers = []cache.Container{noRequests}
+	blnDef := &BalloonDef{Name: "exclusive", PreferSpreadingPods: true}
+	empty := &Balloon{Def: blnDef, Cpus: cpuset.New(), SharedIdleCpus: cpuset.New(), PodIDs: map[string][]string{}}
+	mixed := &Balloon{
+		Def:            blnDef,
+		Instance:       1,
+		Cpus:           cpuset.MustParse("0-1"),
+		SharedIdleCpus: cpuset.MustParse("4-7"),
+		PodIDs:         map[string][]string{"pod": {"main"}},
+	}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+	p.cch = &fakeCache{
+		containers: map[string]cache.Container{"main": main, "sidecar": sidecar, "noreq": noRequests},
+	}
+	p.balloons = []*Balloon{empty, mixed}
+
+	if bln := p.zeroRequestBalloon(blnDef, sidecar); bln != nil {
+		t.Errorf("expected no balloon with the option disabled, got %s", bln.PrettyName())
+	}
+	if _, ok := p.zeroRequestCpus(sidecar, mixed); ok {
+		t.Errorf("expected usual pinning with the option disabled")
+	}
+
+	p.bpoptions.ZeroRequestUsesSharedIdle = true
+	if bln := p.zeroRequestBalloon(blnDef, main); bln != nil {
+		t.Errorf("expected no balloon for a container requesting CPUs, got %s", bln.PrettyName())
+	}
+	if bln := p.zeroRequestBalloon(blnDef, noRequests); bln != nil {
+		t.Errorf("expected no balloon in a pod without CPU requests, got %s", bln.PrettyName())
+	}
+	if bln := p.zeroRequestBalloon(blnDef, sidecar); bln != mixed {
+		t.Errorf("expected sidecar to join the balloon of its pod, got %v", bln)
+	}
+
+	// The sidecar runs on shared idle CPUs of the balloon and does not
+	// add to its CPU requests.
+	mixed.PodIDs["pod"] = append(mixed.PodIDs["pod"], "sidecar")
+	if got := p.requestedMilliCpus(mixed); got != 2000 {
+		t.Errorf("expected 2000 mCPU requested in the balloon, got %d", got)
+	}
+	if cpus, ok := p.zeroRequestCpus(sidecar, mixed); !ok || !cpus.Equals(mixed.SharedIdleCpus) {
+		t.Errorf("expected sidecar pinned to shared idle CPUs %q, got %q", mixed.SharedIdleCpus, cpus)
+	}
+	if _, ok := p.zeroRequestCpus(main, mixed); ok {
+		t.Errorf("expected usual pinning for a container requesting CPUs")
+	}
+
+	// Without shared idle CPUs, or when left alone in the balloon, the
+	// sidecar is pinned to the CPUs of the balloon.
+	mixed.SharedIdleCpus = cpuset.New()
+	if _, ok := p.zeroRequestCpus(sidecar, mixed); ok {
+		t.Errorf("expected usual pinning without shared idle CPUs")
+	}
+	mixed.SharedIdleCpus = cpuset.MustParse("4-7")
+	mixed.PodIDs["pod"] = []string{"sidecar"}
+	if _, ok := p.zeroRequestCpus(sidecar, mixed); ok {
+		t.Errorf("expected usual pinning without containers requesting CPUs")
+	}
+}
+
+func TestCpuPriorityHint(t *testing.T) {
+	tcases := []struct {
+		name     string
+		value    string
+		expected cpuallocator.CPUPriority
+	}{
+		{
+			name:     "no annotation",
+			expected: cpuallocator.PriorityLow,
+		},
+		{
+			name:     "high priority",
+			value:    "high",
+			expected: cpuallocator.PriorityHigh,
+		},
+		{
+			name:     "no priority",
+			value:    "none",
+			expected: cpuallocator.PriorityNone,
+		},
+		{
+			name:     "invalid priority",
+			value:    "urgent",
+			expected: cpuallocator.PriorityLow,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &balloons{}
+			blnDef := &BalloonDef{Name: "promoted", AllocatorPriority: cfgapi.PriorityLow}
+			c := &fakeContainer{id: "ctr", annotations: map[string]string{}}
+			if tc.value != "" {
+				c.annotations[cpuPriorityKey] = tc.value
+			}
+			resetHint := p.setCpuPriorityHint(c)
+			if got := p.allocatorPriority(blnDef); got != tc.expected {
+				t.Errorf("expected priority %s, got %s", tc.expected, got)
+			}
+			resetHint()
+			if got := p.allocatorPriority(blnDef); got != cpuallocator.PriorityLow {
+				t.Errorf("expected priority of the balloon type after allocation, got %s", got)
+			}
+		})
+	}
+}
+
+func TestInspectState(t *testing.T) {
+	main := &fakeContainer{id: "main", podID: "pod", qos: corev1.PodQOSGuaranteed, cpuRequest: "2"}
+	blnDef := &BalloonDef{Name: "exclusive"}
+	bln := &Balloon{
+		Def:            blnDef,
+		Instance:       1,
+		Cpus:           cpuset.MustParse("0-1"),
+		SharedIdleCpus: cpuset.MustParse("4-5"),
+		Mems:           idset.NewIDSet(0),
+		PodIDs:         map[string][]string{"pod": {"main", "gone"}},
+	}
+	p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1})
+	p.cch = &fakeCache{containers: map[string]cache.Container{"main": main}}
+	p.balloons = []*Balloon{bln}
+	p.freeCpus = cpuset.MustParse("2-3,6-7")
+
+	state := p.inspectState()
+	if state.FreeCpus != "2-3,6-7" || state.FreeCpuCount != 4 {
+		t.Errorf("expected free CPUs 2-3,6-7 (4), got %s (%d)", state.FreeCpus, state.FreeCpuCount)
+	}
+	if len(state.Balloons) != 1 {
+		t.Fatalf("expected 1 balloon, got %d", len(state.Balloons))
+	}
+	got := state.Balloons[0]
+	if got.Name != bln.PrettyName() || got.Type != "exclusive" {
+		t.Errorf("expected balloon %s of type exclusive, got %s of type %s", bln.PrettyName(), got.Name, got.Type)
+	}
+	if got.Cpus != "0-1" || got.SharedIdleCpus != "4-5" || got.Mems != "0" {
+		t.Errorf("unexpected cpus %q, shared idle cpus %q or mems %q", got.Cpus, got.SharedIdleCpus, got.Mems)
+	}
+	if got.RequestedMilliCpus != 2000 {
+		t.Errorf("expected 2000 requested mCPU, got %d", got.RequestedMilliCpus)
+	}
+	// Containers missing from the cache are listed by their ID.
+	if strings.Join(got.Containers, ",") != "gone,pod/main" {
+		t.Errorf("expected containers gone,pod/main, got %v", got.Containers)
+	}
+}
+
+func TestBalloonDefChain(t *testing.T) {
+	dflt := &BalloonDef{Name: defaultBalloonDefName}
+	bigcore := &BalloonDef{Name: "bigcore", Fallbacks: []string{"burst", "small"}}
+	burst := &BalloonDef{Name: "burst", Fallbacks: []string{"spill", "small"}}
+	spill := &BalloonDef{Name: "spill"}
+	small := &BalloonDef{Name: "small", Fallbacks: []string{defaultBalloonDefName}}
+	p := &balloons{
+		bpoptions: &BalloonsOptions{
+			BalloonDefs: []*BalloonDef{bigcore, burst, spill, small, dflt},
+		},
+		defaultBalloonDef: dflt,
+	}
+
+	names := func(chain []*BalloonDef) string {
+		s := []string{}
+		for _, blnDef := range chain {
+			s = append(s, blnDef.Name)
+		}
+		return strings.Join(s, ",")
+	}
+
+	if got := names(p.balloonDefChain(spill)); got != "spill" {
+		t.Errorf("expected no fallbacks for spill, got %s", got)
+	}
+	if got := names(p.balloonDefChain(bigcore)); got != "bigcore,burst,spill,small,default" {
+		t.Errorf("expected fallbacks bigcore,burst,spill,small,default, got %s", got)
+	}
+	if got := names(p.balloonDefChain(small)); got != "small,default" {
+		t.Errorf("expected fallbacks small,default, got %s", got)
+	}
+}
+
+func TestAdoptKubeletPinnedCpus(t *testing.T) {
+	pinned := &fakeContainer{id: "pinned", cpusetCpus: "2-3", qos: corev1.PodQOSGuaranteed, cpuRequest: "2"}
+	shared := &fakeContainer{id: "shared", cpusetCpus: "0-7", qos: corev1.PodQOSGuaranteed, cpuRequest: "2"}
+	fractional := &fakeContainer{id: "fractional", cpusetCpus: "2-3", qos: corev1.PodQOSGuaranteed, cpuRequest: "1500m"}
+	burstable := &fakeContainer{id: "burstable", cpusetCpus: "2-3", qos: corev1.PodQOSBurstable, cpuRequest: "2"}
+	p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1})
+	p.cch = &fakeCache{
+		containers: map[string]cache.Container{
+			"pinned": pinned, "shared": shared, "fractional": fractional, "burstable": burstable,
+		},
+	}
+	p.freeCpus = cpuset.MustParse("4-7")
+
+	if cpus, ok := p.kubeletPinnedCpus(pinned); !ok || !cpus.Equals(cpuset.MustParse("2-3")) {
+		t.Errorf("expected container pinned to CPUs 2-3, got %q (%v)", cpus, ok)
+	}
+	for _, c := range []*fakeContainer{shared, fractional, burstable} {
+		if cpus, ok := p.kubeletPinnedCpus(c); ok {
+			t.Errorf("expected container %s not pinned by kubelet, got CPUs %q", c.id, cpus)
+		}
+	}
+
+	resetHint := p.setAdoptHint(pinned)
+	if p.adoptHint.Size() != 0 {
+		t.Errorf("expected no adopt hint with the option disabled, got %q", p.adoptHint)
+	}
+	resetHint()
+
+	p.bpoptions.AdoptKubeletPinnedCpus = true
+	resetHint = p.setAdoptHint(pinned)
+	if !p.adoptHint.Equals(cpuset.MustParse("2-3")) {
+		t.Errorf("expected adopt hint 2-3, got %q", p.adoptHint)
+	}
+
+	// CPUs that cannot be adopted are reallocated as usual.
+	for _, blnDef := range []*BalloonDef{
+		{Name: reservedBalloonDefName},
+		{Name: "shared", SharedPoolOnly: true},
+		{Name: "small", MaxCpus: 1},
+		{Name: "big", MinCpus: 4},
+		{Name: "fits"},
+	} {
+		if bln := p.adoptedBalloon(blnDef, pinned); bln != nil {
+			t.Errorf("expected no adopted balloon of type %q, got %s", blnDef.Name, bln.PrettyName())
+		}
+	}
+	if len(p.balloons) != 0 || !p.freeCpus.Equals(cpuset.MustParse("4-7")) {
+		t.Errorf("expected no changes without adoption, got balloons %v, free CPUs %q", p.balloons, p.freeCpus)
+	}
+
+	resetHint()
+}
+
+func TestMemorySpreadNodes(t *testing.T) {
+	p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7", "", "")
+	a := p.memAllocator
+
+	bw := &BalloonDef{Name: "bw", MemorySpreadNodes: "2-3"}
+	bad := &BalloonDef{Name: "bad", MemorySpreadNodes: "3-4"}
+	if err := p.validateMemorySpreadNodes([]*BalloonDef{bw}, []*BalloonDef{bw}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := p.validateMemorySpreadNodes([]*BalloonDef{bw, bad}, []*BalloonDef{bw, bad})
+	if err == nil || !strings.HasSuffix(err.Error(), "(at balloonTypes[1].memorySpreadNodes)") {
+		t.Errorf("expected error at balloonTypes[1].memorySpreadNodes, got %v", err)
+	}
+
+	mems := idset.NewIDSet(0)
+	bln := &Balloon{Def: bw}
+	c := &fakeContainer{id: "ctr", annotations: map[string]string{}}
+	if got := p.spreadMems(c, bln, mems); got.String() != "0,2,3" {
+		t.Errorf("expected memory spread to 0,2,3, got %s", got)
+	}
+	if mems.String() != "0" {
+		t.Errorf("expected balloon mems unchanged, got %s", mems)
+	}
+
+	c.annotations[memorySpreadNodesKey] = "1"
+	if got := p.spreadMems(c, bln, mems); got.String() != "0,1" {
+		t.Errorf("expected annotation to spread memory to 0,1, got %s", got)
+	}
+	c.annotations[memorySpreadNodesKey] = "7"
+	if _, _, err := p.containerSpreadNodes(c); err == nil {
+		t.Errorf("expected error for unknown annotated memory node")
+	}
+	if got := p.spreadMems(c, bln, mems); got.String() != "0" {
+		t.Errorf("expected invalid annotation to keep memory on 0, got %s", got)
+	}
+
+	// The memory allocator accounts for the whole spread.
+	zone, _, err := a.Allocate(libmem.Container("ctr", "ctr", "burstable", 1<<20,
+		libmem.NodeMaskFromIDSet(idset.NewIDSet(0, 2, 3))))
+	if err != nil {
+		t.Fatalf("unexpected allocation error: %v", err)
+	}
+	if zone != libmem.NewNodeMask(0, 2, 3) {
+		t.Errorf("expected memory allocated from nodes 0,2-3, got %s", zone)
+	}
+}
+
+func TestShareIdleCpusCrossNuma(t *testing.T) {
+	p := newTestPolicy(t, [5]int{1, 1, 2, 4, 1}, "0-3", "4-7")
+	crossing := newTestBalloon(p, &BalloonDef{
+		Name:                   "crossing",
+		ShareIdleCpusInSame:    cfgapi.CPUTopologyLevelNuma,
+		ShareIdleCpusCrossNuma: true,
+	}, "0-1")
+	local := newTestBalloon(p, &BalloonDef{
+		Name:                "local",
+		ShareIdleCpusInSame: cfgapi.CPUTopologyLevelNuma,
+	}, "2-3")
+	remote := newTestBalloon(p, &BalloonDef{Name: "remote"}, "4-5")
+	p.freeCpus = cpuset.MustParse("6-7")
+	p.balloons = []*Balloon{crossing, local, remote}
+	check := func(bln *Balloon, shared, mems string) {
+		t.Helper()
+		if !bln.SharedIdleCpus.Equals(cpuset.MustParse(shared)) {
+			t.Errorf("expected %s to share idle CPUs %q, got %q", bln.Def.Name, shared, bln.SharedIdleCpus)
+		}
+		if bln.Mems.String() != mems {
+			t.Errorf("expected %s on memory nodes %q, got %q", bln.Def.Name, mems, bln.Mems)
+		}
+	}
+
+	// NUMA node 0 has no idle CPUs, only the crossing balloon
+	// shares idle CPUs from NUMA node 1.
+	p.updatePinning(p.shareIdleCpus(p.freeCpus, cpuset.New())...)
+	p.updatePinning(local)
+	check(crossing, "6-7", "0,1")
+	check(local, "", "0")
+
+	// A CPU in NUMA node 0 becomes idle, the crossing balloon stops
+	// sharing CPUs of NUMA node 1 and shares the local idle CPU.
+	local.Cpus = cpuset.New(2)
+	p.freeCpus = p.freeCpus.Union(cpuset.New(3))
+	p.updatePinning(p.shareIdleCpus(cpuset.New(3), cpuset.New())...)
+	check(crossing, "3", "0")
+	check(local, "3", "0")
+
+	// The local idle CPU is allocated again, the crossing balloon
+	// goes back to sharing idle CPUs of NUMA node 1.
+	local.Cpus = cpuset.New(2, 3)
+	p.freeCpus = p.freeCpus.Difference(cpuset.New(3))
+	p.updatePinning(p.shareIdleCpus(cpuset.New(), cpuset.New(3))...)
+	check(crossing, "6-7", "0,1")
+	check(local, "", "0")
+	if remote.SharedIdleCpus.Size() != 0 {
+		t.Errorf("expected remote to share no idle CPUs, got %q", remote.SharedIdleCpus)
+	}
+}
+
+func TestWholeNumaNodes(t *testing.T) {
+	p := newTestPolicy(t, [5]int{2, 1, 2, 2, 1}, "0-1", "2-3", "4-5", "6-7")
+	p.options.System.(*fakeSystem).packages = []cpuset.CPUSet{
+		cpuset.MustParse("0-3"),
+		cpuset.MustParse("4-7"),
+	}
+	allocate := func(cnt int, cpus, mems string) {
+		t.Helper()
+		bln, err := p.newBalloon(&BalloonDef{Name: "numa", WholeNumaNodes: cnt}, false)
+		if err != nil {
+			t.Fatalf("failed to allocate %d whole NUMA nodes: %v", cnt, err)
+		}
+		p.balloons = append(p.balloons, bln)
+		p.updatePinning(bln)
+		if !bln.Cpus.Equals(cpuset.MustParse(cpus)) || bln.Mems.String() != mems {
+			t.Errorf("expected %d whole NUMA nodes with CPUs %q and memory nodes %q, got %q and %q",
+				cnt, cpus, mems, bln.Cpus, bln.Mems)
+		}
+		if !p.freeCpus.Intersection(bln.Cpus).IsEmpty() {
+			t.Errorf("expected CPUs %q of whole NUMA nodes taken from free CPUs %q", bln.Cpus, p.freeCpus)
+		}
+	}
+
+	// CPU 2 is in use, leaving NUMA nodes 0, 2 and 3 free. Two nodes
+	// are allocated from the same package, leaving node 0 free.
+	p.freeCpus = cpuset.MustParse("0-1,3-7")
+	allocate(2, "4-7", "2,3")
+	allocate(1, "0-1", "0")
+	if _, err := p.newBalloon(&BalloonDef{Name: "numa", WholeNumaNodes: 1}, false); err == nil {
+		t.Errorf("expected error when allocating a partially used NUMA node")
+	}
+
+	// Free nodes in different packages are allocated if no package
+	// has enough of them.
+	p.balloons = nil
+	p.freeCpus = cpuset.MustParse("0-1,6-7")
+	allocate(2, "0-1,6-7", "0,3")
+	if p.freeCpus.Size() != 0 {
+		t.Errorf("expected no free CPUs left, got %q", p.freeCpus)
+	}
+}
+
+func TestLimitRebalance(t *testing.T) {
+	p := newTestPolicy(t, [5]int{1, 1, 3, 4, 1})
+	p.freeCpus = cpuset.MustParse("4-7")
+	bln := newTestBalloon(p, &BalloonDef{Name: "rebalanced"}, "0,1,8")
+	ideal, err := p.idealCpus(bln)
+	if err != nil {
+		t.Fatalf("failed to get ideal CPUs: %v", err)
+	}
+	if !ideal.Equals(cpuset.MustParse("4-6")) {
+		t.Fatalf("expected ideal CPUs %q, got %q", "4-6", ideal)
+	}
+
+	// Moving one CPU at a time, the lone CPU in the third NUMA node
+	// goes first.
+	add, remove, err := p.limitRebalance(bln, ideal.Difference(bln.Cpus), bln.Cpus.Difference(ideal), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if add.Size() != 1 || !add.IsSubsetOf(ideal) {
+		t.Errorf("expected to add one of the ideal CPUs %q, got %q", ideal, add)
+	}
+	if !remove.Equals(cpuset.New(8)) {
+		t.Errorf("expected to remove CPU 8, got %q", remove)
+	}
+}
+
+func TestRebalanceAvoidSameSocketAs(t *testing.T) {
+	p := newTestPolicy(t, [5]int{2, 1, 2, 2, 2}, "0-3", "4-7", "8-11", "12-15")
+	p.options.System.(*fakeSystem).packages = []cpuset.CPUSet{
+		cpuset.MustParse("0-7"),
+		cpuset.MustParse("8-15"),
+	}
+	otherDef := &BalloonDef{Name: "other"}
+	noisy := newTestBalloon(p, &BalloonDef{Name: "noisy"}, "0-1")
+	loud := newTestBalloon(p, &BalloonDef{Name: "loud", AvoidSameSocketAs: []string{"noisy"}}, "10-13")
+	p.balloons = []*Balloon{
+		noisy,
+		newTestBalloon(p, otherDef, "8"),
+		newTestBalloon(p, otherDef, "15"),
+		loud,
+	}
+	p.freeCpus = cpuset.MustParse("2-7,9,14")
+
+	// The free NUMA node 4-7 would be more local for the loud
+	// balloon, but it is on the socket of the noisy balloon.
+	if p.rebalance() {
+		t.Errorf("expected no CPUs to move, loud balloon moved to %q", loud.Cpus)
+	}
+	if !loud.Cpus.Equals(cpuset.MustParse("10-13")) {
+		t.Errorf("expected loud balloon to keep CPUs %q, got %q", "10-13", loud.Cpus)
+	}
+}
+
+func TestReconfigureValidation(t *testing.T) {
+	tcases := []struct {
+		name          string
+		change        func(*BalloonsOptions)
+		expectedError string
+	}{
+		{
+			name:   "valid rebalancing parameters",
+			change: func(o *BalloonsOptions) { o.RebalanceMaxCpus = 2 },
+		},
+		{
+			name:          "negative rebalance threshold",
+			change:        func(o *BalloonsOptions) { o.RebalanceThreshold = -1 },
+			expectedError: "(at rebalanceThreshold)",
+		},
+		{
+			name:          "negative rebalance max CPUs",
+			change:        func(o *BalloonsOptions) { o.RebalanceMaxCpus = -1 },
+			expectedError: "(at rebalanceMaxCPUs)",
+		},
+		{
+			name:          "negative max inflation step",
+			change:        func(o *BalloonsOptions) { o.MaxInflationStep = -1 },
+			expectedError: "(at maxInflationStep)",
+		},
+		{
+			name:          "invalid balloon log level",
+			change:        func(o *BalloonsOptions) { o.BalloonDefs[1].LogLevel = "trace" },
+			expectedError: "(at balloonTypes[1].logLevel)",
+		},
+		{
+			name:          "invalid memory allocation failure handling",
+			change:        func(o *BalloonsOptions) { o.OnMemoryAllocFailure = "retry" },
+			expectedError: "(at onMemoryAllocFailure)",
+		},
+		{
+			name:          "memory bandwidth reservation over 100%",
+			change:        func(o *BalloonsOptions) { o.BalloonDefs[1].MinMemBandwidthPct = 150 },
+			expectedError: "(at balloonTypes[1].minMemBandwidthPct)",
+		},
+		{
+			name:          "negative namespace CPU quota",
+			change:        func(o *BalloonsOptions) { o.NamespaceCpuQuotas = map[string]int{"tenant": -1} },
+			expectedError: "(at namespaceCPUQuotas.tenant)",
+		},
+		{
+			name:          "invalid mixed namespace quota handling",
+			change:        func(o *BalloonsOptions) { o.MixedNamespaceQuota = "ignore" },
+			expectedError: "(at mixedNamespaceQuota)",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPolicy(t, [5]int{1, 1, 1, 8, 1}, "0-7")
+			p.bpoptions.BalloonDefs = []*BalloonDef{
+				{Name: reservedBalloonDefName, MinBalloons: 1},
+				{Name: defaultBalloonDefName, MinBalloons: 1, MaxBalloons: 1},
+			}
+			defer p.Stop()
+			orig := p.bpoptions.DeepCopy()
+
+			// Changes that do not affect balloons are applied in
+			// place, they must be validated all the same.
+			newCfg := p.bpoptions.DeepCopy()
+			tc.change(newCfg)
+			err := p.Reconfigure(newCfg)
+			if tc.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(p.bpoptions, newCfg) {
+					t.Errorf("expected configuration change to be applied")
+				}
+				return
+			}
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectedError) {
+				t.Errorf("expected error ending with %q, got %v", tc.expectedError, err)
+			}
+			if !reflect.DeepEqual(p.bpoptions, orig) {
+				t.Errorf("expected configuration to be kept on error")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"math"
+)
+
+// resolveCpuPercentages sets MinCpus and MaxCpus of balloon types that
+// are given as percentages of cpuCount CPUs.
+func resolveCpuPercentages(blnDefs []*BalloonDef, cpuCount int) {
+	for _, blnDef := range blnDefs {
+		if blnDef.MinCpusPercent > 0 {
+			blnDef.MinCpus = percentOfCpus(blnDef.MinCpusPercent, cpuCount)
+			log.Debugf("balloon type %q: minCPUs %g%% of %d CPUs: %d",
+				blnDef.Name, blnDef.MinCpusPercent, cpuCount, blnDef.MinCpus)
+		}
+		if blnDef.MaxCpusPercent > 0 {
+			blnDef.MaxCpus = percentOfCpus(blnDef.MaxCpusPercent, cpuCount)
+			log.Debugf("balloon type %q: maxCPUs %g%% of %d CPUs: %d",
+				blnDef.Name, blnDef.MaxCpusPercent, cpuCount, blnDef.MaxCpus)
+		}
+	}
+}
+
+// percentOfCpus returns percent of cpuCount CPUs, rounded up to whole
+// CPUs. Rounding up never yields 0 CPUs from a non-zero percentage.
+func percentOfCpus(percent float64, cpuCount int) int {
+	return int(math.Ceil(percent*float64(cpuCount)/100 - 1e-9))
+}
@@ -0,0 +1,71 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	cfgapi "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/resmgr/policy/balloons"
+	"github.com/containers/nri-plugins/pkg/cpuallocator"
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+)
+
+const (
+	// cpuPriorityKey is a pod annotation key, the value is the CPU
+	// allocator priority (high, normal, low or none) used instead of
+	// the allocatorPriority of the balloon type when CPUs are acquired
+	// for the container.
+	cpuPriorityKey = "cpu-priority." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+)
+
+// containerCpuPriority returns the CPU allocator priority a container is
+// annotated with. Returns false if the container has no valid annotation.
+func containerCpuPriority(c cache.Container) (cpuallocator.CPUPriority, bool) {
+	value, ok := c.GetEffectiveAnnotation(cpuPriorityKey)
+	if !ok {
+		return cpuallocator.PriorityNone, false
+	}
+	switch prio := cfgapi.CPUPriority(value); prio {
+	case cfgapi.PriorityHigh, cfgapi.PriorityNormal, cfgapi.PriorityLow, cfgapi.PriorityNone:
+		return prio.Value(), true
+	}
+	log.Warnf("ignoring invalid %s annotation %q of container %s, expected one of %q, %q, %q or %q",
+		cpuPriorityKey, value, c.PrettyName(),
+		cfgapi.PriorityHigh, cfgapi.PriorityNormal, cfgapi.PriorityLow, cfgapi.PriorityNone)
+	return cpuallocator.PriorityNone, false
+}
+
+// setCpuPriorityHint sets the CPU allocator priority that overrides the
+// priority of balloon types when acquiring CPUs for a container. Returns
+// a function that clears the hint.
+func (p *balloons) setCpuPriorityHint(c cache.Container) func() {
+	prio, ok := containerCpuPriority(c)
+	if !ok {
+		return func() {}
+	}
+	log.Debug("allocating CPUs with priority %s for %s", prio, c.PrettyName())
+	p.cpuPriorityHint = &prio
+	return func() {
+		p.cpuPriorityHint = nil
+	}
+}
+
+// allocatorPriority returns the CPU allocator priority for acquiring
+// CPUs for a balloon of the given type.
+func (p *balloons) allocatorPriority(blnDef *BalloonDef) cpuallocator.CPUPriority {
+	if p.cpuPriorityHint != nil {
+		return *p.cpuPriorityHint
+	}
+	return blnDef.AllocatorPriority.Value()
+}
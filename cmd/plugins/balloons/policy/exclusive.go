@@ -0,0 +1,322 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containers/nri-plugins/pkg/cgroups"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// cpusetCpusExclusive is the cgroup v2 entry for exclusive CPUs.
+	cpusetCpusExclusive = "cpuset.cpus.exclusive"
+	// cpusetCpusPartition is the cgroup v2 entry for the partition type.
+	cpusetCpusPartition = "cpuset.cpus.partition"
+)
+
+var (
+	// cpusetV2Roots returns the directories under which container
+	// cgroups are looked up in the unified cgroup v2 hierarchy, in
+	// hybrid and in pure cgroup v2 mode.
+	cpusetV2Roots = func() []string {
+		return []string{
+			cgroups.GetV2Dir(),
+			cgroups.GetMountDir(),
+		}
+	}
+	// podsCgroups are the cgroups of all pods with the systemd and
+	// the cgroupfs cgroup drivers.
+	podsCgroups = []string{"/kubepods.slice", "/kubepods"}
+)
+
+// exclusiveCpus manages kernel-enforced exclusive CPUs of containers.
+//
+// A container gets exclusive CPUs by turning its cgroup into a partition
+// root with the CPUs in its cpuset.cpus.exclusive. As container cgroups
+// are not direct children of the root cgroup, they can only be remote
+// partitions. For these the kernel requires that every ancestor cgroup
+// lists the CPUs in its cpuset.cpus.exclusive, too. exclusiveCpus keeps
+// cpuset.cpus.exclusive of ancestors in sync with containers claiming
+// exclusive CPUs. Container cgroups themselves are adjusted using NRI.
+// As NRI updates are applied only after the policy is done, CPUs released
+// by a container are kept in its ancestors until its own cgroup is turned
+// back into a member or removed.
+type exclusiveCpus struct {
+	root      string                    // cgroup v2 root directory
+	claims    map[string]exclusiveClaim // container ID -> claimed CPUs
+	releasing map[string]exclusiveClaim // container ID -> released CPUs still in a partition
+	written   map[string]cpuset.CPUSet  // ancestor cgroup -> exclusive CPUs
+}
+
+// exclusiveClaim is the set of CPUs claimed exclusively by a container.
+type exclusiveClaim struct {
+	dir    string        // cgroup directory of the pod of the container
+	cpus   cpuset.CPUSet // exclusive CPUs of the container
+	cgroup string        // cgroup directory of the container, once released
+}
+
+// newExclusiveCpus creates an exclusive CPU manager for the cgroup v2
+// root which has the cgroup of all pods. Returns nil if the kernel does
+// not support cpuset.cpus.exclusive.
+func newExclusiveCpus() *exclusiveCpus {
+	for _, root := range cpusetV2Roots() {
+		for _, pods := range podsCgroups {
+			if _, err := os.Stat(filepath.Join(root, pods, cpusetCpusExclusive)); err == nil {
+				return &exclusiveCpus{
+					root:      root,
+					claims:    map[string]exclusiveClaim{},
+					releasing: map[string]exclusiveClaim{},
+					written:   map[string]cpuset.CPUSet{},
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// claim records the exclusive CPUs of a container in the pod with the
+// given cgroup directory and updates the pod cgroup and its ancestors
+// accordingly. The cgroup of the container itself may not exist yet.
+func (e *exclusiveCpus) claim(id, dir string, cpus cpuset.CPUSet) error {
+	if prev, ok := e.claims[id]; ok && prev.dir == dir && prev.cpus.Equals(cpus) {
+		return nil
+	}
+	delete(e.releasing, id)
+	e.claims[id] = exclusiveClaim{dir: dir, cpus: cpus}
+	return e.sync()
+}
+
+// release drops the exclusive CPUs of a container with the given cgroup
+// directory and updates the pod cgroup and its ancestors accordingly,
+// once the cgroup of the container is no longer a partition. Returns
+// true if the container had claimed CPUs.
+func (e *exclusiveCpus) release(id, cgroup string) (bool, error) {
+	claim, ok := e.claims[id]
+	if !ok {
+		return false, nil
+	}
+	delete(e.claims, id)
+	claim.cgroup = cgroup
+	e.releasing[id] = claim
+	return true, e.sync()
+}
+
+// flush drops the exclusive CPUs of released containers from the pod
+// cgroups and their ancestors, if their cgroups are no longer partitions.
+func (e *exclusiveCpus) flush() error {
+	if len(e.releasing) == 0 {
+		return nil
+	}
+	return e.sync()
+}
+
+// sync writes cpuset.cpus.exclusive of the pod cgroups of claiming
+// containers and of their ancestors. As exclusive CPUs of a cgroup must be a subset of the ones
+// of its parent, CPUs are first added top-down, then removed bottom-up.
+func (e *exclusiveCpus) sync() error {
+	for id, c := range e.releasing {
+		if !e.isPartition(c.cgroup) {
+			delete(e.releasing, id)
+		}
+	}
+
+	wanted := map[string]cpuset.CPUSet{}
+	for _, claims := range []map[string]exclusiveClaim{e.claims, e.releasing} {
+		for _, c := range claims {
+			for _, dir := range cgroupLineage(c.dir) {
+				if cpus, ok := wanted[dir]; ok {
+					wanted[dir] = cpus.Union(c.cpus)
+				} else {
+					wanted[dir] = c.cpus
+				}
+			}
+		}
+	}
+	for dir := range e.written {
+		if _, ok := wanted[dir]; !ok {
+			wanted[dir] = cpuset.New()
+		}
+	}
+
+	dirs := make([]string, 0, len(wanted))
+	for dir := range wanted {
+		dirs = append(dirs, dir)
+	}
+	slices.SortFunc(dirs, func(a, b string) int {
+		return strings.Count(a, "/") - strings.Count(b, "/")
+	})
+
+	for _, dir := range dirs {
+		cpus := e.written[dir].Union(wanted[dir])
+		if err := e.write(dir, cpus); err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := e.write(dirs[i], wanted[dirs[i]]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *exclusiveCpus) write(dir string, cpus cpuset.CPUSet) error {
+	if prev, ok := e.written[dir]; ok && prev.Equals(cpus) {
+		return nil
+	}
+	entry := filepath.Join(e.root, dir, cpusetCpusExclusive)
+	if err := os.WriteFile(entry, []byte(cpus.String()), 0644); err != nil {
+		if cpus.IsEmpty() && os.IsNotExist(err) {
+			// The cgroup is gone, nothing to release.
+			delete(e.written, dir)
+			return nil
+		}
+		return balloonsError("failed to write %q to %s: %w", cpus, entry, err)
+	}
+	log.Debugf("%s: exclusive CPUs %q", dir, cpus)
+	if cpus.IsEmpty() {
+		delete(e.written, dir)
+	} else {
+		e.written[dir] = cpus
+	}
+	return nil
+}
+
+// isPartition returns true if the cgroup with the given directory exists
+// and is not a member of its parent partition.
+func (e *exclusiveCpus) isPartition(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(e.root, dir, cpusetCpusPartition))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(data)) != "member"
+}
+
+// cgroupLineage returns a cgroup directory and its ancestor cgroups,
+// starting from the top-level one, excluding the root.
+func cgroupLineage(dir string) []string {
+	parts := strings.Split(strings.Trim(filepath.Clean(dir), "/"), "/")
+	lineage := []string{}
+	for i := 1; i <= len(parts); i++ {
+		if parts[i-1] != "" {
+			lineage = append(lineage, "/"+filepath.Join(parts[:i]...))
+		}
+	}
+	return lineage
+}
+
+// podCgroupDir returns the cgroup directory of the pod of a container.
+// Unlike the cgroup of the container, it is known already when the
+// container is being created.
+func podCgroupDir(c cache.Container) string {
+	if pod, ok := c.GetPod(); ok {
+		if dir := pod.GetCgroupParent(); dir != "" {
+			return dir
+		}
+	}
+	if dir := c.GetCgroupDir(); dir != "" {
+		return filepath.Dir(dir)
+	}
+	return ""
+}
+
+// probeExclusiveCpus checks once if the kernel supports exclusive CPUs,
+// when UseCpusetExclusive is enabled.
+func (p *balloons) probeExclusiveCpus() {
+	if !p.bpoptions.UseCpusetExclusive || p.exclusive != nil || p.noExclusive {
+		return
+	}
+	if p.exclusive = newExclusiveCpus(); p.exclusive == nil {
+		log.Warnf("%s not supported, using only %s to pin containers",
+			cpusetCpusExclusive, cgroups.CpusetCpus)
+		p.noExclusive = true
+	}
+}
+
+// pinExclusive makes the CPUs of a container exclusive to it, enforced by
+// the kernel, if configured so. Only the single container in a balloon is
+// made exclusive, as long as it is pinned to CPUs of the balloon only. If
+// the kernel does not support exclusive CPUs, cpuset.cpus alone is used.
+func (p *balloons) pinExclusive(c cache.Container, bln *Balloon, cpus cpuset.CPUSet) {
+	if !p.bpoptions.UseCpusetExclusive || p.exclusive == nil {
+		return
+	}
+
+	if bln.Def == p.reservedBalloonDef || bln.Def.SharedPoolOnly || bln.Def.Overlay || bln.ContainerCount() != 1 ||
+		cpus.IsEmpty() || !cpus.IsSubsetOf(bln.Cpus) {
+		p.unpinExclusive(c)
+		return
+	}
+
+	dir := podCgroupDir(c)
+	if dir == "" {
+		log.Warnf("cannot make CPUs %q exclusive to %s: unknown pod cgroup", cpus, c.PrettyName())
+		p.unpinExclusive(c)
+		return
+	}
+
+	if err := p.exclusive.claim(c.GetID(), dir, cpus); err != nil {
+		log.Warnf("failed to make CPUs %q exclusive to %s: %v", cpus, c.PrettyName(), err)
+		p.unpinExclusive(c)
+		return
+	}
+
+	log.Debug("  - making CPUs %q exclusive to %s", cpus, c.PrettyName())
+	c.SetUnified(cpusetCpusExclusive, cpus.String())
+	c.SetUnified(cpusetCpusPartition, "root")
+}
+
+// unpinExclusive turns a container with exclusive CPUs back into an
+// ordinary cgroup without exclusive CPUs.
+func (p *balloons) unpinExclusive(c cache.Container) {
+	if p.releaseExclusive(c) {
+		c.SetUnified(cpusetCpusPartition, "member")
+		c.SetUnified(cpusetCpusExclusive, "")
+	}
+}
+
+// releaseExclusive releases the exclusive CPUs of a container, if any, in
+// ancestor cgroups. Returns true if the container had exclusive CPUs.
+func (p *balloons) releaseExclusive(c cache.Container) bool {
+	if p.exclusive == nil {
+		return false
+	}
+	released, err := p.exclusive.release(c.GetID(), c.GetCgroupDir())
+	if err != nil {
+		log.Warnf("failed to release exclusive CPUs of %s: %v", c.PrettyName(), err)
+	}
+	return released
+}
+
+// flushExclusive releases exclusive CPUs in ancestor cgroups of containers
+// whose own cgroups have been turned back into members since released.
+func (p *balloons) flushExclusive() {
+	if p.exclusive == nil {
+		return
+	}
+	if err := p.exclusive.flush(); err != nil {
+		log.Warnf("failed to release exclusive CPUs: %v", err)
+	}
+}
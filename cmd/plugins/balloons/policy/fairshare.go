@@ -0,0 +1,81 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+)
+
+const (
+	// fairShareMinMilliCpus is the minimum fair share of a container,
+	// given also to containers that do not request CPUs so that they
+	// are not starved on shared idle CPUs.
+	fairShareMinMilliCpus = 100
+)
+
+// fairCpuShares returns the CPU shares of a container in a balloon that
+// shares idle CPUs with other balloons, if fair sharing is enabled. The
+// containers of the balloon together get shares proportional to the CPUs
+// of the balloon, divided between them by their CPU requests. Returns
+// false if fair sharing does not apply to the container.
+func (p *balloons) fairCpuShares(bln *Balloon, cID string) (int64, bool) {
+	if !p.bpoptions.FairShareIdleCpus || bln.SharedIdleCpus.IsEmpty() {
+		return 0, false
+	}
+	if p.bpoptions.PinCPU != nil && !*p.bpoptions.PinCPU {
+		return 0, false
+	}
+
+	mCpu := fairShareMilliCpus(1000*bln.Cpus.Size(),
+		p.containerRequestedMilliCpus(cID), p.requestedMilliCpus(bln), bln.ContainerCount())
+
+	return int64(cache.MilliCPUToShares(int64(mCpu))), true
+}
+
+// setCpuShares sets the CPU shares of a container in a balloon. If fair
+// sharing applies to the container, it gets its fair share. Otherwise
+// shares are restored to match its CPU request, which undoes a fair share
+// set earlier when the balloon stops sharing idle CPUs or fair sharing is
+// disabled.
+func (p *balloons) setCpuShares(c cache.Container, bln *Balloon, exclusiveQoS bool) {
+	cID := c.GetID()
+	shares, ok := p.fairCpuShares(bln, cID)
+	if !ok || exclusiveQoS {
+		shares = int64(cache.MilliCPUToShares(int64(p.containerRequestedMilliCpus(cID))))
+		if c.GetCPUShares() == shares {
+			return
+		}
+		log.Debug("  - restoring CPU shares %d for %s", shares, c.PrettyName())
+	} else {
+		log.Debug("  - setting fair CPU shares %d for %s", shares, c.PrettyName())
+	}
+	c.SetCPUShares(shares)
+}
+
+// fairShareMilliCpus divides the milli-CPUs of a balloon between its
+// containers in proportion to their requests, or evenly if none of them
+// requests CPUs. Every container gets at least fairShareMinMilliCpus.
+func fairShareMilliCpus(blnMilliCpus, ctrRequest, blnRequests, ctrCount int) int {
+	mCpu := 0
+	switch {
+	case ctrCount == 0:
+		return 0
+	case blnRequests == 0:
+		mCpu = blnMilliCpus / ctrCount
+	default:
+		mCpu = int(int64(blnMilliCpus) * int64(ctrRequest) / int64(blnRequests))
+	}
+	return max(mCpu, fairShareMinMilliCpus)
+}
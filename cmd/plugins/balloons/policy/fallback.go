@@ -0,0 +1,97 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// balloonDefChain returns the balloon types tried in order when
+// allocating a balloon of a type: the type itself, its fallbacks
+// depth-first, and finally the default balloon type. Types without
+// fallbacks do not fall back to anything.
+func (p *balloons) balloonDefChain(blnDef *BalloonDef) []*BalloonDef {
+	chain := []*BalloonDef{blnDef}
+	if len(blnDef.Fallbacks) == 0 {
+		return chain
+	}
+
+	var add func(*BalloonDef)
+	add = func(def *BalloonDef) {
+		for _, name := range def.Fallbacks {
+			fallback := p.balloonDefByName(name)
+			if fallback == nil || slices.Contains(chain, fallback) {
+				continue
+			}
+			chain = append(chain, fallback)
+			add(fallback)
+		}
+	}
+	add(blnDef)
+
+	if p.defaultBalloonDef != nil && !slices.Contains(chain, p.defaultBalloonDef) {
+		chain = append(chain, p.defaultBalloonDef)
+	}
+	return chain
+}
+
+// validateFallbacks checks that all balloon types referred to by
+// Fallbacks exist and that fallbacks do not form a cycle.
+func validateFallbacks(userDefs, blnDefs []*BalloonDef) error {
+	byName := map[string]*BalloonDef{}
+	for _, blnDef := range blnDefs {
+		if _, ok := byName[blnDef.Name]; !ok {
+			byName[blnDef.Name] = blnDef
+		}
+	}
+
+	for _, blnDef := range blnDefs {
+		for i, name := range blnDef.Fallbacks {
+			if _, ok := byName[name]; !ok {
+				return configError(fmt.Sprintf("%s.fallbacks[%d]", balloonTypePath(userDefs, blnDef), i),
+					"unknown balloon type %q in fallbacks of balloon type %q", name, blnDef.Name)
+			}
+		}
+	}
+
+	checked := map[string]bool{}
+	var check func(*BalloonDef, []string) error
+	check = func(blnDef *BalloonDef, chain []string) error {
+		if checked[blnDef.Name] {
+			return nil
+		}
+		chain = append(chain, blnDef.Name)
+		for i, name := range blnDef.Fallbacks {
+			if slices.Contains(chain, name) {
+				return configError(fmt.Sprintf("%s.fallbacks[%d]", balloonTypePath(userDefs, blnDef), i),
+					"balloon type fallback cycle: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+			if err := check(byName[name], chain); err != nil {
+				return err
+			}
+		}
+		checked[blnDef.Name] = true
+		return nil
+	}
+
+	for _, blnDef := range blnDefs {
+		if err := check(blnDef, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
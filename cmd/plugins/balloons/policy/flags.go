@@ -20,9 +20,14 @@ import (
 )
 
 type (
-	BalloonsOptions  = cfgapi.Config
-	BalloonDef       = cfgapi.BalloonDef
-	CPUTopologyLevel = cfgapi.CPUTopologyLevel
+	BalloonsOptions     = cfgapi.Config
+	BalloonDef          = cfgapi.BalloonDef
+	CpuProfile          = cfgapi.CpuProfile
+	CPUTopologyLevel    = cfgapi.CPUTopologyLevel
+	PodSelector         = cfgapi.PodSelector
+	MemoryAllocFailure  = cfgapi.MemoryAllocFailure
+	MixedNamespaceQuota = cfgapi.MixedNamespaceQuota
+	BalloonLogLevel     = cfgapi.BalloonLogLevel
 )
 
 var (
@@ -41,6 +46,16 @@ const (
 	CPUTopologyLevelL2Cache   = cfgapi.CPUTopologyLevelL2Cache
 	CPUTopologyLevelCore      = cfgapi.CPUTopologyLevelCore
 	CPUTopologyLevelThread    = cfgapi.CPUTopologyLevelThread
+
+	MemoryAllocFailureFallback = cfgapi.MemoryAllocFailureFallback
+	MemoryAllocFailureWiden    = cfgapi.MemoryAllocFailureWiden
+	MemoryAllocFailureFail     = cfgapi.MemoryAllocFailureFail
+
+	MixedNamespaceQuotaProportional = cfgapi.MixedNamespaceQuotaProportional
+	MixedNamespaceQuotaReject       = cfgapi.MixedNamespaceQuotaReject
+
+	BalloonLogLevelDebug = cfgapi.BalloonLogLevelDebug
+	BalloonLogLevelInfo  = cfgapi.BalloonLogLevelInfo
 )
 
 func setOmittedDefaults(cfg *cfgapi.Config) {
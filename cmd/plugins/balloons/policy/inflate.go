@@ -0,0 +1,103 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"time"
+)
+
+const (
+	// inflateEvent is the policy event that triggers the next
+	// inflation step of balloons limited by MaxInflationStep.
+	inflateEvent = "inflate"
+	// inflationStepInterval is the time between inflation steps.
+	inflationStepInterval = time.Second
+)
+
+// inflationStepCpuCount returns the number of CPUs a balloon with
+// oldCount CPUs can be resized to when newCount CPUs are needed and
+// inflation is limited to maxStep CPUs at once.
+func inflationStepCpuCount(oldCount, newCount, maxStep int) int {
+	if maxStep > 0 && newCount-oldCount > maxStep {
+		return oldCount + maxStep
+	}
+	return newCount
+}
+
+// trackInflation records whether a balloon resized to newCpuCount CPUs
+// still needs more inflation steps to fit newMilliCpus, and starts or
+// stops the timer for inflation steps accordingly.
+func (p *balloons) trackInflation(bln *Balloon, newMilliCpus, newCpuCount int) {
+	if newCpuCount < p.targetCpuCount(bln, newMilliCpus) {
+		bln.inflateMilliCpus = newMilliCpus
+	} else {
+		bln.inflateMilliCpus = 0
+	}
+	p.updateInflationTimer()
+}
+
+// updateInflationTimer starts the timer for inflation steps if any
+// balloon is still being inflated, and stops it otherwise.
+func (p *balloons) updateInflationTimer() {
+	if !p.hasInflatingBalloons() {
+		p.stopInflationTimer()
+		return
+	}
+	if p.inflateStop == nil {
+		p.inflateStop = p.startEventTicker(inflationStepInterval, inflateEvent)
+	}
+}
+
+// stopInflationTimer stops the timer for inflation steps, if running.
+func (p *balloons) stopInflationTimer() {
+	if p.inflateStop != nil {
+		close(p.inflateStop)
+		p.inflateStop = nil
+	}
+}
+
+// hasInflatingBalloons returns true if any balloon is being inflated
+// in steps.
+func (p *balloons) hasInflatingBalloons() bool {
+	for _, bln := range p.balloons {
+		if bln.inflateMilliCpus > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// inflateBalloons runs the next inflation step on balloons that are
+// being inflated in steps. A balloon that cannot be inflated further
+// is left to its current size. Returns true if any balloon was
+// inflated.
+func (p *balloons) inflateBalloons() bool {
+	changed := false
+
+	for _, bln := range p.balloons {
+		if bln.inflateMilliCpus == 0 {
+			continue
+		}
+		before := bln.Cpus.Size()
+		if err := p.resizeBalloon(bln, bln.inflateMilliCpus); err != nil {
+			log.Warnf("failed to inflate balloon %s to fit %d mCPU: %v",
+				bln.PrettyName(), bln.inflateMilliCpus, err)
+		}
+		changed = changed || bln.Cpus.Size() != before
+	}
+
+	p.updateInflationTimer()
+	return changed
+}
@@ -0,0 +1,130 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/containers/nri-plugins/pkg/instrumentation"
+	"github.com/containers/nri-plugins/pkg/resmgr/events"
+)
+
+const (
+	// InspectPath is the HTTP path serving the current balloons.
+	InspectPath = "/balloons"
+	// inspectEvent is the policy event taking a snapshot of balloons.
+	inspectEvent = "inspect"
+	// inspectTimeout is the time to wait for a snapshot of balloons.
+	inspectTimeout = 5 * time.Second
+)
+
+// InspectState is a snapshot of balloons and free CPUs, served as JSON
+// on InspectPath.
+type InspectState struct {
+	Balloons []*InspectBalloon `json:"balloons"`
+	FreeCpus string            `json:"freeCPUs"`
+	// FreeCpuCount is the number of free CPUs.
+	FreeCpuCount int `json:"freeCPUCount"`
+}
+
+// InspectBalloon is a snapshot of a balloon.
+type InspectBalloon struct {
+	Name               string   `json:"name"`
+	Type               string   `json:"type"`
+	Cpus               string   `json:"cpus"`
+	SharedIdleCpus     string   `json:"sharedIdleCPUs,omitempty"`
+	Mems               string   `json:"mems"`
+	RequestedMilliCpus int      `json:"requestedMilliCPUs"`
+	Containers         []string `json:"containers"`
+}
+
+// inspectState takes a snapshot of balloons and free CPUs.
+func (p *balloons) inspectState() *InspectState {
+	state := &InspectState{
+		Balloons:     make([]*InspectBalloon, 0, len(p.balloons)),
+		FreeCpus:     p.freeCpus.String(),
+		FreeCpuCount: p.freeCpus.Size(),
+	}
+	for _, bln := range p.balloons {
+		ib := &InspectBalloon{
+			Name:               bln.PrettyName(),
+			Type:               bln.Def.Name,
+			Cpus:               bln.Cpus.String(),
+			SharedIdleCpus:     bln.SharedIdleCpus.String(),
+			Mems:               bln.Mems.String(),
+			RequestedMilliCpus: p.requestedMilliCpus(bln),
+			Containers:         []string{},
+		}
+		for _, cID := range bln.ContainerIDs() {
+			if c, ok := p.cch.LookupContainer(cID); ok {
+				ib.Containers = append(ib.Containers, c.PrettyName())
+			} else {
+				ib.Containers = append(ib.Containers, cID)
+			}
+		}
+		sort.Strings(ib.Containers)
+		state.Balloons = append(state.Balloons, ib)
+	}
+	return state
+}
+
+// registerInspectHandler starts serving snapshots of balloons over HTTP.
+func (p *balloons) registerInspectHandler() {
+	mux := instrumentation.HTTPServer().GetMux()
+	mux.HandleFunc(InspectPath, p.serveInspect)
+}
+
+// serveInspect serves a snapshot of balloons as JSON. The snapshot is
+// taken by an inspect event, so that it is consistent with the rest of
+// the policy state.
+func (p *balloons) serveInspect(w http.ResponseWriter, _ *http.Request) {
+	reply := make(chan *InspectState, 1)
+	e := &events.Policy{
+		Type:   inspectEvent,
+		Source: PolicyName,
+		Data:   reply,
+	}
+	if err := p.options.SendEvent(e); err != nil {
+		http.Error(w, "failed to inspect balloons: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var state *InspectState
+	select {
+	case state = <-reply:
+	case <-time.After(inspectTimeout):
+		http.Error(w, "timeout inspecting balloons", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Errorf("failed to serve balloons: %v", err)
+	}
+}
+
+// handleInspectEvent replies to an inspect event with a snapshot of
+// balloons.
+func (p *balloons) handleInspectEvent(e *events.Policy) {
+	reply, ok := e.Data.(chan *InspectState)
+	if !ok {
+		log.Errorf("invalid %s event data %T", inspectEvent, e.Data)
+		return
+	}
+	reply <- p.inspectState()
+}
@@ -0,0 +1,147 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// irqAffinityEntry is the per-IRQ procfs entry for CPU affinity.
+	irqAffinityEntry = "smp_affinity_list"
+)
+
+var (
+	// procIrqDir is the procfs directory with per-IRQ entries.
+	procIrqDir = "/proc/irq"
+)
+
+// irqAffinity keeps device IRQs off a set of CPUs.
+type irqAffinity struct {
+	dir       string                // procfs directory with per-IRQ entries
+	away      cpuset.CPUSet         // CPUs IRQs are currently kept away from
+	orig      map[int]cpuset.CPUSet // original affinity of moved IRQs
+	unmovable map[int]struct{}      // IRQs we failed to move
+}
+
+// newIrqAffinity creates an IRQ affinity manager for the given directory.
+func newIrqAffinity(dir string) *irqAffinity {
+	return &irqAffinity{
+		dir:       dir,
+		away:      cpuset.New(),
+		orig:      map[int]cpuset.CPUSet{},
+		unmovable: map[int]struct{}{},
+	}
+}
+
+// moveAway moves IRQs off the given CPUs. IRQs which would be left
+// without any CPUs are moved to the given fallback CPUs. IRQs moved
+// earlier but no longer affected get their original affinity back.
+func (m *irqAffinity) moveAway(away, fallback cpuset.CPUSet) {
+	if away.Equals(m.away) {
+		return
+	}
+
+	log.Debugf("moving IRQs away from CPUs %q (previously %q)", away, m.away)
+	m.away = away
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		log.Warnf("failed to list IRQs: %v", err)
+		return
+	}
+
+	for _, e := range entries {
+		irq, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if _, ok := m.unmovable[irq]; ok {
+			continue
+		}
+
+		orig, moved := m.orig[irq]
+		if !moved {
+			if orig, err = m.getAffinity(irq); err != nil {
+				log.Debugf("failed to read affinity of IRQ %d: %v", irq, err)
+				continue
+			}
+		}
+
+		affinity := orig.Difference(away)
+		switch {
+		case affinity.Equals(orig):
+			if !moved {
+				continue
+			}
+			delete(m.orig, irq)
+		case affinity.IsEmpty():
+			affinity = fallback
+		}
+		if affinity.IsEmpty() {
+			continue
+		}
+
+		if err := m.setAffinity(irq, affinity); err != nil {
+			log.Warnf("skipping IRQ %d, failed to set affinity to %q: %v", irq, affinity, err)
+			m.unmovable[irq] = struct{}{}
+			continue
+		}
+		if !affinity.Equals(orig) {
+			m.orig[irq] = orig
+		}
+		log.Debugf("IRQ %d affinity %q => %q", irq, orig, affinity)
+	}
+}
+
+// restore restores the original affinity of all moved IRQs.
+func (m *irqAffinity) restore() {
+	m.moveAway(cpuset.New(), cpuset.New())
+}
+
+func (m *irqAffinity) getAffinity(irq int) (cpuset.CPUSet, error) {
+	data, err := os.ReadFile(filepath.Join(m.dir, strconv.Itoa(irq), irqAffinityEntry))
+	if err != nil {
+		return cpuset.New(), err
+	}
+	return cpuset.Parse(strings.TrimSpace(string(data)))
+}
+
+func (m *irqAffinity) setAffinity(irq int, cpus cpuset.CPUSet) error {
+	return os.WriteFile(filepath.Join(m.dir, strconv.Itoa(irq), irqAffinityEntry), []byte(cpus.String()), 0644)
+}
+
+// updateIrqAffinity keeps device IRQs away from the CPUs of balloons
+// which request it.
+func (p *balloons) updateIrqAffinity() {
+	away := cpuset.New()
+	for _, bln := range p.balloons {
+		if bln.Def.MoveIrqsAway {
+			away = away.Union(bln.Cpus)
+		}
+	}
+	if away.IsEmpty() && p.irqs == nil {
+		return
+	}
+	if p.irqs == nil {
+		p.irqs = newIrqAffinity(procIrqDir)
+	}
+	p.irqs.moveAway(away, p.reserved)
+}
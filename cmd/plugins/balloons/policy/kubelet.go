@@ -0,0 +1,110 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// kubeletPinnedCpus returns the CPUs of a container if it looks pinned
+// to exclusive CPUs by the static CPU manager policy of kubelet: the
+// container is in the Guaranteed QoS class, requests whole CPUs, and
+// its cpuset has exactly as many CPUs as it requests.
+func (p *balloons) kubeletPinnedCpus(c cache.Container) (cpuset.CPUSet, bool) {
+	if c.GetQOSClass() != corev1.PodQOSGuaranteed {
+		return cpuset.New(), false
+	}
+	reqMilliCpus := p.containerRequestedMilliCpus(c.GetID())
+	if reqMilliCpus <= 0 || reqMilliCpus%1000 != 0 {
+		return cpuset.New(), false
+	}
+	cpus, err := cpuset.Parse(c.GetCpusetCpus())
+	if err != nil || cpus.Size() != reqMilliCpus/1000 {
+		return cpuset.New(), false
+	}
+	return cpus, true
+}
+
+// setAdoptHint sets the CPUs a container synchronized at startup is
+// pinned to by kubelet, if kubelet-pinned containers are adopted.
+// Returns a function that clears the hint.
+func (p *balloons) setAdoptHint(c cache.Container) func() {
+	if !p.bpoptions.AdoptKubeletPinnedCpus {
+		return func() {}
+	}
+	cpus, ok := p.kubeletPinnedCpus(c)
+	if !ok {
+		return func() {}
+	}
+	log.Debug("container %s looks pinned to CPUs %q by kubelet", c.PrettyName(), cpus)
+	p.adoptHint = cpus
+	return func() {
+		p.adoptHint = cpuset.New()
+	}
+}
+
+// adoptedBalloon returns a new balloon of a definition with the CPUs of
+// the adopt hint for a kubelet-pinned container. Returns nil if there is
+// no hint, or if the CPUs cannot be adopted into a balloon of the
+// definition, in which case the container is allocated as usual.
+func (p *balloons) adoptedBalloon(blnDef *BalloonDef, c cache.Container) *Balloon {
+	cpus := p.adoptHint
+	if cpus.Size() == 0 {
+		return nil
+	}
+	switch {
+	case blnDef.Name == reservedBalloonDefName || blnDef.SharedPoolOnly || blnDef.Overlay ||
+		blnDef.WholeNumaNodes > 0 || blnDef.ExclusiveLLC:
+		log.Infof("cannot adopt container %s pinned by kubelet into balloon type %q, reallocating CPUs",
+			c.PrettyName(), blnDef.Name)
+		return nil
+	case blnDef.MinCpus > cpus.Size() || (blnDef.MaxCpus > NoLimit && blnDef.MaxCpus < cpus.Size()):
+		log.Infof("cannot adopt container %s pinned by kubelet to %d CPUs into balloon type %q (%d-%d CPUs), reallocating CPUs",
+			c.PrettyName(), cpus.Size(), blnDef.Name, blnDef.MinCpus, blnDef.MaxCpus)
+		return nil
+	case !cpus.IsSubsetOf(p.freeCpus):
+		log.Infof("cannot adopt container %s pinned by kubelet, CPUs %q are not free, reallocating CPUs",
+			c.PrettyName(), cpus.Difference(p.freeCpus))
+		return nil
+	}
+
+	// Create the balloon with MinCpus from the kubelet-pinned CPUs,
+	// and add the rest of them directly.
+	stickyHint := p.stickyHint
+	p.stickyHint = cpus
+	bln, err := p.newBalloon(blnDef, false)
+	p.stickyHint = stickyHint
+	if err != nil {
+		log.Infof("cannot adopt container %s pinned by kubelet: %v", c.PrettyName(), err)
+		return nil
+	}
+	rest := cpus.Difference(bln.Cpus)
+	p.freeCpus = p.freeCpus.Difference(rest)
+	bln.Cpus = bln.Cpus.Union(rest)
+	bln.Mems = p.closestMemsOfTypes(bln.Cpus, bln.memTypeMask)
+	p.balloons = append(p.balloons, bln)
+
+	if err := p.useCpuClass(bln); err != nil {
+		log.Errorf("failed to apply CPU configuration to adopted balloon %s (cpus: %s): %s",
+			bln.PrettyName(), bln.Cpus, err)
+	}
+	p.updatePinning(p.shareIdleCpus(p.freeCpus, bln.Cpus)...)
+
+	log.Infof("adopting container %s pinned by kubelet to CPUs %q into new balloon %s",
+		c.PrettyName(), cpus, bln.PrettyName())
+	return bln
+}
@@ -0,0 +1,92 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"strconv"
+
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// latencyCriticalKey is a pod annotation key, the value is a boolean
+	// that marks the container latency-critical.
+	latencyCriticalKey = "latency-critical." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+)
+
+// isLatencyCritical returns true if a container is annotated
+// latency-critical.
+func isLatencyCritical(c cache.Container) bool {
+	value, ok := c.GetEffectiveAnnotation(latencyCriticalKey)
+	if !ok {
+		return false
+	}
+	critical, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("ignoring invalid %s annotation %q of container %s: %v",
+			latencyCriticalKey, value, c.PrettyName(), err)
+		return false
+	}
+	return critical
+}
+
+// hasLatencyCritical returns true if any container in a balloon is
+// latency-critical. The most restrictive hint wins, a single
+// latency-critical container makes the whole balloon latency-critical.
+func (p *balloons) hasLatencyCritical(bln *Balloon) bool {
+	if bln.Def.SharedPoolOnly || bln.Def.Overlay {
+		return false
+	}
+	for _, cID := range bln.ContainerIDs() {
+		if c, ok := p.cch.LookupContainer(cID); ok && isLatencyCritical(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// latencyCriticalCpus returns all hyperthreads of the physical cores of
+// latency-critical balloons. These CPUs are never shared as idle CPUs to
+// other balloons.
+func (p *balloons) latencyCriticalCpus() cpuset.CPUSet {
+	cpus := cpuset.New()
+	for _, bln := range p.balloons {
+		if p.hasLatencyCritical(bln) {
+			cpus = cpus.Union(p.coreCpus(bln.Cpus))
+		}
+	}
+	return cpus
+}
+
+// coreCpus returns all hyperthreads of the physical cores of cpus.
+func (p *balloons) coreCpus(cpus cpuset.CPUSet) cpuset.CPUSet {
+	sys := p.cpuTree.system()
+	threads := cpus
+	for _, cpu := range cpus.UnsortedList() {
+		threads = threads.Union(sys.CPU(cpu).ThreadCPUSet())
+	}
+	return threads
+}
+
+// useLatencyCritical returns true if a container in a balloon is pinned
+// to whole cores of the balloon as latency-critical.
+func (p *balloons) useLatencyCritical(c cache.Container, bln *Balloon) bool {
+	if bln.Def.SharedPoolOnly || bln.Def.Overlay {
+		return false
+	}
+	return isLatencyCritical(c)
+}
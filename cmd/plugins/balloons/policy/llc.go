@@ -0,0 +1,140 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"slices"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	policy "github.com/containers/nri-plugins/pkg/resmgr/policy"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+// usesExclusiveLLC returns true if a balloon type gets whole cache
+// groups when RelaxOnFailure preferences up to relaxed are dropped.
+func usesExclusiveLLC(blnDef *BalloonDef, relaxed []string) bool {
+	return blnDef.ExclusiveLLC && !slices.Contains(relaxed, relaxExclusiveLLC)
+}
+
+// allocateCacheGroups allocates whole cache groups with at least cnt
+// CPUs for a balloon with exclusive last-level cache. CPUs of the groups
+// must be free, or already belong to the balloon. Groups which already
+// have CPUs in the balloon are completed first.
+func (p *balloons) allocateCacheGroups(blnDef *BalloonDef, current, free cpuset.CPUSet, cnt int) (cpuset.CPUSet, error) {
+	groups := p.cpuAllocator.CacheGroups()
+	if len(groups) == 0 {
+		return cpuset.New(), balloonsError("balloon type %s: no cache groups for exclusive LLC", blnDef.Name)
+	}
+
+	available := current.Union(free)
+	candidates := []cpuset.CPUSet{}
+	for _, g := range groups {
+		if g.IsSubsetOf(available) && !g.IsSubsetOf(current) {
+			candidates = append(candidates, g)
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b cpuset.CPUSet) int {
+		aUsed, bUsed := !a.Intersection(current).IsEmpty(), !b.Intersection(current).IsEmpty()
+		switch {
+		case aUsed && !bUsed:
+			return -1
+		case !aUsed && bUsed:
+			return 1
+		}
+		return 0
+	})
+
+	cpus := cpuset.New()
+	for _, g := range candidates {
+		if cpus.Size() >= cnt {
+			break
+		}
+		cpus = cpus.Union(g.Difference(current))
+	}
+	if cpus.Size() < cnt {
+		return cpuset.New(), balloonsError("balloon type %s: not enough free cache groups for %d CPUs, %d CPUs in free groups",
+			blnDef.Name, cnt, cpus.Size())
+	}
+
+	log.Debugf("- allocating cache groups with %d CPUs %q for %d CPUs", cpus.Size(), cpus, cnt)
+	return cpus, nil
+}
+
+// cacheGroupCpusToRelease returns at most cnt CPUs a balloon with
+// exclusive last-level cache can release. CPUs outside whole cache
+// groups of the balloon, left there by a relaxed allocation, are
+// released first. Then whole cache groups are released, as long as
+// they fit in cnt.
+func (p *balloons) cacheGroupCpusToRelease(bln *Balloon, cnt int) cpuset.CPUSet {
+	owned := []cpuset.CPUSet{}
+	whole := cpuset.New()
+	for _, g := range p.cpuAllocator.CacheGroups() {
+		if g.IsSubsetOf(bln.Cpus) {
+			owned = append(owned, g)
+			whole = whole.Union(g)
+		}
+	}
+
+	loose := bln.Cpus.Difference(whole).List()
+	if len(loose) >= cnt {
+		return cpuset.New(loose[len(loose)-cnt:]...)
+	}
+
+	cpus := cpuset.New(loose...)
+	for i := len(owned) - 1; i >= 0; i-- {
+		if cpus.Size()+owned[i].Size() <= cnt {
+			cpus = cpus.Union(owned[i])
+		}
+	}
+	return cpus
+}
+
+// exclusiveLLCZones returns topology zones for balloons with exclusive
+// last-level cache, reporting the CPUs of their cache groups.
+func (p *balloons) exclusiveLLCZones() []*policy.TopologyZone {
+	var zones []*policy.TopologyZone
+	for _, bln := range p.balloons {
+		if !bln.Def.ExclusiveLLC {
+			continue
+		}
+		groups := cpuset.New()
+		for _, g := range p.cpuAllocator.CacheGroups() {
+			if g.IsSubsetOf(bln.Cpus) {
+				groups = groups.Union(g)
+			}
+		}
+		cpus := resource.NewMilliQuantity(1000*int64(bln.Cpus.Size()), resource.DecimalSI)
+		zones = append(zones, &policy.TopologyZone{
+			Name: bln.PrettyName(),
+			Type: "balloon",
+			Resources: []*policy.ZoneResource{
+				{
+					Name:        policy.CPUResource,
+					Capacity:    *cpus,
+					Allocatable: *cpus,
+					Available:   *resource.NewMilliQuantity(int64(max(0, p.freeMilliCpus(bln))), resource.DecimalSI),
+				},
+			},
+			Attributes: []*policy.ZoneAttribute{
+				{
+					Name:  policy.ExclusiveLLCAttribute,
+					Value: groups.String(),
+				},
+			},
+		})
+	}
+	return zones
+}
@@ -0,0 +1,65 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+// balloonLogger emits detailed policy logs attributable to a balloon,
+// honoring the log level override of the balloon type.
+type balloonLogger struct {
+	level BalloonLogLevel
+}
+
+// blnLog returns the logger for detailed logs of a balloon.
+func blnLog(bln *Balloon) balloonLogger {
+	if bln == nil || bln.Def == nil {
+		return balloonLogger{}
+	}
+	return balloonLogger{level: bln.Def.LogLevel}
+}
+
+// enabled returns true if detailed logs of the balloon are emitted.
+func (l balloonLogger) enabled() bool {
+	return detailedLogsEnabled(l.level, log.DebugEnabled())
+}
+
+// detailedLogsEnabled returns true if detailed logs of a balloon with
+// the given log level are emitted, given the policy debug state.
+func detailedLogsEnabled(level BalloonLogLevel, policyDebug bool) bool {
+	switch level {
+	case BalloonLogLevelDebug:
+		return true
+	case BalloonLogLevelInfo:
+		return false
+	}
+	return policyDebug
+}
+
+// Debug emits a detailed log message of the balloon. If debugging is
+// disabled for the policy, but enabled for the balloon, the message is
+// emitted at info level.
+func (l balloonLogger) Debug(format string, args ...interface{}) {
+	if !l.enabled() {
+		return
+	}
+	if log.DebugEnabled() {
+		log.Debug(format, args...)
+	} else {
+		log.Info(format, args...)
+	}
+}
+
+// Debugf is an alias for Debug.
+func (l balloonLogger) Debugf(format string, args ...interface{}) {
+	l.Debug(format, args...)
+}
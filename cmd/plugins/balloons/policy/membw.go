@@ -0,0 +1,296 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// resctrlGroupPrefix prefixes resctrl groups created for balloons.
+	resctrlGroupPrefix = "nri-balloons-"
+	// resctrlThrottledGroup is the resctrl group of CPUs of balloons
+	// without memory bandwidth reservations.
+	resctrlThrottledGroup = resctrlGroupPrefix + "throttled"
+)
+
+var (
+	// sysResctrlDir is the mount point of the resctrl filesystem.
+	sysResctrlDir = "/sys/fs/resctrl"
+)
+
+// memBandwidth reserves memory bandwidth for balloons using Intel RDT
+// Memory Bandwidth Allocation (MBA).
+//
+// MBA can only throttle bandwidth, it cannot guarantee any. Therefore
+// CPUs of a balloon with a reservation are assigned to a resctrl group
+// of their own with unthrottled bandwidth, and CPUs of balloons without
+// reservations are assigned to a shared resctrl group throttled to what
+// is left after reservations. The default resctrl group, which has all
+// the other CPUs of the system, is never modified.
+type memBandwidth struct {
+	dir      string         // resctrl mount point
+	domains  []string       // MBA domain IDs
+	minPct   int            // minimum bandwidth percentage supported
+	granPct  int            // bandwidth percentage granularity
+	reserved map[string]int // resctrl group -> reserved percentage
+}
+
+// newMemBandwidth creates a memory bandwidth manager for the resctrl
+// filesystem mounted at the given directory. Returns an error if MBA
+// is not supported.
+func newMemBandwidth(dir string) (*memBandwidth, error) {
+	mb := &memBandwidth{
+		dir:      dir,
+		minPct:   10,
+		granPct:  10,
+		reserved: map[string]int{},
+	}
+
+	info := filepath.Join(dir, "info", "MB")
+	if _, err := os.Stat(info); err != nil {
+		return nil, balloonsError("memory bandwidth allocation not supported: %w", err)
+	}
+	for entry, value := range map[string]*int{"min_bandwidth": &mb.minPct, "bandwidth_gran": &mb.granPct} {
+		data, err := os.ReadFile(filepath.Join(info, entry))
+		if err != nil {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && v > 0 {
+			*value = v
+		}
+	}
+
+	schemata := filepath.Join(dir, "schemata")
+	data, err := os.ReadFile(schemata)
+	if err != nil {
+		return nil, balloonsError("failed to read %s: %w", schemata, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		domains, ok := strings.CutPrefix(strings.TrimSpace(line), "MB:")
+		if !ok {
+			continue
+		}
+		for _, domain := range strings.Split(domains, ";") {
+			if id, _, ok := strings.Cut(domain, "="); ok {
+				mb.domains = append(mb.domains, strings.TrimSpace(id))
+			}
+		}
+	}
+	if len(mb.domains) == 0 {
+		return nil, balloonsError("no memory bandwidth allocation domains in %s", schemata)
+	}
+
+	return mb, nil
+}
+
+// reserve assigns CPUs to a resctrl group with unthrottled bandwidth and
+// throttles the given CPUs to the bandwidth left unreserved.
+func (mb *memBandwidth) reserve(group string, pct int, cpus, throttled cpuset.CPUSet) error {
+	dir := filepath.Join(mb.dir, group)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return balloonsError("failed to create resctrl group %s: %w", dir, err)
+	}
+	if err := mb.write(group, "schemata", mb.schemata(100)); err != nil {
+		return err
+	}
+	if err := mb.write(group, "cpus_list", cpus.String()); err != nil {
+		return err
+	}
+	mb.reserved[group] = pct
+	return mb.throttle(throttled)
+}
+
+// release removes a resctrl group, returning its CPUs to the default
+// group, and relaxes throttling of the given CPUs accordingly.
+func (mb *memBandwidth) release(group string, throttled cpuset.CPUSet) error {
+	if _, ok := mb.reserved[group]; !ok {
+		return nil
+	}
+	delete(mb.reserved, group)
+	if err := mb.remove(group); err != nil {
+		return err
+	}
+	return mb.throttle(throttled)
+}
+
+// throttle assigns CPUs to the throttled resctrl group and sets its
+// bandwidth to the percentage left unreserved, but at least to the
+// supported minimum. The group is removed if nothing is reserved.
+func (mb *memBandwidth) throttle(cpus cpuset.CPUSet) error {
+	if len(mb.reserved) == 0 {
+		return mb.remove(resctrlThrottledGroup)
+	}
+	total := 0
+	for _, pct := range mb.reserved {
+		total += pct
+	}
+	pct := (100 - total) / mb.granPct * mb.granPct
+	if pct < mb.minPct {
+		log.Warnf("memory bandwidth reservations (%d%%) exceed available bandwidth, "+
+			"throttling other balloons only to the minimum %d%%", total, mb.minPct)
+		pct = mb.minPct
+	}
+	dir := filepath.Join(mb.dir, resctrlThrottledGroup)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return balloonsError("failed to create resctrl group %s: %w", dir, err)
+	}
+	if err := mb.write(resctrlThrottledGroup, "schemata", mb.schemata(pct)); err != nil {
+		return err
+	}
+	return mb.write(resctrlThrottledGroup, "cpus_list", cpus.String())
+}
+
+// remove removes a resctrl group, returning its CPUs to the default group.
+func (mb *memBandwidth) remove(group string) error {
+	dir := filepath.Join(mb.dir, group)
+	if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+		return balloonsError("failed to remove resctrl group %s: %w", dir, err)
+	}
+	return nil
+}
+
+// removeResctrlGroups removes all resctrl groups created for balloons
+// in the resctrl filesystem mounted at the given directory, including
+// groups left behind by earlier instances of the policy.
+func removeResctrlGroups(dir string) error {
+	groups, err := filepath.Glob(filepath.Join(dir, resctrlGroupPrefix+"*"))
+	if err != nil {
+		return balloonsError("failed to look up resctrl groups in %s: %w", dir, err)
+	}
+	for _, group := range groups {
+		if err := os.Remove(group); err != nil && !os.IsNotExist(err) {
+			return balloonsError("failed to remove resctrl group %s: %w", group, err)
+		}
+		log.Debugf("removed resctrl group %s", group)
+	}
+	return nil
+}
+
+// schemata returns an MBA schemata line setting all domains to pct.
+func (mb *memBandwidth) schemata(pct int) string {
+	domains := make([]string, 0, len(mb.domains))
+	for _, id := range mb.domains {
+		domains = append(domains, id+"="+strconv.Itoa(pct))
+	}
+	return "MB:" + strings.Join(domains, ";") + "\n"
+}
+
+func (mb *memBandwidth) write(group, entry, value string) error {
+	path := filepath.Join(mb.dir, group, entry)
+	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+		return balloonsError("failed to write %q to %s: %w", strings.TrimSpace(value), path, err)
+	}
+	return nil
+}
+
+// resctrlGroupOf returns the name of the resctrl group of a balloon.
+func resctrlGroupOf(bln *Balloon) string {
+	return resctrlGroupPrefix + bln.Def.Name + "-" + strconv.Itoa(bln.Instance)
+}
+
+// reserveMemBandwidth reserves memory bandwidth for CPUs of a balloon,
+// if configured so. CPUs of balloons without reservations are throttled
+// once any balloon has a reservation.
+func (p *balloons) reserveMemBandwidth(bln *Balloon) {
+	pct := bln.Def.MinMemBandwidthPct
+	if pct == 0 {
+		p.throttleMemBandwidth()
+		return
+	}
+	if p.noMemBandwidth || bln.Cpus.IsEmpty() {
+		return
+	}
+	if p.memBandwidth == nil {
+		mb, err := newMemBandwidth(sysResctrlDir)
+		if err != nil {
+			log.Warnf("ignoring memory bandwidth reservations: %v", err)
+			p.noMemBandwidth = true
+			return
+		}
+		p.memBandwidth = mb
+	}
+	group := resctrlGroupOf(bln)
+	if err := p.memBandwidth.reserve(group, pct, bln.Cpus, p.throttledCpus()); err != nil {
+		log.Warnf("failed to reserve %d%% memory bandwidth for CPUs %q: %v", pct, bln.Cpus, err)
+	} else {
+		log.Debugf("reserve %d%% memory bandwidth for CPUs %q in resctrl group %s", pct, bln.Cpus, group)
+	}
+}
+
+// releaseMemBandwidth releases the memory bandwidth reserved for CPUs
+// of a balloon, if any.
+func (p *balloons) releaseMemBandwidth(bln *Balloon) {
+	if p.memBandwidth == nil {
+		return
+	}
+	if bln.Def.MinMemBandwidthPct == 0 {
+		p.throttleMemBandwidth()
+		return
+	}
+	if err := p.memBandwidth.release(resctrlGroupOf(bln), p.throttledCpus()); err != nil {
+		log.Warnf("failed to release memory bandwidth of CPUs %q: %v", bln.Cpus, err)
+	}
+}
+
+// throttleMemBandwidth updates the CPUs of balloons without memory
+// bandwidth reservations in the throttled resctrl group.
+func (p *balloons) throttleMemBandwidth() {
+	if p.memBandwidth == nil {
+		return
+	}
+	if err := p.memBandwidth.throttle(p.throttledCpus()); err != nil {
+		log.Warnf("failed to throttle memory bandwidth of CPUs %q: %v", p.throttledCpus(), err)
+	}
+}
+
+// throttledCpus returns CPUs of balloons without memory bandwidth
+// reservations.
+func (p *balloons) throttledCpus() cpuset.CPUSet {
+	cpus := cpuset.New()
+	for _, bln := range p.balloons {
+		if bln.Def.MinMemBandwidthPct == 0 {
+			cpus = cpus.Union(bln.Cpus)
+		}
+	}
+	return cpus
+}
+
+// removeMemBandwidth removes all resctrl groups created for balloons,
+// returning their CPUs to the default resctrl group.
+func (p *balloons) removeMemBandwidth() {
+	p.memBandwidth = nil
+	if err := removeResctrlGroups(sysResctrlDir); err != nil {
+		log.Warnf("failed to remove memory bandwidth reservations: %v", err)
+	}
+}
+
+// validateMemBandwidth checks memory bandwidth reservations of balloon
+// types.
+func validateMemBandwidth(bpoptions *BalloonsOptions, userDefs []*BalloonDef) error {
+	for _, blnDef := range bpoptions.BalloonDefs {
+		if blnDef.MinMemBandwidthPct < 0 || blnDef.MinMemBandwidthPct > 100 {
+			return configError(balloonTypePath(userDefs, blnDef)+".minMemBandwidthPct",
+				"MinMemBandwidthPct (%d) of balloon type %q is not within 0-100",
+				blnDef.MinMemBandwidthPct, blnDef.Name)
+		}
+	}
+	return nil
+}
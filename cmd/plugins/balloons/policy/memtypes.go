@@ -0,0 +1,56 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"strings"
+
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	libmem "github.com/containers/nri-plugins/pkg/resmgr/lib/memory"
+)
+
+const (
+	// memoryTypesKey is a pod annotation key, the value is a comma-separated
+	// list of memory types that overrides the memory types of the balloon.
+	memoryTypesKey = "memory-types." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+)
+
+// containerMemTypes returns the memory types a container is annotated
+// to use instead of the memory types of its balloon. If the container
+// has no balloons-specific annotation, the generic memory-type annotation
+// is used. A zero mask means no override.
+func containerMemTypes(c cache.Container) (libmem.TypeMask, error) {
+	value, ok := c.GetEffectiveAnnotation(memoryTypesKey)
+	if !ok {
+		return c.MemoryTypes()
+	}
+
+	types := []string{}
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return 0, balloonsError("invalid %s annotation %q: no memory types", memoryTypesKey, value)
+	}
+
+	mask, err := memTypeMaskFromStringList(types)
+	if err != nil {
+		return 0, balloonsError("invalid %s annotation %q: %w", memoryTypesKey, value, err)
+	}
+	return mask, nil
+}
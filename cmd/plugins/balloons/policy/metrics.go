@@ -18,7 +18,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	libmem "github.com/containers/nri-plugins/pkg/resmgr/lib/memory"
 	"github.com/containers/nri-plugins/pkg/resmgr/policy"
 	"github.com/containers/nri-plugins/pkg/utils/cpuset"
 	"github.com/prometheus/client_golang/prometheus"
@@ -27,6 +29,11 @@ import (
 // Prometheus Metric descriptor indices and descriptor table
 const (
 	balloonsDesc = iota
+	memAllocatedDesc
+	memFreeDesc
+	memAllocationsDesc
+	memOversubscribedDesc
+	memMovesDesc
 )
 
 var descriptors = []*prometheus.Desc{
@@ -57,11 +64,56 @@ var descriptors = []*prometheus.Desc{
 			"tot_req_millicpu",
 		}, nil,
 	),
+	memAllocatedDesc: prometheus.NewDesc(
+		"balloons_memory_allocated_bytes",
+		"Memory allocated from a node",
+		[]string{"node"}, nil,
+	),
+	memFreeDesc: prometheus.NewDesc(
+		"balloons_memory_free_bytes",
+		"Memory not allocated from a node, negative if oversubscribed",
+		[]string{"node"}, nil,
+	),
+	memAllocationsDesc: prometheus.NewDesc(
+		"balloons_memory_allocations",
+		"Number of memory allocations by priority",
+		[]string{"priority"}, nil,
+	),
+	memOversubscribedDesc: prometheus.NewDesc(
+		"balloons_memory_oversubscribed_zones",
+		"Number of oversubscribed memory zones",
+		nil, nil,
+	),
+	memMovesDesc: prometheus.NewDesc(
+		"balloons_memory_moves_total",
+		"Memory allocations moved to resolve overcommit",
+		nil, nil,
+	),
+}
+
+// Balloon operations counted and timed per balloon type.
+const (
+	opAllocate = "allocate"
+	opRelease  = "release"
+	opInflate  = "inflate"
+	opDeflate  = "deflate"
+)
+
+var balloonOps = []string{opAllocate, opRelease, opInflate, opDeflate}
+
+// balloonOpStats collects counts and latencies of balloon operations per
+// balloon type. Label values are bounded to configured balloon types.
+type balloonOpStats struct {
+	count   *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	types   map[string]struct{}
 }
 
 // Metrics defines the balloons-specific metrics from policy level.
 type Metrics struct {
 	Balloons []*BalloonMetrics
+	Memory   *libmem.AllocatorStats
+	Ops      *balloonOpStats
 }
 
 // BalloonMetrics define metrics of a balloon instance.
@@ -140,6 +192,13 @@ func (p *balloons) GetMetrics() policy.Metrics {
 		bm.ContainerNames = strings.Join(cNames, ",")
 	}
 
+	if p.memAllocator != nil {
+		stats := p.memAllocator.Stats()
+		policyMetrics.Memory = &stats
+	}
+
+	policyMetrics.Ops = p.opStats
+
 	return policyMetrics
 }
 
@@ -147,6 +206,10 @@ func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
 	for _, d := range descriptors {
 		ch <- d
 	}
+	if m.Ops != nil {
+		m.Ops.count.Describe(ch)
+		m.Ops.latency.Describe(ch)
+	}
 }
 
 func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
@@ -154,6 +217,11 @@ func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	if m.Ops != nil {
+		m.Ops.count.Collect(ch)
+		m.Ops.latency.Collect(ch)
+	}
+
 	for _, bm := range m.Balloons {
 		ch <- prometheus.MustNewConstMetric(
 			descriptors[balloonsDesc],
@@ -181,4 +249,104 @@ func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
 			bm.ContainerNames,
 			strconv.Itoa(bm.ContainerReqMilliCpus))
 	}
+
+	if m.Memory == nil {
+		return
+	}
+
+	for id, ns := range m.Memory.Nodes {
+		node := strconv.Itoa(id)
+		ch <- prometheus.MustNewConstMetric(
+			descriptors[memAllocatedDesc],
+			prometheus.GaugeValue,
+			float64(ns.Allocated),
+			node)
+		ch <- prometheus.MustNewConstMetric(
+			descriptors[memFreeDesc],
+			prometheus.GaugeValue,
+			float64(ns.Free),
+			node)
+	}
+	for prio, count := range m.Memory.Allocations {
+		ch <- prometheus.MustNewConstMetric(
+			descriptors[memAllocationsDesc],
+			prometheus.GaugeValue,
+			float64(count),
+			prio.String())
+	}
+	ch <- prometheus.MustNewConstMetric(
+		descriptors[memOversubscribedDesc],
+		prometheus.GaugeValue,
+		float64(m.Memory.Oversubscribed))
+	ch <- prometheus.MustNewConstMetric(
+		descriptors[memMovesDesc],
+		prometheus.CounterValue,
+		float64(m.Memory.Moves))
+}
+
+func newBalloonOpStats() *balloonOpStats {
+	return &balloonOpStats{
+		count: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "balloons_operations_total",
+				Help: "Number of balloon operations by balloon type and operation.",
+			},
+			[]string{
+				"balloon_type",
+				"operation",
+			},
+		),
+		latency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "balloons_operation_duration_seconds",
+				Help:    "Latency of balloon operations by balloon type and operation.",
+				Buckets: prometheus.ExponentialBuckets(0.0001, 2, 14),
+			},
+			[]string{
+				"balloon_type",
+				"operation",
+			},
+		),
+		types: map[string]struct{}{},
+	}
+}
+
+// setTypes sets the balloon types operations are collected for. Series
+// of new types are pre-created, those of removed types are deleted.
+func (s *balloonOpStats) setTypes(blnDefs []*BalloonDef) {
+	if s == nil {
+		return
+	}
+
+	types := map[string]struct{}{}
+	for _, blnDef := range blnDefs {
+		types[blnDef.Name] = struct{}{}
+		if _, ok := s.types[blnDef.Name]; ok {
+			continue
+		}
+		for _, op := range balloonOps {
+			s.count.WithLabelValues(blnDef.Name, op)
+			s.latency.WithLabelValues(blnDef.Name, op)
+		}
+	}
+	for name := range s.types {
+		if _, ok := types[name]; !ok {
+			s.count.DeletePartialMatch(prometheus.Labels{"balloon_type": name})
+			s.latency.DeletePartialMatch(prometheus.Labels{"balloon_type": name})
+		}
+	}
+	s.types = types
+}
+
+// observe records an operation on a balloon of the given type, started at
+// the given time. Operations of unknown balloon types are ignored.
+func (s *balloonOpStats) observe(blnType, op string, start time.Time) {
+	if s == nil {
+		return
+	}
+	if _, ok := s.types[blnType]; !ok {
+		return
+	}
+	s.count.WithLabelValues(blnType, op).Inc()
+	s.latency.WithLabelValues(blnType, op).Observe(time.Since(start).Seconds())
 }
@@ -0,0 +1,153 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+)
+
+// quotaEpsilon absorbs rounding errors in CPUs attributed to namespaces.
+const quotaEpsilon = 1e-6
+
+// countsInNamespaceQuota returns true if CPUs of a balloon are counted
+// in namespace CPU quotas. CPUs of the reserved balloon, and balloons
+// that do not own their CPUs, are not counted.
+func (p *balloons) countsInNamespaceQuota(bln *Balloon) bool {
+	return bln.Def != p.reservedBalloonDef && !bln.Def.SharedPoolOnly && !bln.Def.Overlay
+}
+
+// namespaceShares divides a balloon between namespaces in proportion to
+// CPU requests of their containers, or in proportion to the number of
+// containers if none of them requests CPUs.
+func namespaceShares(milliCpus, containers map[string]int) map[string]float64 {
+	weights, total := milliCpus, 0
+	for _, mcpu := range milliCpus {
+		total += mcpu
+	}
+	if total == 0 {
+		weights = containers
+		for _, count := range containers {
+			total += count
+		}
+	}
+	shares := map[string]float64{}
+	if total == 0 {
+		return shares
+	}
+	for ns, weight := range weights {
+		shares[ns] = float64(weight) / float64(total)
+	}
+	return shares
+}
+
+// namespaceCpuUsage returns the number of CPUs in balloons attributed to
+// each namespace. If c is not nil, usage is computed as if c was placed
+// in bln, and bln had cpus CPUs.
+func (p *balloons) namespaceCpuUsage(bln *Balloon, c cache.Container, cpus int) map[string]float64 {
+	usage := map[string]float64{}
+	for _, b := range p.balloons {
+		if !p.countsInNamespaceQuota(b) {
+			continue
+		}
+		milliCpus, containers := map[string]int{}, map[string]int{}
+		for podID, ctrIDs := range b.PodIDs {
+			pod, ok := p.cch.LookupPod(podID)
+			if !ok {
+				continue
+			}
+			for _, ctrID := range ctrIDs {
+				milliCpus[pod.GetNamespace()] += p.containerRequestedMilliCpus(ctrID)
+				containers[pod.GetNamespace()]++
+			}
+		}
+		size := b.Cpus.Size()
+		if c != nil && b == bln {
+			milliCpus[c.GetNamespace()] += p.containerRequestedMilliCpus(c.GetID())
+			containers[c.GetNamespace()]++
+			size = cpus
+		}
+		for ns, share := range namespaceShares(milliCpus, containers) {
+			usage[ns] += share * float64(size)
+		}
+	}
+	return usage
+}
+
+// checkNamespaceCpuQuota returns an error if placing a container into a
+// balloon resized to cpus CPUs would make any namespace exceed its CPU
+// quota. Namespaces already over their quota, for instance due to a
+// configuration change, are only refused more CPUs.
+func (p *balloons) checkNamespaceCpuQuota(c cache.Container, bln *Balloon, cpus int) error {
+	quotas := p.bpoptions.NamespaceCpuQuotas
+	if len(quotas) == 0 || !p.countsInNamespaceQuota(bln) {
+		return nil
+	}
+	before := p.namespaceCpuUsage(nil, nil, 0)
+	after := p.namespaceCpuUsage(bln, c, cpus)
+	for _, ns := range slices.Sorted(maps.Keys(quotas)) {
+		quota := float64(quotas[ns])
+		if after[ns] > quota+quotaEpsilon && after[ns] > before[ns]+quotaEpsilon {
+			return fmt.Errorf("CPU quota of namespace %q exceeded: placing container %s in balloon %s would use %.2f CPUs, quota is %d CPUs",
+				ns, c.PrettyName(), bln.PrettyName(), after[ns], quotas[ns])
+		}
+	}
+	return nil
+}
+
+// allowsNamespaceMix returns false if placing a container into a balloon
+// would mix a namespace with a CPU quota with other namespaces, and mixing
+// them is rejected by MixedNamespaceQuota.
+func (p *balloons) allowsNamespaceMix(bln *Balloon, c cache.Container) bool {
+	quotas := p.bpoptions.NamespaceCpuQuotas
+	if len(quotas) == 0 || p.bpoptions.MixedNamespaceQuota != MixedNamespaceQuotaReject {
+		return true
+	}
+	ns := c.GetNamespace()
+	_, limited := quotas[ns]
+	for podID, ctrIDs := range bln.PodIDs {
+		if len(ctrIDs) == 0 {
+			continue
+		}
+		pod, ok := p.cch.LookupPod(podID)
+		if !ok || pod.GetNamespace() == ns {
+			continue
+		}
+		if _, ok := quotas[pod.GetNamespace()]; ok || limited {
+			return false
+		}
+	}
+	return true
+}
+
+// validateNamespaceCpuQuotas checks namespace CPU quotas and the handling
+// of balloons with containers of several namespaces.
+func validateNamespaceCpuQuotas(bpoptions *BalloonsOptions) error {
+	for ns, quota := range bpoptions.NamespaceCpuQuotas {
+		if quota < 0 {
+			return configError(fmt.Sprintf("namespaceCPUQuotas.%s", ns),
+				"negative CPU quota (%d) of namespace %q", quota, ns)
+		}
+	}
+	switch bpoptions.MixedNamespaceQuota {
+	case "", MixedNamespaceQuotaProportional, MixedNamespaceQuotaReject:
+	default:
+		return configError("mixedNamespaceQuota", "invalid MixedNamespaceQuota %q", bpoptions.MixedNamespaceQuota)
+	}
+	return nil
+}
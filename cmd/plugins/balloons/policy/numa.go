@@ -0,0 +1,132 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"slices"
+
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+	idset "github.com/intel/goresctrl/pkg/utils"
+)
+
+// numaNodesWithin returns the IDs of NUMA nodes which have CPUs, all of
+// them within the given CPUs.
+func (p *balloons) numaNodesWithin(cpus cpuset.CPUSet) []idset.ID {
+	sys := p.options.System
+	nodes := []idset.ID{}
+	for _, id := range sys.NodeIDs() {
+		nodeCpus := sys.Node(id).CPUSet()
+		if nodeCpus.Size() > 0 && nodeCpus.IsSubsetOf(cpus) {
+			nodes = append(nodes, id)
+		}
+	}
+	return nodes
+}
+
+// numaNodesOf returns the IDs of NUMA nodes with any of the given CPUs.
+func (p *balloons) numaNodesOf(cpus cpuset.CPUSet) idset.IDSet {
+	sys := p.options.System
+	nodes := idset.NewIDSet()
+	for _, id := range sys.NodeIDs() {
+		if !sys.Node(id).CPUSet().Intersection(cpus).IsEmpty() {
+			nodes.Add(id)
+		}
+	}
+	return nodes
+}
+
+// allocateNumaNodes allocates cnt complete NUMA nodes from free CPUs.
+// Nodes in the same package are preferred. Returns the CPUs of the
+// allocated nodes. Allocated CPUs are not removed from free CPUs.
+func (p *balloons) allocateNumaNodes(cnt int) (cpuset.CPUSet, error) {
+	free := p.numaNodesWithin(p.freeCpus)
+	if len(free) < cnt {
+		return cpuset.New(), balloonsError("%d whole NUMA nodes requested, only %d free: %v",
+			cnt, len(free), free)
+	}
+
+	sys := p.options.System
+	nodes := free[:cnt]
+	for _, pkgID := range sys.PackageIDs() {
+		pkgNodes := sys.Package(pkgID).NodeIDs()
+		inPkg := slices.DeleteFunc(slices.Clone(free), func(id idset.ID) bool {
+			return !slices.Contains(pkgNodes, id)
+		})
+		if len(inPkg) >= cnt {
+			nodes = inPkg[:cnt]
+			break
+		}
+	}
+
+	cpus := cpuset.New()
+	for _, id := range nodes {
+		cpus = cpus.Union(sys.Node(id).CPUSet())
+	}
+	log.Debugf("- allocating whole NUMA nodes %v, CPUs %q", nodes, cpus)
+	return cpus, nil
+}
+
+// validateWholeNumaNodes checks that balloon types allocating whole
+// NUMA nodes can be instantiated from the available CPUs.
+func (p *balloons) validateWholeNumaNodes(bpoptions *BalloonsOptions) error {
+	available := len(p.numaNodesWithin(p.allowed))
+	required := 0
+	for _, blnDef := range bpoptions.BalloonDefs {
+		if blnDef.WholeNumaNodes == 0 {
+			continue
+		}
+		if blnDef.WholeNumaNodes > available {
+			return balloonsError("balloon type %q needs %d whole NUMA nodes, only %d available",
+				blnDef.Name, blnDef.WholeNumaNodes, available)
+		}
+		required += blnDef.WholeNumaNodes * blnDef.MinBalloons
+	}
+	if required > available {
+		return balloonsError("MinBalloons of balloon types need %d whole NUMA nodes, only %d available",
+			required, available)
+	}
+	return nil
+}
+
+// pinsMemory returns true if containers of any balloon type are pinned
+// to memory nodes.
+func (p *balloons) pinsMemory() bool {
+	pinMemory := p.bpoptions.PinMemory == nil || *p.bpoptions.PinMemory
+	for _, blnDef := range p.bpoptions.BalloonDefs {
+		if (blnDef.PinMemory == nil && pinMemory) || (blnDef.PinMemory != nil && *blnDef.PinMemory) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNumaBalancing warns if containers are pinned to memory nodes while
+// kernel NUMA balancing is enabled, as it can migrate memory of containers
+// away from the nodes they are pinned to.
+func (p *balloons) checkNumaBalancing() {
+	if !p.pinsMemory() {
+		return
+	}
+	enabled, err := p.options.System.NumaBalancingEnabled()
+	if err != nil {
+		log.Debug("failed to check kernel NUMA balancing: %v", err)
+		return
+	}
+	if enabled {
+		log.Warn("memory pinning is enabled but so is kernel NUMA balancing, " +
+			"which can migrate memory away from pinned nodes; consider disabling " +
+			"it by writing 0 to /proc/sys/kernel/numa_balancing")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+// overlayCpus returns the CPUs containers in overlay balloons run on:
+// the union of CPUs of all other balloons, except those that do not
+// share their CPUs, or the shared pool if other balloons have no CPUs.
+func (p *balloons) overlayCpus() cpuset.CPUSet {
+	cpus := cpuset.New()
+	for _, bln := range p.balloons {
+		if !bln.Def.Overlay && !bln.Def.NoShareExclusiveIdle {
+			cpus = cpus.Union(bln.Cpus)
+		}
+	}
+	if cpus.IsEmpty() {
+		return p.sharedPoolCpus()
+	}
+	return cpus
+}
+
+// updateOverlays repins containers in overlay balloons if CPUs of other
+// balloons have changed since they were last pinned.
+func (p *balloons) updateOverlays() {
+	cpus := p.overlayCpus()
+	if cpus.Equals(p.overlay) {
+		return
+	}
+	p.overlay = cpus
+
+	blns := balloonsByFunc(p.balloons, func(bln *Balloon) bool {
+		return bln.Def.Overlay
+	})
+	if len(blns) == 0 {
+		return
+	}
+	log.Debugf("CPUs of balloons changed to %q, repinning %d overlay balloons", cpus, len(blns))
+	p.updatePinning(blns...)
+}
@@ -0,0 +1,92 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// pinCpusKey is a pod annotation key, the value is a cpuset the
+	// container is forced to, bypassing balloon allocation.
+	pinCpusKey = "pin-cpus." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+)
+
+// pinnedCpusOf returns the cpuset a container is forced to by annotation.
+func pinnedCpusOf(c cache.Container) (cpuset.CPUSet, bool, error) {
+	value, ok := c.GetEffectiveAnnotation(pinCpusKey)
+	if !ok {
+		return cpuset.New(), false, nil
+	}
+	cpus, err := cpuset.Parse(value)
+	if err != nil {
+		return cpuset.New(), true, balloonsError("invalid %s annotation %q: %w", pinCpusKey, value, err)
+	}
+	if cpus.IsEmpty() {
+		return cpuset.New(), true, balloonsError("invalid %s annotation: empty cpuset", pinCpusKey)
+	}
+	return cpus, true, nil
+}
+
+// allocatePinnedCpus forces a container to the given CPUs outside of
+// any balloon. The CPUs are removed from free CPUs until no container
+// is pinned to them any more.
+func (p *balloons) allocatePinnedCpus(c cache.Container, cpus cpuset.CPUSet) error {
+	if !cpus.IsSubsetOf(p.allowed) {
+		return balloonsError("pinned CPUs %q of container %s are not within allowed CPUs %q",
+			cpus, c.PrettyName(), p.allowed)
+	}
+	for _, bln := range p.balloons {
+		if overlap := bln.Cpus.Intersection(cpus); !overlap.IsEmpty() {
+			return balloonsError("pinned CPUs %q of container %s overlap with CPUs %q of balloon %s",
+				cpus, c.PrettyName(), overlap, bln.PrettyName())
+		}
+	}
+
+	log.Infof("pinning container %s to CPUs %q by annotation", c.PrettyName(), cpus)
+	p.pinnedCpus[c.GetID()] = cpus
+	p.freeCpus = p.freeCpus.Difference(cpus)
+	p.updatePinning(p.shareIdleCpus(cpuset.New(), cpus)...)
+	p.pinCpuMem(c, cpus, p.closestMems(cpus), 0, nil)
+	if err := p.memAllocFailed(c); err != nil {
+		p.releasePinnedCpus(c)
+		return balloonsError("memory allocation for container %s failed: %w", c.PrettyName(), err)
+	}
+	return nil
+}
+
+// releasePinnedCpus releases CPUs a container was forced to. Returns
+// false if the container was not pinned by annotation.
+func (p *balloons) releasePinnedCpus(c cache.Container) bool {
+	cpus, ok := p.pinnedCpus[c.GetID()]
+	if !ok {
+		return false
+	}
+	delete(p.pinnedCpus, c.GetID())
+	if err := p.memAllocator.Release(c.GetID()); err != nil {
+		log.Error("failed to release memory for %s: %v", c.PrettyName(), err)
+	}
+
+	// Keep CPUs that other containers are still pinned to.
+	for _, other := range p.pinnedCpus {
+		cpus = cpus.Difference(other)
+	}
+	log.Infof("released CPUs %q pinned by container %s", cpus, c.PrettyName())
+	p.freeCpus = p.freeCpus.Union(cpus)
+	p.updatePinning(p.shareIdleCpus(cpus, cpuset.New())...)
+	return true
+}
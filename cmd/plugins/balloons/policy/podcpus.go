@@ -0,0 +1,62 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+)
+
+// aggregatePodMilliCpus returns the CPU request a balloon aggregating
+// pod CPUs is sized for when a container is added to it. This includes
+// requests of containers already in the balloon, the added container,
+// and other live containers of its pod which are not yet in any balloon
+// but will be placed in a balloon of the same type.
+func (p *balloons) aggregatePodMilliCpus(bln *Balloon, c cache.Container) int {
+	requests := []int{}
+	for _, cID := range bln.ContainerIDs() {
+		requests = append(requests, p.containerRequestedMilliCpus(cID))
+	}
+	requests = append(requests, p.containerRequestedMilliCpus(c.GetID()))
+
+	if pod, ok := c.GetPod(); ok {
+		for _, ctr := range pod.GetContainers() {
+			if ctr.GetID() == c.GetID() || p.balloonByContainer(ctr) != nil {
+				continue
+			}
+			if state := ctr.GetState(); state == cache.ContainerStateExited || state == cache.ContainerStateStale {
+				continue
+			}
+			if blnDef, err := p.chooseBalloonDef(ctr); err != nil || blnDef != bln.Def {
+				continue
+			}
+			requests = append(requests, p.containerRequestedMilliCpus(ctr.GetID()))
+		}
+	}
+
+	return sumMilliCpus(requests)
+}
+
+// sumMilliCpus returns the total of CPU requests of containers sharing
+// a balloon. The total is rounded up to full CPUs only once, when the
+// balloon is resized, so containers without requests, like best-effort
+// sidecars, need no extra CPUs. Even if all requests are zero, at least
+// 1 mCPU is returned, so that the balloon gets a CPU.
+func sumMilliCpus(requests []int) int {
+	total := 0
+	for _, mCpu := range requests {
+		total += mCpu
+	}
+	return max(1, total)
+}
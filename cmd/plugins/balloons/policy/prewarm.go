@@ -0,0 +1,145 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+)
+
+const (
+	// prewarmCpusKey is a pod annotation key, the value is the number
+	// of CPUs a balloon is inflated to when a container of the pod is
+	// assigned to it.
+	prewarmCpusKey = "prewarm-cpus." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+	// prewarmEvent is the policy event that triggers deflating idle
+	// pre-inflated balloons.
+	prewarmEvent = "prewarm"
+	// defaultPrewarmIdlePeriod is the default time after which a
+	// pre-inflated balloon is deflated.
+	defaultPrewarmIdlePeriod = 5 * time.Minute
+)
+
+// prewarmCpuCount returns the number of CPUs a balloon should be
+// pre-inflated to for a container, or 0 if the container does not ask
+// for it. The annotated value is limited by MaxCpus of the balloon and
+// the CPUs the balloon could grow to.
+func (p *balloons) prewarmCpuCount(c cache.Container, bln *Balloon) int {
+	value, ok := c.GetEffectiveAnnotation(prewarmCpusKey)
+	if !ok {
+		return 0
+	}
+	if bln.Def == p.reservedBalloonDef || bln.Def.SharedPoolOnly || bln.Def.Overlay || bln.Def.WholeNumaNodes > 0 {
+		log.Debugf("ignoring %s annotation of container %s, balloon %s is not resizable",
+			prewarmCpusKey, c.PrettyName(), bln.PrettyName())
+		return 0
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		log.Warnf("ignoring invalid %s annotation %q of container %s: expected a positive number of CPUs",
+			prewarmCpusKey, value, c.PrettyName())
+		return 0
+	}
+	if bln.Def.MaxCpus > NoLimit && count > bln.Def.MaxCpus {
+		log.Warnf("%s annotation of container %s: limiting %d CPUs to maxCPUs %d of balloon %s",
+			prewarmCpusKey, c.PrettyName(), count, bln.Def.MaxCpus, bln.PrettyName())
+		count = bln.Def.MaxCpus
+	}
+	if avail := bln.Cpus.Size() + p.freeCpus.Size(); count > avail {
+		log.Warnf("%s annotation of container %s: limiting %d CPUs to %d CPUs available to balloon %s",
+			prewarmCpusKey, c.PrettyName(), count, avail, bln.PrettyName())
+		count = avail
+	}
+	return count
+}
+
+// prewarmBalloon marks a balloon pre-inflated to at least cpus CPUs.
+// Pre-inflated balloons are not deflated below this size until they
+// have been idle, without new pre-inflating containers, for the
+// configured period.
+func (p *balloons) prewarmBalloon(bln *Balloon, cpus int) {
+	bln.prewarmCpus = max(bln.prewarmCpus, cpus)
+	bln.prewarmedAt = time.Now()
+	if p.prewarmStop == nil {
+		p.updatePrewarmTimer()
+	}
+}
+
+// prewarmIdlePeriod returns the configured idle period of pre-inflated
+// balloons.
+func (p *balloons) prewarmIdlePeriod() time.Duration {
+	if p.bpoptions.PrewarmIdlePeriod == nil || p.bpoptions.PrewarmIdlePeriod.Duration <= 0 {
+		return defaultPrewarmIdlePeriod
+	}
+	return p.bpoptions.PrewarmIdlePeriod.Duration
+}
+
+// updatePrewarmTimer (re)starts the timer for deflating idle balloons
+// if any balloon is pre-inflated, and stops it otherwise. Idle balloons
+// are checked twice per idle period.
+func (p *balloons) updatePrewarmTimer() {
+	p.stopPrewarmTimer()
+	if p.hasPrewarmedBalloons() {
+		p.prewarmStop = p.startEventTicker(p.prewarmIdlePeriod()/2, prewarmEvent)
+	}
+}
+
+// stopPrewarmTimer stops the timer for deflating idle balloons, if running.
+func (p *balloons) stopPrewarmTimer() {
+	if p.prewarmStop != nil {
+		close(p.prewarmStop)
+		p.prewarmStop = nil
+	}
+}
+
+// hasPrewarmedBalloons returns true if any balloon is pre-inflated.
+func (p *balloons) hasPrewarmedBalloons() bool {
+	for _, bln := range p.balloons {
+		if bln.prewarmCpus > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// deflateIdleBalloons deflates pre-inflated balloons which have been
+// idle for the configured period to the size their containers need.
+// Returns true if any balloon was deflated.
+func (p *balloons) deflateIdleBalloons() bool {
+	idle := p.prewarmIdlePeriod()
+	changed := false
+
+	for _, bln := range p.balloons {
+		if bln.prewarmCpus == 0 || time.Since(bln.prewarmedAt) < idle {
+			continue
+		}
+		log.Infof("balloon %s idle for %s, deflating from pre-inflated %d CPUs",
+			bln.PrettyName(), idle, bln.prewarmCpus)
+		bln.prewarmCpus = 0
+		before := bln.Cpus.Size()
+		if err := p.resizeBalloon(bln, max(1, p.requestedMilliCpus(bln))); err != nil {
+			log.Warnf("failed to deflate balloon %s: %v", bln.PrettyName(), err)
+		}
+		changed = changed || bln.Cpus.Size() != before
+	}
+
+	if !p.hasPrewarmedBalloons() {
+		p.stopPrewarmTimer()
+	}
+	return changed
+}
@@ -0,0 +1,84 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	cpucontrol "github.com/containers/nri-plugins/pkg/resmgr/control/cpu"
+)
+
+// cpuProfileClassPrefix prefixes names of CPU classes of CPU profiles.
+const cpuProfileClassPrefix = "balloons-cpuprofile:"
+
+// cpuProfileClass returns the name of the CPU class of a CPU profile.
+func cpuProfileClass(name string) string {
+	return cpuProfileClassPrefix + name
+}
+
+// cpuProfileClasses returns the CPU classes of CPU profiles. The class
+// of a profile is its CPU class overridden by the frequency settings of
+// the profile.
+func cpuProfileClasses(profiles map[string]CpuProfile) map[string]cpucontrol.PolicyClass {
+	classes := make(map[string]cpucontrol.PolicyClass, len(profiles))
+	for name, profile := range profiles {
+		classes[cpuProfileClass(name)] = cpucontrol.PolicyClass{
+			Base: profile.CpuClass,
+			Class: cpucontrol.Class{
+				MinFreq:      profile.MinFreq,
+				MaxFreq:      profile.MaxFreq,
+				FreqGovernor: profile.FreqGovernor,
+			},
+		}
+	}
+	return classes
+}
+
+// setCpuProfiles registers the CPU classes of CPU profiles to the CPU
+// controller, which enforces them on CPUs assigned to them.
+func (p *balloons) setCpuProfiles() {
+	if err := cpucontrol.SetPolicyClasses(p.cch, cpuProfileClasses(p.bpoptions.CpuProfiles)); err != nil {
+		log.Warnf("failed to set CPU classes of CPU profiles: %v", err)
+	}
+}
+
+// cpuClassOf returns the CPU class of a balloon type. The class of the
+// CPU profile of the type, if any, overrides the class of the type.
+func (p *balloons) cpuClassOf(blnDef *BalloonDef) string {
+	if blnDef.CpuProfile != "" {
+		if _, ok := p.bpoptions.CpuProfiles[blnDef.CpuProfile]; ok {
+			return cpuProfileClass(blnDef.CpuProfile)
+		}
+	}
+	return blnDef.CpuClass
+}
+
+// validateCpuProfiles checks CPU profiles and references to them.
+func validateCpuProfiles(bpoptions *BalloonsOptions, userDefs []*BalloonDef) error {
+	for name, profile := range bpoptions.CpuProfiles {
+		if profile.MinFreq > 0 && profile.MaxFreq > 0 && profile.MinFreq > profile.MaxFreq {
+			return configError("cpuProfiles."+name+".minFreq", "MinFreq (%d) > MaxFreq (%d) in CPU profile %q",
+				profile.MinFreq, profile.MaxFreq, name)
+		}
+	}
+	for _, blnDef := range bpoptions.BalloonDefs {
+		if blnDef.CpuProfile == "" {
+			continue
+		}
+		if _, ok := bpoptions.CpuProfiles[blnDef.CpuProfile]; !ok {
+			return configError(balloonTypePath(userDefs, blnDef)+".cpuProfile",
+				"balloon type %q refers to undefined CPU profile %q", blnDef.Name, blnDef.CpuProfile)
+		}
+	}
+	return nil
+}
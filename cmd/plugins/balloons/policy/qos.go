@@ -0,0 +1,168 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"maps"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+// hasExclusiveQoS returns true if a container is in the Guaranteed QoS
+// class with an integer CPU request, and is therefore entitled to whole
+// cores of its balloon when QoS-aware pinning is enabled.
+func hasExclusiveQoS(c cache.Container) bool {
+	if c.GetQOSClass() != corev1.PodQOSGuaranteed {
+		return false
+	}
+	req, ok := c.GetResourceRequirements().Requests[corev1.ResourceCPU]
+	if !ok {
+		return false
+	}
+	mCpu := req.MilliValue()
+	return mCpu > 0 && mCpu%1000 == 0
+}
+
+// wholeCoreCpus returns those CPUs whose all hyperthread siblings are in
+// the same set, that is, CPUs of physical cores fully included in cpus.
+func wholeCoreCpus(cpus cpuset.CPUSet, siblings func(int) cpuset.CPUSet) cpuset.CPUSet {
+	whole := make([]int, 0, cpus.Size())
+	for _, cpu := range cpus.UnsortedList() {
+		if siblings(cpu).IsSubsetOf(cpus) {
+			whole = append(whole, cpu)
+		}
+	}
+	return cpuset.New(whole...)
+}
+
+// wholeCores returns the physical cores fully included in cpus, ordered
+// by the lowest CPU of each core.
+func wholeCores(cpus cpuset.CPUSet, siblings func(int) cpuset.CPUSet) []cpuset.CPUSet {
+	cores := []cpuset.CPUSet{}
+	seen := cpuset.New()
+	for _, cpu := range wholeCoreCpus(cpus, siblings).List() {
+		if seen.Contains(cpu) {
+			continue
+		}
+		core := siblings(cpu)
+		cores = append(cores, core)
+		seen = seen.Union(core)
+	}
+	return cores
+}
+
+// assignQoSCores assigns disjoint cores to containers, in the order of
+// their IDs, enough cores to cover the CPU request of each container. A
+// previous assignment is kept if it still covers the request and does
+// not overlap other assignments, so that containers are not moved from
+// core to core whenever their balloon is repinned. Containers that do
+// not fit in the remaining cores get no assignment.
+func assignQoSCores(cores []cpuset.CPUSet, requests map[string]int, previous map[string]cpuset.CPUSet) map[string]cpuset.CPUSet {
+	all := cpuset.New()
+	for _, core := range cores {
+		all = all.Union(core)
+	}
+	assigned := map[string]cpuset.CPUSet{}
+	used := cpuset.New()
+	pending := []string{}
+	for _, cID := range slices.Sorted(maps.Keys(requests)) {
+		cpus, ok := previous[cID]
+		if ok && cpus.Size() >= requests[cID] && cpus.IsSubsetOf(all) && cpus.Intersection(used).IsEmpty() {
+			assigned[cID] = cpus
+			used = used.Union(cpus)
+			continue
+		}
+		pending = append(pending, cID)
+	}
+	for _, cID := range pending {
+		cpus := cpuset.New()
+		for _, core := range cores {
+			if cpus.Size() >= requests[cID] {
+				break
+			}
+			if core.Intersection(used).IsEmpty() {
+				cpus = cpus.Union(core)
+			}
+		}
+		if cpus.Size() < requests[cID] {
+			continue
+		}
+		assigned[cID] = cpus
+		used = used.Union(cpus)
+	}
+	return assigned
+}
+
+// assignQoSCpus assigns disjoint whole cores of a balloon to its
+// containers with exclusive QoS. Returns all assigned CPUs. Containers
+// that do not fit in the whole cores of the balloon get no cores of
+// their own, but share the whole cores left (see exclusiveQoSCpus).
+func (p *balloons) assignQoSCpus(bln *Balloon) cpuset.CPUSet {
+	requests := map[string]int{}
+	for _, cID := range bln.ContainerIDs() {
+		if c, ok := p.cch.LookupContainer(cID); ok && p.useExclusiveQoS(c, bln) {
+			requests[cID] = p.containerRequestedMilliCpus(cID) / 1000
+		}
+	}
+	if len(requests) == 0 {
+		bln.qosCpus = nil
+		return cpuset.New()
+	}
+
+	sys := p.cpuTree.system()
+	cores := wholeCores(bln.Cpus, func(cpu int) cpuset.CPUSet {
+		return sys.CPU(cpu).ThreadCPUSet()
+	})
+	bln.qosCpus = assignQoSCores(cores, requests, bln.qosCpus)
+	if len(bln.qosCpus) < len(requests) {
+		log.Warnf("not enough whole cores in balloon %s for %d Guaranteed containers, %d share cores",
+			bln.PrettyName(), len(requests), len(requests)-len(bln.qosCpus))
+	}
+
+	assigned := cpuset.New()
+	for _, cpus := range bln.qosCpus {
+		assigned = assigned.Union(cpus)
+	}
+	return assigned
+}
+
+// exclusiveQoSCpus returns the CPUs a container with exclusive QoS, or a
+// latency-critical container, is pinned to in a balloon: whole cores of
+// the balloon, without CPUs shared from idle CPUs. If the balloon has no
+// whole cores, all its CPUs are used. Containers with exclusive QoS that
+// have cores of their own are pinned to them instead, see assignQoSCpus.
+func (p *balloons) exclusiveQoSCpus(bln *Balloon) cpuset.CPUSet {
+	sys := p.cpuTree.system()
+	cpus := wholeCoreCpus(bln.Cpus, func(cpu int) cpuset.CPUSet {
+		return sys.CPU(cpu).ThreadCPUSet()
+	})
+	if cpus.IsEmpty() {
+		return bln.Cpus
+	}
+	return cpus
+}
+
+// useExclusiveQoS returns true if a container in a balloon is pinned
+// according to its exclusive QoS.
+func (p *balloons) useExclusiveQoS(c cache.Container, bln *Balloon) bool {
+	if !p.bpoptions.QoSAwarePinning || bln.Def.SharedPoolOnly || bln.Def.Overlay {
+		return false
+	}
+	return hasExclusiveQoS(c)
+}
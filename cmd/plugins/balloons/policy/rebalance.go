@@ -0,0 +1,199 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"time"
+
+	"github.com/containers/nri-plugins/pkg/resmgr/events"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// rebalanceEvent is the policy event that triggers a rebalancing pass.
+	rebalanceEvent = "rebalance"
+)
+
+// startRebalancer (re)starts the periodic rebalancing timer according
+// to the current configuration. Rebalancing passes are not run on the
+// timer goroutine. Instead, the timer sends a policy event which gets
+// delivered to HandleEvent with the resource manager lock held.
+func (p *balloons) startRebalancer() {
+	p.stopRebalancer()
+
+	if p.bpoptions.RebalanceInterval == nil || p.bpoptions.RebalanceInterval.Duration <= 0 {
+		return
+	}
+
+	interval := p.bpoptions.RebalanceInterval.Duration
+	log.Info("rebalancing balloons every %s", interval)
+	p.rebalanceStop = p.startEventTicker(interval, rebalanceEvent)
+}
+
+// startEventTicker starts sending a policy event of the given type on
+// every interval. Sending the events stops when the returned channel
+// is closed.
+func (p *balloons) startEventTicker(interval time.Duration, eventType string) chan struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				e := &events.Policy{
+					Type:   eventType,
+					Source: PolicyName,
+				}
+				if err := p.options.SendEvent(e); err != nil {
+					log.Warnf("failed to send %s event: %v", eventType, err)
+				}
+			}
+		}
+	}()
+
+	return stop
+}
+
+// stopRebalancer stops the periodic rebalancing timer, if running.
+func (p *balloons) stopRebalancer() {
+	if p.rebalanceStop != nil {
+		close(p.rebalanceStop)
+		p.rebalanceStop = nil
+	}
+}
+
+// rebalance runs a rebalancing pass over all balloons. Returns true
+// if CPUs of any balloon were changed.
+func (p *balloons) rebalance() bool {
+	threshold := max(1, p.bpoptions.RebalanceThreshold)
+	maxCpus := p.bpoptions.RebalanceMaxCpus
+	changed := false
+
+	log.Debug("rebalancing balloons...")
+
+	for _, bln := range p.balloons {
+		if bln.Def == p.reservedBalloonDef || bln.Def.WholeNumaNodes > 0 || bln.Def.ExclusiveLLC || bln.Cpus.Size() == 0 {
+			continue
+		}
+
+		ideal, err := p.idealCpus(bln)
+		if err != nil {
+			log.Debugf("no ideal CPUs for balloon %s: %v", bln.PrettyName(), err)
+			continue
+		}
+
+		current := p.localityScore(bln.Cpus)
+		if current-p.localityScore(ideal) < threshold {
+			continue
+		}
+
+		add := ideal.Difference(bln.Cpus)
+		remove := bln.Cpus.Difference(ideal)
+		if maxCpus > NoLimit && add.Size() > maxCpus {
+			if add, remove, err = p.limitRebalance(bln, add, remove, maxCpus); err != nil {
+				log.Debugf("failed to choose CPUs to move in balloon %s: %v", bln.PrettyName(), err)
+				continue
+			}
+		}
+
+		// With a limited number of CPUs to move, make sure that
+		// the partial move still improves locality.
+		newCpus := bln.Cpus.Difference(remove).Union(add)
+		if p.localityScore(newCpus) >= current {
+			log.Debugf("moving %d CPUs would not improve locality of balloon %s",
+				add.Size(), bln.PrettyName())
+			continue
+		}
+
+		log.Infof("rebalance %s: moving CPUs %q to %q", bln.PrettyName(), remove, add)
+		p.forgetCpuClass(bln)
+		bln.Cpus = newCpus
+		p.freeCpus = p.freeCpus.Difference(add)
+		p.keepUnshared(bln, remove)
+		p.freeCpus = p.freeCpus.Union(remove)
+		if err := p.useCpuClass(bln); err != nil {
+			log.Warnf("failed to apply CPU class to balloon %s: %v", bln.PrettyName(), err)
+		}
+		p.updatePinning(p.shareIdleCpus(remove, add)...)
+		p.updatePinning(bln)
+		changed = true
+	}
+
+	return changed
+}
+
+// limitRebalance chooses cnt CPUs to add to a balloon and cnt CPUs to
+// remove from it, out of all CPUs that would be moved to reach its ideal
+// CPUs. The CPUs are chosen by the same topology-aware logic as when
+// inflating and deflating balloons. As all CPUs to add are among the
+// ideal CPUs, they already satisfy the allocation constraints of the
+// balloon.
+func (p *balloons) limitRebalance(bln *Balloon, add, remove cpuset.CPUSet, cnt int) (cpuset.CPUSet, cpuset.CPUSet, error) {
+	prio := bln.Def.AllocatorPriority.Value().Option()
+
+	addFromCpus, _, err := bln.cpuTreeAlloc.ResizeCpus(bln.Cpus.Difference(remove), add, cnt)
+	if err != nil {
+		return cpuset.New(), cpuset.New(), err
+	}
+	addCpus, err := p.cpuAllocator.AllocateCpus(&addFromCpus, cnt, prio)
+	if err != nil {
+		return cpuset.New(), cpuset.New(), err
+	}
+
+	_, removeFromCpus, err := bln.cpuTreeAlloc.ResizeCpus(remove, p.freeCpus, -cnt)
+	if err != nil {
+		return cpuset.New(), cpuset.New(), err
+	}
+	if _, err = p.cpuAllocator.ReleaseCpus(&removeFromCpus, cnt, prio); err != nil {
+		return cpuset.New(), cpuset.New(), err
+	}
+
+	return addCpus, removeFromCpus, nil
+}
+
+// idealCpus returns the CPUs the policy would choose for a balloon of
+// the same size if it could pick them from its current and all free
+// CPUs. The CPUs are chosen under the same constraints as when
+// inflating the balloon, including sockets avoided by its type.
+func (p *balloons) idealCpus(bln *Balloon) (cpuset.CPUSet, error) {
+	candidates := bln.Cpus.Union(p.freeCpus)
+	avoid := p.avoidedSocketCpus(bln.Def, bln.Cpus)
+	return p.allocateCpusAvoiding(bln.Def, bln.cpuTreeAlloc, avoid, cpuset.New(), candidates, bln.Cpus.Size())
+}
+
+// localityScore returns the number of packages, dies, NUMA nodes and
+// L2 caches that a set of CPUs spans. Smaller is better.
+func (p *balloons) localityScore(cpus cpuset.CPUSet) int {
+	score := 0
+	if err := p.cpuTree.DepthFirstWalk(func(t *cpuTreeNode) error {
+		if t.cpus.Intersection(cpus).Size() == 0 {
+			return WalkSkipChildren
+		}
+		switch t.level {
+		case CPUTopologyLevelPackage, CPUTopologyLevelDie, CPUTopologyLevelNuma, CPUTopologyLevelL2Cache:
+			score++
+		case CPUTopologyLevelCore, CPUTopologyLevelThread:
+			return WalkSkipChildren
+		}
+		return nil
+	}); err != nil && err != WalkSkipChildren && err != WalkStop {
+		log.Warnf("failed to walk CPU tree: %v", err)
+	}
+	return score
+}
@@ -0,0 +1,122 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// reconcileEvent is the policy event that triggers a reconciliation pass.
+	reconcileEvent = "reconcile"
+)
+
+// startReconciler (re)starts the periodic reconciliation timer according
+// to the current configuration. Like rebalancing, reconciliation passes
+// are run by HandleEvent with the resource manager lock held.
+func (p *balloons) startReconciler() {
+	p.stopReconciler()
+
+	if p.bpoptions.ReconcileInterval == nil || p.bpoptions.ReconcileInterval.Duration <= 0 {
+		return
+	}
+
+	interval := p.bpoptions.ReconcileInterval.Duration
+	log.Info("reconciling pinning every %s", interval)
+	p.reconcileStop = p.startEventTicker(interval, reconcileEvent)
+}
+
+// stopReconciler stops the periodic reconciliation timer, if running.
+func (p *balloons) stopReconciler() {
+	if p.reconcileStop != nil {
+		close(p.reconcileStop)
+		p.reconcileStop = nil
+	}
+}
+
+// reconcile runs a reconciliation pass over all balloons. Balloons with
+// containers whose cpusets have drifted from the intended pinning are
+// re-pinned. Returns true if any balloon was re-pinned.
+func (p *balloons) reconcile() bool {
+	drifted := []*Balloon{}
+
+	log.Debug("reconciling pinning...")
+
+	for _, bln := range p.balloons {
+		repin := false
+		for _, cID := range bln.ContainerIDs() {
+			c, ok := p.cch.LookupContainer(cID)
+			if !ok {
+				continue
+			}
+			if drift := pinningDrift(c); drift != "" {
+				log.Infof("reconcile: container %s in balloon %s runs on %s, re-pinning",
+					c.PrettyName(), bln.PrettyName(), drift)
+				repin = true
+			}
+		}
+		if repin {
+			drifted = append(drifted, bln)
+		}
+	}
+
+	if len(drifted) == 0 {
+		return false
+	}
+
+	p.updatePinning(drifted...)
+	return true
+}
+
+// pinningDrift returns how the cpuset of a running container differs
+// from the pinning intended for it, or an empty string if it does not.
+// Containers with pending updates are never considered drifted.
+func pinningDrift(c cache.Container) string {
+	if c.GetState() != cache.ContainerStateRunning || c.HasPending(cache.NRI) {
+		return ""
+	}
+
+	var (
+		dir   = c.GetCgroupDir()
+		drift = []string{}
+	)
+
+	check := func(kind, intended string, read func(string) (cpuset.CPUSet, error)) {
+		if intended == "" {
+			return
+		}
+		expected, err := cpuset.Parse(intended)
+		if err != nil {
+			return
+		}
+		actual, err := read(dir)
+		if err != nil {
+			log.Debugf("failed to reconcile %s of %s: %v", kind, c.PrettyName(), err)
+			return
+		}
+		if !actual.Equals(expected) {
+			drift = append(drift, fmt.Sprintf("%s %q instead of %q", kind, actual, expected))
+		}
+	}
+
+	check("CPUs", c.GetCpusetCpus(), cgroupCpusetCpus)
+	check("memory nodes", c.GetCpusetMems(), cgroupCpusetMems)
+
+	return strings.Join(drift, ", ")
+}
@@ -0,0 +1,145 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containers/nri-plugins/pkg/cpuallocator"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// relaxCoreType enforces PreferCoreType strictly until relaxed.
+	relaxCoreType = "preferCoreType"
+	// relaxSpreadOnPhysicalCores enforces PreferSpreadOnPhysicalCores
+	// strictly until relaxed.
+	relaxSpreadOnPhysicalCores = "preferSpreadOnPhysicalCores"
+	// relaxIsolCpus enforces PreferIsolCpus strictly until relaxed.
+	relaxIsolCpus = "preferIsolCpus"
+	// relaxExclusiveLLC enforces ExclusiveLLC strictly until relaxed.
+	relaxExclusiveLLC = "exclusiveLLC"
+)
+
+// allocateCpus allocates cnt CPUs from free CPUs for a balloon of the
+// given type with the given current CPUs. Preferences listed in the
+// RelaxOnFailure of the balloon type are first enforced strictly, then
+// dropped one by one until the allocation succeeds. Sockets avoided by
+// the balloon type are used only if allocation fails without them.
+func (p *balloons) allocateCpus(blnDef *BalloonDef, ta *cpuTreeAllocator, current, free cpuset.CPUSet, cnt int) (cpuset.CPUSet, error) {
+	return p.allocateCpusAvoiding(blnDef, ta, p.avoidedSocketCpus(blnDef, current), current, free, cnt)
+}
+
+// allocateCpusAvoiding allocates cnt CPUs like allocateCpus, using the
+// avoided CPUs only if allocation fails without them.
+func (p *balloons) allocateCpusAvoiding(blnDef *BalloonDef, ta *cpuTreeAllocator, avoid, current, free cpuset.CPUSet, cnt int) (cpuset.CPUSet, error) {
+	if !avoid.Intersection(free).IsEmpty() {
+		cpus, err := p.allocateCpusRelaxed(blnDef, ta, current, free.Difference(avoid), cnt)
+		if err == nil {
+			return cpus, nil
+		}
+		log.Warnf("balloon type %s: cannot avoid sockets of balloon types %v (cpus %q), allocating %d CPUs from any socket: %v",
+			blnDef.Name, blnDef.AvoidSameSocketAs, avoid, cnt, err)
+	}
+	return p.allocateCpusRelaxed(blnDef, ta, current, free, cnt)
+}
+
+// allocateCpusRelaxed allocates cnt CPUs from free CPUs, relaxing the
+// preferences listed in the RelaxOnFailure of the balloon type.
+func (p *balloons) allocateCpusRelaxed(blnDef *BalloonDef, ta *cpuTreeAllocator, current, free cpuset.CPUSet, cnt int) (cpuset.CPUSet, error) {
+	var (
+		relax = blnDef.RelaxOnFailure
+		cpus  cpuset.CPUSet
+		err   error
+	)
+
+	for i := 0; i <= len(relax); i++ {
+		from := free
+		for _, constraint := range relax[i:] {
+			from = from.Intersection(p.strictCpus(blnDef, constraint, current, free))
+		}
+		if usesExclusiveLLC(blnDef, relax[:i]) {
+			cpus, err = p.allocateCacheGroups(blnDef, current, from, cnt)
+		} else {
+			cpus, err = p.allocateCpusFrom(blnDef, ta, current, from, cnt)
+		}
+		if err == nil {
+			return cpus, nil
+		}
+		if i < len(relax) {
+			log.Infof("balloon type %s: failed to allocate %d CPUs with strict %v, relaxing %s: %v",
+				blnDef.Name, cnt, relax[i:], relax[i], err)
+		}
+	}
+
+	return cpuset.New(), err
+}
+
+// allocateCpusFrom allocates cnt CPUs from the given CPUs for a balloon
+// of the given type with the given current CPUs.
+func (p *balloons) allocateCpusFrom(blnDef *BalloonDef, ta *cpuTreeAllocator, current, from cpuset.CPUSet, cnt int) (cpuset.CPUSet, error) {
+	addFromCpus, _, err := ta.ResizeCpus(current, from, cnt)
+	if err != nil {
+		return cpuset.New(), fmt.Errorf("failed to choose a cpuset for allocating %d CPUs from %q: %w",
+			cnt, from, err)
+	}
+	log.Debugf("- allocating %d CPUs from %q", cnt, addFromCpus)
+	cpus, err := p.cpuAllocator.AllocateCpus(&addFromCpus, cnt, p.allocatorPriority(blnDef).Option())
+	if err != nil {
+		allocErr := &cpuallocator.AllocationError{}
+		if errors.As(err, &allocErr) {
+			log.Infof("cannot allocate %d CPUs for balloon type %s: %v", cnt, blnDef.Name, allocErr)
+		}
+		return cpuset.New(), err
+	}
+	return cpus, nil
+}
+
+// strictCpus returns the CPUs allowed for a balloon of the given type,
+// when the given allocation preference is enforced strictly. If the
+// preference is not in use, all free CPUs are allowed.
+func (p *balloons) strictCpus(blnDef *BalloonDef, constraint string, current, free cpuset.CPUSet) cpuset.CPUSet {
+	switch constraint {
+	case relaxCoreType:
+		switch blnDef.PreferCoreType {
+		case "performance":
+			return p.cpuAllocator.GetCPUPriorities()[cpuallocator.PriorityHigh]
+		case "efficient":
+			return p.cpuAllocator.GetCPUPriorities()[cpuallocator.PriorityLow]
+		}
+	case relaxIsolCpus:
+		if blnDef.PreferIsolCpus {
+			return p.options.System.Isolated()
+		}
+	case relaxSpreadOnPhysicalCores:
+		spread := p.bpoptions.PreferSpreadOnPhysicalCores
+		if blnDef.PreferSpreadOnPhysicalCores != nil {
+			spread = *blnDef.PreferSpreadOnPhysicalCores
+		}
+		if sys := p.cpuTree.system(); spread && sys != nil {
+			// Allow a single thread from each physical core
+			// which does not yet run any CPUs of the balloon.
+			idle := cpuset.New()
+			for _, id := range free.UnsortedList() {
+				if sys.CPU(id).ThreadCPUSet().Intersection(current).IsEmpty() {
+					idle = idle.Union(cpuset.New(id))
+				}
+			}
+			return sys.SingleThreadForCPUs(idle)
+		}
+	}
+	return free
+}
@@ -0,0 +1,85 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"strconv"
+
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	idset "github.com/intel/goresctrl/pkg/utils"
+)
+
+const (
+	// sameNumaKey is a pod annotation key, the value is a boolean that
+	// asks for placing containers of the pod on the same NUMA node.
+	sameNumaKey = "same-numa." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+)
+
+// prefersSameNuma returns true if a container is annotated to prefer
+// the NUMA nodes of other containers of its pod.
+func prefersSameNuma(c cache.Container) bool {
+	value, ok := c.GetEffectiveAnnotation(sameNumaKey)
+	if !ok {
+		return false
+	}
+	same, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("ignoring invalid %s annotation %q of container %s: %v",
+			sameNumaKey, value, c.PrettyName(), err)
+		return false
+	}
+	return same
+}
+
+// podNumaNodes returns the NUMA nodes of CPUs of balloons that already
+// contain other containers of the pod of a container.
+func (p *balloons) podNumaNodes(c cache.Container) idset.IDSet {
+	nodes := idset.NewIDSet()
+	for _, bln := range p.balloons {
+		for _, ctrID := range bln.PodIDs[c.GetPodID()] {
+			if ctrID != c.GetID() {
+				nodes.Add(p.numaNodesOf(bln.Cpus).Members()...)
+				break
+			}
+		}
+	}
+	return nodes
+}
+
+// preferSameNuma narrows candidate balloons of a container that prefers
+// the NUMA nodes of its pod to balloons whose CPUs are all on those
+// nodes. This is only a preference: if no candidate qualifies, or other
+// containers of the pod have not been placed yet, all candidates are
+// returned.
+func (p *balloons) preferSameNuma(blns []*Balloon, c cache.Container) []*Balloon {
+	if len(blns) < 2 || !prefersSameNuma(c) {
+		return blns
+	}
+	podNodes := p.podNumaNodes(c)
+	if podNodes.Size() == 0 {
+		return blns
+	}
+	same := balloonsByFunc(blns, func(bln *Balloon) bool {
+		nodes := p.numaNodesOf(bln.Cpus)
+		return nodes.Size() > 0 && podNodes.Has(nodes.Members()...)
+	})
+	if len(same) == 0 {
+		log.Debugf("no balloon on NUMA nodes %s of the pod of container %s, ignoring %s",
+			podNodes, c.PrettyName(), sameNumaKey)
+		return blns
+	}
+	return same
+}
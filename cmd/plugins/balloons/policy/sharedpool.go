@@ -0,0 +1,52 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+// sharedPoolCpus returns the CPUs containers in shared pool only
+// balloons run on: all free CPUs, or reserved CPUs if none are free.
+func (p *balloons) sharedPoolCpus() cpuset.CPUSet {
+	if !p.freeCpus.IsEmpty() {
+		return p.freeCpus
+	}
+	for _, bln := range p.balloons {
+		if bln.Def == p.reservedBalloonDef {
+			return bln.Cpus
+		}
+	}
+	return p.reserved
+}
+
+// updateSharedPool repins containers in shared pool only balloons if
+// the shared pool has changed since they were last pinned.
+func (p *balloons) updateSharedPool() {
+	pool := p.sharedPoolCpus()
+	if pool.Equals(p.sharedPool) {
+		return
+	}
+	p.sharedPool = pool
+
+	blns := balloonsByFunc(p.balloons, func(bln *Balloon) bool {
+		return bln.Def.SharedPoolOnly
+	})
+	if len(blns) == 0 {
+		return
+	}
+	log.Debugf("shared pool changed to %q, repinning %d balloons", pool, len(blns))
+	p.updatePinning(blns...)
+}
@@ -0,0 +1,102 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/kubernetes"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	libmem "github.com/containers/nri-plugins/pkg/resmgr/lib/memory"
+	idset "github.com/intel/goresctrl/pkg/utils"
+)
+
+const (
+	// memorySpreadNodesKey is a pod annotation key, the value is a list
+	// of memory nodes that overrides the MemorySpreadNodes of the balloon.
+	memorySpreadNodesKey = "memory-spread-nodes." + PolicyName + "." + kubernetes.ResmgrKeyNamespace
+)
+
+// containerSpreadNodes returns the memory nodes a container is annotated
+// to spread its memory over in addition to the closest memory nodes of
+// its CPUs. Returns false if the container has no annotation.
+func (p *balloons) containerSpreadNodes(c cache.Container) (idset.IDSet, bool, error) {
+	value, ok := c.GetEffectiveAnnotation(memorySpreadNodesKey)
+	if !ok {
+		return nil, false, nil
+	}
+	nodes, err := p.parseSpreadNodes(value)
+	if err != nil {
+		return nil, true, balloonsError("invalid %s annotation %q: %w", memorySpreadNodesKey, value, err)
+	}
+	return nodes, true, nil
+}
+
+// parseSpreadNodes parses a list of memory nodes and checks that they
+// exist.
+func (p *balloons) parseSpreadNodes(value string) (idset.IDSet, error) {
+	nodes, err := parseIDSet(value)
+	if err != nil {
+		return nil, err
+	}
+	if nodes.Size() == 0 {
+		return nil, balloonsError("no memory nodes")
+	}
+	if unknown := libmem.NodeMaskFromIDSet(nodes) &^ p.memAllocator.Masks().AvailableNodes(); unknown != 0 {
+		return nil, balloonsError("unknown memory nodes %s", unknown)
+	}
+	return nodes, nil
+}
+
+// spreadMems returns the memory nodes of a container in a balloon: mems
+// widened by the memory spread nodes of the container or its balloon.
+// This only allows memory on the nodes. Memory is interleaved over them
+// only if the workload sets an interleaving memory policy, which cannot
+// be done through the container adjustments available to the policy.
+func (p *balloons) spreadMems(c cache.Container, bln *Balloon, mems idset.IDSet) idset.IDSet {
+	nodes, ok, err := p.containerSpreadNodes(c)
+	if err != nil {
+		log.Error("%v", err)
+		return mems
+	}
+	if !ok {
+		if bln.Def.MemorySpreadNodes == "" {
+			return mems
+		}
+		if nodes, err = p.parseSpreadNodes(bln.Def.MemorySpreadNodes); err != nil {
+			log.Error("invalid memorySpreadNodes %q of balloon type %q: %v",
+				bln.Def.MemorySpreadNodes, bln.Def.Name, err)
+			return mems
+		}
+	}
+	spread := mems.Clone()
+	spread.Add(nodes.Members()...)
+	log.Debug("  - allowing memory of %s on %s instead of %s", c.PrettyName(), spread, mems)
+	return spread
+}
+
+// validateMemorySpreadNodes checks that memory spread nodes of balloon
+// types exist.
+func (p *balloons) validateMemorySpreadNodes(userDefs, blnDefs []*BalloonDef) error {
+	for _, blnDef := range blnDefs {
+		if blnDef.MemorySpreadNodes == "" {
+			continue
+		}
+		if _, err := p.parseSpreadNodes(blnDef.MemorySpreadNodes); err != nil {
+			return configError(balloonTypePath(userDefs, blnDef)+".memorySpreadNodes",
+				"invalid memorySpreadNodes %q in balloon type %q: %w",
+				blnDef.MemorySpreadNodes, blnDef.Name, err)
+		}
+	}
+	return nil
+}
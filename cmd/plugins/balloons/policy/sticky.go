@@ -0,0 +1,115 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// keyStickyCpus is the policy cache key for CPUs previously used by containers.
+	keyStickyCpus = "sticky-cpus"
+)
+
+// restoreStickyCpus restores CPUs previously used by containers from the cache.
+func (p *balloons) restoreStickyCpus() {
+	p.stickyCpus = map[string]string{}
+	if p.cch.GetPolicyEntry(keyStickyCpus, &p.stickyCpus) {
+		log.Info("restored previously used CPUs of %d containers", len(p.stickyCpus))
+	}
+}
+
+// saveStickyCpus saves CPUs used by containers to the cache. Entries of
+// containers which no longer exist are dropped. Saving writes the whole
+// cache, so this is called only when recorded CPUs change.
+func (p *balloons) saveStickyCpus() {
+	for id := range p.stickyCpus {
+		if c, ok := p.cch.LookupContainer(id); !ok || c.GetState() == cache.ContainerStateExited {
+			delete(p.stickyCpus, id)
+		}
+	}
+	p.cch.SetPolicyEntry(keyStickyCpus, p.stickyCpus)
+	if err := p.cch.Save(); err != nil {
+		log.Warnf("failed to save previously used CPUs to cache: %v", err)
+	}
+}
+
+// rememberCpus records the CPUs of the balloon of a container. Returns
+// true if the recorded CPUs changed.
+func (p *balloons) rememberCpus(c cache.Container, bln *Balloon) bool {
+	if !p.bpoptions.StickyCpus || bln.Cpus.Size() == 0 {
+		return false
+	}
+	cpus := bln.Cpus.String()
+	if p.stickyCpus[c.GetID()] == cpus {
+		return false
+	}
+	p.stickyCpus[c.GetID()] = cpus
+	return true
+}
+
+// forgetCpus drops the recorded CPUs of a released container. Returns
+// true if the container had recorded CPUs.
+func (p *balloons) forgetCpus(c cache.Container) bool {
+	if _, ok := p.stickyCpus[c.GetID()]; !ok {
+		return false
+	}
+	delete(p.stickyCpus, c.GetID())
+	return true
+}
+
+// setStickyHint sets the CPUs that should be preferred when allocating
+// CPUs for a container. Returns a function that clears the hint.
+func (p *balloons) setStickyHint(c cache.Container) func() {
+	if !p.bpoptions.StickyCpus {
+		return func() {}
+	}
+	prev, ok := p.stickyCpus[c.GetID()]
+	if !ok {
+		return func() {}
+	}
+	cpus, err := cpuset.Parse(prev)
+	if err != nil {
+		log.Warnf("ignoring invalid previously used CPUs %q of %s: %v", prev, c.PrettyName(), err)
+		return func() {}
+	}
+	log.Debug("preferring previously used CPUs %q for %s", cpus, c.PrettyName())
+	p.stickyHint = cpus
+	return func() {
+		p.stickyHint = cpuset.New()
+	}
+}
+
+// takeStickyCpus allocates up to cnt free CPUs from the current sticky
+// hint for a balloon of the given type. Returns the allocated CPUs, which
+// may be fewer than requested or none at all. Allocated CPUs are not
+// removed from free CPUs. Balloons with exclusive last-level cache
+// allocate whole cache groups instead.
+func (p *balloons) takeStickyCpus(blnDef *BalloonDef, cnt int) cpuset.CPUSet {
+	from := p.stickyHint.Intersection(p.freeCpus)
+	if cnt <= 0 || from.Size() == 0 || blnDef.ExclusiveLLC {
+		return cpuset.New()
+	}
+	if from.Size() <= cnt {
+		return from
+	}
+	cpus, err := p.cpuAllocator.AllocateCpus(&from, cnt, p.allocatorPriority(blnDef).Option())
+	if err != nil {
+		log.Debugf("failed to allocate %d previously used CPUs from %q: %v", cnt, from, err)
+		return cpuset.New()
+	}
+	return cpus
+}
@@ -0,0 +1,154 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containers/nri-plugins/pkg/cgroups"
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+const (
+	// verifyEvent is the policy event that triggers verifying the
+	// pinning of containers whose cpusets the policy has updated.
+	verifyEvent = "verify-pinning"
+	// verifyPinningDelay is the time between verification passes,
+	// giving the runtime time to apply updated cpusets.
+	verifyPinningDelay = 5 * time.Second
+)
+
+var (
+	// cpusetRoots returns the directories under which container cgroups
+	// are looked up: the cgroup v1 cpuset controller, the unified cgroup
+	// v2 hierarchy in hybrid mode and the pure cgroup v2 hierarchy.
+	cpusetRoots = func() []string {
+		return []string{
+			cgroups.Cpuset.Path(),
+			cgroups.GetV2Dir(),
+			cgroups.GetMountDir(),
+		}
+	}
+)
+
+// expectPinning records the CPUs a container is pinned to, to be
+// verified once the runtime has applied the pinning, and starts the
+// timer for verification passes.
+func (p *balloons) expectPinning(c cache.Container, cpus cpuset.CPUSet) {
+	if !p.bpoptions.VerifyPinning || (p.bpoptions.PinCPU != nil && !*p.bpoptions.PinCPU) {
+		return
+	}
+	if p.verifyCpus == nil {
+		p.verifyCpus = map[string]cpuset.CPUSet{}
+	}
+	p.verifyCpus[c.GetID()] = cpus
+	if p.verifyStop == nil {
+		p.verifyStop = p.startEventTicker(verifyPinningDelay, verifyEvent)
+	}
+}
+
+// stopVerifier stops the timer for verification passes, if running.
+func (p *balloons) stopVerifier() {
+	if p.verifyStop != nil {
+		close(p.verifyStop)
+		p.verifyStop = nil
+	}
+}
+
+// verifyPinnings verifies the pinning of containers recorded by
+// expectPinning. Containers whose updates are still pending, or which
+// have not started yet, are left for the next pass. Returns the IDs of
+// containers whose pinning did not stick.
+func (p *balloons) verifyPinnings() []string {
+	if !p.bpoptions.VerifyPinning {
+		p.verifyCpus = nil
+		p.stopVerifier()
+		return nil
+	}
+	mismatch := []string{}
+	for cID, expected := range p.verifyCpus {
+		c, ok := p.cch.LookupContainer(cID)
+		if !ok {
+			delete(p.verifyCpus, cID)
+			continue
+		}
+		switch c.GetState() {
+		case cache.ContainerStateCreating, cache.ContainerStateCreated:
+			continue
+		case cache.ContainerStateRunning:
+			if c.HasPending(cache.NRI) {
+				continue
+			}
+			if !p.verifyPinning(c, expected) {
+				mismatch = append(mismatch, cID)
+			}
+		}
+		delete(p.verifyCpus, cID)
+	}
+	if len(p.verifyCpus) == 0 {
+		p.stopVerifier()
+	}
+	return mismatch
+}
+
+// verifyPinning checks that the CPUs a running container is running on
+// are the ones the policy pinned it to. A mismatch is a sign of another
+// agent, for instance the kubelet CPU manager, also changing the cpuset
+// of the container, fighting with the policy. Returns false on mismatch.
+func (p *balloons) verifyPinning(c cache.Container, expected cpuset.CPUSet) bool {
+	actual, err := cgroupCpusetCpus(c.GetCgroupDir())
+	if err != nil {
+		log.Debugf("failed to verify pinning of %s: %v", c.PrettyName(), err)
+		return true
+	}
+
+	if !actual.Equals(expected) {
+		log.Warnf("container %s runs on CPUs %q instead of %q pinned by the policy, "+
+			"another agent (for instance the kubelet CPU manager) may be changing its cpuset",
+			c.PrettyName(), actual, expected)
+		return false
+	}
+	return true
+}
+
+// cgroupCpusetCpus reads cpuset.cpus of the given cgroup directory.
+func cgroupCpusetCpus(dir string) (cpuset.CPUSet, error) {
+	return cgroupCpuset(dir, cgroups.CpusetCpus)
+}
+
+// cgroupCpusetMems reads cpuset.mems of the given cgroup directory.
+func cgroupCpusetMems(dir string) (cpuset.CPUSet, error) {
+	return cgroupCpuset(dir, cgroups.CpusetMems)
+}
+
+// cgroupCpuset reads a cpuset entry of the given cgroup directory.
+func cgroupCpuset(dir, entry string) (cpuset.CPUSet, error) {
+	if dir == "" {
+		return cpuset.New(), fmt.Errorf("unknown cgroup directory")
+	}
+	for _, root := range cpusetRoots() {
+		data, err := os.ReadFile(filepath.Join(root, dir, entry))
+		if err != nil {
+			continue
+		}
+		return cpuset.Parse(strings.TrimSpace(string(data)))
+	}
+	return cpuset.New(), fmt.Errorf("no %s found for cgroup %s", entry, dir)
+}
@@ -0,0 +1,91 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balloons
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+)
+
+// hasZeroRequestInRequestingPod returns true if a container does not
+// request CPUs while other containers of its pod do, like sidecars
+// injected into pods of otherwise exclusive workloads. Such pods are in
+// the Burstable QoS class, since a container without CPU request never
+// qualifies its pod as Guaranteed.
+func hasZeroRequestInRequestingPod(c cache.Container) bool {
+	if requestsCpus(c) {
+		return false
+	}
+	pod, ok := c.GetPod()
+	if !ok || pod.GetQOSClass() != corev1.PodQOSBurstable {
+		return false
+	}
+	for _, other := range pod.GetContainers() {
+		if other.GetID() != c.GetID() && requestsCpus(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsCpus returns true if a container has a non-zero CPU request.
+func requestsCpus(c cache.Container) bool {
+	req, ok := c.GetResourceRequirements().Requests[corev1.ResourceCPU]
+	return ok && !req.IsZero()
+}
+
+// usesSharedIdle returns true if a container without CPU request is
+// placed and pinned according to ZeroRequestUsesSharedIdle.
+func (p *balloons) usesSharedIdle(c cache.Container) bool {
+	return p.bpoptions.ZeroRequestUsesSharedIdle && hasZeroRequestInRequestingPod(c)
+}
+
+// zeroRequestBalloon returns a balloon of a definition that already has
+// CPUs and runs other containers of the pod of a container without CPU
+// request. Placing the container there instead of the fill chain avoids
+// inflating a balloon just to give it a CPU. Returns nil if there is no
+// such balloon.
+func (p *balloons) zeroRequestBalloon(blnDef *BalloonDef, c cache.Container) *Balloon {
+	if !p.usesSharedIdle(c) || blnDef.SharedPoolOnly || blnDef.Overlay {
+		return nil
+	}
+	blns := balloonsByFunc(p.balloonsByDef(blnDef), func(bln *Balloon) bool {
+		_, samePod := bln.PodIDs[c.GetPodID()]
+		return samePod && bln.Cpus.Size() > 0 &&
+			p.memoryFits(bln, c) && p.allowsNamespaceMix(bln, c)
+	})
+	if len(blns) == 0 {
+		return nil
+	}
+	return blns[0]
+}
+
+// zeroRequestCpus returns the CPUs a container without CPU request is
+// pinned to in a balloon: shared idle CPUs of the balloon, so that CPUs
+// of the balloon stay for containers that request them. Returns false
+// if the container is pinned as usual, because the option is disabled,
+// the balloon has no shared idle CPUs, or no other container in the
+// balloon requests CPUs.
+func (p *balloons) zeroRequestCpus(c cache.Container, bln *Balloon) (cpuset.CPUSet, bool) {
+	if !p.usesSharedIdle(c) || bln.Def.SharedPoolOnly || bln.Def.Overlay {
+		return cpuset.New(), false
+	}
+	if bln.SharedIdleCpus.IsEmpty() || p.requestedMilliCpus(bln) == 0 {
+		return cpuset.New(), false
+	}
+	return bln.SharedIdleCpus, true
+}
@@ -0,0 +1,272 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+
+	"github.com/containers/nri-plugins/pkg/audit"
+)
+
+type DynamicSwap struct {
+	// EnableAbove enables swap when memory pressure rises above
+	// this value. Memory pressure is the percentage of time some
+	// tasks were stalled on memory during the last 10 seconds,
+	// "some avg10" in /proc/pressure/memory.
+	EnableAbove float64
+
+	// DisableBelow disables swap when memory pressure falls below
+	// this value. It must be smaller than EnableAbove, so that
+	// swap is not flipped on and off when pressure stays close to
+	// a single threshold.
+	DisableBelow float64
+}
+
+const (
+	// defaultPressurePeriod is the default interval of checking
+	// memory pressure for classes with dynamic swap.
+	defaultPressurePeriod = 10 * time.Second
+)
+
+var (
+	// memoryPressureFile is the PSI file of system-wide memory pressure.
+	memoryPressureFile = "/proc/pressure/memory"
+)
+
+// validateDynamicSwap checks dynamic swap thresholds of QoS classes.
+func validateDynamicSwap(cfg *pluginConfig) error {
+	for _, class := range cfg.Classes {
+		ds := class.DynamicSwap
+		if ds == nil {
+			continue
+		}
+		if ds.DisableBelow < 0 || ds.EnableAbove > 100 || ds.DisableBelow >= ds.EnableAbove {
+			return fmt.Errorf("class %q: invalid DynamicSwap thresholds, expected 0 <= DisableBelow (%.2f) < EnableAbove (%.2f) <= 100",
+				class.Name, ds.DisableBelow, ds.EnableAbove)
+		}
+	}
+	if cfg.PressureCheckPeriod != "" {
+		period, err := time.ParseDuration(cfg.PressureCheckPeriod)
+		if err != nil {
+			return fmt.Errorf("invalid PressureCheckPeriod %q: %w", cfg.PressureCheckPeriod, err)
+		}
+		if period <= 0 {
+			return fmt.Errorf("invalid PressureCheckPeriod %q: must be positive", cfg.PressureCheckPeriod)
+		}
+	}
+	return nil
+}
+
+// dynamicSwapClass returns the class with the given name if it uses dynamic swap.
+func (p *plugin) dynamicSwapClass(name string) *QoSClass {
+	if p.config == nil {
+		return nil
+	}
+	for i, class := range p.config.Classes {
+		if class.Name == name && class.DynamicSwap != nil {
+			return &p.config.Classes[i]
+		}
+	}
+	return nil
+}
+
+// swapMax returns the memory.swap.max value for the current swap state of a class.
+func (p *plugin) swapMax(class string) string {
+	if p.swapOn[class] {
+		return "max"
+	}
+	return "0"
+}
+
+// trackDynamicSwap starts managing swap of a container in a class with
+// dynamic swap, unless memory.swap.max is set by an annotation. Returns
+// true if the container is managed.
+func (p *plugin) trackDynamicSwap(ctrID, class string, effAnn map[string]string) bool {
+	if p.dynamicSwapClass(class) == nil {
+		return false
+	}
+	if _, ok := effAnn["memory.swap.max"]; ok {
+		log.Debugf("not managing swap of container %s, memory.swap.max set by annotation", ctrID)
+		return false
+	}
+	p.swapCtrs[ctrID] = class
+	return true
+}
+
+// Synchronize learns containers with dynamic swap which were created
+// before the plugin was started, and updates their swap to the current
+// state of their class.
+func (p *plugin) Synchronize(ctx context.Context, pods []*api.PodSandbox, ctrs []*api.Container) ([]*api.ContainerUpdate, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	podByID := map[string]*api.PodSandbox{}
+	for _, pod := range pods {
+		podByID[pod.GetId()] = pod
+	}
+
+	updates := []*api.ContainerUpdate{}
+	for _, ctr := range ctrs {
+		pod, ok := podByID[ctr.GetPodSandboxId()]
+		if !ok {
+			continue
+		}
+		effAnn := effectiveAnnotations(pod, ctr)
+		if class := effAnn["class"]; p.trackDynamicSwap(ctr.GetId(), class, effAnn) {
+			log.Debugf("Synchronize %s: class %q, dynamic swap", pprintCtr(pod, ctr), class)
+			updates = append(updates, swapUpdate(ctr.GetId(), p.swapMax(class)))
+		}
+	}
+	audit.ContainerUpdates(auditSource, "Synchronize", updates, nil)
+	return updates, nil
+}
+
+// RemoveContainer stops managing swap of a removed container.
+func (p *plugin) RemoveContainer(ctx context.Context, pod *api.PodSandbox, ctr *api.Container) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.swapCtrs, ctr.GetId())
+	return nil
+}
+
+// monitorPressure periodically checks memory pressure and flips swap of
+// containers in classes with dynamic swap, until ctx is done.
+func (p *plugin) monitorPressure(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.pressureCheckPeriod()):
+		}
+		if updates := p.checkPressure(); len(updates) > 0 {
+			failed, err := p.stub.UpdateContainers(updates)
+			if err != nil {
+				log.Errorf("failed to update swap of containers: %v", err)
+			}
+			for _, u := range failed {
+				log.Errorf("failed to update swap of container %s", u.GetContainerId())
+			}
+			if err == nil {
+				audit.ContainerUpdates(auditSource, "UpdateContainers", updates, nil)
+			}
+		}
+	}
+}
+
+// pressureCheckPeriod returns the configured interval of checking memory pressure.
+func (p *plugin) pressureCheckPeriod() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.config == nil || p.config.PressureCheckPeriod == "" {
+		return defaultPressurePeriod
+	}
+	period, err := time.ParseDuration(p.config.PressureCheckPeriod)
+	if err != nil || period <= 0 {
+		return defaultPressurePeriod
+	}
+	return period
+}
+
+// checkPressure updates the swap state of classes with dynamic swap
+// according to current memory pressure. It returns the updates needed
+// to containers of classes whose state changed.
+func (p *plugin) checkPressure() []*api.ContainerUpdate {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.config == nil {
+		return nil
+	}
+
+	var (
+		pressure float64
+		err      error
+		read     bool
+		updates  []*api.ContainerUpdate
+	)
+
+	for _, class := range p.config.Classes {
+		ds := class.DynamicSwap
+		if ds == nil {
+			continue
+		}
+		if !read {
+			if pressure, err = readMemoryPressure(memoryPressureFile); err != nil {
+				log.Errorf("cannot check memory pressure for dynamic swap: %v", err)
+				return nil
+			}
+			read = true
+		}
+
+		on := p.swapOn[class.Name]
+		switch {
+		case !on && pressure > ds.EnableAbove:
+			on = true
+		case on && pressure < ds.DisableBelow:
+			on = false
+		default:
+			continue
+		}
+		p.swapOn[class.Name] = on
+
+		log.Infof("memory pressure %.2f %%, swap of class %q: memory.swap.max=%s",
+			pressure, class.Name, p.swapMax(class.Name))
+
+		for id, name := range p.swapCtrs {
+			if name == class.Name {
+				updates = append(updates, swapUpdate(id, p.swapMax(class.Name)))
+			}
+		}
+	}
+
+	return updates
+}
+
+// swapUpdate returns an update setting memory.swap.max of a container.
+func swapUpdate(ctrID, swapMax string) *api.ContainerUpdate {
+	u := &api.ContainerUpdate{}
+	u.SetContainerId(ctrID)
+	u.AddLinuxUnified("memory.swap.max", swapMax)
+	return u
+}
+
+// readMemoryPressure returns the "some avg10" memory pressure from a PSI file.
+func readMemoryPressure(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				return strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("%s: no \"some avg10\" memory pressure", path)
+}
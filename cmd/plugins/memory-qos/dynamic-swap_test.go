@@ -0,0 +1,174 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log = logrus.StandardLogger()
+	os.Exit(m.Run())
+}
+
+func writePressure(t *testing.T, path string, someAvg10 float64) {
+	t.Helper()
+	data := fmt.Sprintf("some avg10=%.2f avg60=0.00 avg300=0.00 total=1234\n"+
+		"full avg10=0.00 avg60=0.00 avg300=0.00 total=567\n", someAvg10)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write memory pressure: %v", err)
+	}
+}
+
+func TestReadMemoryPressure(t *testing.T) {
+	tcases := []struct {
+		name     string
+		data     string
+		expected float64
+		fail     bool
+	}{
+		{
+			name: "some and full",
+			data: "some avg10=12.34 avg60=5.00 avg300=1.00 total=100\n" +
+				"full avg10=3.21 avg60=1.00 avg300=0.50 total=50\n",
+			expected: 12.34,
+		},
+		{
+			name:     "full first",
+			data:     "full avg10=3.21 avg60=1.00 avg300=0.50 total=50\nsome avg10=0.50 avg60=0.00 avg300=0.00 total=10\n",
+			expected: 0.5,
+		},
+		{
+			name: "no some line",
+			data: "full avg10=3.21 avg60=1.00 avg300=0.50 total=50\n",
+			fail: true,
+		},
+		{
+			name: "no avg10",
+			data: "some avg60=5.00 avg300=1.00 total=100\n",
+			fail: true,
+		},
+		{
+			name: "invalid avg10",
+			data: "some avg10=high avg60=5.00 avg300=1.00 total=100\n",
+			fail: true,
+		},
+		{
+			name: "empty",
+			fail: true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "memory")
+			if err := os.WriteFile(path, []byte(tc.data), 0644); err != nil {
+				t.Fatalf("failed to write memory pressure: %v", err)
+			}
+			pressure, err := readMemoryPressure(path)
+			switch {
+			case tc.fail && err == nil:
+				t.Errorf("expected error, got pressure %.2f", pressure)
+			case !tc.fail && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case !tc.fail && pressure != tc.expected:
+				t.Errorf("expected pressure %.2f, got %.2f", tc.expected, pressure)
+			}
+		})
+	}
+
+	if _, err := readMemoryPressure(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected error for missing pressure file")
+	}
+}
+
+func TestCheckPressure(t *testing.T) {
+	saved := memoryPressureFile
+	defer func() { memoryPressureFile = saved }()
+	memoryPressureFile = filepath.Join(t.TempDir(), "memory")
+
+	p := &plugin{
+		config: &pluginConfig{
+			Classes: []QoSClass{
+				{
+					Name:        "dynamic",
+					DynamicSwap: &DynamicSwap{EnableAbove: 20, DisableBelow: 5},
+				},
+				{
+					Name: "static",
+				},
+			},
+		},
+		swapOn: map[string]bool{},
+		swapCtrs: map[string]string{
+			"ctr0": "dynamic",
+			"ctr1": "dynamic",
+			"ctr2": "other",
+		},
+	}
+
+	steps := []struct {
+		pressure float64
+		swapMax  string // expected memory.swap.max of updated containers, "" if no updates
+	}{
+		{pressure: 10},                   // between thresholds, swap stays off
+		{pressure: 20},                   // at EnableAbove, swap stays off
+		{pressure: 25, swapMax: "max"},   // above EnableAbove, swap on
+		{pressure: 30},                   // swap already on
+		{pressure: 10},                   // between thresholds, swap stays on
+		{pressure: 5},                    // at DisableBelow, swap stays on
+		{pressure: 4.99, swapMax: "0"},   // below DisableBelow, swap off
+		{pressure: 15},                   // between thresholds, swap stays off
+		{pressure: 20.5, swapMax: "max"}, // above EnableAbove again
+	}
+	for i, step := range steps {
+		writePressure(t, memoryPressureFile, step.pressure)
+		updates := p.checkPressure()
+		if step.swapMax == "" {
+			if len(updates) != 0 {
+				t.Errorf("step %d: pressure %.2f: unexpected updates %v", i, step.pressure, updates)
+			}
+			continue
+		}
+		ids := []string{}
+		for _, u := range updates {
+			ids = append(ids, u.GetContainerId())
+			if swapMax := u.GetLinux().GetResources().GetUnified()["memory.swap.max"]; swapMax != step.swapMax {
+				t.Errorf("step %d: pressure %.2f: expected memory.swap.max=%s for %s, got %q",
+					i, step.pressure, step.swapMax, u.GetContainerId(), swapMax)
+			}
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, []string{"ctr0", "ctr1"}) {
+			t.Errorf("step %d: pressure %.2f: expected updates of ctr0 and ctr1, got %v", i, step.pressure, ids)
+		}
+		if on := p.swapMax("dynamic") == "max"; on != (step.swapMax == "max") {
+			t.Errorf("step %d: pressure %.2f: unexpected swap state of class", i, step.pressure)
+		}
+	}
+
+	// Unreadable pressure leaves swap state unchanged.
+	if err := os.Remove(memoryPressureFile); err != nil {
+		t.Fatalf("failed to remove memory pressure: %v", err)
+	}
+	if updates := p.checkPressure(); len(updates) != 0 || !p.swapOn["dynamic"] {
+		t.Errorf("expected no updates and swap on without memory pressure, got %v", updates)
+	}
+}
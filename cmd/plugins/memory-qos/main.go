@@ -21,6 +21,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"sigs.k8s.io/yaml"
 
@@ -28,11 +29,20 @@ import (
 
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containerd/nri/pkg/stub"
+
+	auditcfg "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/audit"
+	"github.com/containers/nri-plugins/pkg/audit"
 )
 
 type plugin struct {
 	stub   stub.Stub
 	config *pluginConfig
+	lock   sync.Mutex
+
+	// swapOn tells which classes with dynamic swap have swap enabled.
+	swapOn map[string]bool
+	// swapCtrs maps IDs of containers with dynamic swap to their class.
+	swapCtrs map[string]string
 }
 
 type pluginConfig struct {
@@ -48,6 +58,17 @@ type pluginConfig struct {
 	// Classes define how memory of all workloads in each QoS
 	// class should be managed.
 	Classes []QoSClass
+
+	// PressureCheckPeriod is the interval of checking node memory
+	// pressure for classes with DynamicSwap. Example: "5s".
+	// The default is 10s.
+	PressureCheckPeriod string
+
+	// Audit configures recording the cgroup changes the plugin
+	// makes to containers. Example:
+	//     Audit: {Sink: file, File: /var/log/memory-qos-audit.log}
+	// Auditing is off by default.
+	Audit auditcfg.Config
 }
 
 type QoSClass struct {
@@ -63,10 +84,16 @@ type QoSClass struct {
 	// 1.0 means no throttling before getting OOM-killed.
 	// 0.75 throttle (reclaim pages) when usage reaches 75 % of memory limit.
 	SwapLimitRatio float32
+
+	// DynamicSwap enables swap (memory.swap.max = max) of
+	// containers in the class only while node memory is under
+	// pressure, and disables it (memory.swap.max = 0) otherwise.
+	DynamicSwap *DynamicSwap
 }
 
 const (
 	annotationSuffix = ".memory-qos.nri.io"
+	auditSource      = "memory-qos"
 )
 
 var (
@@ -102,6 +129,18 @@ func (p *plugin) setConfig(config []byte) error {
 		log.Debugf("%s", errWithContext)
 		return errWithContext
 	}
+	if err = validateDynamicSwap(&cfg); err != nil {
+		errWithContext := fmt.Errorf("setConfig: %w", err)
+		log.Debugf("%s", errWithContext)
+		return errWithContext
+	}
+	if err = audit.Configure(&cfg.Audit); err != nil {
+		errWithContext := fmt.Errorf("setConfig: %w", err)
+		log.Debugf("%s", errWithContext)
+		return errWithContext
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.config = &cfg
 	log.Tracef("new configuration has %d classes:", len(p.config.Classes))
 	for _, cls := range p.config.Classes {
@@ -204,9 +243,12 @@ func effectiveAnnotations(pod *api.PodSandbox, ctr *api.Container) map[string]st
 func (p *plugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, ctr *api.Container) (*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
 	ppName := pprintCtr(pod, ctr)
 	log.Tracef("CreateContainer %s", ppName)
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	unified := map[string]string{}
 	class := ""
-	for annPrefix, value := range effectiveAnnotations(pod, ctr) {
+	effAnn := effectiveAnnotations(pod, ctr)
+	for annPrefix, value := range effAnn {
 		switch {
 		case annPrefix == "class":
 			if err := p.applyQosClass(pod, ctr, value, unified); err != nil {
@@ -224,6 +266,9 @@ func (p *plugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, ctr *
 			return nil, nil, err
 		}
 	}
+	if p.trackDynamicSwap(ctr.GetId(), class, effAnn) {
+		unified["memory.swap.max"] = p.swapMax(class)
+	}
 	if len(unified) == 0 {
 		log.Debugf("CreateContainer %s: no adjustments", ppName)
 		return nil, nil, nil
@@ -236,6 +281,7 @@ func (p *plugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, ctr *
 		},
 	}
 	log.Debugf("CreateContainer %s: class %q, LinuxResources.Unified=%v", ppName, class, ca.Linux.Resources.Unified)
+	audit.ContainerAdjustment(auditSource, "CreateContainer", ppName, ctr.GetId(), &ca)
 	return &ca, nil, nil
 }
 
@@ -268,7 +314,10 @@ func main() {
 		log.SetLevel(logrus.TraceLevel)
 	}
 
-	p := &plugin{}
+	p := &plugin{
+		swapOn:   map[string]bool{},
+		swapCtrs: map[string]string{},
+	}
 
 	if configFile != "" {
 		log.Debugf("read configuration from %q", configFile)
@@ -295,7 +344,10 @@ func main() {
 		log.Fatalf("failed to create plugin stub: %v", err)
 	}
 
-	if err = p.stub.Run(context.Background()); err != nil {
+	ctx := context.Background()
+	go p.monitorPressure(ctx)
+
+	if err = p.stub.Run(ctx); err != nil {
 		log.Errorf("plugin exited (%v)", err)
 		os.Exit(1)
 	}
@@ -32,12 +32,16 @@ import (
 
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containerd/nri/pkg/stub"
+
+	auditcfg "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/audit"
+	"github.com/containers/nri-plugins/pkg/audit"
 )
 
 type plugin struct {
 	stub           stub.Stub
 	config         *pluginConfig
 	cgroupsDir     string
+	cgroupsV1Mem   string
 	ctrMemtierdEnv map[string]*memtierdEnv
 }
 
@@ -45,6 +49,12 @@ type pluginConfig struct {
 	// Classes define how memory of all workloads in each QoS
 	// class should be managed.
 	Classes []qosClass
+
+	// Audit configures recording the cgroup changes the plugin
+	// makes to containers. Example:
+	//     Audit: {Sink: file, File: /var/log/memtierd-audit.log}
+	// Auditing is off by default.
+	Audit auditcfg.Config
 }
 
 type qosClass struct {
@@ -86,6 +96,7 @@ type options struct {
 
 const (
 	annotationSuffix = ".memtierd.nri.io"
+	auditSource      = "memtierd"
 )
 
 var opt = options{}
@@ -117,6 +128,9 @@ func (p *plugin) setConfig(config []byte) error {
 		log.Tracef("setConfig: parsing failed: %s", err)
 		return fmt.Errorf("setConfig: cannot parse configuration: %w", err)
 	}
+	if err = audit.Configure(&cfg.Audit); err != nil {
+		return fmt.Errorf("setConfig: %w", err)
+	}
 	p.config = &cfg
 	if log.GetLevel() == logrus.TraceLevel {
 		log.Tracef("new configuration has %d classes:", len(p.config.Classes))
@@ -233,6 +247,7 @@ func (p *plugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, ctr *
 		},
 	}
 	log.Debugf("CreateContainer %s: class %q, LinuxResources.Unified=%v", ppName, class, ca.Linux.Resources.Unified)
+	audit.ContainerAdjustment(auditSource, "CreateContainer", ppName, ctr.GetId(), &ca)
 	return &ca, nil, nil
 }
 
@@ -262,6 +277,15 @@ func (p *plugin) StartContainer(ctx context.Context, pod *api.PodSandbox, ctr *a
 		return nil
 	}
 
+	if p.cgroupsV1Mem != "" {
+		return loggedErrorf("cannot launch memtierd for %q: QoS class %q requires cgroup v2 memory controller, but it is mounted on cgroup v1 at %q",
+			ppName, annotatedClass, p.cgroupsV1Mem)
+	}
+	if p.cgroupsDir == "" {
+		return loggedErrorf("cannot launch memtierd for %q: QoS class %q requires cgroup v2, but no cgroup2 mount point was found",
+			ppName, annotatedClass)
+	}
+
 	fullCgroupsPath, err := p.getFullCgroupsPath(ctr)
 	if err != nil {
 		return loggedErrorf("cannot detect cgroup v2 path for container %q: %v", ppName, err)
@@ -342,20 +366,60 @@ func (p *plugin) detectCgroupsDir() error {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	v2Dir, v1MemDir, err := parseCgroupMounts(file)
+	if err != nil {
+		return fmt.Errorf("failed to read /proc/mounts: %v", err)
+	}
+
+	p.cgroupsDir = v2Dir
+	p.cgroupsV1Mem = v1MemDir
+
+	switch {
+	case v2Dir == "" && v1MemDir == "":
+		return fmt.Errorf("cgroup2 missing in /proc/mounts")
+	case v1MemDir != "" && v2Dir == "":
+		log.Warnf("cgroup v1 memory controller mounted at %q, but no cgroup2 mount found: "+
+			"QoS classes with MemtierdConfig require cgroup v2", v1MemDir)
+	case v1MemDir != "":
+		log.Warnf("hybrid cgroup hierarchy: memory controller mounted on cgroup v1 at %q, "+
+			"cgroup2 at %q: QoS classes with MemtierdConfig require cgroup v2 memory controller",
+			v1MemDir, v2Dir)
+	}
+
+	return nil
+}
+
+// parseCgroupMounts parses mount entries in /proc/mounts format. It
+// returns the cgroup v2 mount point and the cgroup v1 mount point of
+// the memory controller, if any.
+func parseCgroupMounts(r io.Reader) (string, string, error) {
+	var v2Dir, v1MemDir string
+
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
 
-		if fields[0] == "cgroup2" {
-			p.cgroupsDir = fields[1]
-			return nil
+		switch fields[2] {
+		case "cgroup2":
+			if v2Dir == "" {
+				v2Dir = fields[1]
+			}
+		case "cgroup":
+			for _, o := range strings.Split(fields[3], ",") {
+				if o == "memory" && v1MemDir == "" {
+					v1MemDir = fields[1]
+				}
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read /proc/mounts: %v", err)
+		return "", "", err
 	}
-	return fmt.Errorf("cgroup2 missing in /proc/mounts")
+
+	return v2Dir, v1MemDir, nil
 }
 
 // getFullCgroupsPath returns container's cgroups directory.
@@ -0,0 +1,75 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCgroupMounts(t *testing.T) {
+	tcases := []struct {
+		name     string
+		mounts   string
+		v2Dir    string
+		v1MemDir string
+	}{
+		{
+			name: "unified",
+			mounts: `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+cgroup2 /sys/fs/cgroup cgroup2 rw,nosuid,nodev,noexec,relatime,nsdelegate,memory_recursiveprot 0 0
+`,
+			v2Dir: "/sys/fs/cgroup",
+		},
+		{
+			name: "hybrid",
+			mounts: `tmpfs /sys/fs/cgroup tmpfs ro,nosuid,nodev,noexec,mode=755 0 0
+cgroup2 /sys/fs/cgroup/unified cgroup2 rw,nosuid,nodev,noexec,relatime,nsdelegate 0 0
+cgroup /sys/fs/cgroup/systemd cgroup rw,nosuid,nodev,noexec,relatime,xattr,name=systemd 0 0
+cgroup /sys/fs/cgroup/cpu,cpuacct cgroup rw,nosuid,nodev,noexec,relatime,cpu,cpuacct 0 0
+cgroup /sys/fs/cgroup/memory cgroup rw,nosuid,nodev,noexec,relatime,memory 0 0
+
+`,
+			v2Dir:    "/sys/fs/cgroup/unified",
+			v1MemDir: "/sys/fs/cgroup/memory",
+		},
+		{
+			name: "legacy",
+			mounts: `tmpfs /sys/fs/cgroup tmpfs ro,nosuid,nodev,noexec,mode=755 0 0
+cgroup /sys/fs/cgroup/memory cgroup rw,nosuid,nodev,noexec,relatime,memory 0 0
+`,
+			v1MemDir: "/sys/fs/cgroup/memory",
+		},
+		{
+			name:   "none",
+			mounts: "proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\n",
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			v2Dir, v1MemDir, err := parseCgroupMounts(strings.NewReader(tc.mounts))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v2Dir != tc.v2Dir {
+				t.Errorf("expected cgroup v2 dir %q, got %q", tc.v2Dir, v2Dir)
+			}
+			if v1MemDir != tc.v1MemDir {
+				t.Errorf("expected cgroup v1 memory dir %q, got %q", tc.v1MemDir, v1MemDir)
+			}
+		})
+	}
+}
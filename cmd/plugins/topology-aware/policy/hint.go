@@ -98,13 +98,15 @@ func (cs *supply) hintCpus(h topology.Hint) cpuset.CPUSet {
 		cpus = cpuset.MustParse(h.CPUs)
 
 	case h.NUMAs != "":
+		nodes := idset.NewIDSet()
 		for _, idstr := range strings.Split(h.NUMAs, ",") {
 			if id, err := strconv.ParseInt(idstr, 0, 0); err == nil {
-				if node := cs.node.System().Node(idset.ID(id)); node != nil {
-					cpus = cpus.Union(node.CPUSet())
-				}
+				nodes.Add(idset.ID(id))
 			}
 		}
+		if nodes.Size() > 0 {
+			cpus = cs.node.System().NodeCPUSet(nodes, false)
+		}
 
 	case h.Sockets != "":
 		for _, idstr := range strings.Split(h.Sockets, ",") {
@@ -24,10 +24,10 @@ func (p *policy) getMemOffer(pool Node, req Request) (*libmem.Offer, error) {
 	)
 
 	if memType := req.MemoryType(); memType == memoryPreserve {
-		zone = libmem.NewNodeMask(pool.GetMemset(memoryAll).Members()...)
+		zone = libmem.NodeMaskFromIDSet(pool.GetMemset(memoryAll))
 		mtyp = p.memAllocator.ZoneType(zone)
 	} else {
-		zone = libmem.NewNodeMask(pool.GetMemset(memType).Members()...)
+		zone = libmem.NodeMaskFromIDSet(pool.GetMemset(memType))
 		mtyp = libmem.TypeMask(memType)
 	}
 
@@ -85,9 +85,9 @@ func (p *policy) memZoneType(zone libmem.NodeMask) libmem.TypeMask {
 }
 
 func (p *policy) poolZoneCapacity(pool Node, memType memoryType) int64 {
-	return p.memAllocator.ZoneCapacity(libmem.NewNodeMask(pool.GetMemset(memType).Members()...))
+	return p.memAllocator.ZoneCapacity(libmem.NodeMaskFromIDSet(pool.GetMemset(memType)))
 }
 
 func (p *policy) poolZoneFree(pool Node, memType memoryType) int64 {
-	return p.memAllocator.ZoneFree(libmem.NewNodeMask(pool.GetMemset(memType).Members()...))
+	return p.memAllocator.ZoneFree(libmem.NodeMaskFromIDSet(pool.GetMemset(memType)))
 }
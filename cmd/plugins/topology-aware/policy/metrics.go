@@ -174,7 +174,7 @@ func (p *policy) NewTopologyAwareMetrics() *TopologyAwareMetrics {
 	for _, pool := range p.pools {
 		var (
 			name = pool.Name()
-			mems = libmem.NewNodeMask(pool.GetMemset(memoryAll).Members()...)
+			mems = libmem.NodeMaskFromIDSet(pool.GetMemset(memoryAll))
 			capa = pool.GetSupply().(*supply)
 			cpus = capa.ReservedCPUs().Union(capa.IsolatedCPUs()).Union(capa.SharableCPUs())
 			zone = &Zone{
@@ -260,7 +260,7 @@ func (m *TopologyAwareMetrics) Update() {
 		var (
 			zone       = m.Zones[pool.Name()]
 			free       = pool.FreeSupply().(*supply)
-			mems       = libmem.NewNodeMask(pool.GetMemset(memoryAll).Members()...)
+			mems       = libmem.NodeMaskFromIDSet(pool.GetMemset(memoryAll))
 			sharedPool = free.SharableCPUs().Union(free.ReservedCPUs())
 			containers = 0
 			sharedctrs = 0
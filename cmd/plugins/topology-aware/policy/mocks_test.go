@@ -69,6 +69,18 @@ func (fake *mockSystemNode) CPUSet() cpuset.CPUSet {
 	return cpuset.New()
 }
 
+func (fake *mockSystemNode) InitiatorCPUs() cpuset.CPUSet {
+	return cpuset.New()
+}
+
+func (fake *mockSystemNode) SetOnline(bool) error {
+	return nil
+}
+
+func (fake *mockSystemNode) MemoryBlocks() ([]system.MemoryBlock, error) {
+	return nil, nil
+}
+
 func (fake *mockSystemNode) Distance() []int {
 	if len(fake.distance) == 0 {
 		return []int{0}
@@ -123,6 +135,14 @@ func (p *mockCPUPackage) LogicalDieClusterCPUSet(idset.ID, idset.ID) cpuset.CPUS
 	return cpuset.New()
 }
 
+func (p *mockCPUPackage) CCXIDs(idset.ID) []idset.ID {
+	return []idset.ID{}
+}
+
+func (p *mockCPUPackage) CCXCPUSet(idset.ID, idset.ID) cpuset.CPUSet {
+	return cpuset.New()
+}
+
 func (p *mockCPUPackage) SstInfo() *sst.SstPackageInfo {
 	return &sst.SstPackageInfo{}
 }
@@ -160,6 +180,12 @@ func (c *mockCPU) ThreadCPUSet() cpuset.CPUSet {
 func (c *mockCPU) FrequencyRange() system.CPUFreq {
 	return system.CPUFreq{}
 }
+func (c *mockCPU) CurrentFrequency() (uint64, error) {
+	return 0, nil
+}
+func (c *mockCPU) Mitigations() map[string]string {
+	return map[string]string{}
+}
 func (c *mockCPU) Online() bool {
 	return true
 }
@@ -169,6 +195,15 @@ func (c *mockCPU) Isolated() bool {
 func (c *mockCPU) SetFrequencyLimits(min, max uint64) error {
 	return nil
 }
+func (c *mockCPU) ScalingGovernor() (string, error) {
+	return "", nil
+}
+func (c *mockCPU) AvailableScalingGovernors() ([]string, error) {
+	return nil, nil
+}
+func (c *mockCPU) SetScalingGovernor(governor string) error {
+	return nil
+}
 
 func (c *mockCPU) SstClos() int {
 	return -1
@@ -263,6 +298,12 @@ func (fake *mockSystem) AllThreadsForCPUs(cpuset.CPUSet) cpuset.CPUSet {
 func (fake *mockSystem) SingleThreadForCPUs(cpuset.CPUSet) cpuset.CPUSet {
 	return cpuset.New()
 }
+func (fake *mockSystem) ValidateCacheTopology() []error {
+	return nil
+}
+func (fake *mockSystem) ExportTopology() ([]byte, error) {
+	return nil, nil
+}
 func (fake *mockSystem) Offlined() cpuset.CPUSet {
 	return cpuset.New()
 }
@@ -273,6 +314,15 @@ func (fake *mockSystem) Isolated() cpuset.CPUSet {
 
 	return cpuset.New()
 }
+func (fake *mockSystem) NodeCPUSet(idset.IDSet, bool) cpuset.CPUSet {
+	return cpuset.New()
+}
+func (fake *mockSystem) EffectiveAllowedCPUs() cpuset.CPUSet {
+	return cpuset.New()
+}
+func (fake *mockSystem) EffectiveAllowedMems() cpuset.CPUSet {
+	return cpuset.New()
+}
 func (fake *mockSystem) CPUSet() cpuset.CPUSet {
 	return cpuset.New()
 }
@@ -317,12 +367,39 @@ func (fake *mockSystem) NodeIDs() []idset.ID {
 func (fake *mockSystem) SetCPUFrequencyLimits(min, max uint64, cpus idset.IDSet) error {
 	return nil
 }
+func (fake *mockSystem) SetCPUFrequencyLimitsForCPUSet(min, max uint64, cpus cpuset.CPUSet) error {
+	return nil
+}
+func (fake *mockSystem) AverageFrequency(cpus cpuset.CPUSet) (uint64, error) {
+	return 0, nil
+}
+func (fake *mockSystem) SetScalingGovernor(governor string, cpus idset.IDSet) error {
+	return nil
+}
 func (fake *mockSystem) SetCpusOnline(online bool, cpus idset.IDSet) (idset.IDSet, error) {
 	return idset.NewIDSet(), nil
 }
 func (fake *mockSystem) NodeDistance(idset.ID, idset.ID) int {
 	return 10
 }
+func (fake *mockSystem) DeviceNUMANode(string) (idset.ID, error) {
+	return idset.Unknown, nil
+}
+func (fake *mockSystem) DevicesNearNode(idset.ID) []string {
+	return nil
+}
+func (fake *mockSystem) NumaBalancingEnabled() (bool, error) {
+	return false, nil
+}
+func (fake *mockSystem) SetNumaBalancing(bool) error {
+	return nil
+}
+func (fake *mockSystem) SMTConsideredSafe() bool {
+	return true
+}
+func (fake *mockSystem) TopologySummary() string {
+	return ""
+}
 func (fake *mockSystem) NodeHintToCPUs(string) string {
 	return ""
 }
@@ -457,6 +534,9 @@ func (m *mockContainer) SetMemoryLimit(int64) {
 func (m *mockContainer) SetMemorySwap(int64) {
 	panic("unimplemented")
 }
+func (m *mockContainer) SetUnified(string, string) {
+	panic("unimplemented")
+}
 func (m *mockContainer) GetPendingAdjustment() *nri.ContainerAdjustment {
 	panic("unimplemented")
 }
@@ -731,6 +811,12 @@ func (m *mockCPUAllocator) GetCPUPriorities() map[cpuallocator.CPUPriority]cpuse
 	return map[cpuallocator.CPUPriority]cpuset.CPUSet{}
 }
 
+func (m *mockCPUAllocator) CacheGroups() []cpuset.CPUSet {
+	return nil
+}
+
+func (m *mockCPUAllocator) RefreshTopology() {}
+
 var (
 	_ cpuallocator.CPUAllocator = &mockCPUAllocator{}
 )
@@ -327,7 +327,7 @@ func (p *policy) GetTopologyZones() []*policyapi.TopologyZone {
 		total := pool.GetSupply().(*supply)
 		free := pool.FreeSupply().(*supply)
 
-		memZone := libmem.NewNodeMask(pool.GetMemset(memoryAll).Members()...)
+		memZone := libmem.NodeMaskFromIDSet(pool.GetMemset(memoryAll))
 		capacity := p.memAllocator.ZoneCapacity(memZone)
 		available := p.memAllocator.ZoneFree(memZone)
 
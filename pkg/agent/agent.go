@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -68,6 +69,15 @@ func WithConfigFile(file string) Option {
 	}
 }
 
+// WithConfigReload sets whether the configuration file is monitored and reloaded
+// when it changes.
+func WithConfigReload(reload bool) Option {
+	return func(a *Agent) error {
+		a.watchConfig = reload
+		return nil
+	}
+}
+
 // WithConfigGroupLabel sets the key used to label nodes into config groups.
 func WithConfigGroupLabel(label string) Option {
 	return func(a *Agent) error {
@@ -121,11 +131,12 @@ var (
 // node can be assigned to a group by setting the group label on the node. By
 // default this group label is 'config.nri/group'.
 type Agent struct {
-	nodeName   string // kubernetes node name, defaults to $NODE_NAME
-	namespace  string // config resource namespace
-	groupLabel string // config resource node grouping label key
-	kubeConfig string // kubeconfig path
-	configFile string // configuration file to use instead of custom resource
+	nodeName    string // kubernetes node name, defaults to $NODE_NAME
+	namespace   string // config resource namespace
+	groupLabel  string // config resource node grouping label key
+	kubeConfig  string // kubeconfig path
+	configFile  string // configuration file to use instead of custom resource
+	watchConfig bool   // reload configuration file when it changes
 
 	cfgIf     ConfigInterface      // custom resource access interface
 	httpCli   *http.Client         // shared HTTP client
@@ -134,6 +145,8 @@ type Agent struct {
 	nrtLock   sync.Mutex           // serialize NRT custom resource updates
 	podResCli *podresapi.Client    // pod resources API client
 
+	nrtUnavailable atomic.Bool // NRT custom resources found unavailable
+
 	notifyFn      NotifyFn        // config resource change notification callback
 	nodeWatch     watch.Interface // kubernetes node watch
 	group         string          // current config group
@@ -155,13 +168,14 @@ func New(cfgIf ConfigInterface, options ...Option) (*Agent, error) {
 	}
 
 	a := &Agent{
-		nodeName:   os.Getenv("NODE_NAME"),
-		kubeConfig: defaultKubeConfig,
-		configFile: defaultConfigFile,
-		namespace:  defaultNamespace,
-		groupLabel: defaultGroupLabel,
-		cfgIf:      cfgIf,
-		stopC:      make(chan struct{}),
+		nodeName:    os.Getenv("NODE_NAME"),
+		kubeConfig:  defaultKubeConfig,
+		configFile:  defaultConfigFile,
+		watchConfig: defaultWatchConfig,
+		namespace:   defaultNamespace,
+		groupLabel:  defaultGroupLabel,
+		cfgIf:       cfgIf,
+		stopC:       make(chan struct{}),
 	}
 
 	for _, o := range options {
@@ -306,6 +320,7 @@ func (a *Agent) configure(newConfig metav1.Object) {
 		log.Info("disabling NRT client")
 		a.nrtCli = nil
 	}
+	a.nrtUnavailable.Store(false)
 
 	// Reconfigure pod resource client, both on initial startup and reconfiguration.
 	// Failure to create a client is not a fatal error.
@@ -431,7 +446,7 @@ func (a *Agent) setupNodeConfigWatch() error {
 	}
 
 	if a.hasLocalConfig() {
-		w, err := watch.File(a.configFile, a.cfgIf.Unmarshal)
+		w, err := watch.File(a.configFile, a.cfgIf.Unmarshal, watch.WithReload(a.watchConfig))
 		if err != nil {
 			return fmt.Errorf("failed to create config file watch for %s: %w", a.configFile, err)
 		}
@@ -605,6 +620,10 @@ func (a *Agent) updateConfig(cfg metav1.Object) {
 			log.Errorf("failed to validate configuration: %v", err)
 
 			a.patchConfigStatus(a.currentCfg, cfg, err)
+			if a.hasLocalConfig() {
+				log.Errorf("rejected configuration from %s, keeping previous one", a.configFile)
+				return
+			}
 			a.currentCfg = cfg
 			return
 		}
@@ -618,7 +637,13 @@ func (a *Agent) updateConfig(cfg metav1.Object) {
 			log.Fatalf("failed to apply configuration: %v", err)
 		} else {
 			log.Errorf("failed to apply configuration: %v", err)
+			if a.hasLocalConfig() {
+				log.Errorf("rejected configuration from %s, keeping previous one", a.configFile)
+				return
+			}
 		}
+	} else if a.hasLocalConfig() {
+		log.Infof("activated configuration from %s", a.configFile)
 	}
 
 	a.currentCfg = cfg
@@ -689,10 +714,11 @@ func sameConfigVersion(cfg1, cfg2 metav1.Object) bool {
 }
 
 var (
-	defaultNamespace  string
-	defaultGroupLabel string
-	defaultKubeConfig string
-	defaultConfigFile string
+	defaultNamespace   string
+	defaultGroupLabel  string
+	defaultKubeConfig  string
+	defaultConfigFile  string
+	defaultWatchConfig bool
 
 	deprecatedGroupLabels = []string{
 		"group.config.nri",
@@ -709,6 +735,8 @@ func init() {
 		"name of the label used to assign the node to a configuration group")
 	flag.StringVar(&defaultConfigFile, "config-file", "",
 		"config file to use/monitor instead of a CustomResource")
+	flag.BoolVar(&defaultWatchConfig, "watch-config", true,
+		"reload config file when it changes, used with -config-file")
 	flag.StringVar(&defaultKubeConfig, "kubeconfig", "",
 		"kubeconfig file to use, empty for in-cluster configuration")
 }
@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	policyapi "github.com/containers/nri-plugins/pkg/resmgr/policy"
@@ -31,8 +32,16 @@ func (a *Agent) UpdateNrtCR(policy string, zones []*policyapi.TopologyZone) erro
 		return nil
 	}
 
+	// Either disabled in the configuration or failed to set up the
+	// client, which has already been logged.
 	if a.nrtCli == nil {
-		return fmt.Errorf("no node resource topology client, can't update CR")
+		log.Debug("no node resource topology client, skipping CR update")
+		return nil
+	}
+
+	if a.nrtUnavailable.Load() {
+		log.Debug("node resource topology CRs unavailable, skipping CR update")
+		return nil
 	}
 
 	log.Info("updating node resource topology CR")
@@ -59,9 +68,16 @@ func (a *Agent) updateNrtCR(policy string, zones []*policyapi.TopologyZone) erro
 
 	cli := a.nrtCli.NodeResourceTopologies()
 	ctx := context.Background()
+	if a.nrtUnavailable.Load() {
+		return nil
+	}
+
 	cr, err := cli.Get(ctx, a.nodeName, metav1.GetOptions{})
 	if err != nil {
 		cr = nil
+		if a.checkNrtUnavailable(err) {
+			return nil
+		}
 		if !errors.IsNotFound(err) {
 			log.Warn("failed to look up current node resource topology CR: %v", err)
 		}
@@ -86,6 +102,9 @@ func (a *Agent) updateNrtCR(policy string, zones []*policyapi.TopologyZone) erro
 
 		_, err = cli.Update(ctx, cr, metav1.UpdateOptions{})
 		if err != nil {
+			if a.checkNrtUnavailable(err) {
+				return nil
+			}
 			return fmt.Errorf("failed to update node resource topology CR: %w", err)
 		}
 
@@ -109,12 +128,47 @@ func (a *Agent) updateNrtCR(policy string, zones []*policyapi.TopologyZone) erro
 
 	_, err = cli.Create(ctx, cr, metav1.CreateOptions{})
 	if err != nil {
+		// Failing to create a CR with NotFound means that the
+		// resource type itself, IOW the CRD, is not found.
+		if errors.IsNotFound(err) {
+			a.setNrtUnavailable("NodeResourceTopology CRD is not installed", err)
+			return nil
+		}
+		if a.checkNrtUnavailable(err) {
+			return nil
+		}
 		return fmt.Errorf("failed to create node resource topology CR: %w", err)
 	}
 
 	return nil
 }
 
+// checkNrtUnavailable checks if the given error indicates that we can't
+// access node resource topology CRs at all, marking them unavailable if so.
+func (a *Agent) checkNrtUnavailable(err error) bool {
+	switch {
+	case meta.IsNoMatchError(err):
+		a.setNrtUnavailable("NodeResourceTopology CRD is not installed", err)
+	case errors.IsForbidden(err):
+		a.setNrtUnavailable("access to NodeResourceTopology CRs is forbidden", err)
+	default:
+		return false
+	}
+	return true
+}
+
+// setNrtUnavailable marks node resource topology CRs unavailable, stopping
+// further update attempts until the agent is reconfigured.
+func (a *Agent) setNrtUnavailable(reason string, err error) {
+	if a.nrtUnavailable.Swap(true) {
+		return
+	}
+	log.Warn("%s (%v), disabling node resource topology CR updates. Install the "+
+		"NodeResourceTopology CRD and grant access to it, then reconfigure, or set "+
+		"agent.nodeResourceTopology to false in the configuration to silence this warning.",
+		reason, err)
+}
+
 func zonesToNrt(in []*policyapi.TopologyZone) nrt.ZoneList {
 	out := nrt.ZoneList{}
 	for _, i := range in {
@@ -22,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 
@@ -33,6 +34,31 @@ import (
 // UnmarshalFn unmarshals YAML data into an object.
 type UnmarshalFn func([]byte, string) (runtime.Object, error)
 
+const (
+	// DefaultDebounce is the default delay for coalescing rapid file updates.
+	DefaultDebounce = 250 * time.Millisecond
+)
+
+// FileOption is an option for a FileWatch.
+type FileOption func(*FileWatch)
+
+// WithDebounce sets the delay used to coalesce rapid successive updates
+// of the file into a single event. Updates are only read and delivered
+// once the file has not been written to for this long.
+func WithDebounce(delay time.Duration) FileOption {
+	return func(w *FileWatch) {
+		w.debounce = delay
+	}
+}
+
+// WithReload sets whether the file is monitored for changes. Without
+// reloading only the initial contents of the file are delivered.
+func WithReload(reload bool) FileOption {
+	return func(w *FileWatch) {
+		w.noReload = !reload
+	}
+}
+
 // FileWatch monitors a file for changes. It implements the apimachinery
 // watch.Interface and generates events similar to the ones generated by
 // an apimachinery watch for custom resources or configmaps.
@@ -40,6 +66,8 @@ type FileWatch struct {
 	dir       string
 	file      string
 	unmarshal UnmarshalFn
+	debounce  time.Duration
+	noReload  bool
 	fsw       *fsnotify.Watcher
 	resultC   chan Event
 	stopLock  sync.Mutex
@@ -48,29 +76,36 @@ type FileWatch struct {
 }
 
 // File creates a watch for monitoring the given file.
-func File(file string, unmarshal UnmarshalFn) (Interface, error) {
+func File(file string, unmarshal UnmarshalFn, options ...FileOption) (Interface, error) {
 	abs, err := filepath.Abs(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get absolute path to %s: %w", file, err)
 	}
 
-	fsw, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create fsnotify watch: %w", err)
-	}
-
 	w := &FileWatch{
 		dir:       path.Dir(abs),
 		file:      path.Base(abs),
 		unmarshal: unmarshal,
-		fsw:       fsw,
+		debounce:  DefaultDebounce,
 		resultC:   make(chan Event, watch.DefaultChanSize),
 		stopC:     make(chan struct{}),
 		doneC:     make(chan struct{}),
 	}
 
-	if err = fsw.Add(w.dir); err != nil {
-		return nil, fmt.Errorf("failed to add %s to watch: %w", w.dir, err)
+	for _, o := range options {
+		o(w)
+	}
+
+	if !w.noReload {
+		w.fsw, err = fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fsnotify watch: %w", err)
+		}
+
+		if err = w.fsw.Add(w.dir); err != nil {
+			w.fsw.Close()
+			return nil, fmt.Errorf("failed to add %s to watch: %w", w.dir, err)
+		}
 	}
 
 	if err = w.run(); err != nil {
@@ -105,17 +140,51 @@ func (w *FileWatch) run() error {
 
 	w.sendEvent(Added, obj)
 
+	if w.noReload {
+		go func() {
+			<-w.stopC
+			close(w.resultC)
+			close(w.doneC)
+		}()
+		return nil
+	}
+
 	go func() {
+		var (
+			timer   *time.Timer
+			timerC  <-chan time.Time
+			pending EventType
+		)
+
+		stopTimer := func() {
+			if timer != nil {
+				timer.Stop()
+			}
+			timerC = nil
+		}
+
 		for {
 			select {
 			case <-w.stopC:
+				stopTimer()
 				w.fsw.Close()
 				close(w.resultC)
 				close(w.doneC)
 				return
 
+			case <-timerC:
+				timerC = nil
+				obj, err := w.readAndUnmarshal()
+				if err != nil {
+					log.Warnf("%v", err)
+					continue
+				}
+
+				w.sendEvent(pending, obj)
+
 			case e, ok := <-w.fsw.Events:
 				if !ok {
+					stopTimer()
 					w.sendEvent(
 						Error,
 						&metav1.Status{
@@ -135,25 +204,22 @@ func (w *FileWatch) run() error {
 				log.Debug("got %v event for %s", e.Op, e.Name)
 
 				switch {
-				case (e.Op & fsnotify.Create) != 0:
-					obj, err := w.readAndUnmarshal()
-					if err != nil {
-						log.Warnf("%v", err)
-						continue
-					}
-
-					w.sendEvent(Added, obj)
-
-				case (e.Op & fsnotify.Write) != 0:
-					obj, err := w.readAndUnmarshal()
-					if err != nil {
-						log.Warnf("%v", err)
-						continue
+				case (e.Op & (fsnotify.Create | fsnotify.Write)) != 0:
+					// Coalesce rapid successive updates into a single
+					// event, delivered once writes have settled down.
+					switch {
+					case (e.Op & fsnotify.Create) != 0:
+						pending = Added
+					case timerC == nil:
+						pending = Modified
 					}
 
-					w.sendEvent(Modified, obj)
+					stopTimer()
+					timer = time.NewTimer(w.debounce)
+					timerC = timer.C
 
 				case (e.Op & (fsnotify.Remove | fsnotify.Rename)) != 0:
+					stopTimer()
 					w.sendEvent(Deleted, nil)
 				}
 			}
@@ -0,0 +1,63 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+// Config provides runtime configuration for auditing the cgroup
+// changes plugins request for containers.
+// +k8s:deepcopy-gen=true
+type Config struct {
+	// Sink selects where audit records are written. With "log",
+	// records are logged by the "audit" logger source. With "file",
+	// records are written as JSON lines to File. Auditing is off by
+	// default.
+	// +optional
+	// +kubebuilder:validation:Enum=log;file
+	Sink Sink `json:"sink,omitempty"`
+	// File is the path of the audit file used by the "file" sink.
+	// The default is /var/log/nri-resource-policy/audit.log.
+	// +optional
+	File string `json:"file,omitempty"`
+	// MaxFileSize is the size in bytes after which the audit file
+	// is rotated. The default is 10 MiB.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// MaxFiles is the number of rotated audit files kept in
+	// addition to the current one. The default is 3.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MaxFiles int `json:"maxFiles,omitempty"`
+}
+
+// Sink is the destination of audit records.
+type Sink string
+
+const (
+	// SinkNone disables auditing.
+	SinkNone Sink = ""
+	// SinkLog writes audit records to the log.
+	SinkLog Sink = "log"
+	// SinkFile writes audit records to a rotated file.
+	SinkFile Sink = "file"
+)
+
+const (
+	// DefaultFile is the default path of the audit file.
+	DefaultFile = "/var/log/nri-resource-policy/audit.log"
+	// DefaultMaxFileSize is the default size of rotating the audit file.
+	DefaultMaxFileSize = 10 << 20
+	// DefaultMaxFiles is the default number of rotated audit files.
+	DefaultMaxFiles = 3
+)
@@ -25,4 +25,18 @@ type Config struct {
 	// +optional
 	// +kubebuilder:example={"computationally-expensive-metrics"}
 	Polled []string `json:"polled,omitempty"`
+	// MaxLabelValues limits the number of distinct values of any label
+	// of a metric. Labels exceeding the limit are reported in the log.
+	// The default is 0: no limit.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MaxLabelValues int `json:"maxLabelValues,omitempty"`
+	// DropHighCardinality drops series with new values of labels which
+	// exceed MaxLabelValues, instead of only reporting them.
+	// +optional
+	DropHighCardinality bool `json:"dropHighCardinality,omitempty"`
+	// HighCardinalityLabels lists labels which are exempt from MaxLabelValues.
+	// +optional
+	// +listType=set
+	HighCardinalityLabels []string `json:"highCardinalityLabels,omitempty"`
 }
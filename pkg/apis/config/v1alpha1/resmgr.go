@@ -17,6 +17,7 @@ package v1alpha1
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/audit"
 	"github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/instrumentation"
 	"github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/log"
 	"github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/resmgr/control"
@@ -41,4 +42,6 @@ type CommonConfig struct {
 	Log log.Config `json:"log,omitempty"`
 	// +optional
 	Instrumentation instrumentation.Config `json:"instrumentation,omitempty"`
+	// +optional
+	Audit audit.Config `json:"audit,omitempty"`
 }
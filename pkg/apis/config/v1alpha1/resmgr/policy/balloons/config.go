@@ -15,13 +15,19 @@
 package balloons
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
 	policy "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/resmgr/policy"
 	resmgr "github.com/containers/nri-plugins/pkg/apis/resmgr/v1alpha1"
 	"github.com/containers/nri-plugins/pkg/cpuallocator"
 	"github.com/containers/nri-plugins/pkg/resmgr/cache"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type (
@@ -47,6 +53,13 @@ type Config struct {
 	// PinMemory controls pinning containers to memory nodes.
 	// +kubebuilder:default=true
 	PinMemory *bool `json:"pinMemory,omitempty"`
+	// OnMemoryAllocFailure controls what happens when memory for a
+	// container cannot be allocated from the memory nodes of its
+	// balloon. "fallback" pins the container to those nodes anyway,
+	// "widen" pins it to all allowed memory nodes, and "fail" fails
+	// the creation of the container. The default is "fallback".
+	// +kubebuilder:validation:Enum=fallback;widen;fail
+	OnMemoryAllocFailure MemoryAllocFailure `json:"onMemoryAllocFailure,omitempty"`
 	// IdleCpuClass controls how unusded CPUs outside any a
 	// balloons are (re)configured.
 	IdleCpuClass string `json:"idleCPUClass,omitempty"`
@@ -76,12 +89,145 @@ type Config struct {
 	BalloonDefs []*BalloonDef `json:"balloonTypes,omitempty"`
 	// Available/allowed (CPU) resources to use.
 	AvailableResources Constraints `json:"availableResources,omitempty"`
-	// Reserved (CPU) resources for kube-system namespace.
+	// Reserved resources for kube-system namespace. The cpu entry
+	// reserves CPUs, the memory entry reserves memory nodes for the
+	// reserved balloon only.
 	// +kubebuilder:validation:Required
 	ReservedResources Constraints `json:"reservedResources"`
+	// DefaultReservedCpus sets the number of CPUs reserved for the
+	// reserved balloon when ReservedResources does not specify cpus
+	// and the reserved balloon type does not set MinCpus. It is one
+	// of "auto", "per-socket", "none" or a number of CPUs. "per-socket"
+	// reserves one CPU per CPU package. "auto" reserves one CPU per
+	// CPU package, or one CPU per 64 available CPUs, whichever is
+	// more. "none" reserves no CPUs, and the reserved balloon gets a
+	// CPU only when it is inflated. The default is "auto".
+	// +kubebuilder:validation:Pattern=`^(auto|per-socket|none|[0-9]+)$`
+	DefaultReservedCpus string `json:"defaultReservedCPUs,omitempty"`
 	// Preserve specifies containers whose resource pinning must not be
 	// modified by the policy.
 	Preserve *ContainerMatchConfig `json:"preserve,omitempty"`
+	// BypassSelector selects pods which are bypassed by the policy.
+	// Containers of matching pods keep the CPUs and memory nodes given
+	// to them by kubelet, and their CPUs are not counted in any
+	// balloon.
+	BypassSelector *PodSelector `json:"bypassSelector,omitempty"`
+	// RebalanceInterval enables periodic rebalancing of balloons.
+	// On every interval the policy checks if CPUs of balloons
+	// could be replaced with free CPUs that have better topology
+	// locality, and moves CPUs when the locality improves enough.
+	// The default is no rebalancing.
+	RebalanceInterval *metav1.Duration `json:"rebalanceInterval,omitempty"`
+	// RebalanceThreshold is the minimum improvement in the
+	// locality score of a balloon that triggers moving its CPUs.
+	// The score is the number of topology elements (packages,
+	// dies, NUMA nodes and L2 caches) that CPUs of a balloon
+	// span. Values smaller than 1 are treated as 1.
+	// +kubebuilder:validation:Minimum=0
+	RebalanceThreshold int `json:"rebalanceThreshold,omitempty"`
+	// RebalanceMaxCpus limits the number of CPUs that are
+	// replaced in a balloon in a single rebalancing pass. The
+	// default is 0: no limit.
+	// +kubebuilder:validation:Minimum=0
+	RebalanceMaxCpus int `json:"rebalanceMaxCPUs,omitempty"`
+	// ReconcileInterval enables periodic reconciliation of pinning.
+	// On every interval the policy compares the cpusets of running
+	// containers against the pinning it intended for them, and
+	// re-pins balloons of containers whose cpusets have drifted.
+	// The default is no reconciliation.
+	ReconcileInterval *metav1.Duration `json:"reconcileInterval,omitempty"`
+	// PrewarmIdlePeriod is the time after which a balloon pre-inflated
+	// for a pod annotated with prewarm-cpus is deflated back to the size
+	// its containers need, unless more containers asking for
+	// pre-inflation are assigned to it. The default is 5 minutes.
+	PrewarmIdlePeriod *metav1.Duration `json:"prewarmIdlePeriod,omitempty"`
+	// MaxInflationStep limits the number of CPUs added to a
+	// balloon at once. Balloons that need more CPUs are inflated
+	// by further steps every second until they fit the requests
+	// of their containers. The default is 0: no limit.
+	// +kubebuilder:validation:Minimum=0
+	MaxInflationStep int `json:"maxInflationStep,omitempty"`
+	// StickyCpus prefers allocating the same CPUs to a container
+	// that it used before the policy was restarted, if they are
+	// still free. This helps keeping CPU caches warm. CPUs used by
+	// containers are stored in the cache in the state directory.
+	StickyCpus bool `json:"stickyCPUs,omitempty"`
+	// FairShareIdleCpus sets CPU weights of containers in balloons
+	// that share idle CPUs, so that time on shared idle CPUs is
+	// divided between balloons in proportion to their CPUs. Weights
+	// are restored to follow CPU requests when a balloon stops
+	// sharing idle CPUs.
+	FairShareIdleCpus bool `json:"fairShareIdleCPUs,omitempty"`
+	// QoSAwarePinning pins containers in the Guaranteed QoS class
+	// with integer CPU requests to whole physical cores of their
+	// balloon, excluding idle CPUs shared from outside the balloon.
+	// Each container gets cores of its own that cover its request, and
+	// other containers of the balloon are kept off these cores if
+	// possible. Containers in other QoS classes are pinned as usual.
+	QoSAwarePinning bool `json:"qosAwarePinning,omitempty"`
+	// ZeroRequestUsesSharedIdle places containers without CPU request
+	// in pods whose other containers request CPUs into a balloon of
+	// their pod that already has CPUs, and pins them to shared idle CPUs
+	// of that balloon, instead of giving them CPUs of a balloon. This keeps
+	// sidecars from inflating balloons and from running on the CPUs
+	// of containers that request them.
+	ZeroRequestUsesSharedIdle bool `json:"zeroRequestUsesSharedIdle,omitempty"`
+	// AdoptKubeletPinnedCpus adopts containers which the static CPU
+	// manager policy of kubelet has pinned to exclusive CPUs, when
+	// the policy starts. Each such container is placed into a new
+	// balloon of its balloon type with the CPUs it already runs on,
+	// so that existing workloads are not moved when migrating from
+	// the static CPU manager policy.
+	AdoptKubeletPinnedCpus bool `json:"adoptKubeletPinnedCPUs,omitempty"`
+	// VerifyPinning reads back the cpuset of containers after the
+	// runtime has applied their pinning, and warns if it differs from
+	// the one set by the policy. This helps detecting other agents,
+	// such as the kubelet CPU manager, that also change cpusets of
+	// containers.
+	VerifyPinning bool `json:"verifyPinning,omitempty"`
+	// UseCpusetExclusive makes CPUs of a container exclusive to it,
+	// enforced by the kernel using cgroup v2 cpuset.cpus.exclusive,
+	// when the container is alone in its balloon. This requires a
+	// kernel with cpuset.cpus.exclusive support. Otherwise only
+	// cpuset.cpus is used.
+	UseCpusetExclusive bool `json:"useCpusetExclusive,omitempty"`
+	// CpuProfiles defines CPU profiles which balloon types can refer
+	// to by name. Profile names are keys followed by properties.
+	CpuProfiles map[string]CpuProfile `json:"cpuProfiles,omitempty"`
+	// NamespaceCpuQuotas limits the number of CPUs in balloons that
+	// containers of a namespace may consume, summed over all
+	// balloons. Keys are namespaces, values are maximum numbers of
+	// CPUs. A balloon is not inflated for a container, nor is the
+	// container placed in it, if its namespace would exceed its
+	// quota. Namespaces without a quota are not limited.
+	NamespaceCpuQuotas map[string]int `json:"namespaceCPUQuotas,omitempty"`
+	// MixedNamespaceQuota controls how CPUs of balloons with
+	// containers of several namespaces are counted against
+	// NamespaceCpuQuotas. "proportional" attributes CPUs of a
+	// balloon to namespaces in proportion to CPU requests of their
+	// containers. "reject" never places containers of a namespace
+	// with a quota into the same balloon with containers of other
+	// namespaces. The default is "proportional".
+	// +kubebuilder:validation:Enum=proportional;reject
+	MixedNamespaceQuota MixedNamespaceQuota `json:"mixedNamespaceQuota,omitempty"`
+}
+
+// CpuProfile bundles a CPU class with CPU frequency settings. The CPU
+// class of a profile, overridden by its frequency settings, is applied
+// on CPUs of balloons referring to it.
+// +k8s:deepcopy-gen=true
+type CpuProfile struct {
+	// CpuClass is the CPU class applied on CPUs with this profile.
+	CpuClass string `json:"cpuClass,omitempty"`
+	// MinFreq is the minimum frequency (kHz) of CPUs with this profile.
+	// +kubebuilder:validation:Minimum=0
+	MinFreq uint `json:"minFreq,omitempty"`
+	// MaxFreq is the maximum frequency (kHz) of CPUs with this profile.
+	// +kubebuilder:validation:Minimum=0
+	MaxFreq uint `json:"maxFreq,omitempty"`
+	// FreqGovernor is the cpufreq scaling governor of CPUs with this
+	// profile.
+	FreqGovernor string `json:"freqGovernor,omitempty"`
 }
 
 type CPUTopologyLevel string
@@ -128,10 +274,18 @@ func (l CPUTopologyLevel) Value() int {
 type BalloonDef struct {
 	// Name of the balloon definition.
 	Name string `json:"name"`
+	// BasedOn is the name of another balloon definition which this
+	// one inherits all its settings from. Settings given in this
+	// definition override the inherited ones.
+	BasedOn string `json:"basedOn,omitempty"`
 	// Namespaces control which namespaces are assigned into
 	// balloon instances from this definition. This is used by
 	// namespace assign methods.
 	Namespaces []string `json:"namespaces,omitempty"`
+	// ContainerNamePatterns is a list of container name globs,
+	// like "*-proxy". Containers whose name matches any of them are
+	// assigned into balloon instances from this definition.
+	ContainerNamePatterns []string `json:"containerNamePatterns,omitempty"`
 	// GroupBy groups containers into same balloon instances if
 	// their GroupBy expressions evaluate to the same group.
 	// Expressions are strings where key references like
@@ -142,15 +296,37 @@ type BalloonDef struct {
 	// to see if a container should be assigned into balloon instances from
 	// this definition.
 	MatchExpressions []resmgr.Expression `json:"matchExpressions,omitempty"`
+	// MinCpuRequest is the smallest CPU request of a container that
+	// is assigned into balloon instances from this definition.
+	MinCpuRequest *resource.Quantity `json:"minCPURequest,omitempty"`
+	// MaxCpuRequest is the largest CPU request of a container that
+	// is assigned into balloon instances from this definition.
+	MaxCpuRequest *resource.Quantity `json:"maxCPURequest,omitempty"`
 	// MaxCpus specifies the maximum number of CPUs exclusively
 	// usable by containers in a balloon. Balloon size will not be
-	// inflated larger than MaxCpus.
+	// inflated larger than MaxCpus. It can be given as a percentage
+	// of the CPUs available to the policy, for instance "50%".
+	// +kubebuilder:validation:XIntOrString
 	MaxCpus int `json:"maxCPUs,omitempty"`
 	// MinCpus specifies the minimum number of CPUs exclusively
 	// usable by containers in a balloon. When new balloon is created,
 	// this will be the number of CPUs reserved for it even if a container
-	// would request less.
+	// would request less. It can be given as a percentage of the CPUs
+	// available to the policy, for instance "25%".
+	// +kubebuilder:validation:XIntOrString
 	MinCpus int `json:"minCPUs,omitempty"`
+	// MaxCpusPercent is MaxCpus given as a percentage of available
+	// CPUs. If set, MaxCpus is resolved from it by the policy.
+	MaxCpusPercent float64 `json:"-"`
+	// MinCpusPercent is MinCpus given as a percentage of available
+	// CPUs. If set, MinCpus is resolved from it by the policy.
+	MinCpusPercent float64 `json:"-"`
+	// WholeNumaNodes allocates this many complete NUMA nodes, all
+	// their CPUs and memory, to each balloon of this type instead
+	// of counting CPUs. Balloons of this type are never resized.
+	// Cannot be used together with MinCpus or MaxCpus.
+	// +kubebuilder:validation:Minimum=0
+	WholeNumaNodes int `json:"wholeNumaNodes,omitempty"`
 	// MemoryTypes lists memory types allowed to containers in a
 	// balloon. Supported types are: DRAM, HBM, PMEM. By default
 	// all memory types in the system are allowed.
@@ -160,6 +336,21 @@ type BalloonDef struct {
 	// PinMemory controls pinning containers to memory nodes.
 	// Overrides the policy level PinMemory setting in this balloon type.
 	PinMemory *bool `json:"pinMemory,omitempty"`
+	// MemorySpreadNodes is a list of memory nodes, for instance
+	// "0-3", added to the memory nodes of containers in a balloon.
+	// CPUs of containers are pinned as usual, while their memory is
+	// allowed on the closest memory nodes and these nodes. Memory is
+	// allocated from the other nodes only when the local node is
+	// full, unless workloads set an interleaving memory policy, for
+	// instance with "numactl --interleave=all".
+	// +optional
+	MemorySpreadNodes string `json:"memorySpreadNodes,omitempty"`
+	// MaxMemory is the maximum total memory limit of containers in
+	// a balloon instance. A container is not placed into a balloon
+	// if the sum of memory limits would exceed MaxMemory or the
+	// capacity of the memory nodes closest to the balloon's CPUs.
+	// By default there is no limit.
+	MaxMemory *resource.Quantity `json:"maxMemory,omitempty"`
 	// AllocatorPriority (High, Normal, Low, None)
 	// This parameter is passed to CPU allocator when creating or
 	// resizing a balloon. At init, balloons with highest priority
@@ -190,6 +381,20 @@ type BalloonDef struct {
 	// CpuClass controls how CPUs of a balloon are (re)configured
 	// whenever a balloon is created, inflated or deflated.
 	CpuClass string `json:"cpuClass,omitempty"`
+	// CpuProfile is the name of the CPU profile applied on CPUs of
+	// balloons of this type. The CPU class of the profile overrides
+	// CpuClass.
+	CpuProfile string `json:"cpuProfile,omitempty"`
+	// MinMemBandwidthPct reserves this percentage of memory bandwidth
+	// for CPUs of each balloon of this type using Intel RDT Memory
+	// Bandwidth Allocation (MBA). CPUs of the balloon are assigned to
+	// an unthrottled resctrl group of their own, while CPUs of
+	// balloons without reservations are assigned to a resctrl group
+	// throttled to what is left unreserved. Ignored with a warning if
+	// MBA is not supported.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MinMemBandwidthPct int `json:"minMemBandwidthPct,omitempty"`
 	// MinBalloons is the number of balloon instances that always
 	// exist even if they would become empty. At init this number
 	// of instances will be created before assigning any
@@ -199,10 +404,24 @@ type BalloonDef struct {
 	// is allowed to co-exist. If reached, new balloons cannot be
 	// created anymore.
 	MaxBalloons int `json:"maxBalloons,omitempty"`
+	// MaxPods is the maximum number of pods whose containers are
+	// assigned to a balloon instance. If reached, containers of
+	// other pods are placed in other balloons. The default is 0:
+	// no limit.
+	// +kubebuilder:validation:Minimum=0
+	MaxPods int `json:"maxPods,omitempty"`
 	// PreferSpreadingPods: containers of the same pod may be
 	// placed on separate balloons. The default is false: prefer
 	// placing containers of a pod to the same balloon(s).
 	PreferSpreadingPods bool `json:"preferSpreadingPods,omitempty"`
+	// AggregatePodCpus keeps all containers of a pod in the same
+	// balloon, and sizes the balloon by the aggregate CPU request of
+	// the pod, including its containers which are yet to be added to
+	// the balloon. Containers of a pod join the balloon of the pod
+	// even if the balloon has to be inflated for them. This avoids
+	// allocating extra CPUs for small sidecar containers. Cannot be
+	// used together with PreferSpreadingPods.
+	AggregatePodCpus bool `json:"aggregatePodCPUs,omitempty"`
 	// PreferPerNamespaceBalloon: if true, containers in different
 	// namespaces are preferably placed in separate balloons,
 	// even if the balloon type is the same for all of them. On
@@ -223,6 +442,21 @@ type BalloonDef struct {
 	// +kubebuilder:validation:Enum="";system;package;die;numa;l2cache;core;thread
 	// +kubebuilder:validation:Format:string
 	ShareIdleCpusInSame CPUTopologyLevel `json:"shareIdleCPUsInSame,omitempty"`
+	// ShareIdleCpusCrossNuma: if there are no idle CPUs in the
+	// <topology-level> of ShareIdleCpusInSame, then allow
+	// workloads to run on idle CPUs anywhere in the system, even
+	// if they are on other NUMA nodes. Sharing falls back to
+	// within <topology-level> once idle CPUs become available
+	// there. The default is false: share only within the level.
+	ShareIdleCpusCrossNuma bool `json:"shareIdleCPUsCrossNUMA,omitempty"`
+	// NoShareExclusiveIdle: never lend CPUs of balloons of this
+	// type to workloads in other balloons. CPUs released by these
+	// balloons are not shared as idle CPUs to other balloons until
+	// they are allocated again, and overlay balloons do not run on
+	// them. This keeps caches of the CPUs warm for the balloon's
+	// own workloads. Balloons of this type may still use idle
+	// CPUs shared from elsewhere. The default is false.
+	NoShareExclusiveIdle bool `json:"noShareExclusiveIdle,omitempty"`
 	// PreferCloseToDevices: prefer creating new balloons of this
 	// type close to listed devices.
 	PreferCloseToDevices []string `json:"preferCloseToDevices,omitempty"`
@@ -239,6 +473,61 @@ type BalloonDef struct {
 	// +optional
 	// +kubebuilder:validation:Enum=efficient;performance
 	PreferCoreType string `json:"preferCoreType,omitempty"`
+	// RelaxOnFailure lists CPU allocation preferences which are first
+	// enforced strictly, then dropped one by one in the listed order
+	// until allocating CPUs for a balloon of this type succeeds.
+	// +listType=atomic
+	// +kubebuilder:validation:items:Enum=preferCoreType;preferSpreadOnPhysicalCores;preferIsolCpus;exclusiveLLC
+	RelaxOnFailure []string `json:"relaxOnFailure,omitempty"`
+	// MoveIrqsAway steers device IRQs off the CPUs of balloons of
+	// this type, onto reserved CPUs if no other CPUs are left for
+	// them. Original IRQ affinities are restored once balloons
+	// release the CPUs. The default is false: IRQs are not moved.
+	// +optional
+	MoveIrqsAway bool `json:"moveIrqsAway,omitempty"`
+	// SharedPoolOnly: balloons of this type never get exclusive
+	// CPUs. Instead their containers are pinned to the shared
+	// pool of all free CPUs, which follows changes in free CPUs.
+	// The default is false: balloons get exclusive CPUs.
+	// +optional
+	SharedPoolOnly bool `json:"sharedPoolOnly,omitempty"`
+	// Overlay: balloons of this type never get CPUs of their own.
+	// Instead their containers are pinned to the union of CPUs of
+	// all other balloons, which follows changes in those balloons.
+	// Containers in overlay balloons are not isolated: they share
+	// CPUs with workloads in all other balloons. The default is
+	// false: balloons get exclusive CPUs.
+	// +optional
+	Overlay bool `json:"overlay,omitempty"`
+	// LogLevel overrides the policy log level for detailed logs of
+	// balloons of this type, like resizing balloons and assigning
+	// containers to them. "debug" emits these logs even if debugging
+	// is disabled for the policy, "info" suppresses them even if
+	// debugging is enabled. The default is to follow the policy log
+	// level.
+	// +optional
+	// +kubebuilder:validation:Enum=debug;info
+	LogLevel BalloonLogLevel `json:"logLevel,omitempty"`
+	// ExclusiveLLC: balloons of this type get whole cache groups,
+	// CPUs sharing a last-level cache, which no other balloon uses.
+	// CPU allocations are rounded up to whole cache groups. If no
+	// free cache group is available, allocation fails unless
+	// "exclusiveLLC" is listed in RelaxOnFailure. The default is
+	// false: cache groups are shared with other balloons.
+	// +optional
+	ExclusiveLLC bool `json:"exclusiveLLC,omitempty"`
+	// AvoidSameSocketAs lists balloon types whose sockets balloons
+	// of this type avoid. CPUs are allocated from other sockets
+	// whenever possible. If that is not possible, CPUs are allocated
+	// from any socket and a warning is logged.
+	// +optional
+	AvoidSameSocketAs []string `json:"avoidSameSocketAs,omitempty"`
+	// Fallbacks lists balloon types tried in order if a container
+	// does not fit in a balloon of this type. Fallbacks of the
+	// listed types are tried in turn, and the default balloon type
+	// is tried last.
+	// +optional
+	Fallbacks []string `json:"fallbacks,omitempty"`
 }
 
 // String stringifies a BalloonDef
@@ -246,6 +535,130 @@ func (bdef BalloonDef) String() string {
 	return bdef.Name
 }
 
+// UnmarshalJSON unmarshals a BalloonDef, accepting MinCpus and MaxCpus
+// either as numbers of CPUs or as percentages of available CPUs.
+func (bdef *BalloonDef) UnmarshalJSON(data []byte) error {
+	type balloonDef BalloonDef
+	aux := struct {
+		*balloonDef
+		MaxCpus json.RawMessage `json:"maxCPUs,omitempty"`
+		MinCpus json.RawMessage `json:"minCPUs,omitempty"`
+	}{
+		balloonDef: (*balloonDef)(bdef),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if len(aux.MaxCpus) > 0 {
+		if bdef.MaxCpus, bdef.MaxCpusPercent, err = parseCpuCount(aux.MaxCpus); err != nil {
+			return fmt.Errorf("invalid maxCPUs of balloon type %q: %w", bdef.Name, err)
+		}
+	}
+	if len(aux.MinCpus) > 0 {
+		if bdef.MinCpus, bdef.MinCpusPercent, err = parseCpuCount(aux.MinCpus); err != nil {
+			return fmt.Errorf("invalid minCPUs of balloon type %q: %w", bdef.Name, err)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON marshals a BalloonDef, with MinCpus and MaxCpus given as
+// percentages if they were configured so.
+func (bdef BalloonDef) MarshalJSON() ([]byte, error) {
+	type balloonDef BalloonDef
+	return json.Marshal(struct {
+		balloonDef
+		MaxCpus any `json:"maxCPUs,omitempty"`
+		MinCpus any `json:"minCPUs,omitempty"`
+	}{
+		balloonDef: balloonDef(bdef),
+		MaxCpus:    cpuCountValue(bdef.MaxCpus, bdef.MaxCpusPercent),
+		MinCpus:    cpuCountValue(bdef.MinCpus, bdef.MinCpusPercent),
+	})
+}
+
+// parseCpuCount parses a number of CPUs, or a percentage of CPUs like "25%".
+func parseCpuCount(data json.RawMessage) (int, float64, error) {
+	count := 0
+	if err := json.Unmarshal(data, &count); err == nil {
+		return count, 0, nil
+	}
+	str := ""
+	if err := json.Unmarshal(data, &str); err != nil {
+		return 0, 0, fmt.Errorf("expected a number of CPUs or a percentage, got %s", data)
+	}
+	pct, ok := strings.CutSuffix(strings.TrimSpace(str), "%")
+	if !ok {
+		count, err := strconv.Atoi(pct)
+		if err != nil {
+			return 0, 0, fmt.Errorf("expected a number of CPUs or a percentage, got %q", str)
+		}
+		return count, 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, 0, fmt.Errorf("invalid percentage %q, expected more than 0%% and at most 100%%", str)
+	}
+	return 0, percent, nil
+}
+
+// cpuCountValue returns the JSON value of a number or a percentage of CPUs.
+func cpuCountValue(count int, percent float64) any {
+	if percent > 0 {
+		return strconv.FormatFloat(percent, 'f', -1, 64) + "%"
+	}
+	if count != 0 {
+		return count
+	}
+	return nil
+}
+
+// HasCpuRequestRange returns true if the BalloonDef limits the CPU
+// requests of containers assigned to it.
+func (bdef BalloonDef) HasCpuRequestRange() bool {
+	return bdef.MinCpuRequest != nil || bdef.MaxCpuRequest != nil
+}
+
+// MatchCpuRequest returns true if the given CPU request in milli-CPUs
+// is within the CPU request range of the BalloonDef.
+func (bdef BalloonDef) MatchCpuRequest(milliCpus int) bool {
+	if bdef.MinCpuRequest != nil && int64(milliCpus) < bdef.MinCpuRequest.MilliValue() {
+		return false
+	}
+	if bdef.MaxCpuRequest != nil && int64(milliCpus) > bdef.MaxCpuRequest.MilliValue() {
+		return false
+	}
+	return true
+}
+
+// MemoryAllocFailure is the behavior when allocating memory fails.
+type MemoryAllocFailure string
+
+const (
+	MemoryAllocFailureFallback MemoryAllocFailure = "fallback"
+	MemoryAllocFailureWiden    MemoryAllocFailure = "widen"
+	MemoryAllocFailureFail     MemoryAllocFailure = "fail"
+)
+
+// MixedNamespaceQuota is the handling of balloons with containers of
+// several namespaces in namespace CPU quotas.
+type MixedNamespaceQuota string
+
+const (
+	MixedNamespaceQuotaProportional MixedNamespaceQuota = "proportional"
+	MixedNamespaceQuotaReject       MixedNamespaceQuota = "reject"
+)
+
+// BalloonLogLevel is the log level of detailed logs of a balloon.
+type BalloonLogLevel string
+
+const (
+	BalloonLogLevelDebug BalloonLogLevel = "debug"
+	BalloonLogLevelInfo  BalloonLogLevel = "info"
+)
+
 type CPUPriority string
 
 const (
@@ -283,6 +696,61 @@ func (cmc *ContainerMatchConfig) MatchContainer(c cache.Container) (string, erro
 	return "", nil
 }
 
+// PodSelector selects pods by namespace and labels. A pod is selected
+// if it matches both namespaces and the label selector, if given.
+// +k8s:deepcopy-gen=true
+type PodSelector struct {
+	// Namespaces lists the namespaces of selected pods. If empty,
+	// pods in any namespace are selected.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// LabelSelector selects pods by their labels. If omitted, pods
+	// with any labels are selected.
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// Validate checks that the selector parses and selects some pods.
+func (ps *PodSelector) Validate() error {
+	if len(ps.Namespaces) == 0 && ps.LabelSelector == nil {
+		return errors.New("pod selector without namespaces or label selector selects all pods")
+	}
+	if ps.LabelSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(ps.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label selector: %w", err)
+		}
+	}
+	return nil
+}
+
+// MatchPod returns true if the given pod is selected.
+func (ps *PodSelector) MatchPod(pod cache.Pod) (bool, error) {
+	if len(ps.Namespaces) > 0 && !slices.Contains(ps.Namespaces, pod.GetNamespace()) {
+		return false, nil
+	}
+	if ps.LabelSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ps.LabelSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid label selector: %w", err)
+	}
+	return selector.Matches(podLabels{pod}), nil
+}
+
+// podLabels adapts the labels of a pod for label selectors.
+type podLabels struct {
+	pod cache.Pod
+}
+
+func (l podLabels) Has(key string) bool {
+	_, ok := l.pod.GetLabel(key)
+	return ok
+}
+
+func (l podLabels) Get(key string) string {
+	value, _ := l.pod.GetLabel(key)
+	return value
+}
+
 func (c *Config) Validate() error {
 	errs := []error{}
 	if c.Preserve != nil {
@@ -292,6 +760,11 @@ func (c *Config) Validate() error {
 			}
 		}
 	}
+	if c.BypassSelector != nil {
+		if err := c.BypassSelector.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("bypassSelector: %w", err))
+		}
+	}
 	for _, blnDef := range c.BalloonDefs {
 		for _, expr := range blnDef.MatchExpressions {
 			if err := expr.Validate(); err != nil {
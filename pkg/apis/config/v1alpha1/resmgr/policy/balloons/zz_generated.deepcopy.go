@@ -21,6 +21,7 @@ package balloons
 import (
 	"github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/resmgr/policy"
 	v1alpha1 "github.com/containers/nri-plugins/pkg/apis/resmgr/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -31,6 +32,11 @@ func (in *BalloonDef) DeepCopyInto(out *BalloonDef) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ContainerNamePatterns != nil {
+		in, out := &in.ContainerNamePatterns, &out.ContainerNamePatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.MatchExpressions != nil {
 		in, out := &in.MatchExpressions, &out.MatchExpressions
 		*out = make([]v1alpha1.Expression, len(*in))
@@ -38,6 +44,16 @@ func (in *BalloonDef) DeepCopyInto(out *BalloonDef) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MinCpuRequest != nil {
+		in, out := &in.MinCpuRequest, &out.MinCpuRequest
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.MaxCpuRequest != nil {
+		in, out := &in.MaxCpuRequest, &out.MaxCpuRequest
+		x := (*in).DeepCopy()
+		*out = &x
+	}
 	if in.MemoryTypes != nil {
 		in, out := &in.MemoryTypes, &out.MemoryTypes
 		*out = make([]string, len(*in))
@@ -48,6 +64,11 @@ func (in *BalloonDef) DeepCopyInto(out *BalloonDef) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.MaxMemory != nil {
+		in, out := &in.MaxMemory, &out.MaxMemory
+		x := (*in).DeepCopy()
+		*out = &x
+	}
 	if in.PreferSpreadOnPhysicalCores != nil {
 		in, out := &in.PreferSpreadOnPhysicalCores, &out.PreferSpreadOnPhysicalCores
 		*out = new(bool)
@@ -73,6 +94,21 @@ func (in *BalloonDef) DeepCopyInto(out *BalloonDef) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.RelaxOnFailure != nil {
+		in, out := &in.RelaxOnFailure, &out.RelaxOnFailure
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AvoidSameSocketAs != nil {
+		in, out := &in.AvoidSameSocketAs, &out.AvoidSameSocketAs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Fallbacks != nil {
+		in, out := &in.Fallbacks, &out.Fallbacks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BalloonDef.
@@ -133,6 +169,40 @@ func (in *Config) DeepCopyInto(out *Config) {
 		*out = new(ContainerMatchConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.BypassSelector != nil {
+		in, out := &in.BypassSelector, &out.BypassSelector
+		*out = new(PodSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RebalanceInterval != nil {
+		in, out := &in.RebalanceInterval, &out.RebalanceInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ReconcileInterval != nil {
+		in, out := &in.ReconcileInterval, &out.ReconcileInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.PrewarmIdlePeriod != nil {
+		in, out := &in.PrewarmIdlePeriod, &out.PrewarmIdlePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.CpuProfiles != nil {
+		in, out := &in.CpuProfiles, &out.CpuProfiles
+		*out = make(map[string]CpuProfile, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NamespaceCpuQuotas != nil {
+		in, out := &in.NamespaceCpuQuotas, &out.NamespaceCpuQuotas
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Config.
@@ -166,3 +236,43 @@ func (in *ContainerMatchConfig) DeepCopy() *ContainerMatchConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CpuProfile) DeepCopyInto(out *CpuProfile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CpuProfile.
+func (in *CpuProfile) DeepCopy() *CpuProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(CpuProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodSelector) DeepCopyInto(out *PodSelector) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodSelector.
+func (in *PodSelector) DeepCopy() *PodSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(PodSelector)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -122,6 +122,7 @@ func (in *CommonConfig) DeepCopyInto(out *CommonConfig) {
 	in.Control.DeepCopyInto(&out.Control)
 	in.Log.DeepCopyInto(&out.Log)
 	in.Instrumentation.DeepCopyInto(&out.Instrumentation)
+	out.Audit = in.Audit
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommonConfig.
@@ -0,0 +1,166 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records the cgroup changes plugins request for containers.
+//
+// Every change a plugin asks the runtime to apply to the cgroups of a
+// container, for instance cpuset.cpus, cpuset.mems, cpu.shares or an
+// unified key, can be recorded with a timestamp. This gives a trail for
+// finding out when and why the cgroup settings of a container changed.
+// Records are written to a configurable Sink. Auditing is off by default.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cfgapi "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/audit"
+	logger "github.com/containers/nri-plugins/pkg/log"
+)
+
+// Record is an audit record of cgroup changes for a single container.
+type Record struct {
+	// Time is the time the changes were requested.
+	Time time.Time `json:"time"`
+	// Source is the plugin or policy requesting the changes.
+	Source string `json:"source"`
+	// Event is the event during which the changes were requested.
+	Event string `json:"event"`
+	// Container is the human readable name of the container.
+	Container string `json:"container,omitempty"`
+	// ContainerID is the ID of the container.
+	ContainerID string `json:"containerID"`
+	// Changes maps changed cgroup keys to their new values.
+	Changes map[string]string `json:"changes"`
+}
+
+// Sink is the interface for writing audit records.
+type Sink interface {
+	// Write writes an audit record.
+	Write(*Record) error
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+var (
+	lock sync.Mutex
+	sink Sink
+	log  = logger.Get("audit")
+)
+
+// Configure sets up auditing according to the given configuration,
+// replacing any previously configured sink.
+func Configure(cfg *cfgapi.Config) error {
+	var (
+		s   Sink
+		err error
+	)
+
+	switch cfg.Sink {
+	case cfgapi.SinkNone:
+	case cfgapi.SinkLog:
+		s = &logSink{}
+	case cfgapi.SinkFile:
+		s, err = NewFileSink(cfg.File, cfg.MaxFileSize, cfg.MaxFiles)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid audit sink %q", cfg.Sink)
+	}
+
+	SetSink(s)
+	return nil
+}
+
+// SetSink replaces the sink of audit records, closing the previous one.
+// A nil sink turns auditing off.
+func SetSink(s Sink) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			log.Warnf("failed to close audit sink: %v", err)
+		}
+	}
+	sink = s
+}
+
+// Enabled returns true if audit records are written.
+func Enabled() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return sink != nil
+}
+
+// Write writes an audit record, unless auditing is off or the record
+// has no changes. Records without a timestamp get the current time.
+func Write(r *Record) {
+	if len(r.Changes) == 0 {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if sink == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if err := sink.Write(r); err != nil {
+		log.Errorf("failed to write audit record: %v", err)
+	}
+}
+
+// String returns the record in a human readable form.
+func (r *Record) String() string {
+	name := r.Container
+	if name == "" {
+		name = r.ContainerID
+	}
+	changes := make([]string, 0, len(r.Changes))
+	for _, key := range slices.Sorted(maps.Keys(r.Changes)) {
+		changes = append(changes, key+"="+r.Changes[key])
+	}
+	return fmt.Sprintf("%s %s: %s: %s", r.Source, r.Event, name, strings.Join(changes, " "))
+}
+
+// logSink writes audit records to the log.
+type logSink struct{}
+
+func (*logSink) Write(r *Record) error {
+	log.Info("%s", r)
+	return nil
+}
+
+func (*logSink) Close() error {
+	return nil
+}
+
+// marshal returns the record as a JSON line.
+func (r *Record) marshal() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,150 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/stretchr/testify/require"
+
+	cfgapi "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/audit"
+)
+
+type testSink struct {
+	records []*Record
+	closed  bool
+}
+
+func (s *testSink) Write(r *Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestResourceChanges(t *testing.T) {
+	r := &api.LinuxResources{
+		Cpu: &api.LinuxCPU{
+			Cpus:   "0-3",
+			Mems:   "0",
+			Shares: api.UInt64(512),
+		},
+		Memory: &api.LinuxMemory{
+			Limit: api.Int64(1 << 30),
+		},
+		Unified: map[string]string{
+			"memory.swap.max": "0",
+		},
+	}
+
+	require.Equal(t, map[string]string{
+		"cpuset.cpus":     "0-3",
+		"cpuset.mems":     "0",
+		"cpu.shares":      "512",
+		"memory.limit":    "1073741824",
+		"memory.swap.max": "0",
+	}, ResourceChanges(r))
+	require.Empty(t, ResourceChanges(nil))
+}
+
+func TestWrite(t *testing.T) {
+	sink := &testSink{}
+	SetSink(sink)
+	defer SetSink(nil)
+	require.True(t, Enabled())
+
+	u := &api.ContainerUpdate{}
+	u.SetContainerId("ctr0")
+	u.SetLinuxCPUSetCPUs("2-3")
+	empty := &api.ContainerUpdate{}
+	empty.SetContainerId("ctr1")
+	ContainerUpdates("test", "UpdateContainers", []*api.ContainerUpdate{u, empty},
+		func(id string) string { return "pod:" + id })
+
+	require.Len(t, sink.records, 1, "updates without changes should not be recorded")
+	r := sink.records[0]
+	require.Equal(t, "ctr0", r.ContainerID)
+	require.Equal(t, "pod:ctr0", r.Container)
+	require.Equal(t, map[string]string{"cpuset.cpus": "2-3"}, r.Changes)
+	require.False(t, r.Time.IsZero())
+	require.Equal(t, "test UpdateContainers: pod:ctr0: cpuset.cpus=2-3", r.String())
+
+	SetSink(nil)
+	require.True(t, sink.closed)
+	require.False(t, Enabled())
+	ContainerUpdates("test", "UpdateContainers", []*api.ContainerUpdate{u}, nil)
+	require.Len(t, sink.records, 1, "records should not be written with auditing off")
+}
+
+func TestFileSink(t *testing.T) {
+	newRecord := func(id string) *Record {
+		return &Record{
+			Time:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Source:      "test",
+			Event:       "CreateContainer",
+			ContainerID: id,
+			Changes:     map[string]string{"cpuset.cpus": "0-1"},
+		}
+	}
+	line, err := newRecord("ctr0").marshal()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	err = Configure(&cfgapi.Config{
+		Sink:        cfgapi.SinkFile,
+		File:        path,
+		MaxFileSize: int64(2 * len(line)),
+		MaxFiles:    2,
+	})
+	require.NoError(t, err)
+	defer SetSink(nil)
+
+	for _, id := range []string{"ctr0", "ctr1", "ctr2", "ctr3", "ctr4"} {
+		Write(newRecord(id))
+	}
+	SetSink(nil)
+
+	readIDs := func(path string) []string {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		ids := []string{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			r := &Record{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), r))
+			ids = append(ids, r.ContainerID)
+		}
+		return ids
+	}
+
+	// Every file holds 2 records, and the oldest record is rotated out.
+	require.Equal(t, []string{"ctr4"}, readIDs(path))
+	require.Equal(t, []string{"ctr2", "ctr3"}, readIDs(path+".1"))
+	require.Equal(t, []string{"ctr0", "ctr1"}, readIDs(path+".2"))
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	require.Error(t, Configure(&cfgapi.Config{Sink: "bogus"}))
+}
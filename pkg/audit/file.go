@@ -0,0 +1,136 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cfgapi "github.com/containers/nri-plugins/pkg/apis/config/v1alpha1/audit"
+)
+
+// FileSink writes audit records as JSON lines to a file. The file is
+// rotated once it grows larger than its maximum size. Rotated files
+// are named by appending .1, .2, and so on, to the file name, .1 being
+// the most recent one.
+type FileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewFileSink creates a new sink writing to the given file. Defaults
+// are used for an empty path, and a maxSize or maxFiles of 0.
+func NewFileSink(path string, maxSize int64, maxFiles int) (*FileSink, error) {
+	if path == "" {
+		path = cfgapi.DefaultFile
+	}
+	if maxSize <= 0 {
+		maxSize = cfgapi.DefaultMaxFileSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = cfgapi.DefaultMaxFiles
+	}
+
+	s := &FileSink{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write writes an audit record to the file, rotating it if necessary.
+func (s *FileSink) Write(r *Record) error {
+	data, err := r.marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	if s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit file %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+// Close closes the audit file.
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create audit file directory: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit file: %w", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.Close(); err != nil {
+		return fmt.Errorf("failed to close audit file %s: %w", s.path, err)
+	}
+
+	for i := s.maxFiles - 1; i > 0; i-- {
+		err := os.Rename(s.rotated(i), s.rotated(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit file: %w", err)
+		}
+	}
+	if err := os.Rename(s.path, s.rotated(1)); err != nil {
+		return fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
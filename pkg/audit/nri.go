@@ -0,0 +1,104 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"strconv"
+
+	"github.com/containerd/nri/pkg/api"
+)
+
+// ContainerAdjustment records the cgroup changes of an NRI adjustment
+// to a container being created.
+func ContainerAdjustment(source, event, name, id string, adjust *api.ContainerAdjustment) {
+	if adjust == nil || adjust.GetLinux() == nil {
+		return
+	}
+	Write(&Record{
+		Source:      source,
+		Event:       event,
+		Container:   name,
+		ContainerID: id,
+		Changes:     ResourceChanges(adjust.GetLinux().GetResources()),
+	})
+}
+
+// ContainerUpdates records the cgroup changes of NRI container updates.
+// The names of containers are looked up using the given function, if
+// it is not nil.
+func ContainerUpdates(source, event string, updates []*api.ContainerUpdate, name func(id string) string) {
+	for _, u := range updates {
+		if u.GetLinux() == nil {
+			continue
+		}
+		id := u.GetContainerId()
+		r := &Record{
+			Source:      source,
+			Event:       event,
+			ContainerID: id,
+			Changes:     ResourceChanges(u.GetLinux().GetResources()),
+		}
+		if name != nil {
+			r.Container = name(id)
+		}
+		Write(r)
+	}
+}
+
+// ResourceChanges returns the cgroup keys and values set by the given
+// NRI Linux resources.
+func ResourceChanges(r *api.LinuxResources) map[string]string {
+	changes := map[string]string{}
+	if r == nil {
+		return changes
+	}
+
+	if cpu := r.GetCpu(); cpu != nil {
+		if cpu.GetCpus() != "" {
+			changes["cpuset.cpus"] = cpu.GetCpus()
+		}
+		if cpu.GetMems() != "" {
+			changes["cpuset.mems"] = cpu.GetMems()
+		}
+		if v := cpu.GetShares(); v != nil {
+			changes["cpu.shares"] = strconv.FormatUint(v.GetValue(), 10)
+		}
+		if v := cpu.GetQuota(); v != nil {
+			changes["cpu.quota"] = strconv.FormatInt(v.GetValue(), 10)
+		}
+		if v := cpu.GetPeriod(); v != nil {
+			changes["cpu.period"] = strconv.FormatUint(v.GetValue(), 10)
+		}
+	}
+	if mem := r.GetMemory(); mem != nil {
+		if v := mem.GetLimit(); v != nil {
+			changes["memory.limit"] = strconv.FormatInt(v.GetValue(), 10)
+		}
+		if v := mem.GetSwap(); v != nil {
+			changes["memory.swap"] = strconv.FormatInt(v.GetValue(), 10)
+		}
+	}
+	if v := r.GetRdtClass(); v != nil {
+		changes["rdt.class"] = v.GetValue()
+	}
+	if v := r.GetBlockioClass(); v != nil {
+		changes["blockio.class"] = v.GetValue()
+	}
+	for key, value := range r.GetUnified() {
+		changes[key] = value
+	}
+
+	return changes
+}
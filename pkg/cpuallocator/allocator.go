@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"slices"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/containers/nri-plugins/pkg/utils/cpuset"
 
@@ -49,14 +51,44 @@ const (
 
 // allocatorHelper encapsulates state for allocating CPUs.
 type allocatorHelper struct {
-	logger.Logger               // allocatorHelper logger instance
-	sys           sysfs.System  // sysfs CPU and topology information
-	topology      topologyCache // cached topology information
-	flags         AllocFlag     // allocation preferences
-	from          cpuset.CPUSet // set of CPUs to allocate from
-	prefer        CPUPriority   // CPU priority to prefer
-	cnt           int           // number of CPUs to allocate
-	result        cpuset.CPUSet // set of CPUs allocated
+	logger.Logger                 // allocatorHelper logger instance
+	sys           sysfs.System    // sysfs CPU and topology information
+	topology      topologyCache   // cached topology information
+	flags         AllocFlag       // allocation preferences
+	from          cpuset.CPUSet   // set of CPUs to allocate from
+	prefer        CPUPriority     // CPU priority to prefer
+	cnt           int             // number of CPUs to allocate
+	result        cpuset.CPUSet   // set of CPUs allocated
+	reserveHigh   int             // number of high-priority CPUs to keep unallocated
+	reserved      cpuset.CPUSet   // high-priority CPUs held back for reserveHigh
+	phases        []AllocPhase    // CPUs obtained in each allocation phase
+	confine       cpuset.CPUSet   // CPUs of the package to confine allocation to
+	confinePkg    idset.ID        // package to confine allocation to
+	load          map[int]float64 // advisory per-CPU load, lower preferred
+}
+
+// AllocPhase describes the number of CPUs obtained in a phase of allocation.
+type AllocPhase struct {
+	Name string
+	Cpus int
+}
+
+// AllocationError describes why a CPU allocation could not be fully satisfied.
+type AllocationError struct {
+	Requested int          // number of CPUs requested
+	Available int          // number of CPUs available for allocation
+	Phases    []AllocPhase // CPUs obtained in each allocation phase
+	Missing   int          // number of CPUs still missing after all phases
+}
+
+// Error implements the error interface for AllocationError.
+func (e *AllocationError) Error() string {
+	phases := make([]string, 0, len(e.Phases))
+	for _, p := range e.Phases {
+		phases = append(phases, fmt.Sprintf("%s: %d", p.Name, p.Cpus))
+	}
+	return fmt.Sprintf("failed to allocate %d CPUs out of %d available, %d missing (%s)",
+		e.Requested, e.Available, e.Missing, strings.Join(phases, ", "))
 }
 
 // CPUAllocator is an interface for a generic CPU allocator
@@ -64,6 +96,15 @@ type CPUAllocator interface {
 	AllocateCpus(from *cpuset.CPUSet, cnt int, options ...Option) (cpuset.CPUSet, error)
 	ReleaseCpus(from *cpuset.CPUSet, cnt int, options ...Option) (cpuset.CPUSet, error)
 	GetCPUPriorities() map[CPUPriority]cpuset.CPUSet
+	// CacheGroups returns the CPUs of cache groups, CPUs sharing the
+	// cache picked for grouping CPUs, in package, die, NUMA node and
+	// CPU ID order. No groups are returned if no cache level provides
+	// useful grouping.
+	CacheGroups() []cpuset.CPUSet
+	// RefreshTopology rebuilds cached topology information, for instance
+	// after CPU hotplug. CPU sets obtained before the refresh might not be
+	// valid any more, so callers must re-check their free CPUs afterwards.
+	RefreshTopology()
 }
 
 type CPUPriority int
@@ -99,10 +140,51 @@ func WithAllocFlags(flags AllocFlag) Option {
 	}
 }
 
+// WithReserveHighPriority keeps at least n high-priority CPUs unallocated,
+// unless the allocation itself prefers high-priority CPUs.
+func WithReserveHighPriority(n int) Option {
+	return func(a *allocatorHelper) error {
+		if n < 0 {
+			return fmt.Errorf("invalid high-priority CPU reserve %d", n)
+		}
+		a.reserveHigh = n
+		return nil
+	}
+}
+
+// WithConfineToPackage restricts the allocation to CPUs of the given
+// package. The allocation fails if the package lacks enough CPUs.
+func WithConfineToPackage(pkg idset.ID) Option {
+	return func(a *allocatorHelper) error {
+		if a.sys == nil {
+			return fmt.Errorf("can't confine allocation to package %d, unknown system topology", pkg)
+		}
+		if !slices.Contains(a.sys.PackageIDs(), pkg) {
+			return fmt.Errorf("can't confine allocation to unknown package %d", pkg)
+		}
+		a.confine = a.sys.Package(pkg).CPUSet()
+		a.confinePkg = pkg
+		return nil
+	}
+}
+
+// WithCPULoad provides the current load of CPUs, for instance utilization
+// sampled from /proc/stat by the caller. Less loaded threads are preferred
+// when they are otherwise equal in topology and priority. The load data is
+// advisory: it never overrides topology-based preferences. CPUs missing
+// from the load map are considered idle.
+func WithCPULoad(load map[int]float64) Option {
+	return func(a *allocatorHelper) error {
+		a.load = load
+		return nil
+	}
+}
+
 type cpuAllocator struct {
 	logger.Logger
 	sys           sysfs.System  // wrapped sysfs.System instance
 	topologyCache topologyCache // topology lookups
+	topologyLock  sync.RWMutex  // protects swapping topologyCache
 }
 
 // topologyCache caches topology lookups
@@ -1006,6 +1088,7 @@ func (a *allocatorHelper) takeIdleThreads() {
 	//     - from packages with fewer remaining free CPUs/cores in a.from
 	//     - from cores with fewer remaining free CPUs/cores in a.from
 	//     - from packages with lower id
+	//     - with lower load, if load is known
 	//     - with lower id
 	sort.Slice(cores,
 		func(i, j int) bool {
@@ -1051,6 +1134,12 @@ func (a *allocatorHelper) takeIdleThreads() {
 				return iCoreFree < jCoreFree
 			}
 
+			iLoad := a.load[int(iCore)]
+			jLoad := a.load[int(jCore)]
+			if iLoad != jLoad {
+				return iLoad < jLoad
+			}
+
 			return iCore < jCore
 		})
 
@@ -1085,32 +1174,61 @@ func (a *allocatorHelper) takeAny() {
 	}
 }
 
+// holdHighPriorityReserve removes reserved high-priority CPUs from the
+// set of CPUs to allocate from, unless we prefer high-priority CPUs.
+func (a *allocatorHelper) holdHighPriorityReserve() {
+	if a.reserveHigh <= 0 || a.prefer == PriorityHigh {
+		return
+	}
+
+	high := a.topology.cpuPriorities[PriorityHigh].Intersection(a.from).List()
+	if len(high) == 0 {
+		return
+	}
+	if len(high) > a.reserveHigh {
+		high = high[len(high)-a.reserveHigh:]
+	}
+
+	a.reserved = cpuset.New(high...)
+	a.from = a.from.Difference(a.reserved)
+
+	a.Debug(" => holding back reserved high-priority CPUs #%s", a.reserved)
+}
+
+// releaseHighPriorityReserve puts held back high-priority CPUs back to free ones.
+func (a *allocatorHelper) releaseHighPriorityReserve() {
+	a.from = a.from.Union(a.reserved)
+}
+
 // Perform CPU allocation.
 func (a *allocatorHelper) allocate() cpuset.CPUSet {
+	a.holdHighPriorityReserve()
+	defer a.releaseHighPriorityReserve()
+
 	if a.sys != nil {
 		if (a.flags & AllocIdlePackages) != 0 {
-			a.takeIdlePackages()
+			a.takePhase("packages", a.takeIdlePackages)
 		}
 		if len(a.topology.kind) > 1 {
 			if a.cnt > 0 && (a.flags&AllocIdleClusters) != 0 {
-				a.takeIdleClusters()
+				a.takePhase("clusters", a.takeIdleClusters)
 			}
 			if a.cnt > 0 && (a.flags&AllocCacheGroups) != 0 {
-				a.takeCacheGroups()
+				a.takePhase("groups", a.takeCacheGroups)
 			}
 		} else {
 			if a.cnt > 0 && (a.flags&AllocCacheGroups) != 0 {
-				a.takeCacheGroups()
+				a.takePhase("groups", a.takeCacheGroups)
 			}
 		}
 		if a.cnt > 0 && (a.flags&AllocIdleCores) != 0 {
-			a.takeIdleCores()
+			a.takePhase("cores", a.takeIdleCores)
 		}
 		if a.cnt > 0 {
-			a.takeIdleThreads()
+			a.takePhase("threads", a.takeIdleThreads)
 		}
 	} else {
-		a.takeAny()
+		a.takePhase("any", a.takeAny)
 	}
 	if a.cnt == 0 {
 		return a.result
@@ -1119,6 +1237,23 @@ func (a *allocatorHelper) allocate() cpuset.CPUSet {
 	return cpuset.New()
 }
 
+// takePhase runs an allocation phase and records the number of CPUs it took.
+func (a *allocatorHelper) takePhase(name string, take func()) {
+	cnt := a.cnt
+	take()
+	a.phases = append(a.phases, AllocPhase{Name: name, Cpus: cnt - a.cnt})
+}
+
+// allocationError returns an error describing an unsatisfied allocation.
+func (a *allocatorHelper) allocationError(requested, available int) *AllocationError {
+	return &AllocationError{
+		Requested: requested,
+		Available: available,
+		Phases:    slices.Clone(a.phases),
+		Missing:   a.cnt,
+	}
+}
+
 type clusterSorter struct {
 	// function to pick or ignore a cluster
 	pick func(*cpuCluster) (bool, cpuset.CPUSet)
@@ -1354,22 +1489,51 @@ func (ca *cpuAllocator) allocateCpus(from *cpuset.CPUSet, cnt int, options ...Op
 	var result cpuset.CPUSet
 	var err error
 
+	a := newAllocatorHelper(ca.sys, ca.topology())
+	for _, o := range options {
+		if err := o(a); err != nil {
+			return cpuset.New(), err
+		}
+	}
+
+	if !a.confine.IsEmpty() {
+		inside := from.Intersection(a.confine)
+		outside := from.Difference(a.confine)
+		if inside.Size() < cnt {
+			return cpuset.New(), fmt.Errorf("package %d has only %d free CPUs, %d requested",
+				a.confinePkg, inside.Size(), cnt)
+		}
+		// Allocate from the package, then put back CPUs outside of it.
+		orig := from
+		from = &inside
+		defer func() {
+			*orig = from.Union(outside)
+		}()
+	}
+
 	switch {
 	case from.Size() < cnt:
 		result, err = cpuset.New(), fmt.Errorf("cpuset %s does not have %d CPUs", from, cnt)
-	case from.Size() == cnt:
+	case from.Size() == cnt && a.reserveHigh == 0:
 		result, err, *from = from.Clone(), nil, cpuset.New()
 	default:
-		a := newAllocatorHelper(ca.sys, ca.topologyCache)
-		for _, o := range options {
-			if err := o(a); err != nil {
-				return cpuset.New(), err
-			}
-		}
 		a.from = from.Clone()
 		a.cnt = cnt
 
-		result, err, *from = a.allocate(), nil, a.from.Clone()
+		available := from.Size()
+		result = a.allocate()
+
+		if result.Size() != cnt {
+			allocErr := a.allocationError(cnt, available)
+			if !a.reserved.IsEmpty() {
+				err = fmt.Errorf("can't allocate %d CPUs without breaking reserve of %d high-priority CPUs: %w",
+					cnt, a.reserveHigh, allocErr)
+			} else {
+				err = allocErr
+			}
+			return cpuset.New(), err
+		}
+		*from = a.from.Clone()
 
 		a.Debug("%d cpus from #%v (preferring #%v) => #%v", cnt, from.Union(result), a.prefer, result)
 	}
@@ -1397,13 +1561,42 @@ func (ca *cpuAllocator) ReleaseCpus(from *cpuset.CPUSet, cnt int, options ...Opt
 // GetCPUPriorities returns the CPUSets for the discovered priorities.
 func (ca *cpuAllocator) GetCPUPriorities() map[CPUPriority]cpuset.CPUSet {
 	prios := make(map[CPUPriority]cpuset.CPUSet)
+	topo := ca.topology()
 	for prio := CPUPriority(0); prio < NumCPUPriorities; prio++ {
-		cset := ca.topologyCache.cpuPriorities[prio]
+		cset := topo.cpuPriorities[prio]
 		prios[prio] = cset.Clone()
 	}
 	return prios
 }
 
+// CacheGroups returns the CPUs of the discovered cache groups.
+func (ca *cpuAllocator) CacheGroups() []cpuset.CPUSet {
+	topo := ca.topology()
+	groups := make([]cpuset.CPUSet, 0, len(topo.cacheGroups))
+	for _, g := range topo.cacheGroups {
+		groups = append(groups, g.cpus.Clone())
+	}
+	return groups
+}
+
+// RefreshTopology rebuilds cached topology information from the current
+// state of the system. The new cache is swapped in once fully built, so
+// allocations in progress keep using the old one.
+func (ca *cpuAllocator) RefreshTopology() {
+	topo := newTopologyCache(ca.sys)
+
+	ca.topologyLock.Lock()
+	defer ca.topologyLock.Unlock()
+	ca.topologyCache = topo
+}
+
+// topology returns the current topology cache.
+func (ca *cpuAllocator) topology() topologyCache {
+	ca.topologyLock.RLock()
+	defer ca.topologyLock.RUnlock()
+	return ca.topologyCache
+}
+
 func newTopologyCache(sys sysfs.System) topologyCache {
 	c := topologyCache{
 		pkg:  make(map[idset.ID]cpuset.CPUSet),
@@ -1664,8 +1857,14 @@ func (c *topologyCache) discoverCPUClusters(sys sysfs.System) {
 		pkg := sys.Package(id)
 		clusters := []*cpuCluster{}
 		for _, die := range pkg.DieIDs() {
-			for _, cl := range pkg.LogicalDieClusterIDs(die) {
-				if cpus := pkg.LogicalDieClusterCPUSet(die, cl); cpus.Size() > 0 {
+			clusterIDs, clusterCPUs := pkg.LogicalDieClusterIDs(die), pkg.LogicalDieClusterCPUSet
+			// If cluster_id does not split the die, as on AMD EPYC, use
+			// core complexes (CPUs sharing an L3 cache) as clusters.
+			if ccxIDs := pkg.CCXIDs(die); len(clusterIDs) <= 1 && len(ccxIDs) > 1 {
+				clusterIDs, clusterCPUs = ccxIDs, pkg.CCXCPUSet
+			}
+			for _, cl := range clusterIDs {
+				if cpus := clusterCPUs(die, cl); cpus.Size() > 0 {
 					clusters = append(clusters, &cpuCluster{
 						pkg:     id,
 						die:     die,
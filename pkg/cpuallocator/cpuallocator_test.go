@@ -15,6 +15,7 @@
 package cpuallocator
 
 import (
+	"errors"
 	"os"
 	"path"
 	"testing"
@@ -101,6 +102,343 @@ func TestAllocatorHelper(t *testing.T) {
 	}
 }
 
+func TestReserveHighPriority(t *testing.T) {
+	// Create tmpdir and decompress testdata there
+	tmpdir, err := os.MkdirTemp("", "nri-resource-policy-test-")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := utils.UncompressTbz2(path.Join("testdata", "sysfs.tar.bz2"), tmpdir); err != nil {
+		t.Fatalf("failed to decompress testdata: %v", err)
+	}
+
+	// Discover mock system from the testdata
+	sys, err := sysfs.DiscoverSystemAt(
+		path.Join(tmpdir, "sysfs", "2-socket-4-node-40-core", "sys"),
+		sysfs.DiscoverCPUTopology, sysfs.DiscoverMemTopology)
+	if err != nil {
+		t.Fatalf("failed to discover mock system: %v", err)
+	}
+	topoCache := newTopologyCache(sys)
+
+	// Fake cpu priorities: 5 cores from pkg #0 as high prio
+	topoCache.cpuPriorities = [NumCPUPriorities]cpuset.CPUSet{
+		cpuset.MustParse("2,5,8,15,17,42,45,48,55,57"),
+		cpuset.MustParse("20-39,60-79"),
+		cpuset.MustParse("0,1,3,4,6,7,9-14,16,18,19,40,41,43,44,46,47,49-54,56,58,59"),
+	}
+
+	tcs := []struct {
+		description string
+		from        cpuset.CPUSet
+		prefer      CPUPriority
+		reserve     int
+		cnt         int
+		expected    cpuset.CPUSet
+	}{
+		{
+			description: "low priority stops short of reserve",
+			from:        cpuset.MustParse("2,3,5,8,10-14"),
+			prefer:      PriorityLow,
+			reserve:     2,
+			cnt:         7,
+			expected:    cpuset.MustParse("2,3,10-14"),
+		},
+		{
+			description: "low priority fails to break reserve",
+			from:        cpuset.MustParse("2,3,5,8,10-14"),
+			prefer:      PriorityLow,
+			reserve:     2,
+			cnt:         8,
+			expected:    cpuset.New(),
+		},
+		{
+			description: "high priority can use reserve",
+			from:        cpuset.MustParse("2,3,5,8,10-14"),
+			prefer:      PriorityHigh,
+			reserve:     2,
+			cnt:         8,
+			expected:    cpuset.MustParse("2,3,5,8,10-13"),
+		},
+	}
+
+	// Run tests
+	for _, tc := range tcs {
+		t.Run(tc.description, func(t *testing.T) {
+			a := newAllocatorHelper(sys, topoCache)
+			a.from = tc.from
+			a.prefer = tc.prefer
+			a.reserveHigh = tc.reserve
+			a.cnt = tc.cnt
+			result := a.allocate()
+			if !result.Equals(tc.expected) {
+				t.Errorf("expected %q, result was %q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestAllocationError(t *testing.T) {
+	// Create tmpdir and decompress testdata there
+	tmpdir, err := os.MkdirTemp("", "nri-resource-policy-test-")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := utils.UncompressTbz2(path.Join("testdata", "sysfs.tar.bz2"), tmpdir); err != nil {
+		t.Fatalf("failed to decompress testdata: %v", err)
+	}
+
+	// Discover mock system from the testdata
+	sys, err := sysfs.DiscoverSystemAt(
+		path.Join(tmpdir, "sysfs", "2-socket-4-node-40-core", "sys"),
+		sysfs.DiscoverCPUTopology, sysfs.DiscoverMemTopology)
+	if err != nil {
+		t.Fatalf("failed to discover mock system: %v", err)
+	}
+	topoCache := newTopologyCache(sys)
+
+	// Fake cpu priorities: 5 cores from pkg #0 as high prio
+	topoCache.cpuPriorities = [NumCPUPriorities]cpuset.CPUSet{
+		cpuset.MustParse("2,5,8,15,17,42,45,48,55,57"),
+		cpuset.MustParse("20-39,60-79"),
+		cpuset.MustParse("0,1,3,4,6,7,9-14,16,18,19,40,41,43,44,46,47,49-54,56,58,59"),
+	}
+
+	ca := &cpuAllocator{
+		Logger:        log,
+		sys:           sys,
+		topologyCache: topoCache,
+	}
+
+	tcs := []struct {
+		description string
+		cnt         int
+		fail        bool
+	}{
+		{
+			description: "allocation succeeds",
+			cnt:         7,
+		},
+		{
+			description: "allocation fails to break reserve",
+			cnt:         8,
+			fail:        true,
+		},
+	}
+
+	// Run tests
+	for _, tc := range tcs {
+		t.Run(tc.description, func(t *testing.T) {
+			orig := cpuset.MustParse("2,3,5,8,10-14")
+			from := orig.Clone()
+			result, err := ca.AllocateCpus(&from, tc.cnt, WithPriority(PriorityLow), WithReserveHighPriority(2))
+			if !tc.fail {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !result.IsEmpty() || !from.Equals(orig) {
+				t.Errorf("expected no CPUs taken from %q by failed allocation, got %q leaving %q",
+					orig, result, from)
+			}
+
+			allocErr := &AllocationError{}
+			if !errors.As(err, &allocErr) {
+				t.Fatalf("expected AllocationError, got %v", err)
+			}
+			if allocErr.Requested != tc.cnt || allocErr.Available != 9 {
+				t.Errorf("expected %d requested of 9 available CPUs, got %d of %d",
+					tc.cnt, allocErr.Requested, allocErr.Available)
+			}
+			got := 0
+			for _, p := range allocErr.Phases {
+				got += p.Cpus
+			}
+			if allocErr.Missing == 0 || got+allocErr.Missing != tc.cnt {
+				t.Errorf("expected phases (%d CPUs) and missing (%d CPUs) to add up to %d",
+					got, allocErr.Missing, tc.cnt)
+			}
+		})
+	}
+}
+
+func TestConfineToPackage(t *testing.T) {
+	// Create tmpdir and decompress testdata there
+	tmpdir, err := os.MkdirTemp("", "nri-resource-policy-test-")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := utils.UncompressTbz2(path.Join("testdata", "sysfs.tar.bz2"), tmpdir); err != nil {
+		t.Fatalf("failed to decompress testdata: %v", err)
+	}
+
+	// Discover mock system from the testdata
+	sys, err := sysfs.DiscoverSystemAt(
+		path.Join(tmpdir, "sysfs", "2-socket-4-node-40-core", "sys"),
+		sysfs.DiscoverCPUTopology, sysfs.DiscoverMemTopology)
+	if err != nil {
+		t.Fatalf("failed to discover mock system: %v", err)
+	}
+
+	ca := &cpuAllocator{
+		Logger:        log,
+		sys:           sys,
+		topologyCache: newTopologyCache(sys),
+	}
+
+	pkg1 := sys.Package(1).CPUSet()
+
+	tcs := []struct {
+		description string
+		from        cpuset.CPUSet
+		cnt         int
+		prio        CPUPriority
+		fail        bool
+	}{
+		{
+			description: "allocation confined to package #1",
+			from:        sys.CPUSet(),
+			cnt:         8,
+			prio:        PriorityNormal,
+		},
+		{
+			description: "confined allocation composes with priority",
+			from:        sys.CPUSet(),
+			cnt:         4,
+			prio:        PriorityLow,
+		},
+		{
+			description: "package #1 has too few free CPUs",
+			from:        sys.Package(0).CPUSet().Union(cpuset.New(pkg1.List()[:2]...)),
+			cnt:         4,
+			prio:        PriorityNormal,
+			fail:        true,
+		},
+	}
+
+	// Run tests
+	for _, tc := range tcs {
+		t.Run(tc.description, func(t *testing.T) {
+			from := tc.from.Clone()
+			result, err := ca.AllocateCpus(&from, tc.cnt, WithPriority(tc.prio), WithConfineToPackage(1))
+			if tc.fail {
+				if err == nil {
+					t.Errorf("expected error, got CPUs %q", result)
+				}
+				if !from.Equals(tc.from) {
+					t.Errorf("expected unchanged free CPUs %q, got %q", tc.from, from)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Size() != tc.cnt || !result.IsSubsetOf(pkg1) {
+				t.Errorf("expected %d CPUs from package #1 (%q), got %q", tc.cnt, pkg1, result)
+			}
+			if !from.Equals(tc.from.Difference(result)) {
+				t.Errorf("expected remaining CPUs %q, got %q", tc.from.Difference(result), from)
+			}
+		})
+	}
+
+	from := sys.CPUSet()
+	if _, err := ca.AllocateCpus(&from, 1, WithConfineToPackage(7)); err == nil {
+		t.Errorf("expected error for unknown package")
+	}
+
+	from = sys.CPUSet()
+	if _, err := NewCPUAllocator(nil).AllocateCpus(&from, 1, WithConfineToPackage(1)); err == nil {
+		t.Errorf("expected error for unknown system topology")
+	}
+	if !from.Equals(sys.CPUSet()) {
+		t.Errorf("expected unchanged free CPUs %q, got %q", sys.CPUSet(), from)
+	}
+}
+
+func TestCPULoad(t *testing.T) {
+	// Create tmpdir and decompress testdata there
+	tmpdir, err := os.MkdirTemp("", "nri-resource-policy-test-")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := utils.UncompressTbz2(path.Join("testdata", "sysfs.tar.bz2"), tmpdir); err != nil {
+		t.Fatalf("failed to decompress testdata: %v", err)
+	}
+
+	// Discover mock system from the testdata
+	sys, err := sysfs.DiscoverSystemAt(
+		path.Join(tmpdir, "sysfs", "2-socket-4-node-40-core", "sys"),
+		sysfs.DiscoverCPUTopology, sysfs.DiscoverMemTopology)
+	if err != nil {
+		t.Fatalf("failed to discover mock system: %v", err)
+	}
+
+	ca := &cpuAllocator{
+		Logger:        log,
+		sys:           sys,
+		topologyCache: newTopologyCache(sys),
+	}
+
+	pkg0 := sys.Package(0).CPUSet().List()
+	pkg1 := sys.Package(1).CPUSet().List()
+	cooler := cpuset.New(pkg0[len(pkg0)-1], pkg0[len(pkg0)-2])
+
+	// Load package #0 except for the cooler CPUs. Load package #1
+	// even less, to check that load never overrides locality.
+	load := map[int]float64{}
+	for _, cpu := range pkg0 {
+		if !cooler.Contains(cpu) {
+			load[cpu] = 0.9
+		}
+	}
+	for _, cpu := range pkg1 {
+		load[cpu] = 0.1
+	}
+
+	tcs := []struct {
+		description string
+		options     []Option
+		expected    cpuset.CPUSet
+	}{
+		{
+			description: "without load",
+			expected:    cpuset.New(pkg0[0], pkg0[1]),
+		},
+		{
+			description: "prefer less loaded threads",
+			options:     []Option{WithCPULoad(load)},
+			expected:    cooler,
+		},
+	}
+
+	// Run tests
+	for _, tc := range tcs {
+		t.Run(tc.description, func(t *testing.T) {
+			// Allocate single threads from package #0 and a single
+			// thread of package #1. Package #0 is preferred by its ID.
+			from := sys.Package(0).CPUSet().Union(cpuset.New(pkg1[0]))
+			options := append([]Option{WithAllocFlags(0), WithPriority(PriorityNone)}, tc.options...)
+			result, err := ca.AllocateCpus(&from, 2, options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.Equals(tc.expected) {
+				t.Errorf("expected CPUs %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
 func TestClusteredAllocation(t *testing.T) {
 	if v := os.Getenv("ENABLE_DEBUG"); v != "" {
 		logger.EnableDebug(logSource)
@@ -746,3 +1084,57 @@ func TestClusteredCoreKindAllocation(t *testing.T) {
 		})
 	}
 }
+
+func TestRefreshTopology(t *testing.T) {
+	// Create tmpdir and decompress testdata there
+	tmpdir, err := os.MkdirTemp("", "nri-resource-policy-test-")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := utils.UncompressTbz2(path.Join("testdata", "sysfs.tar.bz2"), tmpdir); err != nil {
+		t.Fatalf("failed to decompress testdata: %v", err)
+	}
+
+	// Discover mock system from the testdata
+	sys, err := sysfs.DiscoverSystemAt(
+		path.Join(tmpdir, "sysfs", "2-socket-4-node-40-core", "sys"),
+		sysfs.DiscoverCPUTopology, sysfs.DiscoverMemTopology)
+	if err != nil {
+		t.Fatalf("failed to discover mock system: %v", err)
+	}
+
+	// Start with a stale, empty topology cache.
+	ca := &cpuAllocator{
+		Logger:        log,
+		sys:           sys,
+		topologyCache: newTopologyCache(nil),
+	}
+
+	if cpus := ca.GetCPUPriorities()[PriorityNormal]; !cpus.IsEmpty() {
+		t.Fatalf("expected no CPU priorities before refresh, got %q", cpus)
+	}
+
+	ca.RefreshTopology()
+
+	expected := newTopologyCache(sys).cpuPriorities
+	prios := ca.GetCPUPriorities()
+	for prio := CPUPriority(0); prio < NumCPUPriorities; prio++ {
+		if !prios[prio].Equals(expected[prio]) {
+			t.Errorf("expected priority %d CPUs %q after refresh, got %q",
+				prio, expected[prio], prios[prio])
+		}
+	}
+	for _, id := range sys.PackageIDs() {
+		if cpus := ca.topology().pkg[id]; !cpus.Equals(sys.Package(id).CPUSet()) {
+			t.Errorf("expected package #%d CPUs %q after refresh, got %q",
+				id, sys.Package(id).CPUSet(), cpus)
+		}
+	}
+
+	from := sys.CPUSet()
+	if _, err := ca.AllocateCpus(&from, 4); err != nil {
+		t.Errorf("unexpected allocation error after refresh: %v", err)
+	}
+}
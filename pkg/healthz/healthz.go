@@ -28,6 +28,9 @@ var (
 	lock     sync.Mutex
 	checkers = map[string]CheckFn{}
 	sorted   []string
+	// readiness checkers
+	rcheckers = map[string]CheckFn{}
+	rsorted   []string
 	// our logger instance
 	log = logger.NewLogger("health-check")
 )
@@ -49,9 +52,25 @@ func Setup(mux *xhttp.ServeMux) {
 	mux.HandleFunc("/healthz", serve)
 }
 
-// serve serves a single HTTP request.
+// SetupReadiness prepares the given HTTP request multiplexer for serving readyz.
+func SetupReadiness(mux *xhttp.ServeMux) {
+	mux.HandleFunc("/readyz", serveReady)
+}
+
+// serve serves a single healthz HTTP request.
 func serve(w http.ResponseWriter, req *http.Request) {
 	status, details := check()
+	reply(w, status, details)
+}
+
+// serveReady serves a single readyz HTTP request.
+func serveReady(w http.ResponseWriter, req *http.Request) {
+	status, details := checkReady()
+	reply(w, status, details)
+}
+
+// reply writes a reply with the given status and details.
+func reply(w http.ResponseWriter, status Status, details map[string]error) {
 	if status == Healthy {
 		w.WriteHeader(200)
 		_, err := w.Write([]byte("ok"))
@@ -85,14 +104,41 @@ func RegisterHealthChecker(name string, fn CheckFn) {
 	sort.Strings(sorted)
 }
 
+// RegisterReadinessChecker registers the given readiness checker function
+func RegisterReadinessChecker(name string, fn CheckFn) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, conflict := rcheckers[name]; conflict {
+		panic(fmt.Sprintf("readiness checker %q already registered", name))
+	}
+
+	rcheckers[name] = fn
+	rsorted = append(rsorted, name)
+	sort.Strings(rsorted)
+}
+
 // check is called (form the HTTP request handler) to perform custom healthcheck
 func check() (Status, map[string]error) {
-	status := Healthy
-	details := map[string]error{}
+	lock.Lock()
+	defer lock.Unlock()
+
+	return runCheckers(checkers, sorted)
+}
 
+// checkReady is called (from the HTTP request handler) to perform readiness checks
+func checkReady() (Status, map[string]error) {
 	lock.Lock()
 	defer lock.Unlock()
 
+	return runCheckers(rcheckers, rsorted)
+}
+
+// runCheckers runs the given checkers in the given order, collecting results.
+func runCheckers(checkers map[string]CheckFn, sorted []string) (Status, map[string]error) {
+	status := Healthy
+	details := map[string]error{}
+
 	for _, name := range sorted {
 		if s, err := checkers[name](); s != Healthy {
 			if s > status {
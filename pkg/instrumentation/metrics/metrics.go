@@ -37,6 +37,9 @@ var (
 	namespace    = "nri"
 	enabled      []string
 	polled       []string
+	maxValues    int
+	dropValues   bool
+	allowValues  []string
 	reportPeriod time.Duration
 	mux          *http.ServeMux
 	gatherer     *metrics.Gatherer
@@ -69,9 +72,15 @@ func WithMetrics(cfg *config.Config) Option {
 		if cfg != nil {
 			enabled = slices.Clone(cfg.Enabled)
 			polled = slices.Clone(cfg.Polled)
+			maxValues = cfg.MaxLabelValues
+			dropValues = cfg.DropHighCardinality
+			allowValues = slices.Clone(cfg.HighCardinalityLabels)
 		} else {
 			enabled = nil
 			polled = nil
+			maxValues = 0
+			dropValues = false
+			allowValues = nil
 		}
 		return nil
 	}
@@ -102,6 +111,7 @@ func Start(m *http.ServeMux, options ...Option) error {
 		metrics.WithNamespace(namespace),
 		metrics.WithPollInterval(reportPeriod),
 		metrics.WithMetrics(enabled, polled),
+		metrics.WithMaxLabelValues(maxValues, dropValues, allowValues...),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create metrics gatherer: %v", err)
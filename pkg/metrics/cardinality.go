@@ -0,0 +1,134 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	model "github.com/prometheus/client_model/go"
+)
+
+type (
+	// cardinalityGuard keeps track of distinct label values of metrics
+	// and reports, optionally drops, series with too many label values.
+	cardinalityGuard struct {
+		limit  int                                       // max. distinct values per label
+		drop   bool                                      // drop series exceeding limit
+		allow  map[string]struct{}                       // labels exempt from limit
+		values map[string]map[string]map[string]struct{} // seen values per metric per label
+		warned map[string]map[string]struct{}            // labels already reported per metric
+	}
+)
+
+func newCardinalityGuard(limit int, drop bool, allow []string) *cardinalityGuard {
+	if limit <= 0 {
+		return nil
+	}
+
+	g := &cardinalityGuard{
+		limit:  limit,
+		drop:   drop,
+		allow:  make(map[string]struct{}),
+		values: make(map[string]map[string]map[string]struct{}),
+		warned: make(map[string]map[string]struct{}),
+	}
+	for _, label := range allow {
+		g.allow[label] = struct{}{}
+	}
+
+	return g
+}
+
+// filter checks label values of gathered metric families. It reports
+// metrics with labels exceeding the limit of distinct values and drops
+// the offending series if configured so.
+func (g *cardinalityGuard) filter(mfs []*model.MetricFamily) []*model.MetricFamily {
+	if g == nil {
+		return mfs
+	}
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		kept := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if g.check(name, m) || !g.drop {
+				kept = append(kept, m)
+			}
+		}
+		mf.Metric = kept
+	}
+
+	filtered := mfs[:0]
+	for _, mf := range mfs {
+		if len(mf.Metric) > 0 {
+			filtered = append(filtered, mf)
+		}
+	}
+
+	return filtered
+}
+
+// check records label values of a single series. It returns false if
+// any label of the series has a new value beyond the limit.
+func (g *cardinalityGuard) check(name string, m *model.Metric) bool {
+	values, ok := g.values[name]
+	if !ok {
+		values = make(map[string]map[string]struct{})
+		g.values[name] = values
+	}
+
+	ok = true
+	for _, lp := range m.GetLabel() {
+		label, value := lp.GetName(), lp.GetValue()
+		if _, allowed := g.allow[label]; allowed {
+			continue
+		}
+
+		seen, found := values[label]
+		if !found {
+			seen = make(map[string]struct{})
+			values[label] = seen
+		}
+		if _, found = seen[value]; found {
+			continue
+		}
+		if len(seen) < g.limit {
+			seen[value] = struct{}{}
+			continue
+		}
+
+		ok = false
+		g.warn(name, label)
+	}
+
+	return ok
+}
+
+func (g *cardinalityGuard) warn(name, label string) {
+	warned, ok := g.warned[name]
+	if !ok {
+		warned = make(map[string]struct{})
+		g.warned[name] = warned
+	}
+	if _, ok = warned[label]; ok {
+		return
+	}
+	warned[label] = struct{}{}
+
+	action := "reporting"
+	if g.drop {
+		action = "dropping"
+	}
+	log.Warnf("metric %s: label %q has more than %d distinct values, %s new series",
+		name, label, g.limit, action)
+}
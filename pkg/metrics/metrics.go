@@ -521,6 +521,10 @@ type (
 		lock         sync.Mutex
 		enabled      []string
 		polled       []string
+		maxValues    int
+		dropValues   bool
+		allowValues  []string
+		guard        *cardinalityGuard
 	}
 
 	// GathererOption is an option for the gatherer.
@@ -568,6 +572,18 @@ func WithMetrics(enabled, polled []string) GathererOption {
 	}
 }
 
+// WithMaxLabelValues limits the number of distinct values of any label of
+// a metric. Metrics with labels exceeding the limit are reported in the
+// log. If drop is true, series with new values of such labels are dropped.
+// Labels in allow are exempt from the limit. A limit of 0 disables checks.
+func WithMaxLabelValues(limit int, drop bool, allow ...string) GathererOption {
+	return func(g *Gatherer) {
+		g.maxValues = limit
+		g.dropValues = drop
+		g.allowValues = allow
+	}
+}
+
 // NewGatherer creates a new gatherer for the registry, with the given options.
 func (r *Registry) NewGatherer(opts ...GathererOption) (*Gatherer, error) {
 	g := &Gatherer{
@@ -584,6 +600,8 @@ func (r *Registry) NewGatherer(opts ...GathererOption) (*Gatherer, error) {
 		return nil, err
 	}
 
+	g.guard = newCardinalityGuard(g.maxValues, g.dropValues, g.allowValues)
+
 	nsg := prefixedRegisterer(g.namespace, g.Registry)
 
 	for _, grp := range r.groups {
@@ -607,7 +625,7 @@ func (g *Gatherer) Gather() ([]*model.MetricFamily, error) {
 		return nil, err
 	}
 
-	return mfs, nil
+	return g.guard.filter(mfs), nil
 }
 
 // Block the gatherer from polling collectors.
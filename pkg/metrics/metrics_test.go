@@ -425,3 +425,59 @@ func (srv *testServer) collect(t *testing.T) (described, collected) {
 
 	return described(types), collected(metrics)
 }
+
+func TestMaxLabelValues(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		drop          bool
+		allow         []string
+		expectedCount int
+	}{
+		{
+			name:          "report only",
+			expectedCount: 5,
+		},
+		{
+			name:          "drop new series",
+			drop:          true,
+			expectedCount: 2,
+		},
+		{
+			name:          "drop, allowed label",
+			drop:          true,
+			allow:         []string{"pod"},
+			expectedCount: 5,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := metrics.NewRegistry()
+			gv := prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "labeled",
+					Help: "Gauge with a high cardinality label.",
+				},
+				[]string{"pod"},
+			)
+			require.Nil(t, r.Register("labeled", gv), "register gauge")
+			for i := 0; i < 5; i++ {
+				gv.WithLabelValues(fmt.Sprintf("pod%d", i)).Set(float64(i))
+			}
+
+			g, err := r.NewGatherer(
+				metrics.WithMetrics([]string{"*"}, nil),
+				metrics.WithoutPolling(),
+				metrics.WithMaxLabelValues(2, tc.drop, tc.allow...),
+			)
+			require.Nil(t, err, "gatherer creation error")
+			defer g.Stop()
+
+			mfs, err := g.Gather()
+			require.Nil(t, err, "gather error")
+			count := 0
+			for _, mf := range mfs {
+				count += len(mf.GetMetric())
+			}
+			require.Equal(t, tc.expectedCount, count, "gathered series")
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Backend persists the serialized state of the cache, including policy
+// data stored in the cache, so that it can be restored on restart.
+//
+// The cache serializes its state into a versioned snapshot before passing
+// it to the backend. Backends store snapshots as opaque data and do not
+// need to understand their format.
+type Backend interface {
+	// Name returns a name of the backend for logging.
+	Name() string
+	// Store stores the given snapshot, replacing any earlier one.
+	Store(data []byte) error
+	// Load returns the last stored snapshot, or nil if there is none.
+	Load() ([]byte, error)
+}
+
+// fileBackend stores snapshots in a local file.
+type fileBackend struct {
+	path string
+}
+
+// NewFileBackend returns a backend which stores snapshots in the given
+// file. Snapshots are written atomically, so a crash never leaves a
+// partially written snapshot behind.
+func NewFileBackend(path string) Backend {
+	return &fileBackend{
+		path: path,
+	}
+}
+
+func (b *fileBackend) Name() string {
+	return "file " + b.path
+}
+
+func (b *fileBackend) Store(data []byte) error {
+	tmpPath := b.path + ".saving"
+	if err := os.WriteFile(tmpPath, data, cacheFilePerm.prefer); err != nil {
+		return cacheError("failed to write cache to file %q: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return cacheError("failed to rename %q to %q: %v", tmpPath, b.path, err)
+	}
+	return nil
+}
+
+func (b *fileBackend) Load() ([]byte, error) {
+	data, err := os.ReadFile(b.path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, cacheError("failed to load cache from file '%s': %v", b.path, err)
+	}
+	return data, nil
+}
+
+// snapshotMigration converts a snapshot from one version to the next.
+type snapshotMigration struct {
+	to      string
+	migrate func(data []byte) ([]byte, error)
+}
+
+// snapshotMigrations are the known snapshot conversions, keyed by the
+// version they convert from. When the snapshot format changes, bump
+// CacheVersion and add a migration from the previous version here.
+var snapshotMigrations = map[string]snapshotMigration{}
+
+// migrateSnapshot converts a snapshot to the running CacheVersion.
+func migrateSnapshot(data []byte) ([]byte, error) {
+	v := struct{ Version string }{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, cacheError("failed to unmarshal snapshot version: %v", err)
+	}
+
+	for version := v.Version; version != CacheVersion; {
+		m, ok := snapshotMigrations[version]
+		if !ok {
+			return nil, cacheError("can't restore snapshot, version '%s' != running version %s",
+				version, CacheVersion)
+		}
+
+		log.Info("migrating cache snapshot from version %s to %s...", version, m.to)
+
+		migrated, err := m.migrate(data)
+		if err != nil {
+			return nil, cacheError("failed to migrate snapshot from version %s to %s: %v",
+				version, m.to, err)
+		}
+		data, version = migrated, m.to
+	}
+
+	return data, nil
+}
@@ -261,6 +261,8 @@ type Container interface {
 	SetMemoryLimit(int64)
 	// SetMemorySwap sets the swap limit in bytes for the container.
 	SetMemorySwap(int64)
+	// SetUnified sets a cgroup v2 unified resource of the container.
+	SetUnified(key, value string)
 
 	// GetCPUShares gets the CFS CPU shares of the container.
 	GetCPUShares() int64
@@ -458,7 +460,7 @@ var (
 // Our cache of objects.
 type cache struct {
 	sync.Mutex `json:"-"` // we're lockable
-	filePath   string     // where to store to/load from
+	backend    Backend    // where to store to/load from
 	dataDir    string     // container data directory
 
 	Pods       map[string]*pod       // known/cached pods
@@ -481,12 +483,15 @@ var _ Cache = &cache{}
 type Options struct {
 	// CacheDir is the directory the cache should save its state in.
 	CacheDir string
+	// Backend persists the state of the cache. If nil, the state is
+	// stored in a file in CacheDir.
+	Backend Backend
 }
 
 // NewCache instantiates a new cache. Load it from the given path if it exists.
 func NewCache(options Options) (Cache, error) {
 	cch := &cache{
-		filePath:   filepath.Join(options.CacheDir, "cache"),
+		backend:    options.Backend,
 		dataDir:    filepath.Join(options.CacheDir, "containers"),
 		Pods:       make(map[string]*pod),
 		Containers: make(map[string]*container),
@@ -496,8 +501,12 @@ func NewCache(options Options) (Cache, error) {
 		implicit:   make(map[string]ImplicitAffinity),
 	}
 
-	if _, err := cch.checkPerm("cache", cch.filePath, false, cacheFilePerm); err != nil {
-		return nil, cacheError("refusing to use existing cache file: %v", err)
+	if cch.backend == nil {
+		filePath := filepath.Join(options.CacheDir, "cache")
+		if _, err := cch.checkPerm("cache", filePath, false, cacheFilePerm); err != nil {
+			return nil, cacheError("refusing to use existing cache file: %v", err)
+		}
+		cch.backend = NewFileBackend(filePath)
 	}
 	if err := cch.mkdirAll("cache", options.CacheDir, cacheDirPerm); err != nil {
 		return nil, err
@@ -1088,6 +1097,11 @@ func (cch *cache) Restore(data []byte) error {
 		PolicyJSON: make(map[string]string),
 	}
 
+	data, err := migrateSnapshot(data)
+	if err != nil {
+		return err
+	}
+
 	if err := json.Unmarshal(data, &s); err != nil {
 		return cacheError("failed to unmarshal snapshot data: %v", err)
 	}
@@ -1117,40 +1131,27 @@ func (cch *cache) Restore(data []byte) error {
 
 // Save the state of the cache.
 func (cch *cache) Save() error {
-	log.Debug("saving cache to file '%s'...", cch.filePath)
+	log.Debug("saving cache to %s...", cch.backend.Name())
 
 	data, err := cch.Snapshot()
 	if err != nil {
 		return cacheError("failed to save cache: %v", err)
 	}
 
-	tmpPath := cch.filePath + ".saving"
-	if err = os.WriteFile(tmpPath, data, cacheFilePerm.prefer); err != nil {
-		return cacheError("failed to write cache to file %q: %v", tmpPath, err)
-	}
-	if err := os.Rename(tmpPath, cch.filePath); err != nil {
-		return cacheError("failed to rename %q to %q: %v",
-			tmpPath, cch.filePath, err)
-	}
-
-	return nil
+	return cch.backend.Store(data)
 }
 
 // Load loads the last saved state of the cache.
 func (cch *cache) Load() error {
-	log.Debug("loading cache from file '%s'...", cch.filePath)
-
-	data, err := os.ReadFile(cch.filePath)
+	log.Debug("loading cache from %s...", cch.backend.Name())
 
+	data, err := cch.backend.Load()
 	switch {
-	case os.IsNotExist(err):
-		log.Debug("no cache file '%s', nothing to restore", cch.filePath)
-		return nil
+	case err != nil:
+		return err
 	case len(data) == 0:
-		log.Debug("empty cache file '%s', nothing to restore", cch.filePath)
+		log.Debug("no cache in %s, nothing to restore", cch.backend.Name())
 		return nil
-	case err != nil:
-		return cacheError("failed to load cache from file '%s': %v", cch.filePath, err)
 	}
 
 	return cch.Restore(data)
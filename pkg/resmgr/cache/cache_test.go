@@ -87,6 +87,71 @@ var _ = Describe("Cache", func() {
 	})
 })
 
+var _ = Describe("Cache backend", func() {
+	It("persists state in the default file backend", func() {
+		var (
+			dir    = GinkgoT().TempDir()
+			nriPod = makePod()
+		)
+
+		c, err := cache.NewCache(cache.Options{CacheDir: dir})
+		Expect(err).To(BeNil())
+		Expect(c.InsertPod(nriPod, nil)).ToNot(BeNil())
+
+		restored, err := cache.NewCache(cache.Options{CacheDir: dir})
+		Expect(err).To(BeNil())
+		_, ok := restored.LookupPod(nriPod.GetId())
+		Expect(ok).To(BeTrue())
+	})
+
+	It("persists state in a custom backend", func() {
+		var (
+			backend = &memBackend{}
+			nriPod  = makePod()
+		)
+
+		c, err := cache.NewCache(cache.Options{CacheDir: GinkgoT().TempDir(), Backend: backend})
+		Expect(err).To(BeNil())
+		Expect(c.InsertPod(nriPod, nil)).ToNot(BeNil())
+		Expect(c.SetActivePolicy("test")).To(Succeed())
+		Expect(backend.data).ToNot(BeEmpty())
+
+		restored, err := cache.NewCache(cache.Options{CacheDir: GinkgoT().TempDir(), Backend: backend})
+		Expect(err).To(BeNil())
+		_, ok := restored.LookupPod(nriPod.GetId())
+		Expect(ok).To(BeTrue())
+		Expect(restored.GetActivePolicy()).To(Equal("test"))
+	})
+
+	It("refuses to restore snapshots of unknown versions", func() {
+		backend := &memBackend{
+			data: []byte(`{"Version":"999"}`),
+		}
+
+		c, err := cache.NewCache(cache.Options{CacheDir: GinkgoT().TempDir(), Backend: backend})
+		Expect(c).To(BeNil())
+		Expect(err).To(MatchError(ContainSubstring("version '999'")))
+	})
+})
+
+// memBackend stores cache snapshots in memory.
+type memBackend struct {
+	data []byte
+}
+
+func (b *memBackend) Name() string {
+	return "memory"
+}
+
+func (b *memBackend) Store(data []byte) error {
+	b.data = data
+	return nil
+}
+
+func (b *memBackend) Load() ([]byte, error) {
+	return b.data, nil
+}
+
 func makeCache() cache.Cache {
 	c, err := cache.NewCache(cache.Options{CacheDir: GinkgoT().TempDir()})
 	Expect(c).ToNot(BeNil())
@@ -763,6 +763,26 @@ func (c *container) SetMemorySwap(value int64) {
 	c.Ctr.Linux.Resources.Memory.Swap = nri.Int64(value)
 }
 
+func (c *container) SetUnified(key, value string) {
+	switch req := c.getPendingRequest().(type) {
+	case *nri.ContainerAdjustment:
+		req.AddLinuxUnified(key, value)
+	case *nri.ContainerUpdate:
+		req.AddLinuxUnified(key, value)
+	default:
+		log.Error("%s: can't set unified resource %s (%s): incorrect pending request type %T",
+			c.PrettyName(), key, value, c.request)
+		return
+	}
+	c.markPending(NRI)
+
+	c.ensureLinuxResources()
+	if c.Ctr.Linux.Resources.Unified == nil {
+		c.Ctr.Linux.Resources.Unified = map[string]string{}
+	}
+	c.Ctr.Linux.Resources.Unified[key] = value
+}
+
 func (c *container) GetCPUShares() int64 {
 	return int64(c.Ctr.GetLinux().GetResources().GetCpu().GetShares().GetValue())
 }
@@ -24,6 +24,14 @@ func GetClasses() map[string]Class {
 	return getCPUController().getClasses()
 }
 
+// SetPolicyClasses sets CPU classes defined by the active policy. These
+// are available in addition to the configured classes, which take
+// precedence over policy classes with the same name. CPUs assigned to
+// classes are reconfigured according to the new set of classes.
+func SetPolicyClasses(c cache.Cache, classes map[string]PolicyClass) error {
+	return getCPUController().setPolicyClasses(c, classes)
+}
+
 // Assign assigns a set of cpus to a class.
 //
 // TODO: Drop this function. Don't store cpu class in policy data but implement
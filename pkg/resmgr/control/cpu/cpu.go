@@ -38,15 +38,24 @@ const (
 
 // cpuctl encapsulates the runtime state of our CPU enforcement/controller.
 type cpuctl struct {
-	cache         cache.Cache      // resource manager cache
-	system        sysfs.System     // system topology
-	classes       map[string]Class // configured CPU classes
-	uncoreEnabled bool             // whether we need to care about uncore
+	cache         cache.Cache            // resource manager cache
+	system        sysfs.System           // system topology
+	classes       map[string]Class       // configured CPU classes
+	policyClasses map[string]PolicyClass // CPU classes defined by the policy
+	uncoreEnabled bool                   // whether we need to care about uncore
 	started       bool
+	governors     map[int]string // original governors of CPUs we changed
 }
 
 type Class = cfgcpu.Class
 
+// PolicyClass is a CPU class defined by a policy. Settings of the class
+// override the settings of its configured base class, if any.
+type PolicyClass struct {
+	Base  string
+	Class Class
+}
+
 var log logger.Logger = logger.NewLogger(CPUController)
 
 // Ccontroller singleton instance.
@@ -67,19 +76,15 @@ func isEmptyConfig(cfg *cfgapi.Config) bool {
 
 // Start initializes the controller for enforcing decisions.
 func (ctl *cpuctl) Start(cache cache.Cache, cfg *cfgapi.Config) (bool, error) {
-	if isEmptyConfig(cfg) {
+	if isEmptyConfig(cfg) && len(ctl.policyClasses) == 0 {
 		log.Info("empty configuration, disabling controller")
 		return false, nil
 	}
 
-	sys, err := sysfs.DiscoverSystem()
-	if err != nil {
-		return false, fmt.Errorf("failed to discover system topology: %w", err)
+	if err := ctl.discover(cache); err != nil {
+		return false, err
 	}
 
-	ctl.system = sys
-	ctl.cache = cache
-
 	// DEBUG: dump the class assignments we have stored in the cache
 	log.Debug("retrieved cpu class assignments from cache:\n%s", utils.DumpJSON(getClassAssignments(ctl.cache)))
 
@@ -93,6 +98,19 @@ func (ctl *cpuctl) Start(cache cache.Cache, cfg *cfgapi.Config) (bool, error) {
 	return true, nil
 }
 
+// discover discovers the system topology, unless it is already known.
+func (ctl *cpuctl) discover(cache cache.Cache) error {
+	if ctl.system == nil {
+		sys, err := sysfs.DiscoverSystem()
+		if err != nil {
+			return fmt.Errorf("failed to discover system topology: %w", err)
+		}
+		ctl.system = sys
+	}
+	ctl.cache = cache
+	return nil
+}
+
 // Stop shuts down the controller.
 func (ctl *cpuctl) Stop() {
 }
@@ -124,7 +142,7 @@ func (ctl *cpuctl) PostStopHook(c cache.Container) error {
 
 // enforceCpufreq enforces a class-specific cpufreq configuration to a cpuset
 func (ctl *cpuctl) enforceCpufreq(class string, cpus ...int) error {
-	c, ok := ctl.classes[class]
+	c, ok := ctl.lookupClass(class)
 	if !ok {
 		return fmt.Errorf("non-existent cpu class %q", class)
 	}
@@ -145,14 +163,53 @@ func (ctl *cpuctl) enforceCpufreq(class string, cpus ...int) error {
 
 	if governor := c.FreqGovernor; governor != "" {
 		log.Debug("enforcing cpu frequency governor %q from class %q on %v", governor, class, cpus)
-		if err := utils.SetScalingGovernorForCPUs(cpus, governor); err != nil {
+		if err := ctl.setGovernor(governor, cpus...); err != nil {
 			return fmt.Errorf("cannot set cpufreq governor %q: %w", governor, err)
 		}
+	} else if err := ctl.restoreGovernors(cpus...); err != nil {
+		return fmt.Errorf("cannot restore cpufreq governors: %w", err)
 	}
 
 	return nil
 }
 
+// setGovernor sets the cpufreq governor of CPUs, saving their original
+// governor for restoring it once the CPUs are assigned to a class without
+// a governor.
+func (ctl *cpuctl) setGovernor(governor string, cpus ...int) error {
+	if ctl.governors == nil {
+		ctl.governors = map[int]string{}
+	}
+	known := utils.NewIDSet(ctl.system.CPUIDs()...)
+	for _, id := range cpus {
+		if _, ok := ctl.governors[id]; ok || !known.Has(id) {
+			continue
+		}
+		orig, err := ctl.system.CPU(id).ScalingGovernor()
+		if err != nil {
+			return err
+		}
+		ctl.governors[id] = orig
+	}
+	return ctl.system.SetScalingGovernor(governor, utils.NewIDSet(cpus...))
+}
+
+// restoreGovernors restores the original cpufreq governor of CPUs.
+func (ctl *cpuctl) restoreGovernors(cpus ...int) error {
+	for _, id := range cpus {
+		orig, ok := ctl.governors[id]
+		if !ok {
+			continue
+		}
+		log.Debug("restoring cpu frequency governor %q on cpu %d", orig, id)
+		if err := ctl.system.SetScalingGovernor(orig, utils.NewIDSet(id)); err != nil {
+			return err
+		}
+		delete(ctl.governors, id)
+	}
+	return nil
+}
+
 // enforceUncore enforces uncore frequency limits
 func (ctl *cpuctl) enforceUncore(assignments cpuClassAssignments, affectedCPUs ...int) error {
 	if !ctl.uncoreEnabled {
@@ -168,7 +225,7 @@ func (ctl *cpuctl) enforceUncore(assignments cpuClassAssignments, affectedCPUs .
 
 			// Check if this die is affected by the specified cpuset
 			if cpus.Size() == 0 || dieCPUs.Intersection(cpus).Size() > 0 {
-				min, max, minCls, maxCls := effectiveUncoreFreqs(utils.NewIDSet(dieCPUs.List()...), ctl.classes, assignments)
+				min, max, minCls, maxCls := effectiveUncoreFreqs(utils.NewIDSet(dieCPUs.List()...), ctl.getClasses(), assignments)
 
 				if min == 0 && max == 0 {
 					log.Debug("no uncore frequency limits for cpu package/die %d/%d", cpuPkgID, cpuDieID)
@@ -238,21 +295,48 @@ func idSetIntersects(a, b utils.IDSet) bool {
 
 func (ctl *cpuctl) configure(cfg *cfgapi.Config) error {
 	ctl.classes = nil
-	ctl.uncoreEnabled = false
 
 	if cfg != nil && cfg.CPU != nil {
 		ctl.classes = cfg.CPU.Classes
 	}
 
+	return ctl.enforce()
+}
+
+// setPolicyClasses sets the CPU classes defined by the policy and
+// re-enforces class assignments. The controller is started for policy
+// classes even if the configuration has no classes.
+func (ctl *cpuctl) setPolicyClasses(cache cache.Cache, classes map[string]PolicyClass) error {
+	ctl.policyClasses = classes
+
+	if !ctl.started {
+		if len(classes) == 0 {
+			return nil
+		}
+		if err := ctl.discover(cache); err != nil {
+			return err
+		}
+		ctl.started = true
+	}
+
+	return ctl.enforce()
+}
+
+// enforce enforces all classes on the CPUs assigned to them.
+func (ctl *cpuctl) enforce() error {
+	ctl.uncoreEnabled = false
+
+	classes := ctl.getClasses()
+
 	// Re-configure CPUs that are assigned to some known class
 	assignments := *getClassAssignments(ctl.cache)
 
 	// DEBUG: dump the class assignments we have stored in the cache
-	log.Debug("applying cpu controller configuration:\n%s", utils.DumpJSON(ctl.classes))
+	log.Debug("applying cpu controller configuration:\n%s", utils.DumpJSON(classes))
 
 	// Sanity check
 	uncoreAvailable := utils.UncoreFreqAvailable()
-	for name, conf := range ctl.classes {
+	for name, conf := range classes {
 		if conf.UncoreMinFreq != 0 || conf.UncoreMaxFreq != 0 {
 			if !uncoreAvailable {
 				return fmt.Errorf("uncore limits set in cpu class %q but uncore driver not available in the system, make sure that the intel_uncore_frequency driver is loaded", name)
@@ -264,7 +348,7 @@ func (ctl *cpuctl) configure(cfg *cfgapi.Config) error {
 
 	// Configure the system
 	for class, cpus := range assignments {
-		if _, ok := ctl.classes[class]; ok {
+		if _, ok := classes[class]; ok {
 			// Re-configure cpus (sysfs) according to new class parameters
 			if err := ctl.enforceCpufreq(class, cpus.SortedMembers()...); err != nil {
 				log.Error("cpufreq enforcement on re-configure failed: %v", err)
@@ -286,8 +370,48 @@ func (ctl *cpuctl) configure(cfg *cfgapi.Config) error {
 	return nil
 }
 
+// lookupClass looks up a configured or policy-defined class. Configured
+// classes take precedence over policy-defined ones with the same name.
+func (ctl *cpuctl) lookupClass(name string) (Class, bool) {
+	if c, ok := ctl.classes[name]; ok {
+		return c, true
+	}
+	pc, ok := ctl.policyClasses[name]
+	if !ok {
+		return Class{}, false
+	}
+	return ctl.resolvePolicyClass(pc), true
+}
+
+// resolvePolicyClass overlays the settings of a policy class on its base.
+func (ctl *cpuctl) resolvePolicyClass(pc PolicyClass) Class {
+	c := ctl.classes[pc.Base]
+	if pc.Class.MinFreq > 0 {
+		c.MinFreq = pc.Class.MinFreq
+	}
+	if pc.Class.MaxFreq > 0 {
+		c.MaxFreq = pc.Class.MaxFreq
+	}
+	if pc.Class.EnergyPerformancePreference > 0 {
+		c.EnergyPerformancePreference = pc.Class.EnergyPerformancePreference
+	}
+	if pc.Class.UncoreMinFreq > 0 {
+		c.UncoreMinFreq = pc.Class.UncoreMinFreq
+	}
+	if pc.Class.UncoreMaxFreq > 0 {
+		c.UncoreMaxFreq = pc.Class.UncoreMaxFreq
+	}
+	if pc.Class.FreqGovernor != "" {
+		c.FreqGovernor = pc.Class.FreqGovernor
+	}
+	return c
+}
+
 func (ctl *cpuctl) getClasses() map[string]Class {
-	ret := make(map[string]Class, len(ctl.classes))
+	ret := make(map[string]Class, len(ctl.classes)+len(ctl.policyClasses))
+	for k, v := range ctl.policyClasses {
+		ret[k] = ctl.resolvePolicyClass(v)
+	}
 	for k, v := range ctl.classes {
 		ret[k] = v
 	}
@@ -16,6 +16,7 @@ package resmgr
 
 import (
 	logger "github.com/containers/nri-plugins/pkg/log"
+	"github.com/containers/nri-plugins/pkg/resmgr/events"
 )
 
 // Our logger instance for events.
@@ -67,9 +68,29 @@ func (m *resmgr) processEvent(e interface{}) {
 	switch event := e.(type) {
 	case string:
 		evtlog.Debug("'%s'...", event)
-		//case *events.Policy:
-		//m.DeliverPolicyEvent(event)
+	case *events.Policy:
+		m.deliverPolicyEvent(event)
 	default:
 		evtlog.Warn("event of unexpected type %T...", e)
 	}
 }
+
+// deliverPolicyEvent delivers a policy-specific event to the active policy.
+func (m *resmgr) deliverPolicyEvent(e *events.Policy) {
+	m.Lock()
+	defer m.Unlock()
+
+	evtlog.Debug("delivering policy event %s from %s...", e.Type, e.Source)
+
+	changed, err := m.policy.HandleEvent(e)
+	if err != nil {
+		evtlog.Error("policy failed to handle event %s: %v", e.Type, err)
+	}
+	if !changed {
+		return
+	}
+
+	if err := m.nri.updateContainers(); err != nil {
+		evtlog.Error("failed to update containers after event %s: %v", e.Type, err)
+	}
+}
@@ -36,6 +36,7 @@ type options struct {
 	NriPluginName string
 	NriPluginIdx  string
 	NriSocket     string
+	HealthAddress string
 }
 
 // ResourceManager command line options.
@@ -57,6 +58,8 @@ func init() {
 	flag.DurationVar(&opt.MetricsTimer, "metrics-interval", 0,
 		"Obsolete way to set interval for polling/gathering runtime metrics data.\n"+
 			"Use the instrumentation section of the CR-based configuration interface instead.")
+	flag.StringVar(&opt.HealthAddress, "health-address", "",
+		"Address to serve /healthz and /readyz probes on, empty to disable.")
 	flag.StringVar(&opt.StateDir, "state-dir", "/var/lib/nri-resource-policy",
 		"Permanent storage directory path for the resource manager to store its state in.")
 }
@@ -20,6 +20,7 @@ import (
 	"math"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/containers/nri-plugins/pkg/sysfs"
 	"github.com/containers/nri-plugins/pkg/utils/cpuset"
@@ -29,6 +30,7 @@ import (
 // Allocator implements a topology aware but largely policy agnostic scheme
 // for memory accounting and allocation.
 type Allocator struct {
+	lock     sync.Mutex // serializes allocator operations
 	nodes    map[ID]*Node
 	requests map[string]*Request
 	zones    map[NodeMask]*Zone
@@ -37,12 +39,21 @@ type Allocator struct {
 	version  int64
 	journal  *journal
 	custom   CustomFunctions
+	headroom float64          // fraction of node capacity kept unallocated
+	reserve  int64            // amount of node capacity kept unallocated
+	reserved NodeMask         // nodes reserved for requests with access to them
+	moves    int64            // allocations moved to resolve overcommit
+	weights  map[Type]float64 // per memory type distance weights
+	dies     map[Die]NodeMask // nodes of each CPU die
+
+	reservations map[string]string // names of reservations by request ID
 }
 
 // Journal records reversible changes to an allocator.
 type journal struct {
 	updates map[string]NodeMask
 	reverts map[string]NodeMask
+	moves   int64 // allocations moved to resolve overcommit
 }
 
 // Offer represents a possible memory allocation for a request. Valid offers
@@ -53,6 +64,7 @@ type Offer struct {
 	version int64
 	req     *Request
 	updates map[string]NodeMask
+	moves   int64
 }
 
 type (
@@ -60,6 +72,12 @@ type (
 	ID = idset.ID
 )
 
+// Die identifies a CPU die by its package and die ID.
+type Die struct {
+	Package ID
+	ID      ID
+}
+
 const (
 	// ForeachDone as a return value terminates iteration by a Foreach* function.
 	ForeachDone = false
@@ -99,8 +117,41 @@ func WithSystemNodes(sys sysfs.System) AllocatorOption {
 			nodes = append(nodes, n)
 		}
 
-		return WithNodes(nodes)(a)
+		if err := WithNodes(nodes)(a); err != nil {
+			return err
+		}
+
+		return WithDieNodes(systemDieNodes(sys))(a)
+	}
+}
+
+// systemDieNodes returns the memory nodes of each die in the system. Nodes
+// without CPUs belong to a die if all their initiator CPUs are in the die.
+func systemDieNodes(sys sysfs.System) map[Die]NodeMask {
+	dies := map[Die]NodeMask{}
+
+	for _, pkgID := range sys.PackageIDs() {
+		pkg := sys.Package(pkgID)
+		for _, dieID := range pkg.DieIDs() {
+			nodes := NewNodeMask(pkg.DieNodeIDs(dieID)...)
+			dieCPUs := pkg.DieCPUSet(dieID)
+			for _, id := range sys.NodeIDs() {
+				sysNode := sys.Node(id)
+				if !sysNode.CPUSet().IsEmpty() {
+					continue
+				}
+				initiators := sysNode.InitiatorCPUs()
+				if !initiators.IsEmpty() && initiators.IsSubsetOf(dieCPUs) {
+					nodes |= NewNodeMask(id)
+				}
+			}
+			if nodes != 0 {
+				dies[Die{Package: pkgID, ID: dieID}] = nodes
+			}
+		}
 	}
+
+	return dies
 }
 
 // WithNodes is an option to assign the given memory nodes to an allocator.
@@ -121,6 +172,81 @@ func WithNodes(nodes []*Node) AllocatorOption {
 	}
 }
 
+// WithDieNodes is an option to assign memory nodes to CPU dies. With
+// multiple nodes per die, for instance with sub-NUMA clustering, die
+// affinity can be expressed as a multi-node mask using DieNodes, and
+// zone expansion prefers nodes within the same die over others at the
+// same distance. WithSystemNodes sets up dies automatically.
+func WithDieNodes(dies map[Die]NodeMask) AllocatorOption {
+	return func(a *Allocator) error {
+		for die, nodes := range dies {
+			if nodes == 0 {
+				return fmt.Errorf("no nodes for die #%d/%d", die.Package, die.ID)
+			}
+		}
+		a.dies = maps.Clone(dies)
+		return nil
+	}
+}
+
+// WithNodeHeadroom is an option to keep the given fraction of the memory
+// of each node unallocated. The remaining effective node capacity is used
+// in all capacity, free memory and overcommit calculations.
+func WithNodeHeadroom(fraction float64) AllocatorOption {
+	return func(a *Allocator) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("invalid node headroom %v, must be in [0, 1)", fraction)
+		}
+		a.headroom = fraction
+		return nil
+	}
+}
+
+// WithNodeReserve is an option to keep the given amount of the memory of
+// each node unallocated. It can be combined with WithNodeHeadroom, in which
+// case both the fraction and the amount are kept unallocated.
+func WithNodeReserve(amount int64) AllocatorOption {
+	return func(a *Allocator) error {
+		if amount < 0 {
+			return fmt.Errorf("invalid node reserve %d", amount)
+		}
+		a.reserve = amount
+		return nil
+	}
+}
+
+// WithReservedNodes is an option to reserve the given nodes for requests
+// with access to reserved nodes. Other requests never use these nodes.
+// This can be used to protect the working set of system daemons from
+// ordinary workloads.
+func WithReservedNodes(nodes NodeMask) AllocatorOption {
+	return func(a *Allocator) error {
+		a.reserved = nodes
+		return nil
+	}
+}
+
+// WithTypeDistanceWeights is an option to scale node distances by memory
+// type during zone expansion. The distance between two nodes is scaled by
+// the larger weight of their types, so that for instance with a weight of
+// 2.0 for HBM going to or from a remote HBM node costs twice as much as
+// going to a remote DRAM node at the same raw distance. Types without a
+// weight use a weight of 1.0, which reproduces raw distances.
+func WithTypeDistanceWeights(weights map[Type]float64) AllocatorOption {
+	return func(a *Allocator) error {
+		for t, w := range weights {
+			if _, ok := typeToString[t]; !ok {
+				return fmt.Errorf("%w: distance weight for unknown type %d", ErrInvalidType, t)
+			}
+			if w <= 0 {
+				return fmt.Errorf("invalid distance weight %v for type %s, must be > 0", w, t)
+			}
+		}
+		a.weights = maps.Clone(weights)
+		return nil
+	}
+}
+
 // NewAllocator creates a new allocator instance and configures it with
 // the given options.
 func NewAllocator(options ...AllocatorOption) (*Allocator, error) {
@@ -144,15 +270,104 @@ func (a *Allocator) CPUSetAffinity(cpus cpuset.CPUSet) NodeMask {
 	return nodes
 }
 
+// CPUSetAffinityWithType returns the mask of closest nodes of the given
+// type with free capacity for the given cpuset. If none of the nodes in
+// the affinity of the cpuset qualifies, the closest nodes of the type
+// with free capacity are returned instead. Returns 0 if there are no
+// such nodes.
+func (a *Allocator) CPUSetAffinityWithType(cpus cpuset.CPUSet, t Type) NodeMask {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	zone := a.CPUSetAffinity(cpus)
+	if zone == 0 {
+		return 0
+	}
+
+	nodes := zone & a.masks.nodes.byTypes[t.Mask()]
+	if nodes == 0 {
+		nodes = a.newCloseNodesOfType(zone, t)
+	}
+	for nodes != 0 {
+		if free := a.nodesWithFreeCapacity(nodes); free != 0 {
+			return free
+		}
+		zone |= nodes
+		nodes = a.newCloseNodesOfType(zone, t)
+	}
+
+	return 0
+}
+
+// nodesWithFreeCapacity returns the nodes in the mask with free capacity.
+func (a *Allocator) nodesWithFreeCapacity(nodes NodeMask) NodeMask {
+	free := NodeMask(0)
+	a.ForeachNode(nodes, func(n *Node) bool {
+		if a.zoneAvailable(n.Mask()) > 0 {
+			free |= n.Mask()
+		}
+		return ForeachMore
+	})
+	return free
+}
+
+// DieNodes returns the mask of nodes in the given die of the given
+// package, or 0 if the die is unknown. This mask can be used as the
+// affinity of requests to prefer memory anywhere in the die.
+func (a *Allocator) DieNodes(pkg, die ID) NodeMask {
+	return a.dies[Die{Package: pkg, ID: die}] & a.masks.nodes.all
+}
+
 // AssignedZone returns the assigned nodes for the given allocation and
 // whether such an allocation was found.
 func (a *Allocator) AssignedZone(id string) (NodeMask, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	if zone, ok := a.users[id]; ok {
 		return zone, true
 	}
 	return 0, false
 }
 
+// AllocationInfo describes the current state of an allocation.
+type AllocationInfo struct {
+	ID             string   // ID of the allocation
+	Name           string   // name of the allocation
+	Zone           NodeMask // nodes currently assigned to the allocation
+	Affinity       NodeMask // nodes the allocation was requested from
+	Amount         int64    // amount of memory allocated
+	Types          TypeMask // types of memory requested
+	RankedTypes    []Type   // types of memory requested, in order of preference
+	Priority       Priority // priority of the allocation
+	Oversubscribed bool     // whether the assigned zone is oversubscribed
+}
+
+// AllocationInfo returns information about the given allocation and
+// whether such an allocation was found.
+func (a *Allocator) AllocationInfo(id string) (AllocationInfo, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	req, ok := a.requests[id]
+	if !ok {
+		return AllocationInfo{}, false
+	}
+
+	zone := a.users[id]
+	return AllocationInfo{
+		ID:             req.ID(),
+		Name:           req.Name(),
+		Zone:           zone,
+		Affinity:       req.Affinity(),
+		Amount:         req.Size(),
+		Types:          req.Types(),
+		RankedTypes:    slices.Clone(req.RankedTypes()),
+		Priority:       req.Priority(),
+		Oversubscribed: a.zoneFree(zone) < 0,
+	}, true
+}
+
 // ForeachNode calls the given function with each node present in the mask.
 // It stops iterating early if the function returns false.
 func (a *Allocator) ForeachNode(nodes NodeMask, fn func(*Node) bool) {
@@ -176,6 +391,9 @@ func (a *Allocator) ForeachRequest(req *Request, fn func(*Request) bool) {
 // request. Committing any offer invalidates all other offers. Allocating
 // or releasing memory likewise invalidates all offers.
 func (a *Allocator) GetOffer(req *Request) (*Offer, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	log.Debug("get offer for %s", req)
 	defer a.validateState("GetOffer")
 
@@ -184,12 +402,70 @@ func (a *Allocator) GetOffer(req *Request) (*Offer, error) {
 		return nil, err
 	}
 
+	moves := a.journal.moves
 	updates, err := a.revertJournal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	return a.newOffer(req, updates), nil
+	return a.newOffer(req, updates, moves), nil
+}
+
+// WouldFit checks if the given request could be allocated, without making
+// any changes to the state of the Allocator or invalidating any offers. It
+// returns the nodes Allocate would use for the request, or the reason why
+// the request does not fit. Unlike GetOffer, WouldFit does not return an
+// offer, nor any updates to other allocations needed to fit the request.
+func (a *Allocator) WouldFit(req *Request) (bool, NodeMask, string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	log.Debug("check fit for %s", req)
+	defer a.validateState("WouldFit")
+	defer a.cleanupUnusedZones()
+
+	if err := a.allocate(req); err != nil {
+		return false, 0, err.Error()
+	}
+
+	updates, err := a.revertJournal(req)
+	if err != nil {
+		return false, 0, err.Error()
+	}
+
+	return true, updates[req.ID()], ""
+}
+
+// PreviewMoves checks how the given request would be allocated, without
+// making any changes to the state of the Allocator or invalidating any
+// offers. It returns the nodes Allocate would use for the request and
+// the existing allocations Allocate would move to resolve overcommit,
+// together with their new nodes. A policy can use it to reject requests
+// which would disturb too many existing allocations.
+func (a *Allocator) PreviewMoves(req *Request) (NodeMask, map[string]NodeMask, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	log.Debug("preview moves for %s", req)
+	defer a.validateState("PreviewMoves")
+	defer a.cleanupUnusedZones()
+
+	if err := a.allocate(req); err != nil {
+		return 0, nil, err
+	}
+
+	updates, err := a.revertJournal(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	zone := updates[req.ID()]
+	delete(updates, req.ID())
+	if len(updates) == 0 {
+		updates = nil
+	}
+
+	return zone, updates, nil
 }
 
 // Allocate allocates memory for the given request. It is equivalent to
@@ -198,6 +474,9 @@ func (a *Allocator) GetOffer(req *Request) (*Offer, error) {
 // other existing allocations to fulfill the request. The caller must
 // ensure these updates are properly enforced.
 func (a *Allocator) Allocate(req *Request) (NodeMask, map[string]NodeMask, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	log.Debug("allocate %s memory for %s", req.types, req)
 	defer a.validateState("Allocate")
 	defer a.cleanupUnusedZones()
@@ -210,6 +489,69 @@ func (a *Allocator) Allocate(req *Request) (NodeMask, map[string]NodeMask, error
 	return req.zone, a.commitJournal(req), nil
 }
 
+// AllocateBatch allocates memory for all the given requests, or for none
+// of them. It can be used to place all containers of a pod atomically. If
+// allocating any of the requests fails, all changes made for the batch,
+// including moving existing allocations to resolve overcommit, are rolled
+// back and an error is returned. Otherwise AllocateBatch returns the nodes
+// used to satisfy each request, in the order of the requests, together
+// with any updates made to other existing allocations. The caller must
+// ensure these updates are properly enforced.
+func (a *Allocator) AllocateBatch(reqs []*Request) ([]NodeMask, map[string]NodeMask, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	log.Debug("allocate memory for a batch of %d requests", len(reqs))
+	defer a.validateState("AllocateBatch")
+	defer a.cleanupUnusedZones()
+
+	a.DumpState()
+
+	if err := a.startJournal(); err != nil {
+		return nil, nil, err
+	}
+
+	added := []*Request{}
+	for _, req := range reqs {
+		err := a.prepareRequest(req)
+		if err == nil {
+			added = append(added, req)
+			err = a.assignRequest(req)
+		}
+		if err != nil {
+			a.revertBatch(added)
+			return nil, nil, fmt.Errorf("batch allocation failed for %s: %w", req, err)
+		}
+	}
+
+	j := a.closeJournal()
+	zones := make([]NodeMask, 0, len(reqs))
+	for _, req := range reqs {
+		zones = append(zones, req.zone)
+		delete(j.updates, req.ID())
+	}
+	if len(j.updates) == 0 {
+		j.updates = nil
+	}
+
+	a.invalidateOffers()
+
+	return zones, j.updates, nil
+}
+
+// revertBatch reverts the active journal of a failed batch allocation and
+// forgets the requests added by the batch.
+func (a *Allocator) revertBatch(added []*Request) {
+	if _, err := a.revertJournal(nil); err != nil {
+		log.Warn("failed to revert journal on error: %v", err)
+	}
+	for _, req := range added {
+		delete(a.requests, req.ID())
+		req.zone = 0
+	}
+	a.invalidateOffers()
+}
+
 // Realloc updates an existing allocation with the given extra affinity
 // and memory types. Realloc is semantically either expansive or no-op.
 // In particular, Realloc cannot be used to remove assigned nodes and
@@ -223,6 +565,9 @@ func (a *Allocator) Allocate(req *Request) (NodeMask, map[string]NodeMask, error
 // other existing allocations to fulfill the request. The caller must
 // ensure these updates are properly enforced.
 func (a *Allocator) Realloc(id string, affinity NodeMask, types TypeMask) (NodeMask, map[string]NodeMask, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	log.Debug("reallocate to add %s memory affine to %s for %s", types, affinity, id)
 
 	req, ok := a.requests[id]
@@ -235,8 +580,90 @@ func (a *Allocator) Realloc(id string, affinity NodeMask, types TypeMask) (NodeM
 	return a.realloc(req, affinity, types)
 }
 
+// SetPriority changes the priority of an existing allocation without
+// reallocating it. Subsequent overcommit handling treats the allocation
+// according to its new priority. The priority of memory reservations
+// can't be changed, nor can other allocations be turned into ones.
+// SetPriority invalidates all offers.
+func (a *Allocator) SetPriority(id string, p Priority) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.setPriority(id, p)
+}
+
+func (a *Allocator) setPriority(id string, p Priority) error {
+	req, ok := a.requests[id]
+	if !ok {
+		return fmt.Errorf("%w: no request with ID %s", ErrUnknownRequest, id)
+	}
+
+	if req.priority == Reservation || p == Reservation {
+		return fmt.Errorf("%w: can't change priority of %s to %s", ErrInvalidPriority, req, p)
+	}
+
+	if req.priority == p {
+		return nil
+	}
+
+	log.Debug("change priority of %s to %s", req, p)
+
+	req.priority = p
+	a.invalidateOffers()
+
+	return nil
+}
+
+// SetPriorityAndRebalance changes the priority of an existing allocation
+// like SetPriority, then resolves any overcommitted zones. Lowering the
+// priority of an allocation can let it be moved out of an overcommitted
+// zone. The priority stays changed even if overcommit can't be resolved.
+// SetPriorityAndRebalance returns the updated zones of all affected
+// allocations. The caller must ensure these updates are properly enforced.
+func (a *Allocator) SetPriorityAndRebalance(id string, p Priority) (updates map[string]NodeMask, retErr error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.setPriority(id, p); err != nil {
+		return nil, err
+	}
+
+	defer a.validateState("SetPriorityAndRebalance")
+	defer a.cleanupUnusedZones()
+
+	if err := a.startJournal(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			_, err := a.revertJournal(nil)
+			if err != nil {
+				log.Warn("failed to revert journal on error: %v", err)
+			}
+		}
+	}()
+
+	if err := a.handleOvercommit(a.masks.nodes.all); err != nil {
+		return nil, fmt.Errorf("%w: failed to rebalance: %w", ErrNoMem, err)
+	}
+
+	j := a.closeJournal()
+
+	if len(j.updates) == 0 {
+		return nil, nil
+	}
+
+	a.invalidateOffers()
+
+	return j.updates, nil
+}
+
 // Release releases the allocation with the given ID.
 func (a *Allocator) Release(id string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	req, ok := a.requests[id]
 	if !ok {
 		return fmt.Errorf("%w: no request with ID %s", ErrUnknownRequest, id)
@@ -251,12 +678,244 @@ func (a *Allocator) Release(id string) error {
 }
 
 // Reset resets the state of the allocator, releasing all allocations
-// and invalidating all offers.
+// and invalidating all offers. Nodes and reserved nodes are kept, and
+// subsequent allocations behave as on a newly created allocator.
 func (a *Allocator) Reset() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	log.Debug("reset allocations")
 	a.reset()
 }
 
+// ReleaseAll releases all allocations like Reset. It returns the nodes
+// of all released allocations by ID.
+func (a *Allocator) ReleaseAll() map[string]NodeMask {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	released := a.users
+
+	log.Debug("release all %d allocations", len(released))
+	a.reset()
+
+	return released
+}
+
+// SetReservedNodes updates the nodes reserved for requests with access to
+// reserved nodes. Existing allocations are not affected.
+func (a *Allocator) SetReservedNodes(nodes NodeMask) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.checkReservedNodes(nodes); err != nil {
+		return err
+	}
+	a.reserved = nodes
+	a.invalidateOffers()
+	a.DumpConfig()
+
+	return nil
+}
+
+// ReservedNodes returns the nodes reserved for requests with access to
+// reserved nodes.
+func (a *Allocator) ReservedNodes() NodeMask {
+	return a.reserved
+}
+
+// UnreservedCapacity returns the total memory capacity available for
+// requests without access to reserved nodes.
+func (a *Allocator) UnreservedCapacity() int64 {
+	return a.zoneCapacity(a.masks.nodes.hasMemory &^ a.reserved)
+}
+
+func (a *Allocator) checkReservedNodes(nodes NodeMask) error {
+	if unknown := nodes &^ a.masks.nodes.all; unknown != 0 {
+		return fmt.Errorf("%w: unknown nodes reserved (%s)", ErrInvalidNode, unknown)
+	}
+	if nodes != 0 && (a.masks.nodes.hasMemory&^nodes) == 0 {
+		return fmt.Errorf("%w: all nodes with memory reserved (%s)", ErrInvalidNodeMask, nodes)
+	}
+	return nil
+}
+
+// AddNode adds a new node, for instance a hotplugged one, to the allocator.
+// The ID of the node must follow the IDs of existing nodes, and its distance
+// vector must include distances to all existing nodes and to itself. Distance
+// vectors of existing nodes are extended with their distance to the new node.
+// AddNode invalidates all offers. Existing allocations are not affected. Use
+// RebalanceToNode to let existing allocations use the new node.
+func (a *Allocator) AddNode(n *Node) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.nodes[n.id]; ok {
+		return fmt.Errorf("%w: node #%d already exists", ErrInvalidNode, n.id)
+	}
+
+	if cnt := len(a.nodes) + 1; len(n.distance.vector) != cnt {
+		return fmt.Errorf("%w: node #%d has %d distances for %d nodes", ErrInvalidNode,
+			n.id, len(n.distance.vector), cnt)
+	}
+
+	distances := make(map[ID]Distance, len(a.nodes))
+	for id, o := range a.nodes {
+		if len(o.distance.vector) != n.id {
+			return fmt.Errorf("%w: node #%d does not follow existing nodes", ErrInvalidNode, n.id)
+		}
+		d, err := NewDistance(id, append(slices.Clone(o.distance.vector), n.distance.vector[id]))
+		if err != nil {
+			return fmt.Errorf("failed to extend distances of node #%d: %w", id, err)
+		}
+		distances[id] = d
+	}
+
+	for id, d := range distances {
+		a.nodes[id].distance = d
+	}
+	a.nodes[n.id] = n
+	a.masks.addNode(n)
+	a.invalidateOffers()
+
+	log.Info("added %s node #%d with %s memory", n.memType, n.id, prettySize(n.capacity))
+
+	return nil
+}
+
+// RebalanceToNode offers existing allocations close to the given node a
+// move to a zone which includes the node. Only allocations of matching
+// types, with at most the given priority, which are not pinned, and for
+// which the node is among the closest nodes of its type are moved. The
+// new node is added to the zone of these allocations. RebalanceToNode
+// returns the updated zones of all affected allocations. The caller must
+// ensure these updates are properly enforced.
+func (a *Allocator) RebalanceToNode(id ID, limit Priority) (updates map[string]NodeMask, retErr error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	n, ok := a.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown node #%d", ErrInvalidNode, id)
+	}
+
+	log.Debug("rebalance allocations with priority <= %d to node #%d", limit, id)
+
+	defer a.validateState("RebalanceToNode")
+	defer a.cleanupUnusedZones()
+
+	if err := a.startJournal(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			_, err := a.revertJournal(nil)
+			if err != nil {
+				log.Warn("failed to revert journal on error: %v", err)
+			}
+		}
+	}()
+
+	zones := NodeMask(0)
+	for _, req := range SortRequests(a.requests, RequestsWithMaxPriority(limit), RequestsByAge) {
+		if req.IsPinned() || !req.Types().Contains(n.memType) || (req.zone&n.Mask()) != 0 || !req.isAllowed(n.Mask()) {
+			continue
+		}
+		if (a.newCloseNodesOfType(req.zone, n.memType) & n.Mask()) == 0 {
+			continue
+		}
+		a.zoneMove(req.zone|n.Mask(), req)
+		zones |= req.zone
+	}
+
+	if err := a.handleOvercommit(zones); err != nil {
+		return nil, fmt.Errorf("%w: failed to rebalance: %w", ErrNoMem, err)
+	}
+
+	j := a.closeJournal()
+	a.invalidateOffers()
+
+	if len(j.updates) == 0 {
+		return nil, nil
+	}
+
+	return j.updates, nil
+}
+
+// UpgradeRankedTypes moves existing allocations with ranked types to nodes
+// of a more preferred type, if such nodes close to their affinity have now
+// enough memory available. Only allocations with at most the given priority
+// which are not pinned are moved. Allocations of higher priority are moved
+// first. UpgradeRankedTypes returns the updated zones of all affected
+// allocations. The caller must ensure these updates are properly enforced.
+func (a *Allocator) UpgradeRankedTypes(limit Priority) (updates map[string]NodeMask, retErr error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	log.Debug("upgrade ranked types of allocations with priority <= %d", limit)
+
+	defer a.validateState("UpgradeRankedTypes")
+	defer a.cleanupUnusedZones()
+
+	if err := a.startJournal(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			_, err := a.revertJournal(nil)
+			if err != nil {
+				log.Warn("failed to revert journal on error: %v", err)
+			}
+		}
+	}()
+
+	byPriority := func(r1, r2 *Request) int { return -RequestsByPriority(r1, r2) }
+
+	zones := NodeMask(0)
+	for _, req := range SortRequests(a.requests, RequestsWithMaxPriority(limit), byPriority, RequestsByAge) {
+		if req.IsPinned() || len(req.ranked) == 0 {
+			continue
+		}
+
+		rank := a.rankOf(req)
+		if rank == 0 {
+			continue
+		}
+
+		zone := a.rankedZone(req, req.affinity, req.ranked[:rank])
+		if zone == 0 {
+			continue
+		}
+
+		current := req.zone
+		req.zone = zone
+		err := a.ensureNormalMemory(req)
+		zone, req.zone = req.zone, current
+		if err != nil || zone == current {
+			continue
+		}
+
+		log.Debug("- upgrade %s from %s to %s", req, current, zone)
+		a.zoneMove(zone, req)
+		zones |= zone
+	}
+
+	if err := a.handleOvercommit(zones); err != nil {
+		return nil, fmt.Errorf("%w: failed to upgrade: %w", ErrNoMem, err)
+	}
+
+	j := a.closeJournal()
+	a.invalidateOffers()
+
+	if len(j.updates) == 0 {
+		return nil, nil
+	}
+
+	return j.updates, nil
+}
+
 func newAllocator(options ...AllocatorOption) (*Allocator, error) {
 	a := &Allocator{
 		nodes: make(map[ID]*Node),
@@ -279,6 +938,10 @@ func newAllocator(options ...AllocatorOption) (*Allocator, error) {
 		a.masks.addNode(n)
 	}
 
+	if err := a.checkReservedNodes(a.reserved); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFailedOption, err)
+	}
+
 	a.DumpConfig()
 
 	return a, nil
@@ -287,15 +950,7 @@ func newAllocator(options ...AllocatorOption) (*Allocator, error) {
 func (a *Allocator) allocate(req *Request) (retErr error) {
 	a.DumpState()
 
-	if err := a.validateRequest(req); err != nil {
-		return err
-	}
-
-	if err := a.findInitialZone(req); err != nil {
-		return err
-	}
-
-	if err := a.ensureNormalMemory(req); err != nil {
+	if err := a.prepareRequest(req); err != nil {
 		return err
 	}
 
@@ -312,10 +967,37 @@ func (a *Allocator) allocate(req *Request) (retErr error) {
 		}
 	}()
 
+	return a.assignRequest(req)
+}
+
+// prepareRequest validates a new request and finds its initial zone.
+func (a *Allocator) prepareRequest(req *Request) error {
+	if err := a.validateRequest(req); err != nil {
+		return err
+	}
+
+	if err := a.findInitialZone(req); err != nil {
+		return err
+	}
+
+	return a.ensureNormalMemory(req)
+}
+
+// assignRequest assigns a prepared request to its zone and resolves any
+// resulting overcommit. Changes are recorded in the active journal.
+func (a *Allocator) assignRequest(req *Request) error {
 	a.requests[req.ID()] = req
 	a.zoneAssign(req.zone, req)
 
-	return a.handleOvercommit(req.zone)
+	if err := a.handleOvercommit(req.zone); err != nil {
+		if req.local {
+			return fmt.Errorf("%w: local nodes %s exhausted for %s: %w",
+				ErrNoLocalMem, req.allowed, req, err)
+		}
+		return err
+	}
+
+	return nil
 }
 
 func (a *Allocator) realloc(req *Request, nodes NodeMask, types TypeMask) (zone NodeMask, updates map[string]NodeMask, retErr error) {
@@ -347,7 +1029,7 @@ func (a *Allocator) realloc(req *Request, nodes NodeMask, types TypeMask) (zone
 		}
 	}()
 
-	newNodes, newTypes := a.expand(req.zone|nodes, types)
+	newNodes, newTypes := a.expandFor(req, req.zone|nodes, types)
 	if newNodes == 0 {
 		return 0, nil, fmt.Errorf("%w: failed to reallocate, can't find new %s nodes",
 			ErrNoMem, types)
@@ -374,6 +1056,7 @@ func (a *Allocator) release(req *Request) error {
 
 	a.zoneRemove(zone, req.ID())
 	delete(a.requests, req.ID())
+	delete(a.reservations, req.ID())
 	a.invalidateOffers()
 
 	return nil
@@ -383,6 +1066,9 @@ func (a *Allocator) reset() {
 	a.zones = make(map[NodeMask]*Zone)
 	a.users = make(map[string]NodeMask)
 	a.requests = make(map[string]*Request)
+	a.reservations = make(map[string]string)
+	a.journal = nil
+	a.moves = 0
 	a.invalidateOffers()
 }
 
@@ -409,6 +1095,52 @@ func (a *Allocator) validateRequest(req *Request) error {
 		return fmt.Errorf("%w: request without affinity", ErrInvalidNodeMask)
 	}
 
+	if req.allowed != 0 {
+		if (req.allowed & a.masks.nodes.all) != req.allowed {
+			unknown := req.allowed &^ a.masks.nodes.all
+			return fmt.Errorf("%w: unknown nodes allowed (%s)", ErrInvalidNode, unknown)
+		}
+	}
+
+	if req.local {
+		local := req.affinity
+		if req.allowed != 0 {
+			local &= req.allowed
+		}
+		if local == 0 {
+			return fmt.Errorf("%w: local affinity %s outside allowed nodes %s",
+				ErrNotAllowed, req.affinity, req.allowed)
+		}
+		req.allowed = local
+	}
+
+	if a.reserved != 0 && !req.canUseReserved() {
+		allowed := req.allowed
+		if allowed == 0 {
+			allowed = a.masks.nodes.all
+		}
+		if allowed&^a.reserved == 0 {
+			return fmt.Errorf("%w: allowed nodes %s are all reserved", ErrNotAllowed, allowed)
+		}
+		req.allowed = allowed &^ a.reserved
+	}
+
+	if req.allowed != 0 {
+		if (req.affinity & req.allowed) == 0 {
+			return fmt.Errorf("%w: affinity %s outside allowed nodes %s",
+				ErrNotAllowed, req.affinity, req.allowed)
+		}
+		if capacity := a.zoneCapacity(req.allowed); capacity < req.Size() {
+			if req.local {
+				return fmt.Errorf("%w: %w: local nodes %s can't satisfy %s (capacity %s)",
+					ErrNoLocalMem, ErrNoMem, req.allowed, req, prettySize(capacity))
+			}
+			return fmt.Errorf("%w: allowed nodes %s can't satisfy %s (capacity %s)",
+				ErrNoMem, req.allowed, req, prettySize(capacity))
+		}
+		req.affinity &= req.allowed
+	}
+
 	req.types &= a.masks.types
 	if req.types == 0 {
 		req.types = a.zoneType(req.affinity)
@@ -433,6 +1165,11 @@ func (a *Allocator) validateRealloc(req *Request, nodes NodeMask, types TypeMask
 		return 0, 0, false, fmt.Errorf("%w: unknown nodes requested (%s)", ErrInvalidNode, unknown)
 	}
 
+	if !req.isAllowed(nodes) {
+		return 0, 0, false, fmt.Errorf("%w: nodes %s outside allowed nodes %s",
+			ErrNotAllowed, nodes, req.allowed)
+	}
+
 	if (req.types&a.masks.types) != req.types && req.IsStrict() {
 		unavailable := req.types &^ a.masks.types
 		return 0, 0, false, fmt.Errorf("%w: unavailable types requested (%s)", ErrInvalidType, unavailable)
@@ -498,13 +1235,26 @@ func (a *Allocator) findInitialZone(req *Request) error {
 
 	var (
 		zone = req.affinity & a.masks.nodes.all
-		miss = req.types &^ a.zoneType(zone)
+		miss TypeMask
 	)
 
-	if miss != 0 {
+	if near := a.findNearZone(req); near != 0 && req.isAllowed(near) {
+		log.Debug("- find initial zone (start near allocations at %s)", near)
+		zone = near
+	}
+
+	if len(req.ranked) > 0 {
+		if ranked := a.rankedZone(req, zone, req.ranked); ranked != 0 {
+			log.Debug("- find initial zone (ranked types, start at %s)", ranked)
+			req.zone = ranked
+			return nil
+		}
+	}
+
+	if miss = req.types &^ a.zoneType(zone); miss != 0 {
 		log.Debug("- find initial zone (start at %s, expand with %s)", zone, miss)
 
-		nodes, _ := a.expand(zone, miss)
+		nodes, _ := a.expandFor(req, zone, miss)
 		zone |= nodes
 	}
 
@@ -525,6 +1275,107 @@ func (a *Allocator) findInitialZone(req *Request) error {
 	return nil
 }
 
+func (a *Allocator) rankedZone(req *Request, zone NodeMask, ranked []Type) NodeMask {
+	// Find a zone for a request with ranked types.
+	//
+	// We go through the types in decreasing order of preference, and pick
+	// the nodes of the type in the zone, or the closest ones to the zone if
+	// there are none, as long as they have enough memory available for the
+	// request.
+
+	for _, t := range ranked {
+		nodes := zone & a.masks.nodes.byTypes[t.Mask()]
+		if nodes == 0 {
+			nodes = a.newCloseNodesOfType(zone, t)
+		}
+		if req.allowed != 0 {
+			nodes &= req.allowed
+		}
+		if nodes == 0 {
+			continue
+		}
+		if available := a.zoneAvailable(nodes); available < req.Size() {
+			log.Debug("  - %s %s: only %s available", t, nodes, prettySize(available))
+			continue
+		}
+		return nodes
+	}
+
+	return 0
+}
+
+// rankOf returns the rank of the most preferred type in the zone of a
+// request with ranked types.
+func (a *Allocator) rankOf(req *Request) int {
+	types := a.zoneType(req.zone)
+	for rank, t := range req.ranked {
+		if types.Contains(t) {
+			return rank
+		}
+	}
+	return len(req.ranked)
+}
+
+func (a *Allocator) findNearZone(req *Request) NodeMask {
+	// Find the zone of existing allocations the request should be close to.
+	//
+	// If all the referenced allocations are in the same zone we use that.
+	// Otherwise we pick the zone among the referenced ones which minimizes
+	// the combined distance to all referenced zones. Ties are broken by the
+	// distance to the affinity of the request.
+
+	var (
+		zones = []NodeMask{}
+		seen  = map[NodeMask]struct{}{}
+	)
+
+	for _, id := range req.near {
+		other, ok := a.requests[id]
+		if !ok {
+			log.Debug("- ignoring unknown near allocation %q for %s", id, req)
+			continue
+		}
+		zones = append(zones, other.zone)
+		seen[other.zone] = struct{}{}
+	}
+
+	if len(zones) == 0 {
+		return 0
+	}
+
+	var (
+		best     NodeMask
+		bestDist = math.MaxInt
+		bestAff  = math.MaxInt
+	)
+
+	for candidate := range seen {
+		dist := 0
+		for _, zone := range zones {
+			dist += a.zoneDistance(candidate, zone)
+		}
+		aff := a.zoneDistance(candidate, req.affinity)
+		if dist < bestDist || (dist == bestDist && (aff < bestAff || (aff == bestAff && candidate < best))) {
+			best, bestDist, bestAff = candidate, dist, aff
+		}
+	}
+
+	return best
+}
+
+// zoneDistance returns the largest distance between any nodes of two zones.
+func (a *Allocator) zoneDistance(zone1, zone2 NodeMask) int {
+	dist := 0
+	(zone1 & a.masks.nodes.all).Foreach(func(id1 ID) bool {
+		(zone2 & a.masks.nodes.all).Foreach(func(id2 ID) bool {
+			dist = max(dist, a.nodes[id1].DistanceTo(id2))
+			return ForeachMore
+		})
+		return ForeachMore
+	})
+	return dist
+}
+
 func (a *Allocator) ensureNormalMemory(req *Request) error {
 	// Make sure that request has some initial normal memory.
 	//
@@ -566,7 +1417,7 @@ func (a *Allocator) ensureNormalMemory(req *Request) error {
 
 	log.Debug("- ensure normal memory for %s (with %s types)", zone, types)
 
-	for n, _ := a.expand(zone, types); n != 0; n, _ = a.expand(zone, types) {
+	for n, _ := a.expandFor(req, zone, types); n != 0; n, _ = a.expandFor(req, zone, types) {
 		zone |= n
 
 		if (zone & a.masks.nodes.normal) != 0 {
@@ -580,11 +1431,12 @@ func (a *Allocator) ensureNormalMemory(req *Request) error {
 	return fmt.Errorf("no normal memory (of any type %s)", types)
 }
 
-func (a *Allocator) newOffer(req *Request, updates map[string]NodeMask) *Offer {
+func (a *Allocator) newOffer(req *Request, updates map[string]NodeMask, moves int64) *Offer {
 	return &Offer{
 		a:       a,
 		req:     req,
 		updates: updates,
+		moves:   moves,
 		version: a.version,
 	}
 }
@@ -606,6 +1458,26 @@ func (a *Allocator) expand(zone NodeMask, types TypeMask) (NodeMask, TypeMask) {
 	return nodes, types
 }
 
+// expandFor expands a zone for a request, using only nodes the request is
+// allowed to use. If expansion would only add nodes which are not allowed,
+// it continues past those nodes until allowed ones are found.
+func (a *Allocator) expandFor(req *Request, zone NodeMask, types TypeMask) (NodeMask, TypeMask) {
+	if req.allowed == 0 {
+		return a.expand(zone, types)
+	}
+
+	for {
+		nodes, _ := a.expand(zone, types)
+		if nodes == 0 {
+			return 0, 0
+		}
+		if allowed := nodes & req.allowed; allowed != 0 {
+			return allowed, a.zoneType(allowed)
+		}
+		zone |= nodes
+	}
+}
+
 func (a *Allocator) defaultExpand(zone NodeMask, types TypeMask) (NodeMask, TypeMask) {
 	// The default zone expansion algorithm expands the zone by adding
 	// the closest set of new nodes for each allowed type, doing a single
@@ -632,6 +1504,12 @@ func (a *Allocator) defaultExpand(zone NodeMask, types TypeMask) (NodeMask, Type
 		return true
 	})
 
+	// Stay within the dies of the zone, if some of the new nodes are there.
+	if local := newNodes & a.dieSpan(zone); local != 0 && local != newNodes {
+		details.Debug("limiting expansion of %s to die-local nodes %s", zone, local)
+		newNodes, newTypes = local, a.zoneType(local)
+	}
+
 	if newNodes != 0 {
 		details.Debug("expanded nodes %s by types %s to %s %s", zone, types, newTypes, newNodes)
 	}
@@ -639,18 +1517,30 @@ func (a *Allocator) defaultExpand(zone NodeMask, types TypeMask) (NodeMask, Type
 	return newNodes, newTypes
 }
 
+// dieSpan returns all nodes of the dies with nodes in the given zone.
+func (a *Allocator) dieSpan(zone NodeMask) NodeMask {
+	var span NodeMask
+	for _, nodes := range a.dies {
+		if nodes&zone != 0 {
+			span |= nodes
+		}
+	}
+	return span
+}
+
 func (a *Allocator) newCloseNodesOfType(zone NodeMask, t Type) NodeMask {
 	var (
 		close NodeMask
-		max   = math.MaxInt
+		max   = math.MaxFloat64
 	)
 
 	a.ForeachNode(zone, func(node *Node) bool {
-		node.ForeachDistance(func(dist int, nodes NodeMask) bool {
+		node.ForeachDistance(func(d int, nodes NodeMask) bool {
 			nodes &= a.masks.nodes.byTypes[t.Mask()] &^ zone
 			if nodes == 0 {
 				return true
 			}
+			dist := a.weightedDistance(node.Type(), t, d)
 			if dist <= max {
 				max = dist
 				close |= nodes
@@ -663,6 +1553,20 @@ func (a *Allocator) newCloseNodesOfType(zone NodeMask, t Type) NodeMask {
 	return close
 }
 
+// weightedDistance returns the distance between nodes of the given types
+// scaled by the larger of their distance weights.
+func (a *Allocator) weightedDistance(t1, t2 Type, dist int) float64 {
+	return float64(dist) * max(a.typeWeight(t1), a.typeWeight(t2))
+}
+
+// typeWeight returns the distance weight of the given memory type.
+func (a *Allocator) typeWeight(t Type) float64 {
+	if w, ok := a.weights[t]; ok {
+		return w
+	}
+	return 1.0
+}
+
 func (a *Allocator) handleOvercommit(nodes NodeMask) error {
 	oc, spill := a.checkOvercommit(nodes)
 	if len(oc) == 0 {
@@ -729,12 +1633,22 @@ func (a *Allocator) defaultHandleOvercommit(nodes NodeMask, oc []NodeMask, spill
 
 	var (
 		failed = []string{}
+		pinned = []string{}
 		total  = int64(0)
 	)
 
-	for z, amount := range spill {
+	for _, z := range oc {
+		amount := spill[z]
 		failed = append(failed, z.String())
 		total += amount
+		for _, req := range SortRequests(a.zones[z].users, (*Request).IsPinned, RequestsByAge) {
+			pinned = append(pinned, req.Name())
+		}
+	}
+
+	if len(pinned) > 0 {
+		return fmt.Errorf("%w: failed to resolve overcommit, zones %s overcommit by %s: %w: %s",
+			ErrNoMem, strings.Join(failed, ","), prettySize(total), ErrPinned, strings.Join(pinned, ", "))
 	}
 
 	return fmt.Errorf("%w: failed to resolve overcommit, zones %s overcommit by %s",
@@ -784,8 +1698,7 @@ func (a *Allocator) startJournal() error {
 }
 
 func (a *Allocator) commitJournal(req *Request) map[string]NodeMask {
-	j := a.journal
-	a.journal = nil
+	j := a.closeJournal()
 
 	delete(j.updates, req.ID())
 	if len(j.updates) == 0 {
@@ -795,6 +1708,14 @@ func (a *Allocator) commitJournal(req *Request) map[string]NodeMask {
 	return j.updates
 }
 
+// closeJournal deactivates the journal, keeping all recorded changes.
+func (a *Allocator) closeJournal() *journal {
+	j := a.journal
+	a.journal = nil
+	a.moves += j.moves
+	return j
+}
+
 func (a *Allocator) revertJournal(req *Request) (map[string]NodeMask, error) {
 	if a.journal == nil {
 		return nil, nil
@@ -845,6 +1766,14 @@ func (j *journal) assign(zone NodeMask, id string) {
 	j.reverts[id] = 0
 }
 
+// move records a request moved to resolve overcommit.
+func (j *journal) move() {
+	if j == nil {
+		return
+	}
+	j.moves++
+}
+
 func (j *journal) delete(zone NodeMask, id string) {
 	if j == nil {
 		return
@@ -856,9 +1785,13 @@ func (j *journal) delete(zone NodeMask, id string) {
 }
 
 // Commit the given offer, turning it into an allocation. Any other
-// offers are invalidated.
+// offers are invalidated. Committing an offer which has been invalidated
+// fails with ErrExpiredOffer.
 func (o *Offer) Commit() (NodeMask, map[string]NodeMask, error) {
-	if !o.IsValid() {
+	o.a.lock.Lock()
+	defer o.a.lock.Unlock()
+
+	if !o.isValid() {
 		return 0, nil, fmt.Errorf("%w: version %d != %d", ErrExpiredOffer, o.version, o.a.version)
 	}
 
@@ -880,6 +1813,7 @@ func (o *Offer) Commit() (NodeMask, map[string]NodeMask, error) {
 		}
 	}
 
+	o.a.moves += o.moves
 	o.a.invalidateOffers()
 
 	log.Debug("committed offer %s to %s", o.req, o.req.Zone())
@@ -887,7 +1821,15 @@ func (o *Offer) Commit() (NodeMask, map[string]NodeMask, error) {
 	return o.NodeMask(), o.Updates(), nil
 }
 
+// IsValid returns true if the offer has not been invalidated.
 func (o *Offer) IsValid() bool {
+	o.a.lock.Lock()
+	defer o.a.lock.Unlock()
+
+	return o.isValid()
+}
+
+func (o *Offer) isValid() bool {
 	return o.version == o.a.version
 }
 
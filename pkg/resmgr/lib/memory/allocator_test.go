@@ -15,7 +15,9 @@
 package libmem_test
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -87,6 +89,256 @@ func TestOffer(t *testing.T) {
 	require.Equal(t, n, NodeMask(0), "failed commit should return 0 NodeMask")
 }
 
+func TestWouldFit(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "test setup",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err, "unexpected NewAllocator() error")
+	require.NotNil(t, a, "unexpected nil allocator")
+
+	o, err := a.GetOffer(Container("id0", "test", "burstable", 1, NewNodeMask(1)))
+	require.Nil(t, err, "unexpected GetOffer() error")
+
+	ok, zone, reason := a.WouldFit(Container("id1", "test", "guaranteed", 3, NewNodeMask(0)))
+	require.True(t, ok, "unexpected WouldFit() failure: %s", reason)
+	require.Equal(t, NewNodeMask(0), zone, "unexpected WouldFit() zone")
+	require.Empty(t, reason, "unexpected WouldFit() reason")
+
+	_, found := a.AllocationInfo("id1")
+	require.False(t, found, "WouldFit() should not allocate")
+	require.True(t, o.IsValid(), "WouldFit() should not invalidate offers")
+
+	allocated, _, err := a.Allocate(Container("id1", "test", "guaranteed", 3, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected Allocate() error")
+	require.Equal(t, zone, allocated, "WouldFit() inconsistent with Allocate()")
+
+	ok, zone, reason = a.WouldFit(Container("id2", "test", "guaranteed", 6, NewNodeMask(0)))
+	require.False(t, ok, "unexpected WouldFit() success")
+	require.Equal(t, NodeMask(0), zone, "unexpected WouldFit() zone on failure")
+	require.NotEmpty(t, reason, "missing WouldFit() reason")
+
+	info, found := a.AllocationInfo("id1")
+	require.True(t, found, "failed WouldFit() should keep existing allocations")
+	require.Equal(t, allocated, info.Zone, "failed WouldFit() should not move existing allocations")
+}
+
+func TestPreviewMoves(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	_, _, err = a.Allocate(Container("1", "ctr1", "burstable", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+	o, err := a.GetOffer(Container("0", "ctr0", "burstable", 10, NewNodeMask(1)))
+	require.Nil(t, err, "unexpected GetOffer() error")
+	before := a.Stats()
+
+	zone, moves, err := a.PreviewMoves(Container("2", "ctr2", "guaranteed", 10, NewNodeMask(1)))
+	require.Nil(t, err, "unexpected PreviewMoves() error")
+	require.Equal(t, NewNodeMask(1), zone, "unexpected PreviewMoves() zone")
+	require.Nil(t, moves, "unexpected PreviewMoves() moves")
+
+	zone, moves, err = a.PreviewMoves(Container("2", "ctr2", "guaranteed", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected PreviewMoves() error")
+	require.Equal(t, NewNodeMask(0), zone, "unexpected PreviewMoves() zone")
+	require.Equal(t, map[string]NodeMask{"1": NewNodeMask(0, 1)}, moves, "unexpected PreviewMoves() moves")
+
+	require.Equal(t, before, a.Stats(), "PreviewMoves() should not change state")
+	_, found := a.AllocationInfo("2")
+	require.False(t, found, "PreviewMoves() should not allocate")
+	require.True(t, o.IsValid(), "PreviewMoves() should not invalidate offers")
+
+	allocated, updates, err := a.Allocate(Container("2", "ctr2", "guaranteed", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected Allocate() error")
+	require.Equal(t, zone, allocated, "PreviewMoves() zone inconsistent with Allocate()")
+	require.Equal(t, moves, updates, "PreviewMoves() moves inconsistent with Allocate()")
+
+	_, _, err = a.PreviewMoves(Container("3", "ctr3", "guaranteed", 20, NewNodeMask(5)))
+	require.ErrorIs(t, err, ErrInvalidNode)
+}
+
+func TestReservation(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	_, _, err = a.Allocate(Container("1", "ctr1", "burstable", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+
+	_, err = a.Reserve("balloon", NewNodeMask(0), 60)
+	require.ErrorIs(t, err, ErrNoMem, "Reserve() should not move existing allocations")
+	require.Empty(t, a.Stats().Reservations, "failed Reserve() should not reserve memory")
+	zone, _ := a.AssignedZone("1")
+	require.Equal(t, NewNodeMask(0), zone, "failed Reserve() should not move allocations")
+
+	h, err := a.Reserve("balloon", NewNodeMask(1), 60)
+	require.Nil(t, err, "unexpected Reserve() error")
+	require.Equal(t, "balloon", h.Name())
+	require.Equal(t, map[string]int64{"balloon": 60}, a.Stats().Reservations)
+
+	zone, _, err = a.Allocate(Container("2", "ctr2", "burstable", 60, NewNodeMask(1)))
+	require.Nil(t, err, "unexpected allocation failure")
+	require.Equal(t, NewNodeMask(0, 1), zone, "reservation should cause overcommit")
+
+	require.Nil(t, h.Release(), "unexpected Release() error")
+	require.Empty(t, a.Stats().Reservations, "Release() should release reservation")
+	require.NotNil(t, h.Release(), "Release() of a released reservation should fail")
+
+	h, err = a.Reserve("converted", NewNodeMask(1), 30)
+	require.Nil(t, err, "unexpected Reserve() error")
+	zone, _, err = h.Allocate(Container("3", "ctr3", "guaranteed", 30, NewNodeMask(1)))
+	require.Nil(t, err, "unexpected ReservationHandle.Allocate() error")
+	require.Equal(t, NewNodeMask(1), zone)
+	require.Empty(t, a.Stats().Reservations, "allocated reservation should be released")
+	_, found := a.AllocationInfo(h.ID())
+	require.False(t, found, "allocated reservation should be released")
+
+	h, err = a.Reserve("kept", NewNodeMask(1), 10)
+	require.Nil(t, err, "unexpected Reserve() error")
+	_, _, err = h.Allocate(Container("4", "ctr4", "guaranteed", 10, NewNodeMask(5)))
+	require.ErrorIs(t, err, ErrInvalidNode)
+	require.Equal(t, map[string]int64{"kept": 10}, a.Stats().Reservations,
+		"failed ReservationHandle.Allocate() should keep reservation")
+	zone, _ = a.AssignedZone(h.ID())
+	require.Equal(t, NewNodeMask(1), zone, "failed ReservationHandle.Allocate() should keep reservation")
+}
+
+func TestConcurrentOffers(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "test setup",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+
+		count  = 16
+		offers = make([]*Offer, count)
+		errs   = make([]error, count)
+		wg     sync.WaitGroup
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err, "unexpected NewAllocator() error")
+	require.NotNil(t, a, "unexpected nil allocator")
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("id%d", i)
+			offers[i], errs[i] = a.GetOffer(Container(id, "test", "burstable", 1, NewNodeMask(ID(i%2))))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < count; i++ {
+		require.Nil(t, errs[i], "unexpected GetOffer() error")
+		require.True(t, offers[i].IsValid(), "unexpected invalid offer")
+	}
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = offers[i].Commit()
+		}(i)
+	}
+	wg.Wait()
+
+	committed := 0
+	for i := 0; i < count; i++ {
+		_, found := a.AllocationInfo(fmt.Sprintf("id%d", i))
+		if errs[i] == nil {
+			committed++
+			require.True(t, found, "committed offer not allocated")
+		} else {
+			require.True(t, errors.Is(errs[i], ErrExpiredOffer), "unexpected Commit() error")
+			require.False(t, found, "failed offer allocated")
+		}
+		require.False(t, offers[i].IsValid(), "unexpected valid offer after commit")
+	}
+	require.Equal(t, 1, committed, "committed offers")
+	require.Equal(t, int64(1), a.ZoneUsage(NewNodeMask(0, 1)), "allocated memory")
+}
+
 func TestCPUSetAffinity(t *testing.T) {
 	var (
 		setup = &testSetup{
@@ -326,6 +578,274 @@ func TestExpand(t *testing.T) {
 	}
 }
 
+func TestTypeDistanceWeights(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "3 DRAM+1 HBM NUMA nodes",
+			types: []Type{
+				TypeDRAM, TypeHBM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{}, {}, {}, {},
+			},
+			distances: [][]int{
+				{10, 11, 21, 30},
+				{11, 10, 30, 17},
+				{21, 30, 10, 21},
+				{30, 17, 21, 10},
+			},
+		}
+	)
+
+	_, err := NewAllocator(WithNodes(setup.nodes(t)),
+		WithTypeDistanceWeights(map[Type]float64{TypeHBM: 0}))
+	require.NotNil(t, err, "expected failure with zero weight")
+	_, err = NewAllocator(WithNodes(setup.nodes(t)),
+		WithTypeDistanceWeights(map[Type]float64{Type(7): 1.5}))
+	require.ErrorIs(t, err, ErrInvalidType, "expected failure with unknown type")
+
+	type testCase struct {
+		name    string
+		weights map[Type]float64
+		nodes   NodeMask
+		types   TypeMask
+		result  NodeMask
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:   "raw distances, DRAM expansion from DRAM #0 and HBM #1",
+			nodes:  NewNodeMask(0, 1),
+			types:  TypeMaskDRAM,
+			result: NewNodeMask(2, 3),
+		},
+		{
+			name:    "default weights, DRAM expansion from DRAM #0 and HBM #1",
+			weights: map[Type]float64{TypeDRAM: 1.0, TypeHBM: 1.0},
+			nodes:   NewNodeMask(0, 1),
+			types:   TypeMaskDRAM,
+			result:  NewNodeMask(2, 3),
+		},
+		{
+			name:    "remote HBM more expensive, DRAM expansion from DRAM #0 and HBM #1",
+			weights: map[Type]float64{TypeHBM: 2.0},
+			nodes:   NewNodeMask(0, 1),
+			types:   TypeMaskDRAM,
+			result:  NewNodeMask(2),
+		},
+		{
+			name:    "remote DRAM more expensive, DRAM expansion from DRAM #0 and HBM #1",
+			weights: map[Type]float64{TypeDRAM: 2.0},
+			nodes:   NewNodeMask(0, 1),
+			types:   TypeMaskDRAM,
+			result:  NewNodeMask(2, 3),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewAllocator(WithNodes(setup.nodes(t)), WithTypeDistanceWeights(tc.weights))
+			require.Nil(t, err)
+			require.NotNil(t, a)
+
+			newNodes, _ := a.Expand(tc.nodes, tc.types)
+			require.Equal(t, tc.result, newNodes)
+		})
+	}
+}
+
+func TestCPUSetAffinityWithType(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM+2 HBM NUMA nodes, 4 bytes per node, no HBM close to CPU #0",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeHBM, TypeHBM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {2, 3}, {4, 5},
+			},
+			distances: [][]int{
+				{10, 21, 14, 17},
+				{21, 10, 11, 28},
+				{14, 11, 10, 28},
+				{17, 28, 28, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	require.Equal(t, NewNodeMask(0), a.CPUSetAffinity(cpuset.New(0)))
+	require.Equal(t, NewNodeMask(0), a.CPUSetAffinityWithType(cpuset.New(0), TypeDRAM))
+	require.Equal(t, NewNodeMask(2), a.CPUSetAffinityWithType(cpuset.New(2), TypeHBM))
+	require.Equal(t, NodeMask(0), a.CPUSetAffinityWithType(cpuset.New(0), TypePMEM),
+		"unexpected nodes of missing type")
+	require.Equal(t, NodeMask(0), a.CPUSetAffinityWithType(cpuset.New(8), TypeHBM),
+		"unexpected nodes for CPUs without affinity")
+
+	// The closest node lacks HBM, the slightly farther HBM node is chosen.
+	require.Equal(t, NewNodeMask(2), a.CPUSetAffinityWithType(cpuset.New(0), TypeHBM))
+
+	// Without free capacity left there, the next closest one is chosen.
+	_, _, err = a.Allocate(ContainerWithStrictTypes("id1", "test", "guaranteed", 4, NewNodeMask(2), TypeMaskHBM))
+	require.Nil(t, err)
+	require.Equal(t, NewNodeMask(3), a.CPUSetAffinityWithType(cpuset.New(0), TypeHBM))
+	require.Equal(t, NewNodeMask(3), a.CPUSetAffinityWithType(cpuset.New(2), TypeHBM))
+
+	_, _, err = a.Allocate(ContainerWithStrictTypes("id2", "test", "guaranteed", 4, NewNodeMask(3), TypeMaskHBM))
+	require.Nil(t, err)
+	require.Equal(t, NodeMask(0), a.CPUSetAffinityWithType(cpuset.New(0), TypeHBM),
+		"unexpected nodes without free HBM")
+}
+
+func TestDieNodes(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 dies with 2 DRAM NUMA nodes each",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 12, 12, 12},
+				{12, 10, 12, 12},
+				{12, 12, 10, 12},
+				{12, 12, 12, 10},
+			},
+		}
+		dies = map[Die]NodeMask{
+			{Package: 0, ID: 0}: NewNodeMask(0, 1),
+			{Package: 0, ID: 1}: NewNodeMask(2, 3),
+		}
+	)
+
+	_, err := NewAllocator(WithNodes(setup.nodes(t)),
+		WithDieNodes(map[Die]NodeMask{{Package: 0, ID: 0}: 0}))
+	require.NotNil(t, err, "expected failure with a die without nodes")
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.Equal(t, NodeMask(0), a.DieNodes(0, 0), "unexpected nodes without dies")
+	newNodes, _ := a.Expand(NewNodeMask(0), TypeMaskDRAM)
+	require.Equal(t, NewNodeMask(1, 2, 3), newNodes, "expansion without dies")
+
+	a, err = NewAllocator(WithNodes(setup.nodes(t)), WithDieNodes(dies))
+	require.Nil(t, err)
+	require.Equal(t, NewNodeMask(0, 1), a.DieNodes(0, 0))
+	require.Equal(t, NewNodeMask(2, 3), a.DieNodes(0, 1))
+	require.Equal(t, NodeMask(0), a.DieNodes(1, 0), "unexpected nodes for unknown die")
+
+	newNodes, _ = a.Expand(NewNodeMask(0), TypeMaskDRAM)
+	require.Equal(t, NewNodeMask(1), newNodes, "expansion should stay within die")
+	newNodes, _ = a.Expand(NewNodeMask(0, 1), TypeMaskDRAM)
+	require.Equal(t, NewNodeMask(2, 3), newNodes, "expansion should leave a full die")
+
+	// Overcommit within a node is resolved within the die, and spills over
+	// to the other die only when the whole die runs out of memory.
+	_, _, err = a.Allocate(Container("id1", "test", "burstable", 3, NewNodeMask(0)))
+	require.Nil(t, err)
+	_, _, err = a.Allocate(Container("id2", "test", "burstable", 3, NewNodeMask(0)))
+	require.Nil(t, err)
+	zone1, _ := a.AssignedZone("id1")
+	zone2, _ := a.AssignedZone("id2")
+	require.Equal(t, NewNodeMask(0, 1), zone1|zone2, "overcommit should be resolved within die")
+
+	nodes, _, err := a.Allocate(Container("id3", "test", "guaranteed", 2, a.DieNodes(0, 0)))
+	require.Nil(t, err)
+	require.Equal(t, NewNodeMask(0, 1), nodes, "die affinity")
+
+	_, _, err = a.Allocate(Container("id4", "test", "burstable", 2, a.DieNodes(0, 0)))
+	require.Nil(t, err)
+	zones := NodeMask(0)
+	for _, id := range []string{"id1", "id2", "id3", "id4"} {
+		zone, _ := a.AssignedZone(id)
+		zones |= zone
+	}
+	require.Equal(t, NewNodeMask(0, 1, 2, 3), zones, "overcommit should spill over to other die")
+}
+
+func TestSimulateWithDies(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 dies with 2 DRAM NUMA nodes each",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 12, 12, 12},
+				{12, 10, 12, 12},
+				{12, 12, 10, 12},
+				{12, 12, 12, 10},
+			},
+		}
+		dies = map[Die]NodeMask{
+			{Package: 0, ID: 0}: NewNodeMask(0, 1),
+			{Package: 0, ID: 1}: NewNodeMask(2, 3),
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)), WithDieNodes(dies))
+	require.Nil(t, err)
+
+	batch := []*Request{
+		Container("1", "1", "burstable", 3, NewNodeMask(0)),
+		Container("2", "2", "burstable", 3, NewNodeMask(0)),
+		Container("3", "3", "burstable", 3, NewNodeMask(2)),
+		Container("4", "4", "burstable", 3, NewNodeMask(2)),
+		Container("5", "5", "burstable", 2, a.DieNodes(0, 0)),
+	}
+
+	res, err := a.Simulate(batch)
+	require.Nil(t, err, "unexpected Simulate() error")
+	require.Empty(t, res.Failures, "simulated failures")
+
+	for _, req := range batch {
+		_, _, err := a.Allocate(req)
+		require.Nil(t, err, "unexpected Allocate() error")
+	}
+
+	for _, req := range batch {
+		zone, ok := a.AssignedZone(req.ID())
+		require.True(t, ok, "request %s not allocated", req.ID())
+		require.Equal(t, zone, res.Placements[req.ID()], "simulated placement of %s", req.ID())
+	}
+	for _, id := range []string{"1", "2"} {
+		require.Zero(t, res.Placements[id]&a.DieNodes(0, 1), "simulated placement of %s left its die", id)
+	}
+	for _, id := range []string{"3", "4"} {
+		require.Zero(t, res.Placements[id]&a.DieNodes(0, 0), "simulated placement of %s left its die", id)
+	}
+}
+
 func TestAllocate(t *testing.T) {
 	var (
 		setup = &testSetup{
@@ -1001,7 +1521,914 @@ func TestPreservedAllocation(t *testing.T) {
 	}
 }
 
-func TestRealloc(t *testing.T) {
+func TestPinnedAllocation(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "4 DRAM+4 PMEM NUMA nodes, 4 bytes per node, 2 close CPUs",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+				TypePMEM, TypePMEM, TypePMEM, TypePMEM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+				{8, 9}, {10, 11}, {12, 13}, {14, 15},
+			},
+			distances: [][]int{
+				{10, 21, 11, 21, 17, 28, 28, 28},
+				{21, 10, 21, 11, 28, 28, 17, 28},
+				{11, 21, 10, 21, 28, 17, 28, 28},
+				{21, 11, 21, 10, 28, 28, 28, 17},
+				{17, 28, 28, 28, 10, 28, 28, 28},
+				{28, 28, 17, 28, 28, 10, 28, 28},
+				{28, 17, 28, 28, 28, 28, 10, 28},
+				{28, 28, 28, 17, 28, 28, 28, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	type testCase struct {
+		name      string
+		id        string
+		limit     int64
+		types     TypeMask
+		pinned    bool
+		affinity  NodeMask
+		qos       string
+		result    NodeMask
+		updates   map[string]NodeMask
+		newNodes  NodeMask
+		realloced NodeMask
+		zones     map[string]NodeMask
+		fail      bool
+		release   []string
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:     "4 bytes of DRAM from node #0, pinned",
+			id:       "1",
+			affinity: NewNodeMask(0),
+			limit:    4,
+			types:    TypeMaskDRAM,
+			pinned:   true,
+			qos:      "besteffort",
+			result:   NewNodeMask(0),
+		},
+		{
+			name:     "1 byte of DRAM from node #0, moved instead of pinned lower priority",
+			id:       "2",
+			affinity: NewNodeMask(0),
+			limit:    1,
+			types:    TypeMaskDRAM,
+			qos:      "guaranteed",
+			result:   NewNodeMask(0, 2),
+			zones:    map[string]NodeMask{"1": NewNodeMask(0)},
+		},
+		{
+			name:     "1 byte of DRAM from node #0, pinned, zone full of pinned allocations",
+			id:       "3",
+			affinity: NewNodeMask(0),
+			limit:    1,
+			types:    TypeMaskDRAM,
+			pinned:   true,
+			qos:      "guaranteed",
+			fail:     true,
+			zones: map[string]NodeMask{
+				"1": NewNodeMask(0),
+				"2": NewNodeMask(0, 2),
+			},
+		},
+		{
+			name:      "pinned allocation expanded to node #1 by its owner",
+			id:        "4",
+			affinity:  NewNodeMask(1),
+			limit:     2,
+			types:     TypeMaskDRAM,
+			pinned:    true,
+			qos:       "guaranteed",
+			result:    NewNodeMask(1),
+			newNodes:  NewNodeMask(0),
+			realloced: NewNodeMask(0, 1, 2, 3),
+			zones: map[string]NodeMask{
+				"1": NewNodeMask(0),
+				"2": NewNodeMask(0, 2),
+			},
+			release: []string{"1", "2", "4"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []RequestOption{
+				WithName(tc.name),
+				WithQosClass(tc.qos),
+				WithPreferredTypes(tc.types),
+			}
+			if tc.pinned {
+				opts = append(opts, WithPinned())
+			}
+
+			nodes, updates, err := a.Allocate(NewRequest(tc.id, tc.limit, tc.affinity, opts...))
+
+			if tc.fail {
+				require.ErrorIs(t, err, ErrNoMem, "unexpected allocation success")
+				require.ErrorIs(t, err, ErrPinned, "unexpected allocation failure")
+				require.Equal(t, NodeMask(0), nodes, tc.name)
+				require.Nil(t, updates, tc.name)
+				t.Logf("* got error %v", err)
+			} else {
+				require.Nil(t, err, "unexpected allocation failure")
+				require.Equal(t, tc.result, nodes, "allocated nodes")
+				require.Equal(t, tc.updates, updates, "updated nodes")
+			}
+
+			if tc.newNodes != 0 {
+				nodes, _, err = a.Realloc(tc.id, tc.newNodes, 0)
+				require.Nil(t, err, "unexpected realloc failure")
+				require.Equal(t, tc.realloced, nodes, "realloced nodes")
+			}
+
+			for id, zone := range tc.zones {
+				assigned, ok := a.AssignedZone(id)
+				require.True(t, ok, "assigned zone of ID #"+id)
+				require.Equal(t, zone, assigned, "assigned zone of ID #"+id)
+			}
+
+			for _, id := range tc.release {
+				err := a.Release(id)
+				require.Nil(t, err, "release of ID #"+id)
+			}
+		})
+	}
+}
+
+func TestNearAllocations(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "4 DRAM+4 PMEM NUMA nodes, 4 bytes per node, 2 close CPUs",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+				TypePMEM, TypePMEM, TypePMEM, TypePMEM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+				{8, 9}, {10, 11}, {12, 13}, {14, 15},
+			},
+			distances: [][]int{
+				{10, 21, 11, 21, 17, 28, 28, 28},
+				{21, 10, 21, 11, 28, 28, 17, 28},
+				{11, 21, 10, 21, 28, 17, 28, 28},
+				{21, 11, 21, 10, 28, 28, 28, 17},
+				{17, 28, 28, 28, 10, 28, 28, 28},
+				{28, 28, 17, 28, 28, 10, 28, 28},
+				{28, 17, 28, 28, 28, 28, 10, 28},
+				{28, 28, 28, 17, 28, 28, 28, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	type testCase struct {
+		name     string
+		id       string
+		affinity NodeMask
+		near     []string
+		result   NodeMask
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:     "1 byte of DRAM from node #0",
+			id:       "1",
+			affinity: NewNodeMask(0),
+			result:   NewNodeMask(0),
+		},
+		{
+			name:     "1 byte of DRAM affine to node #3, near #1",
+			id:       "2",
+			affinity: NewNodeMask(3),
+			near:     []string{"1"},
+			result:   NewNodeMask(0),
+		},
+		{
+			name:     "1 byte of DRAM from node #1",
+			id:       "3",
+			affinity: NewNodeMask(1),
+			result:   NewNodeMask(1),
+		},
+		{
+			name:     "1 byte of DRAM affine to node #3, near #1, #2 and #3",
+			id:       "4",
+			affinity: NewNodeMask(3),
+			near:     []string{"1", "2", "3"},
+			result:   NewNodeMask(0),
+		},
+		{
+			name:     "1 byte of DRAM affine to node #2, equally near #1 and #3",
+			id:       "5",
+			affinity: NewNodeMask(2),
+			near:     []string{"1", "3"},
+			result:   NewNodeMask(0),
+		},
+		{
+			name:     "1 byte of DRAM affine to node #3, near unknown",
+			id:       "6",
+			affinity: NewNodeMask(3),
+			near:     []string{"unknown"},
+			result:   NewNodeMask(3),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []RequestOption{
+				WithName(tc.name),
+				WithQosClass("burstable"),
+				WithPreferredTypes(TypeMaskDRAM),
+			}
+			if len(tc.near) > 0 {
+				opts = append(opts, NearAllocations(tc.near...))
+			}
+
+			nodes, _, err := a.Allocate(NewRequest(tc.id, 1, tc.affinity, opts...))
+			require.Nil(t, err, "unexpected allocation failure")
+			require.Equal(t, tc.result, nodes, "allocated nodes")
+		})
+	}
+}
+
+func TestAllowedNodes(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "4 DRAM NUMA nodes, 4 bytes per node, 2 close CPUs",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 21, 11, 21},
+				{21, 10, 21, 11},
+				{11, 21, 10, 21},
+				{21, 11, 21, 10},
+			},
+		}
+		allowed = NewNodeMask(0, 1)
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	type testCase struct {
+		name     string
+		id       string
+		limit    int64
+		affinity NodeMask
+		allowed  NodeMask
+		fail     error
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:     "3 bytes from node #0, allowed #0,#1",
+			id:       "1",
+			limit:    3,
+			affinity: NewNodeMask(0),
+			allowed:  allowed,
+		},
+		{
+			name:     "3 more bytes from node #0, expand past #2 to allowed #1",
+			id:       "2",
+			limit:    3,
+			affinity: NewNodeMask(0),
+			allowed:  allowed,
+		},
+		{
+			name:     "affinity outside allowed nodes",
+			id:       "3",
+			limit:    1,
+			affinity: NewNodeMask(2),
+			allowed:  allowed,
+			fail:     ErrNotAllowed,
+		},
+		{
+			name:     "more than the capacity of allowed nodes",
+			id:       "4",
+			limit:    5,
+			affinity: NewNodeMask(0),
+			allowed:  NewNodeMask(0),
+			fail:     ErrNoMem,
+		},
+		{
+			name:     "more than the free memory of allowed nodes",
+			id:       "5",
+			limit:    3,
+			affinity: NewNodeMask(1),
+			allowed:  allowed,
+			fail:     ErrNoMem,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := NewRequest(tc.id, tc.limit, tc.affinity,
+				WithName(tc.name),
+				WithQosClass("burstable"),
+				WithAllowedNodes(tc.allowed),
+			)
+			require.Equal(t, tc.allowed, req.AllowedNodes())
+
+			_, _, err := a.Allocate(req)
+			if tc.fail != nil {
+				require.ErrorIs(t, err, tc.fail, "expected allocation failure")
+				return
+			}
+			require.Nil(t, err, "unexpected allocation failure")
+
+			for _, id := range []string{"1", "2"} {
+				if info, ok := a.AllocationInfo(id); ok {
+					require.True(t, info.Zone.IsSubsetOf(allowed),
+						"allocation %s in %s, outside allowed nodes %s", id, info.Zone, allowed)
+				}
+			}
+		})
+	}
+
+	_, _, err = a.Realloc("1", NewNodeMask(2), 0)
+	require.ErrorIs(t, err, ErrNotAllowed, "expected reallocation failure")
+}
+
+func TestRequireLocalNode(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "4 DRAM NUMA nodes, 4 bytes per node, 2 close CPUs",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 21, 11, 21},
+				{21, 10, 21, 11},
+				{11, 21, 10, 21},
+				{21, 11, 21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	type testCase struct {
+		name     string
+		id       string
+		limit    int64
+		affinity NodeMask
+		allowed  NodeMask
+		local    bool
+		zone     NodeMask
+		fail     error
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:     "3 local bytes from node #0",
+			id:       "1",
+			limit:    3,
+			affinity: NewNodeMask(0),
+			local:    true,
+			zone:     NewNodeMask(0),
+		},
+		{
+			name:     "3 more bytes from node #0, moved to remote nodes",
+			id:       "2",
+			limit:    3,
+			affinity: NewNodeMask(0),
+			zone:     NewNodeMask(0, 2),
+		},
+		{
+			name:     "2 more local bytes from node #0, local nodes exhausted",
+			id:       "3",
+			limit:    2,
+			affinity: NewNodeMask(0),
+			local:    true,
+			fail:     ErrNoLocalMem,
+		},
+		{
+			name:     "more than the capacity of local nodes",
+			id:       "4",
+			limit:    5,
+			affinity: NewNodeMask(1),
+			local:    true,
+			fail:     ErrNoLocalMem,
+		},
+		{
+			name:     "local affinity outside allowed nodes",
+			id:       "5",
+			limit:    1,
+			affinity: NewNodeMask(1),
+			allowed:  NewNodeMask(3),
+			local:    true,
+			fail:     ErrNotAllowed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []RequestOption{
+				WithName(tc.name),
+				WithQosClass("burstable"),
+				WithAllowedNodes(tc.allowed),
+			}
+			if tc.local {
+				opts = append(opts, RequireLocalNode())
+			}
+			req := NewRequest(tc.id, tc.limit, tc.affinity, opts...)
+			require.Equal(t, tc.local, req.IsLocal())
+			require.Equal(t, tc.local, req.IsPinned())
+
+			_, _, err := a.Allocate(req)
+			if tc.fail != nil {
+				require.ErrorIs(t, err, tc.fail, "expected allocation failure")
+				return
+			}
+			require.Nil(t, err, "unexpected allocation failure")
+
+			zone, ok := a.AssignedZone(tc.id)
+			require.True(t, ok, "allocation %s not found", tc.id)
+			require.Equal(t, tc.zone, zone, "allocation %s", tc.id)
+		})
+	}
+
+	zone, ok := a.AssignedZone("1")
+	require.True(t, ok, "local allocation not found")
+	require.Equal(t, NewNodeMask(0), zone, "local allocation moved")
+
+	_, _, err = a.Realloc("1", NewNodeMask(2), 0)
+	require.ErrorIs(t, err, ErrNotAllowed, "expected reallocation failure")
+}
+
+func TestReservedNodes(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 4 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	_, err := NewAllocator(WithNodes(setup.nodes(t)), WithReservedNodes(NewNodeMask(2)))
+	require.ErrorIs(t, err, ErrInvalidNode, "expected failure reserving unknown node")
+	_, err = NewAllocator(WithNodes(setup.nodes(t)), WithReservedNodes(NewNodeMask(0, 1)))
+	require.ErrorIs(t, err, ErrInvalidNodeMask, "expected failure reserving all nodes")
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)), WithReservedNodes(NewNodeMask(1)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+	require.Equal(t, NewNodeMask(1), a.ReservedNodes())
+	require.Equal(t, int64(4), a.UnreservedCapacity())
+
+	type testCase struct {
+		name     string
+		id       string
+		limit    int64
+		affinity NodeMask
+		access   bool
+		zone     NodeMask
+		fail     error
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:     "3 bytes from node #0",
+			id:       "1",
+			limit:    3,
+			affinity: NewNodeMask(0),
+			zone:     NewNodeMask(0),
+		},
+		{
+			name:     "2 more bytes from node #0, can't expand to reserved #1",
+			id:       "2",
+			limit:    2,
+			affinity: NewNodeMask(0),
+			fail:     ErrNoMem,
+		},
+		{
+			name:     "affinity to reserved node #1",
+			id:       "3",
+			limit:    1,
+			affinity: NewNodeMask(1),
+			fail:     ErrNotAllowed,
+		},
+		{
+			name:     "affinity to reserved node #1 with access",
+			id:       "4",
+			limit:    3,
+			affinity: NewNodeMask(1),
+			access:   true,
+			zone:     NewNodeMask(1),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []RequestOption{
+				WithName(tc.name),
+				WithQosClass("burstable"),
+			}
+			if tc.access {
+				opts = append(opts, WithReservedNodeAccess())
+			}
+			zone, _, err := a.Allocate(NewRequest(tc.id, tc.limit, tc.affinity, opts...))
+			if tc.fail != nil {
+				require.ErrorIs(t, err, tc.fail, "expected allocation failure")
+				return
+			}
+			require.Nil(t, err, "unexpected allocation failure")
+			require.Equal(t, tc.zone, zone)
+		})
+	}
+
+	require.ErrorIs(t, a.SetReservedNodes(NewNodeMask(3)), ErrInvalidNode)
+	require.Nil(t, a.SetReservedNodes(0))
+	require.Equal(t, int64(8), a.UnreservedCapacity())
+}
+
+func TestAllocationInfo(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 4 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	_, ok := a.AllocationInfo("1")
+	require.False(t, ok, "info for unknown allocation")
+
+	nodes, _, err := a.Allocate(ContainerWithTypes("1", "ctr1", "guaranteed", 3, NewNodeMask(1), TypeMaskDRAM))
+	require.Nil(t, err, "unexpected allocation failure")
+
+	info, ok := a.AllocationInfo("1")
+	require.True(t, ok, "info for allocation")
+	require.Equal(t, AllocationInfo{
+		ID:       "1",
+		Name:     "ctr1",
+		Zone:     nodes,
+		Affinity: NewNodeMask(1),
+		Amount:   3,
+		Types:    TypeMaskDRAM,
+		Priority: Guaranteed,
+	}, info)
+
+	require.Nil(t, a.Release("1"))
+	_, ok = a.AllocationInfo("1")
+	require.False(t, ok, "info for released allocation")
+}
+
+func TestAllocateBatch(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	_, _, err = a.Allocate(Container("1", "ctr1", "burstable", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+	before := a.Stats()
+
+	// The first request moves the existing allocation to resolve
+	// overcommit, the last one fails. Everything must be rolled back.
+	_, _, err = a.AllocateBatch([]*Request{
+		Container("2", "ctr2", "guaranteed", 60, NewNodeMask(0)),
+		Container("3", "ctr3", "guaranteed", 20, NewNodeMask(1)),
+		Container("4", "ctr4", "guaranteed", 20, NewNodeMask(5)),
+	})
+	require.NotNil(t, err, "unexpected batch allocation success")
+	require.ErrorIs(t, err, ErrInvalidNode)
+	require.Equal(t, before, a.Stats(), "state after failed batch allocation")
+	zone, ok := a.AssignedZone("1")
+	require.True(t, ok)
+	require.Equal(t, NewNodeMask(0), zone, "existing allocation after failed batch allocation")
+	for _, id := range []string{"2", "3", "4"} {
+		_, ok := a.AssignedZone(id)
+		require.False(t, ok, "allocation %s after failed batch allocation", id)
+	}
+
+	// Duplicate requests within a batch fail the whole batch.
+	_, _, err = a.AllocateBatch([]*Request{
+		Container("2", "ctr2", "guaranteed", 10, NewNodeMask(0)),
+		Container("2", "ctr2", "guaranteed", 10, NewNodeMask(0)),
+	})
+	require.ErrorIs(t, err, ErrAlreadyExists)
+	require.Equal(t, before, a.Stats(), "state after failed batch allocation")
+
+	zones, updates, err := a.AllocateBatch([]*Request{
+		Container("2", "ctr2", "guaranteed", 60, NewNodeMask(0)),
+		Container("3", "ctr3", "guaranteed", 20, NewNodeMask(1)),
+	})
+	require.Nil(t, err, "unexpected batch allocation failure")
+	require.Equal(t, []NodeMask{NewNodeMask(0), NewNodeMask(1)}, zones)
+	require.Equal(t, map[string]NodeMask{"1": NewNodeMask(0, 1)}, updates)
+	require.NotEqual(t, int64(0), a.Stats().Moves, "moves of a committed batch")
+
+	zones, updates, err = a.AllocateBatch(nil)
+	require.Nil(t, err, "unexpected empty batch allocation failure")
+	require.Empty(t, zones)
+	require.Nil(t, updates)
+}
+
+func TestAllocatorStats(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	require.Equal(t, AllocatorStats{
+		Nodes: map[ID]NodeStats{
+			0: {Capacity: 100, Free: 100},
+			1: {Capacity: 100, Free: 100},
+		},
+		Allocations: map[Priority]int{},
+	}, a.Stats())
+
+	_, _, err = a.Allocate(Container("1", "ctr1", "burstable", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+
+	offer, err := a.GetOffer(Container("2", "ctr2", "guaranteed", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected offer failure")
+	require.Equal(t, int64(0), a.Stats().Moves, "moves of an uncommitted offer")
+
+	_, _, err = offer.Commit()
+	require.Nil(t, err, "unexpected offer commit failure")
+
+	require.Equal(t, AllocatorStats{
+		Nodes: map[ID]NodeStats{
+			0: {Capacity: 100, Allocated: 90, Free: 10},
+			1: {Capacity: 100, Allocated: 30, Free: 70},
+		},
+		Allocations: map[Priority]int{
+			Burstable:  1,
+			Guaranteed: 1,
+		},
+		Moves: 1,
+	}, a.Stats())
+
+	require.Nil(t, a.Release("1"))
+	stats := a.Stats()
+	require.Equal(t, map[Priority]int{Guaranteed: 1}, stats.Allocations)
+	require.Equal(t, int64(1), stats.Moves, "moves are cumulative")
+}
+
+func TestNodeHeadroom(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	_, err := NewAllocator(WithNodes(setup.nodes(t)), WithNodeHeadroom(1))
+	require.NotNil(t, err, "invalid headroom accepted")
+
+	type testCase struct {
+		name     string
+		options  []AllocatorOption
+		capacity int64
+		result   NodeMask
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:     "no headroom",
+			capacity: 100,
+			result:   NewNodeMask(0),
+		},
+		{
+			name:     "10% headroom",
+			options:  []AllocatorOption{WithNodeHeadroom(0.1)},
+			capacity: 90,
+			result:   NewNodeMask(0, 1),
+		},
+		{
+			name:     "10 bytes reserve",
+			options:  []AllocatorOption{WithNodeReserve(10)},
+			capacity: 90,
+			result:   NewNodeMask(0, 1),
+		},
+		{
+			name:     "5% headroom and 2 bytes reserve",
+			options:  []AllocatorOption{WithNodeHeadroom(0.05), WithNodeReserve(2)},
+			capacity: 93,
+			result:   NewNodeMask(0),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewAllocator(append([]AllocatorOption{WithNodes(setup.nodes(t))}, tc.options...)...)
+			require.Nil(t, err)
+			require.NotNil(t, a)
+
+			require.Equal(t, tc.capacity, a.ZoneCapacity(NewNodeMask(0)), "node capacity")
+			require.Equal(t, 2*tc.capacity, a.ZoneCapacity(NewNodeMask(0, 1)), "zone capacity")
+
+			nodes, _, err := a.Allocate(ContainerWithTypes("1", "ctr1", "burstable", 93, NewNodeMask(0), TypeMaskDRAM))
+			require.Nil(t, err, "unexpected allocation failure")
+			require.Equal(t, tc.result, nodes, "allocated nodes")
+			require.Equal(t, 2*tc.capacity-93, a.ZoneFree(NewNodeMask(0, 1)), "free memory")
+		})
+	}
+}
+
+func TestAddNode(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	_, _, err = a.Allocate(ContainerWithTypes("1", "ctr1", "burstable", 10, NewNodeMask(0), TypeMaskDRAM))
+	require.Nil(t, err, "unexpected allocation failure")
+	_, _, err = a.Allocate(ContainerWithTypes("2", "ctr2", "guaranteed", 10, NewNodeMask(0), TypeMaskDRAM))
+	require.Nil(t, err, "unexpected allocation failure")
+	_, _, err = a.Allocate(ContainerWithTypes("3", "ctr3", "burstable", 10, NewNodeMask(1), TypeMaskDRAM))
+	require.Nil(t, err, "unexpected allocation failure")
+
+	dup, err := NewNode(1, TypeDRAM, 100, false, cpuset.New(), []int{14, 10, 26})
+	require.Nil(t, err)
+	require.NotNil(t, a.AddNode(dup), "duplicate node accepted")
+
+	short, err := NewNode(2, TypeDRAM, 100, false, cpuset.New(), []int{14, 26})
+	require.NotNil(t, err, "node with invalid distances created")
+	require.Nil(t, short)
+
+	gap, err := NewNode(3, TypeDRAM, 100, false, cpuset.New(), []int{14, 26, 30, 10})
+	require.Nil(t, err)
+	require.NotNil(t, a.AddNode(gap), "node with too many distances accepted")
+
+	n, err := NewNode(2, TypeDRAM, 100, false, cpuset.New(), []int{14, 26, 10})
+	require.Nil(t, err)
+	require.Nil(t, a.AddNode(n), "failed to add node")
+
+	require.Equal(t, NewNodeMask(0, 1, 2), a.Masks().NodesByTypes(TypeMaskDRAM), "DRAM nodes")
+	distances := map[ID]int{}
+	a.ForeachNode(NewNodeMask(0, 1), func(n *Node) bool {
+		distances[n.ID()] = n.DistanceTo(2)
+		return ForeachMore
+	})
+	require.Equal(t, map[ID]int{0: 14, 1: 26}, distances, "distances to new node")
+	require.Equal(t, int64(300), a.ZoneCapacity(NewNodeMask(0, 1, 2)), "zone capacity")
+
+	updates, err := a.RebalanceToNode(2, Burstable)
+	require.Nil(t, err, "unexpected rebalancing failure")
+	require.Equal(t, map[string]NodeMask{"1": NewNodeMask(0, 2)}, updates, "rebalanced allocations")
+
+	zone, _ := a.AssignedZone("2")
+	require.Equal(t, NewNodeMask(0), zone, "guaranteed allocation moved")
+	zone, _ = a.AssignedZone("3")
+	require.Equal(t, NewNodeMask(1), zone, "allocation far from new node moved")
+
+	_, err = a.RebalanceToNode(3, Burstable)
+	require.NotNil(t, err, "rebalancing to unknown node accepted")
+}
+
+func TestRealloc(t *testing.T) {
 	var (
 		setup = &testSetup{
 			description: "4 DRAM+4 PMEM NUMA nodes, 4 bytes per node, 2 close CPUs",
@@ -1450,3 +2877,344 @@ func (s *testSetup) nodes(t *testing.T) []*Node {
 
 	return nodes
 }
+
+func TestRankedTypes(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM+2 HBM NUMA nodes, 100 bytes per DRAM, 20 bytes per HBM node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+				TypeHBM, TypeHBM,
+			},
+			capacities: []int64{
+				100, 100,
+				20, 20,
+			},
+			movability: []bool{
+				normal, normal,
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+				{}, {},
+			},
+			distances: [][]int{
+				{10, 21, 14, 26},
+				{21, 10, 26, 14},
+				{14, 26, 10, 28},
+				{26, 14, 28, 10},
+			},
+		}
+		ranked = []Type{TypeHBM, TypeDRAM}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	zone, _, err := a.Allocate(ContainerWithRankedTypes("1", "ctr1", "burstable", 15, NewNodeMask(0), ranked))
+	require.Nil(t, err, "unexpected allocation failure")
+	require.Equal(t, NewNodeMask(2), zone, "preferred type allocated")
+
+	zone, _, err = a.Allocate(ContainerWithRankedTypes("2", "ctr2", "burstable", 10, NewNodeMask(0), ranked))
+	require.Nil(t, err, "unexpected allocation failure")
+	require.Equal(t, NewNodeMask(0), zone, "fallback type allocated")
+
+	updates, err := a.UpgradeRankedTypes(Guaranteed)
+	require.Nil(t, err, "unexpected upgrade failure")
+	require.Nil(t, updates, "allocation upgraded without available memory")
+
+	require.Nil(t, a.Release("1"), "unexpected release failure")
+
+	updates, err = a.UpgradeRankedTypes(NoPriority)
+	require.Nil(t, err, "unexpected upgrade failure")
+	require.Nil(t, updates, "allocation above priority limit upgraded")
+
+	updates, err = a.UpgradeRankedTypes(Guaranteed)
+	require.Nil(t, err, "unexpected upgrade failure")
+	require.Equal(t, map[string]NodeMask{"2": NewNodeMask(2)}, updates, "upgraded allocations")
+
+	info, ok := a.AllocationInfo("2")
+	require.True(t, ok, "allocation not found")
+	require.Equal(t, NewNodeMask(2), info.Zone, "upgraded zone")
+	require.Equal(t, ranked, info.RankedTypes, "ranked types")
+}
+
+func TestSetPriority(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 DRAM NUMA nodes, 100 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				100, 100,
+			},
+			movability: []bool{
+				normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3},
+			},
+			distances: [][]int{
+				{10, 21},
+				{21, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	_, _, err = a.Allocate(Container("1", "ctr1", "burstable", 60, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+	_, _, err = a.Allocate(Container("2", "ctr2", "guaranteed", 30, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+
+	require.NotNil(t, a.SetPriority("unknown", Guaranteed), "unknown allocation accepted")
+	require.NotNil(t, a.SetPriority("1", Reservation), "reservation priority accepted")
+
+	require.Nil(t, a.SetPriority("1", Guaranteed), "failed to raise priority")
+	updates, err := a.SetPriorityAndRebalance("2", Burstable)
+	require.Nil(t, err, "failed to lower priority")
+	require.Nil(t, updates, "allocations moved without overcommit")
+
+	info, _ := a.AllocationInfo("1")
+	require.Equal(t, Guaranteed, info.Priority, "raised priority")
+
+	// With re-tagged priorities, overcommit moves allocation #2 instead of #1.
+	_, updates, err = a.Allocate(Container("3", "ctr3", "guaranteed", 30, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected allocation failure")
+	require.Equal(t, map[string]NodeMask{"2": NewNodeMask(0, 1)}, updates, "moved allocations")
+}
+
+func TestDeterministicPlacement(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 pairs of close DRAM NUMA nodes, 4 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 11, 21, 21},
+				{11, 10, 21, 21},
+				{21, 21, 10, 11},
+				{21, 21, 11, 10},
+			},
+		}
+	)
+
+	type allocation struct {
+		id       string
+		qos      string
+		limit    int64
+		affinity NodeMask
+	}
+
+	sequence := []allocation{
+		{"1", "burstable", 3, NewNodeMask(0)},
+		{"2", "burstable", 3, NewNodeMask(1)},
+		{"3", "burstable", 3, NewNodeMask(2)},
+		{"4", "burstable", 3, NewNodeMask(3)},
+		{"5", "besteffort", 1, NewNodeMask(0)},
+		{"6", "besteffort", 1, NewNodeMask(2)},
+		{"7", "burstable", 2, NewNodeMask(1)},
+		{"8", "burstable", 2, NewNodeMask(3)},
+		{"9", "guaranteed", 3, NewNodeMask(0, 2)},
+		{"10", "besteffort", 1, NewNodeMask(1, 3)},
+	}
+
+	run := func() []string {
+		a, err := NewAllocator(WithNodes(setup.nodes(t)))
+		require.Nil(t, err, "unexpected NewAllocator() error")
+
+		results := []string{}
+		for _, alloc := range sequence {
+			req := Container(alloc.id, alloc.id, alloc.qos, alloc.limit, alloc.affinity)
+			zone, updates, err := a.Allocate(req)
+			results = append(results, fmt.Sprintf("%s: %s %v %v", alloc.id, zone, updates, err))
+		}
+		for _, alloc := range sequence {
+			if zone, ok := a.AssignedZone(alloc.id); ok {
+				results = append(results, fmt.Sprintf("%s: %s", alloc.id, zone))
+			}
+		}
+		return results
+	}
+
+	expected := run()
+	for i := 0; i < 100; i++ {
+		require.Equal(t, expected, run(), "placements differ in run #%d", i)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 pairs of close DRAM NUMA nodes, 4 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 11, 21, 21},
+				{11, 10, 21, 21},
+				{21, 21, 10, 11},
+				{21, 21, 11, 10},
+			},
+		}
+	)
+
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err, "unexpected NewAllocator() error")
+
+	_, _, err = a.Allocate(Container("1", "1", "burstable", 3, NewNodeMask(0)))
+	require.Nil(t, err, "unexpected Allocate() error")
+	_, _, err = a.Allocate(Container("2", "2", "burstable", 3, NewNodeMask(2)))
+	require.Nil(t, err, "unexpected Allocate() error")
+
+	offer, err := a.GetOffer(Container("offer", "offer", "burstable", 1, NewNodeMask(3)))
+	require.Nil(t, err, "unexpected GetOffer() error")
+
+	batch := []*Request{
+		Container("3", "3", "burstable", 3, NewNodeMask(1)),
+		Container("4", "4", "besteffort", 1, NewNodeMask(0)),
+		Container("5", "5", "burstable", 2, NewNodeMask(0)),
+		Container("6", "6", "guaranteed", 3, NewNodeMask(2, 3)),
+		Container("7", "7", "guaranteed", 16, NewNodeMask(3)),
+		Container("1", "1", "burstable", 1, NewNodeMask(0)),
+	}
+
+	res, err := a.Simulate(batch)
+	require.Nil(t, err, "unexpected Simulate() error")
+
+	require.Len(t, res.Failures, 2, "simulated failures")
+	require.ErrorIs(t, res.Failures["7"], ErrNoMem, "simulated failure of too large request")
+	require.ErrorIs(t, res.Failures["1"], ErrAlreadyExists, "simulated failure of existing request")
+	require.NotEmpty(t, res.Overcommits, "simulated overcommits")
+	require.NotZero(t, res.Moves, "simulated moves")
+
+	for _, req := range batch {
+		require.Zero(t, req.Zone(), "simulated request %s changed", req.ID())
+		if req.ID() != "1" {
+			_, ok := a.AssignedZone(req.ID())
+			require.False(t, ok, "simulated request %s allocated", req.ID())
+		}
+	}
+
+	zone, ok := a.AssignedZone("1")
+	require.True(t, ok, "allocation 1 lost")
+	require.Equal(t, NewNodeMask(0), zone, "allocation 1 changed")
+	require.True(t, offer.IsValid(), "offer invalidated by simulation")
+
+	failures := map[string]error{}
+	for _, req := range batch {
+		if _, _, err := a.Allocate(req); err != nil {
+			failures[req.ID()] = err
+		}
+	}
+
+	require.Equal(t, len(res.Failures), len(failures), "actual failures")
+	for id := range res.Failures {
+		require.Contains(t, failures, id, "actual failures")
+	}
+	for id, nodes := range res.Placements {
+		zone, ok := a.AssignedZone(id)
+		require.True(t, ok, "simulated placement %s not allocated", id)
+		require.Equal(t, nodes, zone, "simulated placement of %s", id)
+	}
+}
+
+func TestReleaseAll(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "2 pairs of close DRAM NUMA nodes, 4 bytes per node",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 11, 21, 21},
+				{11, 10, 21, 21},
+				{21, 21, 10, 11},
+				{21, 21, 11, 10},
+			},
+		}
+		requests = func() []*Request {
+			return []*Request{
+				Container("1", "1", "burstable", 3, NewNodeMask(0)),
+				Container("2", "2", "besteffort", 3, NewNodeMask(0)),
+				Container("3", "3", "guaranteed", 2, NewNodeMask(2)),
+			}
+		}
+	)
+
+	fresh, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err, "unexpected NewAllocator() error")
+	a, err := NewAllocator(WithNodes(setup.nodes(t)))
+	require.Nil(t, err, "unexpected NewAllocator() error")
+
+	allocated := map[string]NodeMask{}
+	for _, req := range requests() {
+		nodes, _, err := a.Allocate(req)
+		require.Nil(t, err, "unexpected Allocate() error")
+		allocated[req.ID()] = nodes
+	}
+	for id := range allocated {
+		allocated[id], _ = a.AssignedZone(id)
+	}
+	require.NotZero(t, a.Stats().Moves, "moves before ReleaseAll()")
+
+	offer, err := a.GetOffer(Container("offer", "offer", "burstable", 1, NewNodeMask(3)))
+	require.Nil(t, err, "unexpected GetOffer() error")
+
+	released := a.ReleaseAll()
+	require.Equal(t, allocated, released, "released allocations")
+
+	for id := range allocated {
+		_, ok := a.AssignedZone(id)
+		require.False(t, ok, "allocation %s after ReleaseAll()", id)
+	}
+	require.Equal(t, fresh.Stats(), a.Stats(), "stats after ReleaseAll()")
+
+	_, _, err = offer.Commit()
+	require.ErrorIs(t, err, ErrExpiredOffer, "commit of offer after ReleaseAll()")
+
+	for i, req := range requests() {
+		nodes, updates, err := a.Allocate(req)
+		require.Nil(t, err, "unexpected Allocate() error")
+		expNodes, expUpdates, err := fresh.Allocate(requests()[i])
+		require.Nil(t, err, "unexpected Allocate() error")
+		require.Equal(t, expNodes, nodes, "nodes of %s after ReleaseAll()", req.ID())
+		require.Equal(t, expUpdates, updates, "updates of %s after ReleaseAll()", req.ID())
+	}
+
+	a.Reset()
+	require.Empty(t, a.ReleaseAll(), "allocations after Reset()")
+}
@@ -81,6 +81,48 @@ func WithCustomFunctions(c *CustomFunctions) AllocatorOption {
 	}
 }
 
+// ExpandFunc returns the extra nodes to expand the given zone with for
+// the given memory types.
+type ExpandFunc func(zone NodeMask, types TypeMask) NodeMask
+
+// ExpandContext describes a zone expansion for an Expander.
+type ExpandContext struct {
+	// Zone is the zone to expand.
+	Zone NodeMask
+	// Types are the memory types the expanded zone should provide.
+	Types TypeMask
+	// Allocator is the customized allocator.
+	Allocator CustomAllocator
+}
+
+// Expander is a function to override or wrap zone expansion. It returns
+// the extra nodes to expand the zone in the context with. Any nodes already
+// in the zone are ignored. An expander can call defaultExpand to get the
+// extra nodes the default implementation would use.
+type Expander func(ctx ExpandContext, defaultExpand ExpandFunc) NodeMask
+
+// WithExpander returns an option for overriding zone expansion in an
+// Allocator. It is a shorthand for setting CustomFunctions.ExpandZone.
+// If the expander is nil, the default implementation is used. Options
+// are applied in order, so a later WithCustomFunctions overrides this.
+func WithExpander(e Expander) AllocatorOption {
+	return func(a *Allocator) error {
+		if e == nil {
+			a.custom.ExpandZone = nil
+			return nil
+		}
+		a.custom.ExpandZone = func(zone NodeMask, types TypeMask, ca CustomAllocator) NodeMask {
+			ctx := ExpandContext{
+				Zone:      zone,
+				Types:     types,
+				Allocator: ca,
+			}
+			return e(ctx, ca.DefaultExpandZone)
+		}
+		return nil
+	}
+}
+
 func (c *customAllocator) CheckOvercommit() map[NodeMask]int64 {
 	_, overcommits := c.a.checkOvercommit(0)
 	return overcommits
@@ -108,7 +150,16 @@ func (c *customAllocator) MoveRequest(id string, zone NodeMask) error {
 	if !ok {
 		return fmt.Errorf("%w: no request with ID %s", ErrUnknownRequest, id)
 	}
+	if req.IsPinned() && req.zone != zone {
+		return fmt.Errorf("%w: can't move %s", ErrPinned, req)
+	}
+	if !req.isAllowed(zone) {
+		return fmt.Errorf("%w: can't move %s to %s", ErrNotAllowed, req, zone)
+	}
 
+	if req.zone != zone {
+		c.a.journal.move()
+	}
 	c.a.zoneMove(zone, req)
 	return nil
 }
@@ -171,3 +171,75 @@ func TestCustomExpansion(t *testing.T) {
 		})
 	}
 }
+
+func TestExpander(t *testing.T) {
+	var (
+		setup = &testSetup{
+			description: "4 DRAM nodes, 4 bytes per node, 2 close CPUs",
+			types: []Type{
+				TypeDRAM, TypeDRAM, TypeDRAM, TypeDRAM,
+			},
+			capacities: []int64{
+				4, 4, 4, 4,
+			},
+			movability: []bool{
+				normal, normal, normal, normal,
+			},
+			closeCPUs: [][]int{
+				{0, 1}, {2, 3}, {4, 5}, {6, 7},
+			},
+			distances: [][]int{
+				{10, 21, 11, 21},
+				{21, 10, 21, 11},
+				{11, 21, 10, 21},
+				{21, 11, 21, 10},
+			},
+		}
+	)
+
+	type testCase struct {
+		name     string
+		expander Expander
+		result   NodeMask
+	}
+
+	for _, tc := range []*testCase{
+		{
+			name:   "no expander, default expansion",
+			result: NewNodeMask(0, 2),
+		},
+		{
+			name: "expander wrapping default expansion",
+			expander: func(ctx ExpandContext, defaultExpand ExpandFunc) NodeMask {
+				require.NotNil(t, ctx.Allocator)
+				return defaultExpand(ctx.Zone, ctx.Types)
+			},
+			result: NewNodeMask(0, 2),
+		},
+		{
+			name: "expander overriding default expansion",
+			expander: func(ctx ExpandContext, defaultExpand ExpandFunc) NodeMask {
+				if ctx.Zone == NewNodeMask(0) {
+					return NewNodeMask(1)
+				}
+				return defaultExpand(ctx.Zone, ctx.Types)
+			},
+			result: NewNodeMask(0, 1),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewAllocator(
+				WithNodes(setup.nodes(t)),
+				WithExpander(tc.expander),
+			)
+			require.Nil(t, err)
+			require.NotNil(t, a)
+
+			nodes, _, err := a.Allocate(
+				ContainerWithTypes("1", tc.name, "burstable", 6, NewNodeMask(0), TypeMaskDRAM),
+			)
+			require.Nil(t, err, "unexpected allocation failure")
+			require.Equal(t, tc.result, nodes, "allocated nodes")
+		})
+	}
+}
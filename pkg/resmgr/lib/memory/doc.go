@@ -39,7 +39,25 @@
 // preference results in a failed allocation. The request also has an
 // Priority. This tells the allocator how eagerly it can / easily it
 // should move the allocation to other memory zones later, if some zone
-// runs out of memory due to subsequent allocations.
+// runs out of memory due to subsequent allocations. Finally, a request
+// can be pinned, in which case it is never moved by the allocator to
+// resolve overcommit, regardless of its priority. A request can also be
+// restricted to a set of allowed nodes, for instance to the effective
+// cpuset.mems of its cgroup. The allocator never assigns nodes outside
+// this set to the request, neither initially nor when moving it later,
+// and fails the request if the allowed nodes cannot satisfy it. Nodes
+// can also be reserved in the allocator, for instance for system daemons.
+// Reserved nodes are excluded from the allowed nodes of all requests, but
+// those given access to them. Finally, a request can require local memory,
+// in which case it is pinned and restricted to its affinity nodes. Such a
+// request never touches remote memory; it fails with ErrNoLocalMem if
+// its affinity nodes run out of memory.
+//
+// Affinity can span multiple nodes. For instance, with sub-NUMA clustering
+// a CPU die can have several memory nodes, and DieNodes returns the nodes
+// of a die for use as the affinity of requests which should get memory
+// from anywhere in the die. When expanding a zone, the allocator prefers
+// nodes in the same dies as the zone over other nodes at equal distance.
 //
 // # Allocation Algorithm, Initial Zone Selection
 //
@@ -47,7 +65,15 @@
 // zone is the closest zone that satisfies the type preferences of the
 // request and has at least one node with normal memory (as opposed to
 // movable memory). Note that for non-strict requests this zone might not
-// have all the preferred types.
+// have all the preferred types. A request can also ask to be placed near
+// other existing allocations, in which case the search starts from the
+// zone of those allocations instead of the affinity of the request.
+//
+// A type preference can also be ranked, listing types in decreasing order
+// of preference. For ranked requests the initial zone is the closest set
+// of nodes of the most preferred type with enough memory available for
+// the request. UpgradeRankedTypes can later be used to move such requests
+// to nodes of a more preferred type, once those have enough free memory.
 //
 // # Allocation Algorithm, Overcommit Handling
 //
@@ -59,15 +85,36 @@
 // it is assigned to (IOW satisfied using) the zone, or another zone with
 // a subset of the nodes of the first zone. For instance, allocations with
 // assigned zones {0}, {2}, and {0, 2}, fully fit into {0, 1, 2, 3}, while
-// zones {0, 4}, {2, 5}, or {0, 2, 4, 5} do not.
+// zones {0, 4}, {2, 5}, or {0, 2, 4, 5} do not. If the allocator is
+// configured with node headroom or reserve, the memory available in each
+// node is reduced accordingly, leaving some breathing room to the kernel.
 //
 // If any zone is oversubscribed, an overcommit handling algorithm kicks in
 // to reduce memory usage in overcommitted nodes. Memory usage is reduced by
 // moving allocations from the zone to a new one with a superset of nodes
 // of the original zone. An expansion algorithm using node affinity, types
 // and distance vectors is used to determine the superset zone. Overcommit
-// handling prefers moving allocations with lower priority first. Allocation
-// fails if the overcommit handler cannot resolve all overcommit.
+// handling prefers moving allocations with lower priority first. Pinned
+// allocations are never moved. Allocation fails if the overcommit handler
+// cannot resolve all overcommit. The priority of an existing allocation
+// can be changed using SetPriority, or SetPriorityAndRebalance which also
+// resolves any overcommit the new priority allows to.
+//
+// # Adding Nodes
+//
+// Nodes which come online at runtime, for instance hotplugged CXL memory,
+// can be added to an existing Allocator using AddNode. Existing allocations
+// are left untouched by AddNode. RebalanceToNode can be used to add a new
+// node to the zone of nearby allocations with low enough priority, letting
+// these use memory of the new node.
+//
+// # Resetting an Allocator
+//
+// Reset releases all allocations of an Allocator at once, for instance when
+// a policy is reconfigured and all allocations are going to be recreated.
+// ReleaseAll does the same and returns the nodes of released allocations.
+// Nodes and reserved nodes are kept, and subsequent allocations behave as
+// on a newly created Allocator.
 //
 // # Customizing an Allocator
 //
@@ -75,7 +122,10 @@
 // limited is to set up the Allocator with a curated set of node distance
 // vectors. Since node expansion looks at the distance vectors to decide
 // how to expand a zone, by altering the distance vector one can change the
-// the order and set of new nodes considered during zone expansion.
+// the order and set of new nodes considered during zone expansion. Distances
+// can also be scaled per memory type using WithTypeDistanceWeights, for
+// instance to make going to or from remote HBM more expensive than going to
+// remote DRAM.
 //
 // Another more involved but direct and more flexible way to customize an
 // Allocator is to explicitly set it up with custom functions for node
@@ -85,6 +135,8 @@
 // functions get access to the built-in default implementations. Often
 // this allows implementing exception style extra handling just for cases
 // of special interest but otherwise rely on the default implementations.
+// Custom functions are set up using the WithCustomFunctions option. For
+// node expansion alone, the WithExpander option provides a shorthand.
 //
 // # Allocation Offers
 //
@@ -99,5 +151,39 @@
 // Allocators state with those details. Multiple parallel offers can be
 // queried at any time. An offer, but only a single offer, can then be
 // turned into an allocation by committing it, once the best allocation
-// alternative has been determined.
+// alternative has been determined. When only a yes or no answer, together
+// with the nodes that would be used, is needed, for instance for admission
+// checks, WouldFit provides a lighter alternative to offers. PreviewMoves
+// also tells which existing allocations would be moved to resolve
+// overcommit, so that an allocation disturbing too many of them can be
+// rejected before it is made.
+//
+// Offers can be requested, checked for validity, and committed from
+// multiple goroutines concurrently. Committing an offer invalidates all
+// other offers, as does any other operation which changes allocations.
+// Committing an invalidated offer fails with ErrExpiredOffer. The same
+// holds for allocating, reallocating and releasing memory, which are all
+// serialized by the Allocator. Zone and node queries and iteration are
+// not serialized, since custom functions use them while an operation is
+// in progress.
+//
+// # Reservations
+//
+// Reserve sets aside memory for a named allocation expected in the future,
+// for instance for a pre-created balloon which has no containers yet. A
+// reservation is accounted like an immovable allocation, so it is taken
+// into account when resolving overcommit, but reserving only takes free
+// memory and never moves existing allocations. A reservation can be
+// released, or turned into an actual allocation once the expected request
+// arrives. Reserved memory is listed by name in the Allocator statistics.
+//
+// # Simulation
+//
+// Simulate applies a whole batch of requests to a scratch copy of an
+// Allocator, without changing its state. The result tells where each
+// request would be placed, which overcommits would be resolved by moving
+// existing allocations, and which requests would fail. This can be used
+// to check whether a set of workloads fits the memory topology of a node
+// before deploying them. Allocation is deterministic, so allocating the
+// same requests in the same order afterwards yields the same result.
 package libmem
@@ -22,10 +22,14 @@ var (
 	ErrInvalidNode     = fmt.Errorf("libmem: invalid node")
 	ErrInvalidNodeMask = fmt.Errorf("libmem: invalid NodeMask")
 	ErrInvalidQosClass = fmt.Errorf("libmem: invalid QoS class")
+	ErrInvalidPriority = fmt.Errorf("libmem: invalid priority")
 	ErrExpiredOffer    = fmt.Errorf("libmem: expired offer")
 	ErrUnknownRequest  = fmt.Errorf("libmem: unknown allocation")
 	ErrAlreadyExists   = fmt.Errorf("libmem: allocation already exists")
 	ErrNoMem           = fmt.Errorf("libmem: insufficient available memory")
+	ErrNoLocalMem      = fmt.Errorf("libmem: insufficient available local memory")
 	ErrNoZone          = fmt.Errorf("libmem: failed to find zone")
+	ErrPinned          = fmt.Errorf("libmem: allocation is pinned")
+	ErrNotAllowed      = fmt.Errorf("libmem: nodes not allowed")
 	ErrInternalError   = fmt.Errorf("libmem: internal error")
 )
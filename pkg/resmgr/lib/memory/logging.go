@@ -61,6 +61,18 @@ func (a *Allocator) DumpNodes(context ...interface{}) {
 func (a *Allocator) DumpConfig(context ...interface{}) {
 	prefix := formatPrefix(context...)
 	log.Info("%smemory allocator configuration", prefix)
+	if a.headroom > 0 || a.reserve > 0 {
+		log.Info("%s  node headroom %.2f%%, reserve %s", prefix, 100*a.headroom, prettySize(a.reserve))
+	}
+	if a.reserved != 0 {
+		log.Info("%s  reserved nodes %s, %s capacity left unreserved", prefix, a.reserved,
+			prettySize(a.UnreservedCapacity()))
+	}
+	for _, t := range []Type{TypeDRAM, TypePMEM, TypeHBM} {
+		if w, ok := a.weights[t]; ok {
+			log.Info("%s  %s distance weight %.2f", prefix, t, w)
+		}
+	}
 	a.DumpNodes(prefix)
 }
 
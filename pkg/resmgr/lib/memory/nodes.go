@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+	idset "github.com/intel/goresctrl/pkg/utils"
 )
 
 // Node represents a memory node with some amount and type of attached memory.
@@ -198,7 +199,8 @@ func (d *Distance) NodesAt(dist int) NodeMask {
 }
 
 type (
-	// NodeMask represents a set of node IDs as a bit mask.
+	// NodeMask represents a set of node IDs as a bit mask. It can hold
+	// IDs from 0 to MaxNodeID.
 	NodeMask uint64
 )
 
@@ -212,6 +214,17 @@ func NewNodeMask(ids ...ID) NodeMask {
 	return NodeMask(0).Set(ids...)
 }
 
+// NodeMaskFromIDSet returns a NodeMask with the IDs in the given IDSet.
+func NodeMaskFromIDSet(ids idset.IDSet) NodeMask {
+	return NewNodeMask(ids.Members()...)
+}
+
+// NodeMaskFromCPUSet returns a NodeMask with the IDs in the given CPUSet.
+// This is useful for memory sets stored as a cpuset.CPUSet.
+func NodeMaskFromCPUSet(ids cpuset.CPUSet) NodeMask {
+	return NewNodeMask(ids.UnsortedList()...)
+}
+
 // ParseNodeMask parses the given string representation of a NodeMask.
 func ParseNodeMask(str string) (NodeMask, error) {
 	m := NodeMask(0)
@@ -281,10 +294,22 @@ func (m NodeMask) Slice() []ID {
 	return ids
 }
 
+// IDSet returns the node IDs stored in the NodeMask as an IDSet.
+func (m NodeMask) IDSet() idset.IDSet {
+	return idset.NewIDSet(m.Slice()...)
+}
+
+// CPUSet returns the node IDs stored in the NodeMask as a CPUSet. This
+// is useful for memory sets stored as a cpuset.CPUSet.
+func (m NodeMask) CPUSet() cpuset.CPUSet {
+	return cpuset.New(m.Slice()...)
+}
+
 // Set returns a NodeMask with both the original and the given IDs added.
+// IDs outside 0 - MaxNodeID can't be stored in a NodeMask and are ignored.
 func (m NodeMask) Set(ids ...ID) NodeMask {
 	for _, id := range ids {
-		m |= (1 << id)
+		m |= nodeBit(id)
 	}
 	return m
 }
@@ -292,7 +317,7 @@ func (m NodeMask) Set(ids ...ID) NodeMask {
 // Clear returns a NodeMask with the given IDs removed.
 func (m NodeMask) Clear(ids ...ID) NodeMask {
 	for _, id := range ids {
-		m &^= (1 << id)
+		m &^= nodeBit(id)
 	}
 	return m
 }
@@ -300,7 +325,7 @@ func (m NodeMask) Clear(ids ...ID) NodeMask {
 // Contains returns true if all the given IDs are present in the NodeMask.
 func (m NodeMask) Contains(ids ...ID) bool {
 	for _, id := range ids {
-		if (m & (1 << id)) == 0 {
+		if bit := nodeBit(id); bit == 0 || (m&bit) == 0 {
 			return false
 		}
 	}
@@ -310,13 +335,22 @@ func (m NodeMask) Contains(ids ...ID) bool {
 // ContainsAny returns true if any of the given IDs are present in the NodeMask.
 func (m NodeMask) ContainsAny(ids ...ID) bool {
 	for _, id := range ids {
-		if (m & (1 << id)) != 0 {
+		if (m & nodeBit(id)) != 0 {
 			return true
 		}
 	}
 	return false
 }
 
+// nodeBit returns the NodeMask bit for the given ID, or 0 for IDs out of
+// range.
+func nodeBit(id ID) NodeMask {
+	if id < 0 || id > MaxNodeID {
+		return 0
+	}
+	return 1 << id
+}
+
 // And returns a NodeMask with all IDs which are present in both NodeMasks.
 func (m NodeMask) And(o NodeMask) NodeMask {
 	return m & o
@@ -332,6 +366,39 @@ func (m NodeMask) AndNot(o NodeMask) NodeMask {
 	return m &^ o
 }
 
+// Union returns a NodeMask with all IDs which are present in either NodeMask.
+// It is equivalent to Or.
+func (m NodeMask) Union(o NodeMask) NodeMask {
+	return m | o
+}
+
+// Intersection returns a NodeMask with all IDs which are present in both
+// NodeMasks. It is equivalent to And.
+func (m NodeMask) Intersection(o NodeMask) NodeMask {
+	return m & o
+}
+
+// Difference returns a NodeMask with all IDs which are present in m but not
+// in o. It is equivalent to AndNot.
+func (m NodeMask) Difference(o NodeMask) NodeMask {
+	return m &^ o
+}
+
+// IsEmpty returns true if no IDs are present in the NodeMask.
+func (m NodeMask) IsEmpty() bool {
+	return m == 0
+}
+
+// IsSubsetOf returns true if all IDs present in m are also present in o.
+func (m NodeMask) IsSubsetOf(o NodeMask) bool {
+	return m&^o == 0
+}
+
+// Intersects returns true if any ID is present in both NodeMasks.
+func (m NodeMask) Intersects(o NodeMask) bool {
+	return m&o != 0
+}
+
 // Size returns the number of IDs present in the NodeMask.
 func (m NodeMask) Size() int {
 	return bits.OnesCount64(uint64(m))
@@ -20,6 +20,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	. "github.com/containers/nri-plugins/pkg/resmgr/lib/memory"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
+	idset "github.com/intel/goresctrl/pkg/utils"
 )
 
 func TestParseNodeMask(t *testing.T) {
@@ -137,3 +139,61 @@ func TestMemsetString(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeMaskRoundTrip(t *testing.T) {
+	for _, mask := range []NodeMask{
+		0,
+		NewNodeMask(0),
+		NewNodeMask(MaxNodeID),
+		NewNodeMask(0, 1, 2, 5, 6, 9, 31, 32, 33, 62, MaxNodeID),
+		^NodeMask(0),
+	} {
+		t.Run(mask.MemsetString(), func(t *testing.T) {
+			parsed, err := ParseNodeMask(mask.MemsetString())
+			require.NoError(t, err)
+			require.Equal(t, mask, parsed, "MemsetString/ParseNodeMask")
+
+			require.Equal(t, mask, NodeMaskFromIDSet(mask.IDSet()), "IDSet/NodeMaskFromIDSet")
+			require.Equal(t, mask, NodeMaskFromCPUSet(mask.CPUSet()), "CPUSet/NodeMaskFromCPUSet")
+			require.Equal(t, mask.Size(), mask.IDSet().Size(), "IDSet size")
+			require.Equal(t, mask.MemsetString(), mask.CPUSet().String(), "CPUSet string")
+		})
+	}
+
+	_, err := ParseNodeMask("0,64")
+	require.Error(t, err, "node ID above MaxNodeID")
+	require.Equal(t, NewNodeMask(0), NodeMaskFromIDSet(idset.NewIDSet(0, MaxNodeID+1)),
+		"IDs above MaxNodeID should be ignored")
+	require.Equal(t, NewNodeMask(1), NodeMaskFromCPUSet(cpuset.New(1, 100)),
+		"IDs above MaxNodeID should be ignored")
+}
+
+func TestNodeMaskSetOperations(t *testing.T) {
+	var (
+		m1 = MustParseNodeMask("0-3,40")
+		m2 = MustParseNodeMask("2-5,63")
+	)
+
+	require.Equal(t, MustParseNodeMask("0-5,40,63"), m1.Union(m2))
+	require.Equal(t, MustParseNodeMask("2-3"), m1.Intersection(m2))
+	require.Equal(t, MustParseNodeMask("0-1,40"), m1.Difference(m2))
+	require.Equal(t, m1.Or(m2), m1.Union(m2))
+	require.Equal(t, m1.And(m2), m1.Intersection(m2))
+	require.Equal(t, m1.AndNot(m2), m1.Difference(m2))
+
+	require.True(t, m1.Intersects(m2))
+	require.False(t, m1.Intersects(MustParseNodeMask("10-20")))
+	require.True(t, MustParseNodeMask("1-2").IsSubsetOf(m1))
+	require.False(t, m2.IsSubsetOf(m1))
+	require.True(t, NodeMask(0).IsSubsetOf(m1))
+	require.True(t, NodeMask(0).IsEmpty())
+	require.False(t, m1.IsEmpty())
+	require.Equal(t, 5, m1.Size())
+
+	require.True(t, m2.Contains(2, 63))
+	require.False(t, m2.Contains(2, 64))
+	require.False(t, m2.Contains(-1))
+	require.False(t, m2.ContainsAny(-1, 64))
+	require.Equal(t, m2, m2.Set(-1, 64))
+	require.Equal(t, m2, m2.Clear(-1, 64))
+}
@@ -32,7 +32,13 @@ type Request struct {
 	affinity NodeMask // nodes to start allocating memory from
 	types    TypeMask // types of nodes to use for fulfilling the request
 	strict   bool     // strict preference for types
+	ranked   []Type   // types in decreasing order of preference
 	priority Priority // larger priority means more reluctance to move a request
+	pinned   bool     // never move this request to resolve overcommit
+	near     []string // IDs of allocations to co-locate this request with
+	allowed  NodeMask // nodes the request is allowed to use, 0 for any
+	reserved bool     // request can use reserved nodes
+	local    bool     // request must stay on its affinity nodes
 	zone     NodeMask // the nodes allocated for the request, ideally == affinity
 	created  int64    // timestamp of creation for this request
 }
@@ -91,6 +97,18 @@ func ContainerWithStrictTypes(id, name, qos string, limit int64, affin NodeMask,
 	return NewRequest(id, limit, affin, opts...)
 }
 
+// ContainerWithRankedTypes is a convenience function to create a request
+// with ranked memory type preference for a container of a particular QoS
+// class. The QoS class is used to set the priority for the request.
+func ContainerWithRankedTypes(id, name, qos string, limit int64, affin NodeMask, ranked []Type) *Request {
+	opts := []RequestOption{
+		WithName(name),
+		WithQosClass(qos),
+		WithRankedTypes(ranked...),
+	}
+	return NewRequest(id, limit, affin, opts...)
+}
+
 // PreserveContainer is a convenience function to create an allocation
 // request for a container with 'preserved' memory. Such a request has
 // higher priority than other, ordinary requests. The allocator tries to
@@ -133,6 +151,18 @@ func WithStrictTypes(types TypeMask) RequestOption {
 	}
 }
 
+// WithRankedTypes returns an option to set memory types for a request in
+// decreasing order of preference. The allocator picks the closest nodes of
+// the most preferred type with enough memory available for the request.
+// Later, UpgradeRankedTypes can move the request to more preferred types
+// once memory of those becomes available.
+func WithRankedTypes(ranked ...Type) RequestOption {
+	return func(r *Request) {
+		r.ranked = slices.Clone(ranked)
+		r.types = NewTypeMask(ranked...)
+	}
+}
+
 // WithPriority returns an option to set the priority of a request.
 func WithPriority(p Priority) RequestOption {
 	return func(r *Request) {
@@ -140,6 +170,57 @@ func WithPriority(p Priority) RequestOption {
 	}
 }
 
+// WithPinned returns an option to pin a request. Pinned requests are never
+// moved to another zone when resolving zone overcommit. Instead, other
+// requests are moved or the allocation fails. Note that pinning is not the
+// same as priority: requests of any priority can be pinned.
+func WithPinned() RequestOption {
+	return func(r *Request) {
+		r.pinned = true
+	}
+}
+
+// WithReservedNodeAccess returns an option to let a request use nodes
+// reserved in the allocator. Preserved requests and memory reservations
+// can always use reserved nodes.
+func WithReservedNodeAccess() RequestOption {
+	return func(r *Request) {
+		r.reserved = true
+	}
+}
+
+// RequireLocalNode returns an option to keep a request on the nodes of its
+// affinity, typically the nodes directly attached to the CPUs of the
+// workload. The request is never expanded to, or moved to, other nodes.
+// Local requests are also pinned. If the affinity nodes can't fit the
+// request, allocation fails with ErrNoLocalMem.
+func RequireLocalNode() RequestOption {
+	return func(r *Request) {
+		r.local = true
+		r.pinned = true
+	}
+}
+
+// NearAllocations returns an option to bias the initial zone of a request
+// towards the zones of the given existing allocations. This is useful for
+// co-locating the memory of cooperating workloads. Unknown IDs are ignored.
+// If none of the IDs are known, normal affinity is used.
+func NearAllocations(ids ...string) RequestOption {
+	return func(r *Request) {
+		r.near = slices.Clone(ids)
+	}
+}
+
+// WithAllowedNodes returns an option to restrict a request to the given
+// nodes, for instance to the effective cpuset.mems of a container cgroup.
+// Neither the initial zone of the request, nor any zone it is later moved
+// to, will contain nodes outside the allowed ones.
+func WithAllowedNodes(nodes NodeMask) RequestOption {
+	return func(r *Request) {
+		r.allowed = nodes
+	}
+}
+
 // WithQosClass returns an option to set the priority of a request based on a QoS class.
 func WithQosClass(qosClass string) RequestOption {
 	switch strings.ToLower(qosClass) {
@@ -213,6 +294,14 @@ func (r *Request) String() string {
 		kind = r.priority.String() + " workload"
 	}
 
+	if r.pinned {
+		kind = "pinned " + kind
+	}
+
+	if r.local {
+		kind = "local " + kind
+	}
+
 	if size == "0" {
 		size = ""
 	} else {
@@ -247,11 +336,48 @@ func (r *Request) Types() TypeMask {
 	return r.types
 }
 
+// RankedTypes returns the memory types for this request in decreasing order
+// of preference, if set.
+func (r *Request) RankedTypes() []Type {
+	return r.ranked
+}
+
 // IsStrict returns whether the type preference for this request is strict.
 func (r *Request) IsStrict() bool {
 	return r.strict
 }
 
+// IsPinned returns whether this request is pinned to its zone.
+func (r *Request) IsPinned() bool {
+	return r.pinned
+}
+
+// Near returns the IDs of allocations this request should be co-located with.
+func (r *Request) Near() []string {
+	return r.near
+}
+
+// IsLocal returns whether this request must stay on its affinity nodes.
+func (r *Request) IsLocal() bool {
+	return r.local
+}
+
+// AllowedNodes returns the nodes this request is allowed to use, or 0 if
+// the request is not restricted.
+func (r *Request) AllowedNodes() NodeMask {
+	return r.allowed
+}
+
+// canUseReserved returns true if the request can use reserved nodes.
+func (r *Request) canUseReserved() bool {
+	return r.reserved || r.priority >= Preserved
+}
+
+// isAllowed returns true if the request is allowed to use a zone.
+func (r *Request) isAllowed(zone NodeMask) bool {
+	return r.allowed == 0 || (zone&^r.allowed) == 0
+}
+
 // Priority returns the priority for this request.
 func (r *Request) Priority() Priority {
 	return r.priority
@@ -298,7 +424,9 @@ func NewID() string {
 
 // SortRequests filters the requests by a filter function into a slice,
 // then sorts the slice by chaining the given sorting functions. A nil
-// filter function picks all requests.
+// filter function picks all requests. Requests which compare equal by
+// all sorting functions are ordered by their IDs, so the result never
+// depends on map iteration order.
 func SortRequests(requests map[string]*Request, f RequestFilter, s ...RequestSorter) []*Request {
 	slice := make([]*Request, 0, len(requests))
 	for _, req := range requests {
@@ -313,7 +441,7 @@ func SortRequests(requests map[string]*Request, f RequestFilter, s ...RequestSor
 					return diff
 				}
 			}
-			return 0
+			return strings.Compare(r1.ID(), r2.ID())
 		})
 	}
 	return slice
@@ -332,6 +460,11 @@ func RequestsWithMaxPriority(limit Priority) RequestFilter {
 	}
 }
 
+// RequestsNotPinned filters out pinned requests.
+func RequestsNotPinned(r *Request) bool {
+	return !r.IsPinned()
+}
+
 // RequestsByPriority compares requests by increasing priority.
 func RequestsByPriority(r1, r2 *Request) int {
 	return int(r1.Priority() - r2.Priority())
@@ -0,0 +1,134 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libmem
+
+import (
+	"fmt"
+)
+
+// ReservationHandle refers to memory capacity reserved for a future
+// allocation. Reserved capacity is accounted like an allocation which is
+// never moved, so other allocations can't overcommit it. A reservation
+// can be released, or turned into an actual allocation.
+type ReservationHandle struct {
+	a    *Allocator
+	id   string
+	name string
+}
+
+// Reserve reserves the given amount of memory from the given nodes for a
+// named future allocation. Reserving only uses free capacity. If existing
+// allocations would need to be moved to make room for the reservation,
+// Reserve fails with ErrNoMem.
+func (a *Allocator) Reserve(name string, nodes NodeMask, amount int64) (*ReservationHandle, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	req := ReservedMemory(amount, nodes, WithName(name), RequireLocalNode())
+
+	log.Debug("reserve %s memory from nodes %s for %s", prettySize(amount), nodes, name)
+	defer a.validateState("Reserve")
+	defer a.cleanupUnusedZones()
+
+	if err := a.allocate(req); err != nil {
+		return nil, err
+	}
+
+	moved := 0
+	for id := range a.journal.updates {
+		if id != req.ID() {
+			moved++
+		}
+	}
+	if moved > 0 {
+		if _, err := a.revertJournal(req); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: reserving memory for %s would move %d allocations",
+			ErrNoMem, name, moved)
+	}
+
+	a.commitJournal(req)
+	a.reservations[req.ID()] = name
+	a.invalidateOffers()
+
+	return &ReservationHandle{a: a, id: req.ID(), name: name}, nil
+}
+
+// ID returns the ID of the reservation. The reservation can also be
+// released using this ID with Release.
+func (h *ReservationHandle) ID() string {
+	return h.id
+}
+
+// Name returns the name of the reservation.
+func (h *ReservationHandle) Name() string {
+	return h.name
+}
+
+// Release releases the reserved memory.
+func (h *ReservationHandle) Release() error {
+	return h.a.Release(h.id)
+}
+
+// Allocate turns the reservation into an actual allocation for the given
+// request. The reserved memory is released and the request is allocated
+// as by Allocate. If allocating the request fails, the reservation is
+// kept.
+func (h *ReservationHandle) Allocate(req *Request) (NodeMask, map[string]NodeMask, error) {
+	a := h.a
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	res, ok := a.requests[h.id]
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: no reservation with ID %s", ErrUnknownRequest, h.id)
+	}
+
+	log.Debug("allocate reserved memory of %s for %s", h.name, req)
+	defer a.validateState("ReservationHandle.Allocate")
+	defer a.cleanupUnusedZones()
+
+	zone := res.zone
+	if err := a.release(res); err != nil {
+		return 0, nil, err
+	}
+
+	if err := a.allocate(req); err != nil {
+		a.zoneAssign(zone, res)
+		a.requests[res.ID()] = res
+		a.reservations[res.ID()] = h.name
+		return 0, nil, err
+	}
+
+	return req.zone, a.commitJournal(req), nil
+}
+
+// reservationStats returns the amount of memory reserved by name, or nil
+// if there are no reservations.
+func (a *Allocator) reservationStats() map[string]int64 {
+	if len(a.reservations) == 0 {
+		return nil
+	}
+
+	stats := make(map[string]int64, len(a.reservations))
+	for id, name := range a.reservations {
+		if req, ok := a.requests[id]; ok {
+			stats[name] += req.Size()
+		}
+	}
+	return stats
+}
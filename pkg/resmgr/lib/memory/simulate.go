@@ -0,0 +1,144 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libmem
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// SimResult is the outcome of simulating a batch of allocation requests.
+type SimResult struct {
+	// Placements are the nodes of successfully allocated requests, once
+	// the whole batch has been applied.
+	Placements map[string]NodeMask
+	// Overcommits are the overcommits resolved by moving allocations, in
+	// the order they occurred.
+	Overcommits []SimOvercommit
+	// Moves is the number of allocations moved to resolve overcommit.
+	Moves int64
+	// Failures are the errors for requests which could not be allocated.
+	Failures map[string]error
+}
+
+// SimOvercommit describes an overcommit caused by a simulated request.
+type SimOvercommit struct {
+	// ID is the ID of the request which caused the overcommit.
+	ID string
+	// Updates are the new nodes of the allocations moved to resolve it.
+	Updates map[string]NodeMask
+}
+
+// Simulate applies a batch of requests, in the given order, to a scratch
+// copy of the Allocator and returns the resulting placements, overcommit
+// resolutions and failures. Neither the Allocator nor the requests are
+// changed, and no offers are invalidated. Since allocation is deterministic,
+// allocating the same requests in the same order yields the same result,
+// provided the state of the Allocator does not change in between.
+func (a *Allocator) Simulate(requests []*Request) (SimResult, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	log.Debug("simulate allocation of %d requests", len(requests))
+
+	for i, req := range requests {
+		if req == nil {
+			return SimResult{}, fmt.Errorf("%w: nil request #%d in simulation", ErrUnknownRequest, i)
+		}
+	}
+
+	sim := a.scratchCopy()
+	res := SimResult{
+		Placements: make(map[string]NodeMask),
+		Failures:   make(map[string]error),
+	}
+
+	for _, r := range requests {
+		req := r.clone()
+		if err := sim.allocate(req); err != nil {
+			log.Debug("  - simulated %s failed: %v", req, err)
+			res.Failures[req.ID()] = err
+			continue
+		}
+
+		sim.cleanupUnusedZones()
+
+		if updates := sim.commitJournal(req); len(updates) > 0 {
+			res.Overcommits = append(res.Overcommits, SimOvercommit{
+				ID:      req.ID(),
+				Updates: updates,
+			})
+		}
+		res.Placements[req.ID()] = 0
+	}
+
+	for id := range res.Placements {
+		res.Placements[id] = sim.users[id]
+	}
+	res.Moves = sim.moves - a.moves
+
+	return res, nil
+}
+
+// scratchCopy returns a copy of the allocator which can be freely changed
+// without affecting the original one. Nodes, masks, dies and custom functions
+// are not changed by allocation and are therefore shared with the copy.
+func (a *Allocator) scratchCopy() *Allocator {
+	c := &Allocator{
+		nodes:    a.nodes,
+		requests: make(map[string]*Request, len(a.requests)),
+		zones:    make(map[NodeMask]*Zone, len(a.zones)),
+		users:    maps.Clone(a.users),
+		masks:    a.masks,
+		version:  a.version,
+		custom:   a.custom,
+		headroom: a.headroom,
+		reserve:  a.reserve,
+		reserved: a.reserved,
+		moves:    a.moves,
+		weights:  a.weights,
+		dies:     a.dies,
+
+		reservations: maps.Clone(a.reservations),
+	}
+
+	for id, req := range a.requests {
+		c.requests[id] = req.clone()
+	}
+
+	for nodes, zone := range a.zones {
+		z := &Zone{
+			nodes:    zone.nodes,
+			types:    zone.types,
+			capacity: zone.capacity,
+			users:    make(map[string]*Request, len(zone.users)),
+		}
+		for id := range zone.users {
+			z.users[id] = c.requests[id]
+		}
+		c.zones[nodes] = z
+	}
+
+	return c
+}
+
+// clone returns a copy of the request.
+func (r *Request) clone() *Request {
+	c := *r
+	c.ranked = slices.Clone(r.ranked)
+	c.near = slices.Clone(r.near)
+	return &c
+}
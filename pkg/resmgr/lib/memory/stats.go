@@ -0,0 +1,92 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libmem
+
+// AllocatorStats is a snapshot of memory usage and overcommit handling in
+// an allocator, meant for capacity planning and detecting thrashing.
+type AllocatorStats struct {
+	Nodes          map[ID]NodeStats // memory usage per node
+	Allocations    map[Priority]int // number of allocations by priority
+	Oversubscribed int              // number of currently oversubscribed zones
+	Moves          int64            // allocations moved to resolve overcommit
+	Reservations   map[string]int64 // memory reserved by reservation name
+}
+
+// NodeStats describes memory usage of a single node. Allocations which
+// span several nodes are accounted to their nodes in proportion to node
+// capacity. Free memory is negative if the node is oversubscribed.
+type NodeStats struct {
+	Capacity  int64 // effective capacity, without headroom or reserve
+	Allocated int64 // amount of memory allocated
+	Free      int64 // amount of memory not allocated
+}
+
+// Stats returns a consistent snapshot of allocator statistics.
+func (a *Allocator) Stats() AllocatorStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	stats := AllocatorStats{
+		Nodes:        make(map[ID]NodeStats, len(a.nodes)),
+		Allocations:  make(map[Priority]int),
+		Moves:        a.moves,
+		Reservations: a.reservationStats(),
+	}
+
+	for id, n := range a.nodes {
+		stats.Nodes[id] = NodeStats{
+			Capacity: a.nodeCapacity(n),
+		}
+	}
+
+	usage := make(map[NodeMask]int64, len(a.zones))
+	for nodes, z := range a.zones {
+		capacity := a.zoneCapacity(nodes)
+		for _, req := range z.users {
+			stats.Allocations[req.Priority()]++
+			usage[nodes] += req.Size()
+			if capacity == 0 {
+				continue
+			}
+			(nodes & a.masks.nodes.hasMemory).Foreach(func(id ID) bool {
+				ns := stats.Nodes[id]
+				ns.Allocated += int64(float64(req.Size()) * float64(ns.Capacity) / float64(capacity))
+				stats.Nodes[id] = ns
+				return ForeachMore
+			})
+		}
+	}
+
+	for id, ns := range stats.Nodes {
+		ns.Free = ns.Capacity - ns.Allocated
+		stats.Nodes[id] = ns
+	}
+
+	// An allocation is considered to belong to a zone if its nodes
+	// fully fit into the zone.
+	for zone := range a.zones {
+		used := int64(0)
+		for nodes, amount := range usage {
+			if (zone & nodes) == nodes {
+				used += amount
+			}
+		}
+		if used > a.zoneCapacity(zone) {
+			stats.Oversubscribed++
+		}
+	}
+
+	return stats
+}
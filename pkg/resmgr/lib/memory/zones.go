@@ -14,7 +14,10 @@
 
 package libmem
 
-import "slices"
+import (
+	"cmp"
+	"slices"
+)
 
 // Zone is a collection of Nodes which is collectively used to fulfill one
 // or more allocation requests.
@@ -66,7 +69,9 @@ type ZoneSorter func(z1, z2 NodeMask) int
 
 // SortZones filters zones by a filter function into a slice, then
 // sorts the slice by chaining the given sorting functions. A nil
-// filter function picks all zones.
+// filter function picks all zones. Zones which compare equal by all
+// sorting functions are ordered by their node masks, so the result
+// never depends on map iteration order.
 func (a *Allocator) SortZones(f ZoneFilter, s ...ZoneSorter) []NodeMask {
 	slice := make([]NodeMask, 0, len(a.zones))
 	for z := range a.zones {
@@ -81,13 +86,15 @@ func (a *Allocator) SortZones(f ZoneFilter, s ...ZoneSorter) []NodeMask {
 					return diff
 				}
 			}
-			return 0
+			return cmp.Compare(z1, z2)
 		})
 	}
 	return slice
 }
 
-// ZonesByUsersSubzonesFirst compares zone by number of users.
+// ZonesByUsersSubzonesFirst compares zones by decreasing number of users,
+// then by increasing number of nodes, which puts subzones before their
+// superzones, then by node mask.
 func (a *Allocator) ZonesByUsersSubzonesFirst(zone1, zone2 NodeMask) int {
 	z1, z2 := a.zones[zone1], a.zones[zone2]
 	if z1 != nil && z2 != nil {
@@ -96,18 +103,11 @@ func (a *Allocator) ZonesByUsersSubzonesFirst(zone1, zone2 NodeMask) int {
 		}
 	}
 
-	if (zone1 & zone2) == zone1 {
-		return -1
-	}
-	if (zone1 & zone2) == zone2 {
-		return 1
-	}
-
-	if diff := zone2.Size() - zone1.Size(); diff != 0 {
+	if diff := zone1.Size() - zone2.Size(); diff != 0 {
 		return diff
 	}
 
-	return int(zone2 - zone1)
+	return cmp.Compare(zone1, zone2)
 }
 
 func (a *Allocator) zoneType(zone NodeMask) TypeMask {
@@ -131,13 +131,24 @@ func (a *Allocator) zoneCapacity(zone NodeMask) int64 {
 		capacity = z.capacity
 	} else {
 		for _, id := range (zone & a.masks.nodes.hasMemory).Slice() {
-			capacity += a.nodes[id].capacity
+			capacity += a.nodeCapacity(a.nodes[id])
 		}
 	}
 
 	return capacity
 }
 
+// nodeCapacity returns the effective capacity of the node, with any
+// headroom or reserve excluded.
+func (a *Allocator) nodeCapacity(n *Node) int64 {
+	capacity := n.capacity
+	if a.headroom > 0 {
+		capacity -= int64(float64(n.capacity) * a.headroom)
+	}
+	capacity -= a.reserve
+	return max(0, capacity)
+}
+
 func (a *Allocator) zoneUsage(zone NodeMask) int64 {
 	var usage int64
 
@@ -241,7 +252,8 @@ func (a *Allocator) zoneShrinkUsage(zone NodeMask, amount int64, limit Priority,
 	// This is used by our default overcommit handler to shrink usage of a zone.
 	//
 	//   - find a new zone by expanding this one, optionally with extra types
-	//   - pick requests up to an priority limit, sort them by decreasing size
+	//   - pick unpinned requests up to an priority limit, sort them by decreasing size
+	//   - find a separate new zone for requests not allowed to use all new nodes
 	//   - move requests to new zone, stop if we've freed up enough capacity
 	//
 	// TODO(klihub): We compare our internally set creation time stamps and
@@ -260,15 +272,28 @@ func (a *Allocator) zoneShrinkUsage(zone NodeMask, amount int64, limit Priority,
 		return 0
 	}
 
+	movable := func(r *Request) bool {
+		return RequestsNotPinned(r) && RequestsWithMaxPriority(limit)(r)
+	}
+
 	moved := int64(0)
 	for _, req := range SortRequests(z.users,
-		RequestsWithMaxPriority(limit),
+		movable,
 		RequestsByPriority,
 		RequestsBySize,
 		RequestsByAge,
 	) {
 		if !req.IsStrict() || req.Types() == z.types|types {
-			a.zoneMove(zone|nodes, req)
+			target := zone | nodes
+			if !req.isAllowed(target) {
+				allowed, _ := a.expandFor(req, zone, z.types|extra)
+				if allowed == 0 {
+					continue
+				}
+				target = zone | allowed
+			}
+			a.zoneMove(target, req)
+			a.journal.move()
 			moved += req.Size()
 			if moved >= amount {
 				break
@@ -18,6 +18,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 	"syscall"
 
@@ -68,10 +69,27 @@ func (m *Main) Run() error {
 	}
 	defer m.stopTracing()
 
+	m.stopOnSignal(syscall.SIGINT, syscall.SIGTERM)
+
 	err := m.mgr.Start()
 	return err
 }
 
+// stopOnSignal stops the resource manager and exits on any of the given
+// signals.
+func (m *Main) stopOnSignal(signals ...os.Signal) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, signals...)
+
+	go func() {
+		sig := <-sigCh
+		log.Infof("received signal %v, shutting down...", sig)
+		m.mgr.Stop()
+		m.stopTracing()
+		os.Exit(0)
+	}()
+}
+
 func (m *Main) ResourceManager() resmgr.ResourceManager {
 	return m.mgr
 }
@@ -0,0 +1,116 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resmgr
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/containers/nri-plugins/pkg/metrics"
+)
+
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	// nriHandlers is the fixed set of NRI handlers we collect metrics for.
+	nriHandlers = []string{
+		Configure,
+		Synchronize,
+		RunPodSandbox,
+		StopPodSandbox,
+		RemovePodSandbox,
+		CreateContainer,
+		StartContainer,
+		UpdateContainer,
+		StopContainer,
+		RemoveContainer,
+		UpdateContainers,
+	}
+)
+
+// nriCollector collects metrics about NRI requests handled by us.
+type nriCollector struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+func newNRICollector() *nriCollector {
+	c := &nriCollector{
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nri_requests_total",
+				Help: "Number of handled NRI requests by handler and result.",
+			},
+			[]string{
+				"handler",
+				"result",
+			},
+		),
+		latency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "nri_request_duration_seconds",
+				Help:    "Latency of handling NRI requests by handler.",
+				Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+			},
+			[]string{
+				"handler",
+			},
+		),
+	}
+
+	// Pre-create all series, so that rates are available from the start.
+	for _, h := range nriHandlers {
+		c.requests.WithLabelValues(h, resultSuccess)
+		c.requests.WithLabelValues(h, resultError)
+		c.latency.WithLabelValues(h)
+	}
+
+	return c
+}
+
+func (c *nriCollector) register() error {
+	return metrics.Register("nri", c, metrics.WithGroup("resmgr"))
+}
+
+func (c *nriCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.latency.Describe(ch)
+}
+
+func (c *nriCollector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.latency.Collect(ch)
+}
+
+// observe records the outcome and latency of an NRI request. It is meant
+// to be deferred at the start of the handler with a pointer to the error
+// the handler returns, if any.
+func (c *nriCollector) observe(handler string, start time.Time, errp *error) {
+	if c == nil {
+		return
+	}
+
+	result := resultSuccess
+	if errp != nil && *errp != nil {
+		result = resultError
+	}
+
+	c.requests.WithLabelValues(handler, result).Inc()
+	c.latency.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+}
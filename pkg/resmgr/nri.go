@@ -21,6 +21,7 @@ import (
 	"slices"
 	"time"
 
+	"github.com/containers/nri-plugins/pkg/audit"
 	"github.com/containers/nri-plugins/pkg/instrumentation/metrics"
 	"github.com/containers/nri-plugins/pkg/instrumentation/tracing"
 	logger "github.com/containers/nri-plugins/pkg/log"
@@ -35,9 +36,10 @@ import (
 )
 
 type nriPlugin struct {
-	stub   stub.Stub
-	resmgr *resmgr
-	byname map[string]cache.Container
+	stub    stub.Stub
+	resmgr  *resmgr
+	byname  map[string]cache.Container
+	metrics *nriCollector
 }
 
 var (
@@ -51,12 +53,17 @@ const (
 
 func newNRIPlugin(resmgr *resmgr) (*nriPlugin, error) {
 	p := &nriPlugin{
-		resmgr: resmgr,
-		byname: make(map[string]cache.Container),
+		resmgr:  resmgr,
+		byname:  make(map[string]cache.Container),
+		metrics: newNRICollector(),
 	}
 
 	nri.Info("creating plugin...")
 
+	if err := p.metrics.register(); err != nil {
+		return nil, fmt.Errorf("failed to register NRI metrics collector: %w", err)
+	}
+
 	return p, nil
 }
 
@@ -176,6 +183,7 @@ func (p *nriPlugin) unmapContainer(ctr cache.Container) {
 
 func (p *nriPlugin) Configure(ctx context.Context, cfg, runtime, version string) (stub.EventMask, error) {
 	event := Configure
+	defer p.metrics.observe(event, time.Now(), nil)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -250,6 +258,7 @@ func (p *nriPlugin) syncWithNRI(pods []*api.PodSandbox, containers []*api.Contai
 
 func (p *nriPlugin) Synchronize(ctx context.Context, pods []*api.PodSandbox, containers []*api.Container) (updates []*api.ContainerUpdate, retErr error) {
 	event := Synchronize
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -281,12 +290,17 @@ func (p *nriPlugin) Synchronize(ctx context.Context, pods []*api.PodSandbox, con
 	}
 
 	m.updateTopologyZones()
+	m.ready.Store(true)
 
-	return p.getPendingUpdates(nil), nil
+	updates = p.getPendingUpdates(nil)
+	p.audit(event, nil, nil, updates)
+
+	return updates, nil
 }
 
 func (p *nriPlugin) RunPodSandbox(ctx context.Context, pod *api.PodSandbox) (retErr error) {
 	event := RunPodSandbox
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -317,6 +331,7 @@ func (p *nriPlugin) RunPodSandbox(ctx context.Context, pod *api.PodSandbox) (ret
 
 func (p *nriPlugin) StopPodSandbox(ctx context.Context, podSandbox *api.PodSandbox) (retErr error) {
 	event := StopPodSandbox
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -351,6 +366,7 @@ func (p *nriPlugin) StopPodSandbox(ctx context.Context, podSandbox *api.PodSandb
 
 func (p *nriPlugin) RemovePodSandbox(ctx context.Context, podSandbox *api.PodSandbox) (retErr error) {
 	event := RemovePodSandbox
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -387,6 +403,7 @@ func (p *nriPlugin) RemovePodSandbox(ctx context.Context, podSandbox *api.PodSan
 
 func (p *nriPlugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, container *api.Container) (adjust *api.ContainerAdjustment, updates []*api.ContainerUpdate, retErr error) {
 	event := CreateContainer
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -452,6 +469,7 @@ func (p *nriPlugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, co
 
 	adjust = p.getPendingAdjustment(container)
 	updates = p.getPendingUpdates(container)
+	p.audit(event, container, adjust, updates)
 
 	p.mapNameToContainer(c)
 
@@ -460,6 +478,7 @@ func (p *nriPlugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, co
 
 func (p *nriPlugin) StartContainer(ctx context.Context, pod *api.PodSandbox, container *api.Container) (retErr error) {
 	event := StartContainer
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -508,6 +527,7 @@ func (p *nriPlugin) StartContainer(ctx context.Context, pod *api.PodSandbox, con
 
 func (p *nriPlugin) UpdateContainer(ctx context.Context, pod *api.PodSandbox, container *api.Container, res *api.LinuxResources) (updates []*api.ContainerUpdate, retErr error) {
 	event := UpdateContainer
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -567,11 +587,15 @@ func (p *nriPlugin) UpdateContainer(ctx context.Context, pod *api.PodSandbox, co
 		}
 	}
 
-	return p.getPendingUpdates(nil), nil
+	updates = p.getPendingUpdates(nil)
+	p.audit(event, nil, nil, updates)
+
+	return updates, nil
 }
 
 func (p *nriPlugin) StopContainer(ctx context.Context, pod *api.PodSandbox, container *api.Container) (updates []*api.ContainerUpdate, retErr error) {
 	event := StopContainer
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -607,11 +631,15 @@ func (p *nriPlugin) StopContainer(ctx context.Context, pod *api.PodSandbox, cont
 	c.UpdateState(cache.ContainerStateExited)
 	m.updateTopologyZones()
 
-	return p.getPendingUpdates(container), nil
+	updates = p.getPendingUpdates(container)
+	p.audit(event, nil, nil, updates)
+
+	return updates, nil
 }
 
 func (p *nriPlugin) RemoveContainer(ctx context.Context, pod *api.PodSandbox, container *api.Container) (retErr error) {
 	event := RemoveContainer
+	defer p.metrics.observe(event, time.Now(), &retErr)
 
 	_, span := tracing.StartSpan(
 		ctx,
@@ -646,6 +674,7 @@ func (p *nriPlugin) updateContainers() (retErr error) {
 	updates := p.getPendingUpdates(nil)
 
 	event := UpdateContainers
+	defer p.metrics.observe(event, time.Now(), &retErr)
 	p.dump(out, event, updates)
 	defer func() {
 		p.dump(in, event, retErr)
@@ -656,9 +685,32 @@ func (p *nriPlugin) updateContainers() (retErr error) {
 		return fmt.Errorf("post-config container update failed: %w", err)
 	}
 
+	p.audit(event, nil, nil, updates)
+
 	return nil
 }
 
+// audit records the cgroup changes of a container adjustment and updates.
+func (p *nriPlugin) audit(event string, container *api.Container, adjust *api.ContainerAdjustment, updates []*api.ContainerUpdate) {
+	if !audit.Enabled() {
+		return
+	}
+
+	source := p.resmgr.policy.ActivePolicy()
+	if adjust != nil {
+		audit.ContainerAdjustment(source, event, p.containerName(container.GetId()), container.GetId(), adjust)
+	}
+	audit.ContainerUpdates(source, event, updates, p.containerName)
+}
+
+// containerName returns the name of a cached container for auditing.
+func (p *nriPlugin) containerName(id string) string {
+	if c, ok := p.resmgr.cache.LookupContainer(id); ok {
+		return c.PrettyName()
+	}
+	return ""
+}
+
 func (p *nriPlugin) getPendingAdjustment(container *api.Container) *api.ContainerAdjustment {
 	if c, ok := p.resmgr.cache.LookupContainer(container.GetId()); ok {
 		adjust := c.GetPendingAdjustment()
@@ -17,10 +17,13 @@ package policy
 import (
 	"bytes"
 	"fmt"
+	"net/http"
 	"sort"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/resource"
 
+	"github.com/containers/nri-plugins/pkg/instrumentation"
 	"github.com/containers/nri-plugins/pkg/resmgr/cache"
 	"github.com/containers/nri-plugins/pkg/resmgr/events"
 	"github.com/prometheus/client_golang/prometheus"
@@ -145,6 +148,15 @@ type Policy interface {
 	ExportResourceData(cache.Container)
 	// GetTopologyZones returns the policy/pool data for 'topology zone' CRDs.
 	GetTopologyZones() []*TopologyZone
+	// Stop stops the active policy.
+	Stop()
+}
+
+// Stopper is implemented by policy backends which need to stop timers
+// or clean up changes they have made when the resource manager stops.
+type Stopper interface {
+	// Stop stops the policy backend.
+	Stop()
 }
 
 // Metrics is the interface we expect policy-specific metrics to implement.
@@ -171,6 +183,8 @@ const (
 	ReservedCPUsAttribute = "reserved cpuset"
 	// IsolatedCPUsAttribute is the attribute name for the assignable isolated CPU set
 	IsolatedCPUsAttribute = "isolated cpuset"
+	// ExclusiveLLCAttribute is the attribute name for the CPU set of cache groups used exclusively
+	ExclusiveLLCAttribute = "exclusive LLC cpuset"
 )
 
 // TopologyZone provides policy-/pool-specific data for 'node resource topology' CRs.
@@ -224,6 +238,10 @@ func NewPolicy(backend Backend, cache cache.Cache, o *Options) (Policy, error) {
 		return nil, policyError("failed to discover system topology: %v", err)
 	}
 	p.system = sys
+	log.InfoBlock("  <system topology> ", "%s", strings.TrimSuffix(sys.TopologySummary(), "\n"))
+
+	mux := instrumentation.HTTPServer().GetMux()
+	mux.HandleFunc(cacheTopologyPath, p.serveCacheTopology)
 
 	pcollect := p.newPolicyCollector()
 	if err := pcollect.register(); err != nil {
@@ -324,3 +342,26 @@ func (p *policy) ExportResourceData(c cache.Container) {
 func (p *policy) GetTopologyZones() []*TopologyZone {
 	return p.active.GetTopologyZones()
 }
+
+// Stop stops the active policy, if it needs stopping.
+func (p *policy) Stop() {
+	if s, ok := p.active.(Stopper); ok {
+		s.Stop()
+	}
+}
+
+// cacheTopologyPath is the HTTP path for checking the cache topology.
+const cacheTopologyPath = "/cache-topology"
+
+// serveCacheTopology reports inconsistencies in the discovered cache
+// topology over HTTP.
+func (p *policy) serveCacheTopology(w http.ResponseWriter, _ *http.Request) {
+	errs := p.system.ValidateCacheTopology()
+	if len(errs) == 0 {
+		fmt.Fprintf(w, "cache topology is consistent\r\n")
+		return
+	}
+	for _, err := range errs {
+		fmt.Fprintf(w, "%v\r\n", err)
+	}
+}
@@ -17,11 +17,14 @@ package resmgr
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	//	"time"
 
 	"github.com/containers/nri-plugins/pkg/agent"
+	"github.com/containers/nri-plugins/pkg/audit"
 	"github.com/containers/nri-plugins/pkg/healthz"
+	xhttp "github.com/containers/nri-plugins/pkg/http"
 	"github.com/containers/nri-plugins/pkg/instrumentation"
 	logger "github.com/containers/nri-plugins/pkg/log"
 	"github.com/containers/nri-plugins/pkg/pidfile"
@@ -59,6 +62,8 @@ type resmgr struct {
 	events  chan interface{} // channel for delivering events
 	stop    chan interface{} // channel for signalling shutdown to goroutines
 	nri     *nriPlugin       // NRI plugins, if we're running as such
+	health  *xhttp.Server    // HTTP server for liveness and readiness probes
+	ready   atomic.Bool      // NRI registered and initial Sync completed
 	running bool
 }
 
@@ -120,6 +125,10 @@ func NewResourceManager(backend policy.Backend, agt *agent.Agent) (ResourceManag
 
 // Start the resource manager.
 func (m *resmgr) Start() error {
+	if err := m.health.Start(opt.HealthAddress); err != nil {
+		return resmgrError("failed to start health probe server: %v", err)
+	}
+
 	log.Infof("starting agent, waiting for initial configuration...")
 	err := m.agent.Start(m.updateConfig)
 	if err != nil {
@@ -174,6 +183,9 @@ func (m *resmgr) start(cfg cfgapi.ResmgrConfig) error {
 	if err := logger.Configure(&mCfg.Log); err != nil {
 		log.Warnf("failed to configure logger: %v", err)
 	}
+	if err := audit.Configure(&mCfg.Audit); err != nil {
+		log.Warnf("failed to configure auditing: %v", err)
+	}
 
 	if err := m.policy.Start(m.cfg.PolicyConfig()); err != nil {
 		return err
@@ -214,7 +226,10 @@ func (m *resmgr) Stop() {
 	m.Lock()
 	defer m.Unlock()
 
+	m.ready.Store(false)
+	m.policy.Stop()
 	m.nri.stop()
+	m.health.Shutdown(true)
 }
 
 // setupCache creates a cache and reloads its last saved state if found.
@@ -255,6 +270,19 @@ func (m *resmgr) setupPolicy(backend policy.Backend) error {
 func (m *resmgr) setupHealthCheck() {
 	mux := instrumentation.HTTPServer().GetMux()
 	healthz.Setup(mux)
+
+	m.health = xhttp.NewServer()
+	healthz.Setup(m.health.GetMux())
+	healthz.SetupReadiness(m.health.GetMux())
+	healthz.RegisterReadinessChecker("resource-manager", m.checkReady)
+}
+
+// checkReady checks if we have registered with NRI and completed initial Sync.
+func (m *resmgr) checkReady() (healthz.Status, error) {
+	if !m.ready.Load() {
+		return healthz.NonFunctional, fmt.Errorf("resource manager not synchronized with NRI yet")
+	}
+	return healthz.Healthy, nil
 }
 
 // setupControllers sets up the resource controllers.
@@ -295,6 +323,9 @@ func (m *resmgr) reconfigure(cfg cfgapi.ResmgrConfig) error {
 		if err := logger.Configure(&mCfg.Log); err != nil {
 			log.Warnf("failed to configure logger: %v", err)
 		}
+		if err := audit.Configure(&mCfg.Audit); err != nil {
+			log.Warnf("failed to configure auditing: %v", err)
+		}
 		if err := instrumentation.Reconfigure(&mCfg.Instrumentation); err != nil {
 			return err
 		}
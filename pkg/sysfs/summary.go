@@ -0,0 +1,179 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysfs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	idset "github.com/intel/goresctrl/pkg/utils"
+)
+
+// TopologySummary returns a compact summary of the system topology, one
+// "key: value" line per item. Cache sizes are given in KiB with a K
+// suffix and memory sizes in MiB with an M suffix. The keys and their
+// order are stable, so that the summary can be parsed.
+func (sys *system) TopologySummary() string {
+	var (
+		b     = &strings.Builder{}
+		dies  = 0
+		cores = map[CoreKind]int{}
+		seen  = map[[3]idset.ID]struct{}{}
+	)
+
+	line := func(key, format string, args ...interface{}) {
+		fmt.Fprintf(b, "%s: %s\n", key, fmt.Sprintf(format, args...))
+	}
+
+	for _, pkg := range sys.packages {
+		dies += len(pkg.DieIDs())
+	}
+	for _, cpu := range sys.cpus {
+		core := [3]idset.ID{cpu.pkg, cpu.die, cpu.core}
+		if _, ok := seen[core]; !ok {
+			seen[core] = struct{}{}
+			cores[cpu.coreKind]++
+		}
+	}
+
+	line("sockets", "%d", sys.SocketCount())
+	line("dies", "%d", dies)
+	line("nodes", "%d", sys.NUMANodeCount())
+	line("cpus", "%d (%s)", sys.CPUCount(), orNone(sys.CPUSet().String()))
+	line("online cpus", "%s", orNone(sys.OnlineCPUs().String()))
+	line("isolated cpus", "%s", orNone(sys.IsolatedCPUs().String()))
+	line("cores", "%d", len(seen))
+	if kinds := sys.CoreKinds(); len(kinds) > 1 {
+		for _, kind := range kinds {
+			line(kind.String()+"s", "%d", cores[kind])
+		}
+	}
+	if sys.MinThreadCount() == sys.MaxThreadCount() {
+		line("threads per core", "%d", sys.MaxThreadCount())
+	} else {
+		line("threads per core", "%d-%d", sys.MinThreadCount(), sys.MaxThreadCount())
+	}
+
+	sys.summarizeCaches(line)
+	sys.summarizeMemory(line)
+	sys.summarizeSst(line)
+
+	return b.String()
+}
+
+// summarizeCaches adds the count and size of caches per level and type
+// to a topology summary. Caches of the same level and type but of
+// different sizes, for instance of P- and E-cores, are listed on the
+// same line in increasing order of size.
+func (sys *system) summarizeCaches(line func(string, string, ...interface{})) {
+	counts := map[string]map[uint64]int{}
+	seen := map[*Cache]struct{}{}
+	for _, cpu := range sys.cpus {
+		for _, c := range cpu.caches {
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			seen[c] = struct{}{}
+			name := fmt.Sprintf("L%d", c.level)
+			switch c.kind {
+			case DataCache:
+				name += "d"
+			case InstructionCache:
+				name += "i"
+			}
+			if counts[name] == nil {
+				counts[name] = map[uint64]int{}
+			}
+			counts[name][c.size]++
+		}
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		sizes := make([]uint64, 0, len(counts[name]))
+		for size := range counts[name] {
+			sizes = append(sizes, size)
+		}
+		slices.Sort(sizes)
+		items := make([]string, 0, len(sizes))
+		for _, size := range sizes {
+			items = append(items, fmt.Sprintf("%d x %dK", counts[name][size], size/1024))
+		}
+		line("cache "+name, "%s", strings.Join(items, ", "))
+	}
+}
+
+// summarizeMemory adds the memory type, CPUs and size of each NUMA node,
+// and the total size of memory per type to a topology summary.
+func (sys *system) summarizeMemory(line func(string, string, ...interface{})) {
+	totals := map[MemoryType]uint64{}
+	for _, id := range sys.NodeIDs() {
+		node := sys.nodes[id]
+		size := "unknown"
+		if info, err := node.MemoryInfo(); err == nil {
+			totals[node.memoryType] += info.MemTotal
+			size = fmt.Sprintf("%dM", info.MemTotal/(1024*1024))
+		}
+		line(fmt.Sprintf("node #%d", id), "package %d, die %d, %s, cpus %s, memory %s",
+			node.pkg, node.die, node.memoryType, orNone(node.CPUSet().String()), size)
+	}
+
+	types := make([]MemoryType, 0, len(totals))
+	for t := range totals {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	for _, t := range types {
+		line("memory "+t.String(), "%dM", totals[t]/(1024*1024))
+	}
+}
+
+// summarizeSst adds the Speed Select Technology status of each package
+// to a topology summary, if SST discovery was requested.
+func (sys *system) summarizeSst(line func(string, string, ...interface{})) {
+	if (sys.flags & DiscoverSst) == 0 {
+		return
+	}
+
+	enabled := map[bool]string{false: "disabled", true: "enabled"}
+	found := false
+	for _, id := range sys.PackageIDs() {
+		info := sys.packages[id].sstInfo
+		if info == nil {
+			continue
+		}
+		found = true
+		line(fmt.Sprintf("sst package #%d", id), "PP level %d/%d, CP %s, BF %s, TF %s",
+			info.PPCurrentLevel, info.PPMaxLevel,
+			enabled[info.CPEnabled], enabled[info.BFEnabled], enabled[info.TFEnabled])
+	}
+	if !found {
+		line("sst", "not supported")
+	}
+}
+
+// orNone returns s, or "none" if s is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -25,6 +25,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/containers/nri-plugins/pkg/utils/cpuset"
 
@@ -46,6 +47,18 @@ const (
 	sysfsCPUPath = "devices/system/cpu"
 	// sysfs device/node subdirectory path
 	sysfsNumaNodePath = "devices/system/node"
+	// sysfs cgroup v2 subdirectory path
+	sysfsCgroupPath = "fs/cgroup"
+	// sysfs PCI devices subdirectory path
+	sysfsPCIDevicesPath = "bus/pci/devices"
+	// sysfs CPU vulnerabilities subdirectory path
+	sysfsCPUVulnerabilitiesPath = "devices/system/cpu/vulnerabilities"
+	// sysfs SMT control entry path
+	sysfsSMTControlPath = "devices/system/cpu/smt/control"
+	// procfs kernel NUMA balancing (autonuma) control
+	procNumaBalancing = "sys/kernel/numa_balancing"
+	// procfs cgroup membership of our own process
+	procSelfCgroup = "self/cgroup"
 )
 
 // DiscoveryFlag controls what hardware details to discover.
@@ -97,6 +110,9 @@ type System interface {
 	Discover(flags DiscoveryFlag) error
 	SetCpusOnline(online bool, cpus idset.IDSet) (idset.IDSet, error)
 	SetCPUFrequencyLimits(min, max uint64, cpus idset.IDSet) error
+	SetCPUFrequencyLimitsForCPUSet(min, max uint64, cpus cpuset.CPUSet) error
+	SetScalingGovernor(governor string, cpus idset.IDSet) error
+	AverageFrequency(cpus cpuset.CPUSet) (uint64, error)
 	PackageIDs() []idset.ID
 	NodeIDs() []idset.ID
 	CPUIDs() []idset.ID
@@ -120,11 +136,27 @@ type System interface {
 	CoreKinds() []CoreKind
 	AllThreadsForCPUs(cpuset.CPUSet) cpuset.CPUSet
 	SingleThreadForCPUs(cpuset.CPUSet) cpuset.CPUSet
+	ValidateCacheTopology() []error
+	ExportTopology() ([]byte, error)
 
 	Offlined() cpuset.CPUSet
 	Isolated() cpuset.CPUSet
 
+	EffectiveAllowedCPUs() cpuset.CPUSet
+	EffectiveAllowedMems() cpuset.CPUSet
+
 	NodeHintToCPUs(string) string
+	NodeCPUSet(nodes idset.IDSet, onlineOnly bool) cpuset.CPUSet
+
+	DeviceNUMANode(pciAddress string) (idset.ID, error)
+	DevicesNearNode(node idset.ID) []string
+
+	NumaBalancingEnabled() (bool, error)
+	SetNumaBalancing(enabled bool) error
+
+	SMTConsideredSafe() bool
+
+	TopologySummary() string
 }
 
 // System devices
@@ -157,6 +189,8 @@ type CPUPackage interface {
 	DieClusterCPUSet(idset.ID, idset.ID) cpuset.CPUSet
 	LogicalDieClusterIDs(idset.ID) []idset.ID
 	LogicalDieClusterCPUSet(idset.ID, idset.ID) cpuset.CPUSet
+	CCXIDs(idset.ID) []idset.ID
+	CCXCPUSet(idset.ID, idset.ID) cpuset.CPUSet
 	SstInfo() *sst.SstPackageInfo
 }
 
@@ -169,6 +203,7 @@ type cpuPackage struct {
 	dieNodes        map[idset.ID]idset.IDSet              // NUMA nodes per die
 	clusterCPUs     map[idset.ID]map[idset.ID]idset.IDSet // per die per cluster CPUs
 	logicalClusters map[idset.ID]map[idset.ID]idset.IDSet // clusters with combined hyperthreads
+	ccxCPUs         map[idset.ID]map[idset.ID]idset.IDSet // per die per CCX (L3 cache) CPUs
 	sstInfo         *sst.SstPackageInfo                   // Speed Select Technology info
 }
 
@@ -183,6 +218,9 @@ type Node interface {
 	MemoryInfo() (*MemInfo, error)
 	GetMemoryType() MemoryType
 	HasNormalMemory() bool
+	InitiatorCPUs() cpuset.CPUSet
+	SetOnline(online bool) error
+	MemoryBlocks() ([]MemoryBlock, error)
 }
 
 type node struct {
@@ -194,6 +232,7 @@ type node struct {
 	memoryType MemoryType  // node memory type
 	normalMem  bool        // node has memory in a normal (kernel space allocatable) zone
 	distance   []int       // distance/cost to other NUMA nodes
+	initiators idset.IDSet // access initiator CPUs for a CPU-less node
 }
 
 // CPU is a CPU core.
@@ -207,10 +246,15 @@ type CPU interface {
 	ThreadCPUSet() cpuset.CPUSet
 	BaseFrequency() uint64
 	FrequencyRange() CPUFreq
+	CurrentFrequency() (uint64, error)
+	Mitigations() map[string]string
 	EPP() EPP
 	Online() bool
 	Isolated() bool
 	SetFrequencyLimits(min, max uint64) error
+	ScalingGovernor() (string, error)
+	AvailableScalingGovernors() ([]string, error)
+	SetScalingGovernor(governor string) error
 	SstClos() int
 	CacheCount() int
 	GetCaches() []*Cache
@@ -285,9 +329,22 @@ var (
 
 // MemInfo contains data read from a NUMA node meminfo file.
 type MemInfo struct {
-	MemTotal uint64
-	MemFree  uint64
-	MemUsed  uint64
+	MemTotal     uint64
+	MemFree      uint64
+	MemUsed      uint64
+	FilePages    uint64 // page cache, including shared memory
+	AnonPages    uint64 // anonymous memory
+	ActiveFile   uint64 // recently used page cache
+	InactiveFile uint64 // page cache not used recently
+	SReclaimable uint64 // reclaimable kernel slab memory
+	Reclaimable  uint64 // estimate of memory the kernel can reclaim
+}
+
+// MemoryBlock is a hotpluggable block of memory of a NUMA node.
+type MemoryBlock struct {
+	ID     idset.ID // memory block id
+	Online bool     // whether the block is online
+	Size   uint64   // size of the block in bytes
 }
 
 // CacheType specifies a cache type.
@@ -422,6 +479,13 @@ func (sys *system) Discover(flags DiscoveryFlag) error {
 				}
 			}
 		}
+		sys.discoverNodeInitiators()
+	}
+
+	if (sys.flags & DiscoverCache) != 0 {
+		for _, err := range sys.ValidateCacheTopology() {
+			sys.Warn("inconsistent cache topology: %v", err)
+		}
 	}
 
 	if sys.DebugEnabled() {
@@ -455,6 +519,10 @@ func (sys *system) Discover(flags DiscoveryFlag) error {
 					sys.Debug("    die #%v logical cluster #%v cpus: %s", die, cluster,
 						pkg.LogicalDieClusterCPUSet(die, cluster).String())
 				}
+				for _, ccx := range pkg.CCXIDs(die) {
+					sys.Debug("    die #%v CCX #%v cpus: %s", die, ccx,
+						pkg.CCXCPUSet(die, ccx).String())
+				}
 			}
 		}
 
@@ -465,6 +533,9 @@ func (sys *system) Discover(flags DiscoveryFlag) error {
 			sys.Debug("  distance: %v", node.distance)
 			sys.Debug("   package: #%d", node.pkg)
 			sys.Debug("       die: #%d", node.die)
+			if node.cpus.Size() == 0 {
+				sys.Debug("initiators: %s", node.initiators)
+			}
 		}
 
 		for _, id := range sys.CPUIDs() {
@@ -556,6 +627,86 @@ func (sys *system) SetCPUFrequencyLimits(min, max uint64, cpus idset.IDSet) erro
 	return nil
 }
 
+// SetCPUFrequencyLimitsForCPUSet sets the CPU frequency scaling limits of
+// the given CPUs. Unknown and offline CPUs are skipped. Unlike with
+// SetCPUFrequencyLimits, a failure does not stop setting the limits of
+// the rest of the CPUs. The returned error lists all CPUs which failed.
+func (sys *system) SetCPUFrequencyLimitsForCPUSet(min, max uint64, cpus cpuset.CPUSet) error {
+	var (
+		failed = []int{}
+		errs   = []error{}
+	)
+
+	for _, id := range cpus.List() {
+		cpu, ok := sys.cpus[id]
+		if !ok || !cpu.Online() {
+			continue
+		}
+		if err := cpu.SetFrequencyLimits(min, max); err != nil {
+			failed = append(failed, id)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to set frequency limits of CPUs %s: %w",
+			cpuset.New(failed...), errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// SetScalingGovernor sets the cpufreq scaling governor. Nil set implies all CPUs.
+func (sys *system) SetScalingGovernor(governor string, cpus idset.IDSet) error {
+	if cpus == nil {
+		cpus = idset.NewIDSet(sys.CPUIDs()...)
+	}
+
+	for _, id := range cpus.Members() {
+		if cpu, ok := sys.cpus[id]; ok {
+			if err := cpu.SetScalingGovernor(governor); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AverageFrequency returns the average current frequency (kHz) of the
+// given CPUs. Unknown and offline CPUs, and CPUs without cpufreq support,
+// are skipped. An error wrapping errors.ErrUnsupported is returned if the
+// frequency of none of the CPUs is available.
+func (sys *system) AverageFrequency(cpus cpuset.CPUSet) (uint64, error) {
+	var (
+		sum   uint64
+		count uint64
+	)
+
+	for _, id := range cpus.List() {
+		cpu, ok := sys.cpus[id]
+		if !ok || !cpu.Online() {
+			continue
+		}
+		freq, err := cpu.CurrentFrequency()
+		if err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				continue
+			}
+			return 0, err
+		}
+		sum += freq
+		count++
+	}
+
+	if count == 0 {
+		return 0, fmt.Errorf("%w: no current frequency available for CPUs %s",
+			errors.ErrUnsupported, cpus)
+	}
+
+	return sum / count, nil
+}
+
 // PackageIDs gets the ids of all packages present in the system.
 func (sys *system) PackageIDs() []idset.ID {
 	ids := make([]idset.ID, len(sys.packages))
@@ -733,6 +884,51 @@ func (sys *system) SingleThreadForCPUs(cpus cpuset.CPUSet) cpuset.CPUSet {
 	return cpuset.New(result...)
 }
 
+// ValidateCacheTopology cross-checks the CPUs sharing each cache against
+// the caches reported by each CPU and against the package topology. It
+// returns an error for each inconsistency found, for instance for a cache
+// shared by CPUs in different packages. Inconsistencies are not fatal but
+// may cause cache-based CPU allocation to misbehave.
+func (sys *system) ValidateCacheTopology() []error {
+	var (
+		errs    []error
+		checked = map[*Cache]struct{}{}
+	)
+
+	for _, id := range sys.CPUIDs() {
+		cpu := sys.cpus[id]
+		for _, cch := range cpu.caches {
+			name := fmt.Sprintf("L%d %s cache #%d", cch.level, cch.kind, cch.id)
+			if !cch.cpus.Has(id) {
+				errs = append(errs, fmt.Errorf("%s of CPU #%d does not list the CPU in shared CPUs %s",
+					name, id, cch.cpus))
+			}
+			if _, ok := checked[cch]; ok {
+				continue
+			}
+			checked[cch] = struct{}{}
+			pkgs := idset.NewIDSet()
+			for _, sharing := range cch.cpus.SortedMembers() {
+				other, ok := sys.cpus[sharing]
+				if !ok || !other.online {
+					continue
+				}
+				pkgs.Add(other.pkg)
+				if !slices.Contains(other.caches, cch) {
+					errs = append(errs, fmt.Errorf("%s is shared by CPU #%d, which reports another L%d %s cache",
+						name, sharing, cch.level, cch.kind))
+				}
+			}
+			if pkgs.Size() > 1 {
+				errs = append(errs, fmt.Errorf("%s is shared by CPUs %s in different packages %s",
+					name, cch.cpus, pkgs))
+			}
+		}
+	}
+
+	return errs
+}
+
 // Offlined gets the set of offlined CPUs.
 func (sys *system) Offlined() cpuset.CPUSet {
 	return sys.OfflineCPUs()
@@ -743,6 +939,75 @@ func (sys *system) Isolated() cpuset.CPUSet {
 	return sys.IsolatedCPUs()
 }
 
+// EffectiveAllowedCPUs gets the set of CPUs pods are allowed to use. This
+// is the effective cpuset of the cgroup of all pods, located from our own
+// cgroup. Unlike the cpuset of our own cgroup, it does not change when we
+// get pinned ourselves. If the effective cpuset cannot be determined, all
+// online CPUs are returned.
+func (sys *system) EffectiveAllowedCPUs() cpuset.CPUSet {
+	cpus, err := sys.readPodsCpuset("cpuset.cpus.effective")
+	if err != nil {
+		log.Warnf("failed to get effective allowed CPUs, using all online CPUs: %v", err)
+		return sys.OnlineCPUs()
+	}
+	return cpus
+}
+
+// EffectiveAllowedMems gets the set of memory nodes pods are allowed to
+// use. This is the effective set of the cgroup of all pods, located from
+// our own cgroup. If the effective set cannot be determined, all memory
+// nodes are returned.
+func (sys *system) EffectiveAllowedMems() cpuset.CPUSet {
+	mems, err := sys.readPodsCpuset("cpuset.mems.effective")
+	if err != nil {
+		log.Warnf("failed to get effective allowed memory nodes, using all nodes: %v", err)
+		return CPUSetFromIDSet(idset.NewIDSet(sys.NodeIDs()...))
+	}
+	return mems
+}
+
+// podsCgroupDirs are the top-level cgroups of all pods with the systemd
+// and the cgroupfs cgroup drivers.
+var podsCgroupDirs = []string{"kubepods.slice", "kubepods"}
+
+// readPodsCpuset reads the given effective cpuset entry of the cgroup of
+// all pods.
+func (sys *system) readPodsCpuset(entry string) (cpuset.CPUSet, error) {
+	dir, err := sys.podsCgroupDir()
+	if err != nil {
+		return cpuset.New(), err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, entry))
+	if err != nil {
+		return cpuset.New(), err
+	}
+	return cpuset.Parse(strings.TrimSpace(string(data)))
+}
+
+// podsCgroupDir returns the directory of the cgroup of all pods, which is
+// the top-level ancestor of our own cgroup v2 cgroup. In a private cgroup
+// namespace our own cgroup is seen as the root and the cgroup of all pods
+// is not visible, in which case an error is returned.
+func (sys *system) podsCgroupDir() (string, error) {
+	file := filepath.Join(sys.procPath(), procSelfCgroup)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		own, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		top, _, _ := strings.Cut(strings.TrimPrefix(own, "/"), "/")
+		if !slices.Contains(podsCgroupDirs, top) {
+			return "", fmt.Errorf("own cgroup %q is not in the cgroup of pods (private cgroup namespace?)", own)
+		}
+		return filepath.Join(sys.path, sysfsCgroupPath, top), nil
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %s", file)
+}
+
 // Resolve given node topology hints to CPUs.
 func (sys *system) NodeHintToCPUs(nodes string) string {
 	mset, err := cpuset.Parse(nodes)
@@ -751,14 +1016,179 @@ func (sys *system) NodeHintToCPUs(nodes string) string {
 		return ""
 	}
 
+	return sys.NodeCPUSet(idset.NewIDSet(mset.List()...), true).String()
+}
+
+// NodeCPUSet returns the CPUs of the given nodes, optionally excluding
+// offline CPUs. Unknown nodes are ignored.
+func (sys *system) NodeCPUSet(nodes idset.IDSet, onlineOnly bool) cpuset.CPUSet {
 	cset := cpuset.New()
-	for _, id := range mset.List() {
+	for _, id := range nodes.Members() {
 		if n, ok := sys.nodes[id]; ok {
 			cset = cset.Union(n.CPUSet())
 		}
 	}
 
-	return cset.Intersection(sys.OnlineCPUs()).String()
+	if onlineOnly {
+		cset = cset.Intersection(sys.OnlineCPUs())
+	}
+
+	return cset
+}
+
+// DeviceNUMANode returns the NUMA node of the PCI device with the given address,
+// for instance "0000:3b:00.0". If the kernel does not know the locality of the
+// device, idset.Unknown is returned.
+func (sys *system) DeviceNUMANode(pciAddress string) (idset.ID, error) {
+	var node int
+
+	devPath := filepath.Join(sys.path, sysfsPCIDevicesPath, pciAddress)
+	if _, err := readSysfsEntry(devPath, "numa_node", &node); err != nil {
+		return idset.Unknown, err
+	}
+	if node < 0 {
+		return idset.Unknown, nil
+	}
+
+	return idset.ID(node), nil
+}
+
+// DevicesNearNode returns the addresses of PCI devices local to the given NUMA node.
+func (sys *system) DevicesNearNode(node idset.ID) []string {
+	entries, err := os.ReadDir(filepath.Join(sys.path, sysfsPCIDevicesPath))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			sys.Warn("failed to read PCI devices: %v", err)
+		}
+		return nil
+	}
+
+	devices := []string{}
+	for _, entry := range entries {
+		addr := entry.Name()
+		id, err := sys.DeviceNUMANode(addr)
+		if err != nil {
+			sys.Debug("failed to get NUMA node of PCI device %s: %v", addr, err)
+			continue
+		}
+		if id == node && id != idset.Unknown {
+			devices = append(devices, addr)
+		}
+	}
+
+	return devices
+}
+
+// NumaBalancingEnabled returns true if kernel NUMA balancing (autonuma)
+// is enabled. Autonuma can migrate pages away from the memory nodes that
+// containers are pinned to.
+func (sys *system) NumaBalancingEnabled() (bool, error) {
+	mode := 0
+	if _, err := readSysfsEntry(sys.procPath(), procNumaBalancing, &mode); err != nil {
+		return false, err
+	}
+	return mode != 0, nil
+}
+
+// SMTConsideredSafe returns false if the kernel reports any CPU
+// vulnerability whose mitigation leaves hyperthread siblings exposed to
+// each other, for instance MDS or L1TF with "SMT vulnerable". Running
+// untrusted workloads on siblings of other workloads should then be
+// avoided. SMT is considered safe if it is disabled or not supported, or
+// if the kernel does not report vulnerabilities at all.
+func (sys *system) SMTConsideredSafe() bool {
+	control, err := readSysfsEntry(sys.path, sysfsSMTControlPath, nil)
+	if err == nil {
+		switch control {
+		case "off", "forceoff", "notsupported", "notimplemented":
+			return true
+		}
+	}
+
+	for name, status := range readMitigations(filepath.Join(sys.path, sysfsCPUVulnerabilitiesPath)) {
+		if smtVulnerable(status) {
+			sys.Debug("SMT not considered safe, vulnerability %s: %s", name, status)
+			return false
+		}
+	}
+
+	return true
+}
+
+// readMitigations reads the status of CPU vulnerabilities from the given
+// directory. Unreadable entries are skipped.
+func readMitigations(dir string) map[string]string {
+	mitigations := map[string]string{}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("failed to read CPU vulnerabilities: %v", err)
+		}
+		return mitigations
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		status, err := readSysfsEntry(dir, entry.Name(), nil)
+		if err != nil {
+			log.Debug("failed to read CPU vulnerability %s: %v", entry.Name(), err)
+			continue
+		}
+		mitigations[entry.Name()] = strings.TrimSpace(status)
+	}
+
+	return mitigations
+}
+
+// smtVulnerable returns true if a vulnerability status tells that
+// hyperthread siblings are not protected from each other.
+func smtVulnerable(status string) bool {
+	status = strings.ToLower(status)
+	return strings.Contains(status, "smt vulnerable") ||
+		strings.Contains(status, "smt host state unknown")
+}
+
+// SetNumaBalancing enables or disables kernel NUMA balancing system-wide.
+// Enabling keeps any already enabled balancing mode. If we are not allowed
+// to change NUMA balancing, for instance because /proc/sys is read-only in
+// our container, the returned error wraps fs.ErrPermission.
+func (sys *system) SetNumaBalancing(enabled bool) error {
+	mode := 0
+	if enabled {
+		mode = 1
+	}
+
+	current := 0
+	if _, err := readSysfsEntry(sys.procPath(), procNumaBalancing, &current); err != nil {
+		return err
+	}
+	if (current != 0) == enabled {
+		return nil
+	}
+
+	_, err := writeSysfsEntry(sys.procPath(), procNumaBalancing, mode, nil)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS) {
+			return fmt.Errorf("%w: not allowed to change NUMA balancing: %v", fs.ErrPermission, err)
+		}
+		return err
+	}
+
+	if enabled {
+		sys.Info("enabled NUMA balancing")
+	} else {
+		sys.Info("disabled NUMA balancing")
+	}
+
+	return nil
+}
+
+// procPath returns the procfs mount point, next to the sysfs one.
+func (sys *system) procPath() string {
+	return filepath.Join(filepath.Dir(sys.path), "proc")
 }
 
 // Discover Cpus present in the system.
@@ -1066,6 +1496,31 @@ func (c *cpu) SstClos() int {
 	return c.sstClos
 }
 
+// CurrentFrequency returns the current frequency (kHz) of this CPU, as
+// reported by cpufreq in scaling_cur_freq. On x86 the kernel derives it
+// from the APERF and MPERF counters, so it reflects throttling and boost.
+// Reading it is cheap enough for periodic sampling. An error wrapping
+// errors.ErrUnsupported is returned if cpufreq is not available.
+func (c *cpu) CurrentFrequency() (uint64, error) {
+	freq := uint64(0)
+	if _, err := readSysfsEntry(c.path, "cpufreq/scaling_cur_freq", &freq); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, sysfsError(c.path, "%w: no current frequency of CPU #%d",
+				errors.ErrUnsupported, c.id)
+		}
+		return 0, err
+	}
+	return freq, nil
+}
+
+// Mitigations returns the status of known CPU vulnerabilities, keyed by
+// vulnerability name, as reported in /sys/devices/system/cpu/vulnerabilities.
+// The kernel reports these system-wide, so they are the same for all CPUs.
+// An empty map is returned if the kernel does not report vulnerabilities.
+func (c *cpu) Mitigations() map[string]string {
+	return readMitigations(filepath.Join(filepath.Dir(c.path), "vulnerabilities"))
+}
+
 // SetFrequencyLimits sets the frequency scaling limits for this CPU.
 func (c *cpu) SetFrequencyLimits(min, max uint64) error {
 	if c.freq.min == 0 {
@@ -1097,6 +1552,46 @@ func (c *cpu) SetFrequencyLimits(min, max uint64) error {
 	return nil
 }
 
+// ScalingGovernor returns the current cpufreq scaling governor of this CPU.
+func (c *cpu) ScalingGovernor() (string, error) {
+	governor := ""
+	if _, err := readSysfsEntry(c.path, "cpufreq/scaling_governor", &governor); err != nil {
+		return "", err
+	}
+	return governor, nil
+}
+
+// AvailableScalingGovernors returns the cpufreq scaling governors available
+// for this CPU. Some drivers, like intel_pstate in active mode, only offer
+// the performance and powersave governors.
+func (c *cpu) AvailableScalingGovernors() ([]string, error) {
+	governors, err := readSysfsEntry(c.path, "cpufreq/scaling_available_governors", nil)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(governors), nil
+}
+
+// SetScalingGovernor sets the cpufreq scaling governor of this CPU. The
+// governor must be one of the available ones.
+func (c *cpu) SetScalingGovernor(governor string) error {
+	available, err := c.AvailableScalingGovernors()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(available, governor) {
+		return sysfsError(filepath.Join(c.path, "cpufreq/scaling_governor"),
+			"governor %q not available (available governors: %s)",
+			governor, strings.Join(available, ", "))
+	}
+
+	if _, err := writeSysfsEntry(c.path, "cpufreq/scaling_governor", governor, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // CacheCount returns the number of caches for this CPU.
 func (c *cpu) CacheCount() int {
 	return len(c.caches)
@@ -1197,6 +1692,16 @@ func (c *cpu) GetLastLevelCacheCPUSet() cpuset.CPUSet {
 	return cpus
 }
 
+// l3Cache returns the L3 data or unified cache of this CPU, if known.
+func (c *cpu) l3Cache() *Cache {
+	for _, cch := range c.GetCachesByLevel(3) {
+		if cch.kind != InstructionCache {
+			return cch
+		}
+	}
+	return nil
+}
+
 // CoreKind returns the core kind (P-/E-core) for this CPU.
 func (c *cpu) CoreKind() CoreKind {
 	return c.coreKind
@@ -1373,6 +1878,53 @@ func (sys *system) discoverNode(path string) error {
 	return nil
 }
 
+// Discover access initiator CPUs for CPU-less NUMA nodes. These are the
+// CPUs of the nodes listed as initiators in the access0 HMAT attributes.
+// If the firmware provides no HMAT, fall back to the CPUs of the package
+// of the closest nodes with CPUs.
+func (sys *system) discoverNodeInitiators() {
+	for _, mem := range sys.nodes {
+		if mem.cpus.Size() > 0 {
+			continue
+		}
+
+		mem.initiators = idset.NewIDSet()
+		entries, _ := filepath.Glob(filepath.Join(mem.path, "access0", "initiators", "node[0-9]*"))
+		for _, entry := range entries {
+			if n, ok := sys.nodes[getEnumeratedID(entry)]; ok {
+				mem.initiators.Add(n.cpus.Members()...)
+			}
+		}
+
+		if mem.initiators.Size() > 0 {
+			continue
+		}
+
+		minDist := -1
+		closest := []*node{}
+		for _, n := range sys.nodes {
+			if n.cpus.Size() == 0 {
+				continue
+			}
+			dist := mem.DistanceFrom(n.id)
+			switch {
+			case dist < 0:
+				continue
+			case minDist < 0 || dist < minDist:
+				minDist = dist
+				closest = []*node{n}
+			case dist == minDist:
+				closest = append(closest, n)
+			}
+		}
+		for _, n := range closest {
+			if pkg, ok := sys.packages[n.pkg]; ok {
+				mem.initiators.Add(pkg.cpus.Members()...)
+			}
+		}
+	}
+}
+
 // ID returns id of this node.
 func (n *node) ID() idset.ID {
 	return n.id
@@ -1408,16 +1960,26 @@ func (n *node) DistanceFrom(id idset.ID) int {
 }
 
 // MemoryInfo memory info for the node (partial content from the meminfo sysfs entry).
+// Entries missing on older kernels are reported as zero.
 func (n *node) MemoryInfo() (*MemInfo, error) {
 	meminfo := filepath.Join(n.path, "meminfo")
 	buf := &MemInfo{}
 	err := ParseFileEntries(meminfo,
 		map[string]interface{}{
-			"MemTotal:": &buf.MemTotal,
-			"MemFree:":  &buf.MemFree,
+			"MemTotal:":       &buf.MemTotal,
+			"MemFree:":        &buf.MemFree,
+			"FilePages:":      &buf.FilePages,
+			"AnonPages:":      &buf.AnonPages,
+			"Active(file):":   &buf.ActiveFile,
+			"Inactive(file):": &buf.InactiveFile,
+			"SReclaimable:":   &buf.SReclaimable,
 		},
 		func(line string) (string, string, error) {
 			fields := strings.Fields(strings.TrimSpace(line))
+			if len(fields) == 0 {
+				// Older kernels lack some entries, we might hit the end.
+				return "", "", nil
+			}
 			if len(fields) < 4 {
 				return "", "", sysfsError(meminfo, "failed to parse entry: '%s'", line)
 			}
@@ -1449,6 +2011,7 @@ func (n *node) MemoryInfo() (*MemInfo, error) {
 	}
 
 	buf.MemUsed = buf.MemTotal - buf.MemFree
+	buf.Reclaimable = buf.ActiveFile + buf.InactiveFile + buf.SReclaimable
 
 	return buf, nil
 }
@@ -1463,6 +2026,105 @@ func (n *node) HasNormalMemory() bool {
 	return n.normalMem
 }
 
+// InitiatorCPUs returns the CPUs which access the memory of this node
+// locally. For nodes with CPUs these are the CPUs of the node itself.
+func (n *node) InitiatorCPUs() cpuset.CPUSet {
+	if n.cpus.Size() > 0 {
+		return n.CPUSet()
+	}
+	return CPUSetFromIDSet(n.initiators)
+}
+
+// SetOnline brings all memory blocks of the node online or offline, for
+// instance to hotplug CXL memory before allocating from it. Blocks already
+// in the requested state are left alone. If the node has no hotpluggable
+// memory blocks, the returned error wraps errors.ErrUnsupported. If we are
+// not allowed to change the state of memory blocks, the returned error
+// wraps fs.ErrPermission.
+func (n *node) SetOnline(online bool) error {
+	state := "offline"
+	if online {
+		state = "online"
+	}
+
+	blocks, _ := filepath.Glob(filepath.Join(n.path, "memory[0-9]*"))
+	if len(blocks) == 0 {
+		return sysfsError(n.path, "%w: no memory blocks, memory hotplug not supported",
+			errors.ErrUnsupported)
+	}
+
+	for _, block := range blocks {
+		current := ""
+		if _, err := readSysfsEntry(block, "state", &current); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return sysfsError(block, "%w: memory hotplug not supported", errors.ErrUnsupported)
+			}
+			return err
+		}
+		if current == state {
+			continue
+		}
+		if _, err := writeSysfsEntry(block, "state", state, nil); err != nil {
+			if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS) {
+				return fmt.Errorf("%w: not allowed to set memory of node #%d %s: %v",
+					fs.ErrPermission, n.id, state, err)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MemoryBlocks returns the memory blocks of the node, sorted by their
+// ID, with their online state and size. Partially hotplugged memory is
+// accounted precisely by the blocks online. If memory block sysfs is
+// absent, no blocks are returned.
+func (n *node) MemoryBlocks() ([]MemoryBlock, error) {
+	entries, _ := filepath.Glob(filepath.Join(n.path, "memory[0-9]*"))
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	memDir := filepath.Join(filepath.Dir(filepath.Dir(n.path)), "memory")
+	hexSize, err := readSysfsEntry(memDir, "block_size_bytes", nil)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	size, err := strconv.ParseUint(hexSize, 16, 64)
+	if err != nil {
+		return nil, sysfsError(filepath.Join(memDir, "block_size_bytes"),
+			"failed to parse memory block size %q: %w", hexSize, err)
+	}
+
+	blocks := make([]MemoryBlock, 0, len(entries))
+	for _, entry := range entries {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(entry), "memory"))
+		if err != nil {
+			continue
+		}
+		state := ""
+		if _, err := readSysfsEntry(entry, "state", &state); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		blocks = append(blocks, MemoryBlock{
+			ID:     idset.ID(id),
+			Online: state == "online",
+			Size:   size,
+		})
+	}
+
+	slices.SortFunc(blocks, func(a, b MemoryBlock) int { return int(a.ID) - int(b.ID) })
+
+	return blocks, nil
+}
+
 // Discover physical packages (CPU sockets) present in the system.
 func (sys *system) discoverPackages() error {
 	if sys.packages != nil {
@@ -1483,6 +2145,7 @@ func (sys *system) discoverPackages() error {
 				dieNodes:        make(map[idset.ID]idset.IDSet),
 				clusterCPUs:     make(map[idset.ID]map[idset.ID]idset.IDSet),
 				logicalClusters: make(map[idset.ID]map[idset.ID]idset.IDSet),
+				ccxCPUs:         make(map[idset.ID]map[idset.ID]idset.IDSet),
 			}
 			sys.packages[cpu.pkg] = pkg
 		}
@@ -1513,6 +2176,22 @@ func (sys *system) discoverPackages() error {
 		} else {
 			clusterCPUs.Add(cpu.id)
 		}
+
+		// CPUs sharing an L3 cache form a core complex (CCX). Unlike
+		// cluster_id, this is uniform across vendors. For instance, on
+		// AMD EPYC cluster_id does not identify CCXs.
+		if l3 := cpu.l3Cache(); l3 != nil {
+			dieCCXCPUs, ok := pkg.ccxCPUs[cpu.die]
+			if !ok {
+				dieCCXCPUs = make(map[idset.ID]idset.IDSet)
+				pkg.ccxCPUs[cpu.die] = dieCCXCPUs
+			}
+			if ccxCPUs, ok := dieCCXCPUs[l3.id]; !ok {
+				dieCCXCPUs[l3.id] = idset.NewIDSet(cpu.id)
+			} else {
+				ccxCPUs.Add(cpu.id)
+			}
+		}
 	}
 
 	for _, pkg := range sys.packages {
@@ -1734,6 +2413,30 @@ func (p *cpuPackage) LogicalDieClusterCPUSet(die idset.ID, cluster idset.ID) cpu
 	return cpuset.New()
 }
 
+// CCXIDs returns the IDs of core complexes (CCXs), CPUs sharing an L3
+// cache, in the given die of this package. The ID of a CCX is the ID of
+// its L3 cache. CCXs are only discovered together with CPU caches.
+func (p *cpuPackage) CCXIDs(die idset.ID) []idset.ID {
+	if dieCCXs, ok := p.ccxCPUs[die]; ok {
+		ids := idset.NewIDSet()
+		for id := range dieCCXs {
+			ids.Add(id)
+		}
+		return ids.SortedMembers()
+	}
+	return []idset.ID{}
+}
+
+// CCXCPUSet returns the CPUs of the given die and core complex (CCX).
+func (p *cpuPackage) CCXCPUSet(die idset.ID, ccx idset.ID) cpuset.CPUSet {
+	if dieCCXs, ok := p.ccxCPUs[die]; ok {
+		if ids, ok := dieCCXs[ccx]; ok {
+			return CPUSetFromIDSet(ids)
+		}
+	}
+	return cpuset.New()
+}
+
 func (p *cpuPackage) SstInfo() *sst.SstPackageInfo {
 	return p.sstInfo
 }
@@ -15,10 +15,17 @@
 package sysfs_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/containers/nri-plugins/pkg/sysfs"
+	"github.com/containers/nri-plugins/pkg/utils/cpuset"
 	idset "github.com/intel/goresctrl/pkg/utils"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -211,3 +218,723 @@ var _ = DescribeTable("P-/E-Core CPU detection",
 	Entry("P-Cores", "sample1", PerformanceCore, "0-7"),
 	Entry("E-Cores", "sample1", EfficientCore, "8-15"),
 )
+
+var _ = Describe("PCI device NUMA locality", func() {
+	var devices string
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		devices = path.Join(cwd, "testdata/sample1/sys/bus/pci/devices")
+		for addr, node := range map[string]string{
+			"0000:00:02.0": "0",
+			"0000:3b:00.0": "0",
+			"0000:af:00.0": "-1",
+		} {
+			Expect(os.MkdirAll(path.Join(devices, addr), 0755)).To(Succeed())
+			Expect(os.WriteFile(path.Join(devices, addr, "numa_node"), []byte(node+"\n"), 0644)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(path.Dir(path.Dir(devices)))).To(Succeed())
+	})
+
+	It("reports the NUMA node of a device", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		node, err := sys.DeviceNUMANode("0000:3b:00.0")
+		Expect(err).To(BeNil())
+		Expect(node).To(Equal(ID(0)))
+	})
+
+	It("reports unknown NUMA node for a device without locality", func() {
+		sys := sampleSysfs["sample1"]
+		node, err := sys.DeviceNUMANode("0000:af:00.0")
+		Expect(err).To(BeNil())
+		Expect(node).To(Equal(idset.Unknown))
+	})
+
+	It("fails for a nonexistent device", func() {
+		sys := sampleSysfs["sample1"]
+		_, err := sys.DeviceNUMANode("0000:ff:00.0")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("lists devices near a NUMA node", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys.DevicesNearNode(0)).To(Equal([]string{"0000:00:02.0", "0000:3b:00.0"}))
+		Expect(sys.DevicesNearNode(1)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("NUMA node initiators", func() {
+	It("reports the CPUs of a node with CPUs", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		node := sys.Node(0)
+		Expect(node.InitiatorCPUs()).To(Equal(node.CPUSet()))
+	})
+
+	It("falls back to closest package CPUs for a CPU-less node without HMAT", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		node := sys.Node(4)
+		Expect(node.CPUSet().IsEmpty()).To(BeTrue())
+		Expect(node.InitiatorCPUs()).To(Equal(sys.Package(0).CPUSet()))
+	})
+})
+
+var _ = Describe("Node CPUs", func() {
+	It("returns all CPUs of the given nodes", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		expected := sys.Node(0).CPUSet().Union(sys.Node(1).CPUSet())
+		Expect(expected.IsEmpty()).To(BeFalse())
+		Expect(sys.NodeCPUSet(idset.NewIDSet(0, 1), false)).To(Equal(expected))
+	})
+
+	It("excludes offline CPUs if asked to", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		nodes := idset.NewIDSet(0, 1)
+		all := sys.Node(0).CPUSet().Union(sys.Node(1).CPUSet())
+		Expect(all.Size()).To(BeNumerically(">", 2))
+
+		offline := idset.NewIDSet(all.List()[1:3]...)
+		changed, err := sys.SetCpusOnline(false, offline)
+		Expect(err).To(BeNil())
+		Expect(changed).To(Equal(offline))
+		DeferCleanup(func() {
+			_, err := sys.SetCpusOnline(true, offline)
+			Expect(err).To(BeNil())
+		})
+
+		online := all.Difference(sysfs.CPUSetFromIDSet(offline))
+		Expect(sys.NodeCPUSet(nodes, true)).To(Equal(online))
+		Expect(sys.NodeCPUSet(nodes, false)).To(Equal(all))
+		Expect(sys.NodeHintToCPUs("0,1")).To(Equal(online.String()))
+	})
+
+	It("ignores CPU-less and unknown nodes", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		Expect(sys.NodeCPUSet(idset.NewIDSet(0, 4, 99), false)).To(Equal(sys.Node(0).CPUSet()))
+	})
+})
+
+var _ = Describe("CPU scaling governor", func() {
+	AfterEach(func() {
+		cwd, _ := os.Getwd()
+		entry := path.Join(cwd, "testdata/sample1/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor")
+		Expect(os.WriteFile(entry, []byte("powersave\n"), 0644)).To(Succeed())
+	})
+
+	It("reports the current and available governors", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		governor, err := sys.CPU(0).ScalingGovernor()
+		Expect(err).To(BeNil())
+		Expect(governor).To(Equal("powersave"))
+		available, err := sys.CPU(0).AvailableScalingGovernors()
+		Expect(err).To(BeNil())
+		Expect(available).To(Equal([]string{"performance", "powersave"}))
+	})
+
+	It("sets an available governor", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(sys.SetScalingGovernor("performance", idset.NewIDSet(0))).To(Succeed())
+		governor, err := sys.CPU(0).ScalingGovernor()
+		Expect(err).To(BeNil())
+		Expect(governor).To(Equal("performance"))
+	})
+
+	It("rejects an unavailable governor", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(sys.CPU(0).SetScalingGovernor("ondemand")).ToNot(Succeed())
+		governor, err := sys.CPU(0).ScalingGovernor()
+		Expect(err).To(BeNil())
+		Expect(governor).To(Equal("powersave"))
+	})
+})
+
+var _ = Describe("NUMA balancing", func() {
+	var entry string
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		dir := path.Join(cwd, "testdata/sample1/proc/sys/kernel")
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		entry = path.Join(dir, "numa_balancing")
+	})
+
+	AfterEach(func() {
+		cwd, _ := os.Getwd()
+		Expect(os.RemoveAll(path.Join(cwd, "testdata/sample1/proc"))).To(Succeed())
+	})
+
+	It("reports whether NUMA balancing is enabled", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		for mode, enabled := range map[string]bool{"0": false, "1": true, "2": true, "3": true} {
+			Expect(os.WriteFile(entry, []byte(mode+"\n"), 0644)).To(Succeed())
+			Expect(sys.NumaBalancingEnabled()).To(Equal(enabled))
+		}
+	})
+
+	It("enables and disables NUMA balancing", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(os.WriteFile(entry, []byte("1\n"), 0644)).To(Succeed())
+		Expect(sys.SetNumaBalancing(false)).To(Succeed())
+		Expect(sys.NumaBalancingEnabled()).To(BeFalse())
+		Expect(sys.SetNumaBalancing(true)).To(Succeed())
+		Expect(sys.NumaBalancingEnabled()).To(BeTrue())
+	})
+
+	It("keeps an enabled NUMA balancing mode", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(os.WriteFile(entry, []byte("2\n"), 0644)).To(Succeed())
+		Expect(sys.SetNumaBalancing(true)).To(Succeed())
+		data, err := os.ReadFile(entry)
+		Expect(err).To(BeNil())
+		Expect(strings.TrimSpace(string(data))).To(Equal("2"))
+	})
+
+	It("fails without NUMA balancing support", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		_, err := sys.NumaBalancingEnabled()
+		Expect(err).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("CPUs and memory nodes allowed for pods", func() {
+	var (
+		cgroup string
+		proc   string
+	)
+
+	setOwnCgroup := func(own string) {
+		Expect(os.WriteFile(path.Join(proc, "cgroup"), []byte("0::"+own+"\n"), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		cgroup = path.Join(cwd, "testdata/sample1/sys/fs/cgroup")
+		proc = path.Join(cwd, "testdata/sample1/proc/self")
+		Expect(os.MkdirAll(path.Join(cgroup, "kubepods.slice"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(path.Join(cgroup, "kubepods"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(proc, 0755)).To(Succeed())
+		Expect(os.WriteFile(path.Join(cgroup, "cpuset.cpus.effective"), []byte("0-3\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(path.Join(cgroup, "cpuset.mems.effective"), []byte("0\n"), 0644)).To(Succeed())
+		for dir, cpus := range map[string]string{"kubepods.slice": "1-2\n", "kubepods": "2-3\n"} {
+			Expect(os.WriteFile(path.Join(cgroup, dir, "cpuset.cpus.effective"), []byte(cpus), 0644)).To(Succeed())
+			Expect(os.WriteFile(path.Join(cgroup, dir, "cpuset.mems.effective"), []byte("0\n"), 0644)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(path.Dir(cgroup))).To(Succeed())
+		Expect(os.RemoveAll(path.Dir(proc))).To(Succeed())
+	})
+
+	It("reads the effective cpuset of the cgroup of pods with the systemd driver", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		setOwnCgroup("/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1.slice/cri-containerd-1.scope")
+		Expect(sys.EffectiveAllowedCPUs()).To(Equal(cpuset.New(1, 2)))
+		Expect(sys.EffectiveAllowedMems()).To(Equal(cpuset.New(0)))
+	})
+
+	It("reads the effective cpuset of the cgroup of pods with the cgroupfs driver", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		setOwnCgroup("/kubepods/besteffort/pod1/1")
+		Expect(sys.EffectiveAllowedCPUs()).To(Equal(cpuset.New(2, 3)))
+	})
+
+	It("does not read the root cgroup in a private cgroup namespace", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		setOwnCgroup("/")
+		Expect(sys.EffectiveAllowedCPUs()).To(Equal(sys.OnlineCPUs()))
+	})
+
+	It("falls back to all online CPUs without cgroups", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(os.RemoveAll(proc)).To(Succeed())
+		Expect(sys.EffectiveAllowedCPUs()).To(Equal(sys.OnlineCPUs()))
+	})
+})
+
+var _ = Describe("memory node online state", func() {
+	var blocks []string
+
+	state := func(block string) string {
+		data, err := os.ReadFile(path.Join(block, "state"))
+		Expect(err).To(BeNil())
+		return strings.TrimSpace(string(data))
+	}
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		entries, err := filepath.Glob(path.Join(cwd, "testdata/sample1/sys/devices/system/node/node0/memory[0-9]*"))
+		Expect(err).To(BeNil())
+		Expect(entries).ToNot(BeEmpty())
+		blocks = entries
+	})
+
+	AfterEach(func() {
+		cwd, _ := os.Getwd()
+		Expect(os.RemoveAll(path.Join(cwd, "testdata/sample1/sys/devices/system/memory"))).To(Succeed())
+	})
+
+	createBlocks := func(state string) {
+		cwd, _ := os.Getwd()
+		for _, block := range blocks {
+			dir := path.Join(cwd, "testdata/sample1/sys/devices/system/memory", path.Base(block))
+			Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+			Expect(os.WriteFile(path.Join(dir, "state"), []byte(state+"\n"), 0644)).To(Succeed())
+		}
+	}
+
+	It("takes memory of a node offline and back online", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		createBlocks("online")
+		Expect(sys.Node(0).SetOnline(false)).To(Succeed())
+		for _, block := range blocks {
+			Expect(state(block)).To(Equal("offline"))
+		}
+		Expect(sys.Node(0).SetOnline(true)).To(Succeed())
+		for _, block := range blocks {
+			Expect(state(block)).To(Equal("online"))
+		}
+	})
+
+	It("fails without memory hotplug support", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		err := sys.Node(0).SetOnline(true)
+		Expect(errors.Is(err, errors.ErrUnsupported)).To(BeTrue())
+	})
+
+	It("lists memory blocks of a node with their state and size", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		createBlocks("online")
+		cwd, _ := os.Getwd()
+		memDir := path.Join(cwd, "testdata/sample1/sys/devices/system/memory")
+		Expect(os.WriteFile(path.Join(memDir, "block_size_bytes"), []byte("8000000\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(path.Join(memDir, path.Base(blocks[0]), "state"), []byte("offline\n"), 0644)).To(Succeed())
+
+		memBlocks, err := sys.Node(0).MemoryBlocks()
+		Expect(err).To(BeNil())
+		Expect(memBlocks).To(HaveLen(len(blocks)))
+		offline := 0
+		for i, block := range memBlocks {
+			Expect(block.Size).To(Equal(uint64(128 << 20)))
+			if i > 0 {
+				Expect(block.ID).To(BeNumerically(">", memBlocks[i-1].ID))
+			}
+			if !block.Online {
+				Expect(path.Base(blocks[0])).To(Equal(fmt.Sprintf("memory%d", block.ID)))
+				offline++
+			}
+		}
+		Expect(offline).To(Equal(1))
+	})
+
+	It("lists no memory blocks without memory block sysfs", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		memBlocks, err := sys.Node(0).MemoryBlocks()
+		Expect(err).To(BeNil())
+		Expect(memBlocks).To(BeEmpty())
+	})
+})
+
+var _ = Describe("CPU frequency limits for a CPU set", func() {
+	var cpufreq string
+
+	entry := func(policy int, name string) string {
+		data, err := os.ReadFile(path.Join(cpufreq, "policy"+strconv.Itoa(policy), name))
+		Expect(err).To(BeNil())
+		return strings.TrimSpace(string(data))
+	}
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		cpufreq = path.Join(cwd, "testdata/sample1/sys/devices/system/cpu/cpufreq")
+	})
+
+	AfterEach(func() {
+		for _, policy := range []string{"policy0", "policy1", "policy2"} {
+			dir := path.Join(cpufreq, policy)
+			Expect(os.RemoveAll(path.Join(dir, "scaling_min_freq"))).To(Succeed())
+			Expect(os.WriteFile(path.Join(dir, "scaling_min_freq"), []byte("400000\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(path.Join(dir, "scaling_max_freq"), []byte("4800000\n"), 0644)).To(Succeed())
+		}
+	})
+
+	It("sets the limits of all given CPUs", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(sys.SetCPUFrequencyLimitsForCPUSet(1000000000, 2000000000, cpuset.New(0, 1))).To(Succeed())
+		for _, policy := range []int{0, 1} {
+			Expect(entry(policy, "scaling_min_freq")).To(Equal("1000000"))
+			Expect(entry(policy, "scaling_max_freq")).To(Equal("2000000"))
+		}
+		Expect(entry(2, "scaling_max_freq")).To(Equal("4800000"))
+	})
+
+	It("skips unknown CPUs", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(sys.SetCPUFrequencyLimitsForCPUSet(1000000000, 2000000000, cpuset.New(0, 999))).To(Succeed())
+		Expect(entry(0, "scaling_max_freq")).To(Equal("2000000"))
+	})
+
+	It("sets the rest of the CPUs and reports the ones which failed", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		// Make writing the limits of CPU #1 fail.
+		minFreq := path.Join(cpufreq, "policy1", "scaling_min_freq")
+		Expect(os.Remove(minFreq)).To(Succeed())
+		Expect(os.Mkdir(minFreq, 0755)).To(Succeed())
+		err := sys.SetCPUFrequencyLimitsForCPUSet(1000000000, 2000000000, cpuset.New(0, 1, 2))
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("CPUs 1:"))
+		Expect(entry(0, "scaling_max_freq")).To(Equal("2000000"))
+		Expect(entry(2, "scaling_max_freq")).To(Equal("2000000"))
+	})
+})
+
+var _ = Describe("CPU current frequency", func() {
+	var curFreq string
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		curFreq = path.Join(cwd, "testdata/sample1/sys/devices/system/cpu/cpufreq/policy1/scaling_cur_freq")
+	})
+
+	AfterEach(func() {
+		Expect(os.WriteFile(curFreq, []byte("3900000\n"), 0644)).To(Succeed())
+	})
+
+	It("reads the current frequency of a CPU", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		freq, err := sys.CPU(0).CurrentFrequency()
+		Expect(err).To(BeNil())
+		Expect(freq).To(Equal(uint64(3900000)))
+	})
+
+	It("averages the current frequency of CPUs", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(os.WriteFile(curFreq, []byte("3000000\n"), 0644)).To(Succeed())
+		freq, err := sys.AverageFrequency(cpuset.New(0, 1, 999))
+		Expect(err).To(BeNil())
+		Expect(freq).To(Equal(uint64(3450000)))
+	})
+
+	It("skips CPUs without current frequency", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		Expect(os.Remove(curFreq)).To(Succeed())
+		_, err := sys.CPU(1).CurrentFrequency()
+		Expect(errors.Is(err, errors.ErrUnsupported)).To(BeTrue())
+		freq, err := sys.AverageFrequency(cpuset.New(0, 1))
+		Expect(err).To(BeNil())
+		Expect(freq).To(Equal(uint64(3900000)))
+		_, err = sys.AverageFrequency(cpuset.New(1))
+		Expect(errors.Is(err, errors.ErrUnsupported)).To(BeTrue())
+	})
+})
+
+var _ = Describe("CPU vulnerabilities", func() {
+	var smtControl string
+
+	BeforeEach(func() {
+		cwd, _ := os.Getwd()
+		smtControl = path.Join(cwd, "testdata/sample2/sys/devices/system/cpu/smt/control")
+	})
+
+	AfterEach(func() {
+		Expect(os.WriteFile(smtControl, []byte("on\n"), 0644)).To(Succeed())
+	})
+
+	It("reads the mitigation status of CPU vulnerabilities", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		mitigations := sys.CPU(0).Mitigations()
+		Expect(mitigations).To(HaveLen(11))
+		Expect(mitigations).To(HaveKeyWithValue("mds", "Not affected"))
+		Expect(mitigations).To(HaveKeyWithValue("mmio_stale_data", "Mitigation: Clear CPU buffers; SMT vulnerable"))
+		Expect(sys.CPU(1).Mitigations()).To(Equal(mitigations))
+	})
+
+	It("tells whether SMT is considered safe", func() {
+		Expect(sampleSysfs["sample1"].SMTConsideredSafe()).To(BeTrue())
+		Expect(sampleSysfs["sample2"].SMTConsideredSafe()).To(BeFalse())
+		Expect(os.WriteFile(smtControl, []byte("off\n"), 0644)).To(Succeed())
+		Expect(sampleSysfs["sample2"].SMTConsideredSafe()).To(BeTrue())
+	})
+})
+
+var _ = Describe("Node memory info", func() {
+	It("reports the file, anonymous and reclaimable memory of a node", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		info, err := sys.Node(0).MemoryInfo()
+		Expect(err).To(BeNil())
+		Expect(info.MemTotal).To(Equal(uint64(40645580 * 1024)))
+		Expect(info.MemUsed).To(Equal(info.MemTotal - info.MemFree))
+		Expect(info.FilePages).To(Equal(uint64(15426788 * 1024)))
+		Expect(info.AnonPages).To(Equal(uint64(4941772 * 1024)))
+		Expect(info.Reclaimable).To(Equal(uint64((2709424 + 10782832 + 1304264) * 1024)))
+	})
+})
+
+var _ = Describe("CCX detection", func() {
+	var root string
+
+	// writeSysfs creates a single package, single die sysfs tree with 16
+	// CPUs in 4 CCXs of 4 CPUs. Like on AMD EPYC, each core has its own
+	// cluster_id, so only the shared L3 caches identify the CCXs.
+	writeSysfs := func(root string) {
+		entries := map[string]string{
+			"devices/system/cpu/possible":           "0-15",
+			"devices/system/cpu/present":            "0-15",
+			"devices/system/cpu/online":             "0-15",
+			"devices/system/cpu/isolated":           "",
+			"devices/system/node/online":            "0",
+			"devices/system/node/has_memory":        "0",
+			"devices/system/node/has_normal_memory": "0",
+			"devices/system/node/node0/cpulist":     "0-15",
+			"devices/system/node/node0/distance":    "10",
+		}
+		for id := 0; id < 16; id++ {
+			cpu := fmt.Sprintf("devices/system/cpu/cpu%d/", id)
+			ccx := id / 4
+			for entry, value := range map[string]string{
+				"topology/physical_package_id": "0",
+				"topology/die_id":              "0",
+				"topology/cluster_id":          strconv.Itoa(id),
+				"topology/core_id":             strconv.Itoa(id),
+				"topology/core_cpus_list":      strconv.Itoa(id),
+				"node0/cpulist":                "0-15",
+				"cache/index0/id":              strconv.Itoa(id),
+				"cache/index0/level":           "1",
+				"cache/index0/type":            "Data",
+				"cache/index0/size":            "32K",
+				"cache/index0/shared_cpu_list": strconv.Itoa(id),
+				"cache/index3/id":              strconv.Itoa(ccx),
+				"cache/index3/level":           "3",
+				"cache/index3/type":            "Unified",
+				"cache/index3/size":            "32768K",
+				"cache/index3/shared_cpu_list": fmt.Sprintf("%d-%d", 4*ccx, 4*ccx+3),
+			} {
+				entries[cpu+entry] = value
+			}
+		}
+		for entry, value := range entries {
+			file := path.Join(root, entry)
+			Expect(os.MkdirAll(path.Dir(file), 0755)).To(Succeed())
+			Expect(os.WriteFile(file, []byte(value+"\n"), 0644)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		writeSysfs(root)
+	})
+
+	It("detects CCXs of a die from shared L3 caches", func() {
+		sys, err := sysfs.DiscoverSystemAt(root, sysfs.DiscoverCPUTopology, sysfs.DiscoverCache)
+		Expect(err).To(BeNil())
+		Expect(sys).ToNot(BeNil())
+
+		pkg := sys.Package(0)
+		Expect(pkg.LogicalDieClusterIDs(0)).To(HaveLen(1))
+		Expect(pkg.CCXIDs(0)).To(Equal([]ID{0, 1, 2, 3}))
+		for ccx := 0; ccx < 4; ccx++ {
+			Expect(pkg.CCXCPUSet(0, ccx).String()).To(Equal(fmt.Sprintf("%d-%d", 4*ccx, 4*ccx+3)))
+		}
+		Expect(pkg.CCXIDs(1)).To(BeEmpty())
+		Expect(pkg.CCXCPUSet(0, 4).IsEmpty()).To(BeTrue())
+	})
+})
+
+var _ = Describe("Cache topology validation", func() {
+	It("finds no inconsistencies in sample sysfs", func() {
+		Expect(sampleSysfs["sample1"].ValidateCacheTopology()).To(BeEmpty())
+	})
+
+	It("detects caches shared inconsistently", func() {
+		// Create a sysfs tree with 8 CPUs in 2 packages where all CPUs
+		// but CPU 3 claim to share the same L3 cache.
+		root := GinkgoT().TempDir()
+		entries := map[string]string{
+			"devices/system/cpu/possible":           "0-7",
+			"devices/system/cpu/present":            "0-7",
+			"devices/system/cpu/online":             "0-7",
+			"devices/system/cpu/isolated":           "",
+			"devices/system/node/online":            "0-1",
+			"devices/system/node/has_memory":        "0-1",
+			"devices/system/node/has_normal_memory": "0-1",
+			"devices/system/node/node0/cpulist":     "0-3",
+			"devices/system/node/node0/distance":    "10 21",
+			"devices/system/node/node1/cpulist":     "4-7",
+			"devices/system/node/node1/distance":    "21 10",
+		}
+		for id := 0; id < 8; id++ {
+			cpu := fmt.Sprintf("devices/system/cpu/cpu%d/", id)
+			l3, shared := "0", "0-7"
+			if id == 3 {
+				l3, shared = "1", "3"
+			}
+			for entry, value := range map[string]string{
+				"topology/physical_package_id":      strconv.Itoa(id / 4),
+				"topology/die_id":                   "0",
+				"topology/cluster_id":               strconv.Itoa(id),
+				"topology/core_id":                  strconv.Itoa(id),
+				"topology/core_cpus_list":           strconv.Itoa(id),
+				fmt.Sprintf("node%d/cpulist", id/4): fmt.Sprintf("%d-%d", id/4*4, id/4*4+3),
+				"cache/index3/id":                   l3,
+				"cache/index3/level":                "3",
+				"cache/index3/type":                 "Unified",
+				"cache/index3/size":                 "32768K",
+				"cache/index3/shared_cpu_list":      shared,
+			} {
+				entries[cpu+entry] = value
+			}
+		}
+		for entry, value := range entries {
+			file := path.Join(root, entry)
+			Expect(os.MkdirAll(path.Dir(file), 0755)).To(Succeed())
+			Expect(os.WriteFile(file, []byte(value+"\n"), 0644)).To(Succeed())
+		}
+
+		sys, err := sysfs.DiscoverSystemAt(root, sysfs.DiscoverCPUTopology, sysfs.DiscoverCache)
+		Expect(err).To(BeNil())
+		Expect(sys).ToNot(BeNil())
+
+		errs := sys.ValidateCacheTopology()
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0]).To(MatchError(ContainSubstring("shared by CPU #3, which reports another L3")))
+		Expect(errs[1]).To(MatchError(ContainSubstring("in different packages")))
+	})
+})
+
+var _ = Describe("Topology export", func() {
+	It("loads an exported topology identical to the discovered one", func() {
+		sys := sampleSysfs["sample1"]
+
+		data, err := sys.ExportTopology()
+		Expect(err).To(BeNil())
+
+		loaded, err := sysfs.DiscoverSystemFromJSON(data)
+		Expect(err).To(BeNil())
+		Expect(loaded).ToNot(BeNil())
+
+		Expect(loaded.CPUIDs()).To(Equal(sys.CPUIDs()))
+		Expect(loaded.NodeIDs()).To(Equal(sys.NodeIDs()))
+		Expect(loaded.PackageIDs()).To(Equal(sys.PackageIDs()))
+		Expect(loaded.OnlineCPUs()).To(Equal(sys.OnlineCPUs()))
+		for _, id := range sys.PackageIDs() {
+			Expect(loaded.Package(id).CPUSet()).To(Equal(sys.Package(id).CPUSet()))
+			Expect(loaded.Package(id).DieIDs()).To(Equal(sys.Package(id).DieIDs()))
+		}
+		for _, id := range sys.NodeIDs() {
+			Expect(loaded.Node(id).CPUSet()).To(Equal(sys.Node(id).CPUSet()))
+			Expect(loaded.Node(id).Distance()).To(Equal(sys.Node(id).Distance()))
+		}
+		for _, id := range sys.CPUIDs() {
+			Expect(loaded.CPU(id).ThreadCPUSet()).To(Equal(sys.CPU(id).ThreadCPUSet()))
+			Expect(loaded.CPU(id).CacheCount()).To(Equal(sys.CPU(id).CacheCount()))
+			Expect(loaded.CPU(id).GetLastLevelCacheCPUSet()).To(Equal(sys.CPU(id).GetLastLevelCacheCPUSet()))
+		}
+		Expect(loaded.ValidateCacheTopology()).To(BeEmpty())
+
+		reexported, err := loaded.ExportTopology()
+		Expect(err).To(BeNil())
+		Expect(reexported).To(MatchJSON(data))
+	})
+
+	It("rejects corrupt and stale topologies", func() {
+		data, err := sampleSysfs["sample1"].ExportTopology()
+		Expect(err).To(BeNil())
+
+		exported := map[string]json.RawMessage{}
+		Expect(json.Unmarshal(data, &exported)).To(Succeed())
+
+		exported["checksum"] = json.RawMessage(`"0000"`)
+		corrupt, err := json.Marshal(exported)
+		Expect(err).To(BeNil())
+		_, err = sysfs.DiscoverSystemFromJSON(corrupt)
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+
+		Expect(json.Unmarshal(data, &exported)).To(Succeed())
+		exported["fingerprint"] = json.RawMessage(`"0000"`)
+		stale, err := json.Marshal(exported)
+		Expect(err).To(BeNil())
+		_, err = sysfs.DiscoverSystemFromJSON(stale)
+		Expect(err).To(MatchError(sysfs.ErrStaleTopology))
+
+		_, err = sysfs.DiscoverSystemFromJSON([]byte("not json"))
+		Expect(err).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("topology summary", func() {
+	It("summarizes a hybrid system", func() {
+		sys := sampleSysfs["sample1"]
+		Expect(sys).ToNot(BeNil())
+		summary := sys.TopologySummary()
+		for _, line := range []string{
+			"sockets: 1",
+			"cpus: 16 (0-15)",
+			"cores: 12",
+			"P-cores: 4",
+			"E-cores: 8",
+			"threads per core: 1-2",
+			"cache L1d: 8 x 32K, 4 x 48K",
+			"cache L3: 1 x 18432K",
+			"node #0: package 0, die 0, DRAM, cpus 0-15, memory 39692M",
+		} {
+			Expect(strings.Split(summary, "\n")).To(ContainElement(line))
+		}
+	})
+
+	It("summarizes nodes and memory types", func() {
+		sys := sampleSysfs["sample2"]
+		Expect(sys).ToNot(BeNil())
+		lines := strings.Split(strings.TrimSuffix(sys.TopologySummary(), "\n"), "\n")
+		for _, line := range []string{
+			"sockets: 2",
+			"nodes: 8",
+			"isolated cpus: 4-7,60-63",
+			"cores: 56",
+			"threads per core: 2",
+			"node #4: package 0, die 0, PMEM, cpus none, memory 126976M",
+			"memory DRAM: 128371M",
+			"memory PMEM: 507904M",
+		} {
+			Expect(lines).To(ContainElement(line))
+		}
+		keys := map[string]bool{}
+		for _, line := range lines {
+			key, _, ok := strings.Cut(line, ": ")
+			Expect(ok).To(BeTrue(), "summary line %q", line)
+			Expect(keys[key]).To(BeFalse(), "duplicate summary key %q", key)
+			keys[key] = true
+		}
+		Expect(keys).ToNot(HaveKey("P-cores"))
+	})
+})
@@ -0,0 +1,378 @@
+// Copyright The NRI Plugins Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	idset "github.com/intel/goresctrl/pkg/utils"
+)
+
+const (
+	// topologyVersion is the version of the exported topology format.
+	topologyVersion = 1
+)
+
+var (
+	// ErrStaleTopology is returned when an exported topology does not
+	// match the hardware of the running system anymore.
+	ErrStaleTopology = errors.New("stale topology")
+
+	// fingerprintEntries are the sysfs entries which identify the
+	// hardware topology. If any of these change, an exported topology
+	// is considered stale.
+	fingerprintEntries = []string{
+		sysfsCPUPath + "/possible",
+		sysfsCPUPath + "/present",
+		sysfsCPUPath + "/online",
+		sysfsCPUPath + "/isolated",
+		sysfsNumaNodePath + "/possible",
+		sysfsNumaNodePath + "/online",
+		sysfsNumaNodePath + "/has_normal_memory",
+	}
+)
+
+// exportedTopology is the serialized form of a discovered system.
+type exportedTopology struct {
+	Version     int             `json:"version"`
+	Fingerprint string          `json:"fingerprint"`
+	Checksum    string          `json:"checksum"`
+	System      json.RawMessage `json:"system"`
+}
+
+type exportedSystem struct {
+	Flags        DiscoveryFlag            `json:"flags"`
+	Path         string                   `json:"path"`
+	Packages     []*exportedPackage       `json:"packages,omitempty"`
+	Nodes        []*exportedNode          `json:"nodes,omitempty"`
+	CPUs         []*exportedCPU           `json:"cpus,omitempty"`
+	Caches       []*exportedCache         `json:"caches,omitempty"`
+	PossibleCPUs idset.IDSet              `json:"possibleCPUs"`
+	PresentCPUs  idset.IDSet              `json:"presentCPUs"`
+	OnlineCPUs   idset.IDSet              `json:"onlineCPUs"`
+	IsolatedCPUs idset.IDSet              `json:"isolatedCPUs"`
+	CoreKindCPUs map[CoreKind]idset.IDSet `json:"coreKindCPUs,omitempty"`
+	MinThreads   int                      `json:"minThreads"`
+	MaxThreads   int                      `json:"maxThreads"`
+}
+
+type exportedPackage struct {
+	ID              idset.ID                              `json:"id"`
+	CPUs            idset.IDSet                           `json:"cpus"`
+	Nodes           idset.IDSet                           `json:"nodes"`
+	Dies            idset.IDSet                           `json:"dies"`
+	DieCPUs         map[idset.ID]idset.IDSet              `json:"dieCPUs,omitempty"`
+	DieNodes        map[idset.ID]idset.IDSet              `json:"dieNodes,omitempty"`
+	ClusterCPUs     map[idset.ID]map[idset.ID]idset.IDSet `json:"clusterCPUs,omitempty"`
+	LogicalClusters map[idset.ID]map[idset.ID]idset.IDSet `json:"logicalClusters,omitempty"`
+	CCXCPUs         map[idset.ID]map[idset.ID]idset.IDSet `json:"ccxCPUs,omitempty"`
+}
+
+type exportedNode struct {
+	Path       string      `json:"path"`
+	ID         idset.ID    `json:"id"`
+	Package    idset.ID    `json:"package"`
+	Die        idset.ID    `json:"die"`
+	CPUs       idset.IDSet `json:"cpus"`
+	MemoryType MemoryType  `json:"memoryType"`
+	NormalMem  bool        `json:"normalMem"`
+	Distance   []int       `json:"distance,omitempty"`
+	Initiators idset.IDSet `json:"initiators"`
+}
+
+type exportedCPU struct {
+	Path     string      `json:"path"`
+	ID       idset.ID    `json:"id"`
+	Package  idset.ID    `json:"package"`
+	Die      idset.ID    `json:"die"`
+	Cluster  idset.ID    `json:"cluster"`
+	Node     idset.ID    `json:"node"`
+	Core     idset.ID    `json:"core"`
+	Threads  idset.IDSet `json:"threads"`
+	BaseFreq uint64      `json:"baseFreq"`
+	MinFreq  uint64      `json:"minFreq"`
+	MaxFreq  uint64      `json:"maxFreq"`
+	EPP      EPP         `json:"epp"`
+	Online   bool        `json:"online"`
+	Isolated bool        `json:"isolated"`
+	SstClos  int         `json:"sstClos"`
+	Caches   []int       `json:"caches,omitempty"` // indices into exportedSystem.Caches
+	CoreKind CoreKind    `json:"coreKind"`
+}
+
+type exportedCache struct {
+	ID    idset.ID    `json:"id"`
+	Level int         `json:"level"`
+	Kind  CacheType   `json:"kind"`
+	Size  uint64      `json:"size"`
+	CPUs  idset.IDSet `json:"cpus"`
+}
+
+// ExportTopology exports the discovered topology of the system as JSON.
+// The exported data can be loaded using DiscoverSystemFromJSON to skip
+// walking sysfs, for instance to speed up startup on large systems or
+// to run tests with a topology fixture. Speed Select details are not
+// exported, these are rediscovered when the topology is loaded.
+func (sys *system) ExportTopology() ([]byte, error) {
+	es := &exportedSystem{
+		Flags:        sys.flags,
+		Path:         sys.path,
+		PossibleCPUs: sys.possibleCPUs,
+		PresentCPUs:  sys.presentCPUs,
+		OnlineCPUs:   sys.onlineCPUs,
+		IsolatedCPUs: sys.isolatedCPUs,
+		CoreKindCPUs: sys.coreKindCPUs,
+		MinThreads:   sys.minThreads,
+		MaxThreads:   sys.maxThreads,
+	}
+
+	for _, id := range sys.PackageIDs() {
+		pkg := sys.packages[id]
+		es.Packages = append(es.Packages, &exportedPackage{
+			ID:              pkg.id,
+			CPUs:            pkg.cpus,
+			Nodes:           pkg.nodes,
+			Dies:            pkg.dies,
+			DieCPUs:         pkg.dieCPUs,
+			DieNodes:        pkg.dieNodes,
+			ClusterCPUs:     pkg.clusterCPUs,
+			LogicalClusters: pkg.logicalClusters,
+			CCXCPUs:         pkg.ccxCPUs,
+		})
+	}
+
+	for _, id := range sys.NodeIDs() {
+		n := sys.nodes[id]
+		es.Nodes = append(es.Nodes, &exportedNode{
+			Path:       n.path,
+			ID:         n.id,
+			Package:    n.pkg,
+			Die:        n.die,
+			CPUs:       n.cpus,
+			MemoryType: n.memoryType,
+			NormalMem:  n.normalMem,
+			Distance:   n.distance,
+			Initiators: n.initiators,
+		})
+	}
+
+	cacheIdx := map[*Cache]int{}
+	for _, id := range sys.CPUIDs() {
+		c := sys.cpus[id]
+		ec := &exportedCPU{
+			Path:     c.path,
+			ID:       c.id,
+			Package:  c.pkg,
+			Die:      c.die,
+			Cluster:  c.cluster,
+			Node:     c.node,
+			Core:     c.core,
+			Threads:  c.threads,
+			BaseFreq: c.baseFreq,
+			MinFreq:  c.freq.min,
+			MaxFreq:  c.freq.max,
+			EPP:      c.epp,
+			Online:   c.online,
+			Isolated: c.isolated,
+			SstClos:  c.sstClos,
+			CoreKind: c.coreKind,
+		}
+		for _, cch := range c.caches {
+			idx, ok := cacheIdx[cch]
+			if !ok {
+				idx = len(es.Caches)
+				cacheIdx[cch] = idx
+				es.Caches = append(es.Caches, &exportedCache{
+					ID:    cch.id,
+					Level: cch.level,
+					Kind:  cch.kind,
+					Size:  cch.size,
+					CPUs:  cch.cpus,
+				})
+			}
+			ec.Caches = append(ec.Caches, idx)
+		}
+		es.CPUs = append(es.CPUs, ec)
+	}
+
+	data, err := json.Marshal(es)
+	if err != nil {
+		return nil, sysfsError("topology", "failed to export: %v", err)
+	}
+
+	fingerprint, err := topologyFingerprint(sys.path)
+	if err != nil {
+		return nil, sysfsError("topology", "failed to export: %v", err)
+	}
+
+	return json.Marshal(&exportedTopology{
+		Version:     topologyVersion,
+		Fingerprint: fingerprint,
+		Checksum:    checksum(data),
+		System:      data,
+	})
+}
+
+// DiscoverSystemFromJSON loads a topology exported by ExportTopology,
+// skipping the sysfs walks of discovery. A topology which is corrupt,
+// or of an unknown version, is rejected with an error. A topology
+// which does not match the hardware of the running system anymore is
+// rejected with ErrStaleTopology. In both cases the caller is expected
+// to fall back to a fresh discovery.
+func DiscoverSystemFromJSON(data []byte) (System, error) {
+	et := &exportedTopology{}
+	if err := json.Unmarshal(data, et); err != nil {
+		return nil, sysfsError("topology", "failed to load: %v", err)
+	}
+
+	if et.Version != topologyVersion {
+		return nil, sysfsError("topology", "unsupported version %d (expected %d)",
+			et.Version, topologyVersion)
+	}
+	if checksum(et.System) != et.Checksum {
+		return nil, sysfsError("topology", "checksum mismatch, corrupt data")
+	}
+
+	es := &exportedSystem{}
+	if err := json.Unmarshal(et.System, es); err != nil {
+		return nil, sysfsError("topology", "failed to load: %v", err)
+	}
+
+	fingerprint, err := topologyFingerprint(es.Path)
+	if err != nil {
+		return nil, sysfsError("topology", "failed to load: %v", err)
+	}
+	if fingerprint != et.Fingerprint {
+		return nil, sysfsError("topology", "%w: hardware at %s has changed", ErrStaleTopology, es.Path)
+	}
+
+	sys := &system{
+		Logger:       log,
+		flags:        es.Flags,
+		path:         es.Path,
+		packages:     make(map[idset.ID]*cpuPackage),
+		nodes:        make(map[idset.ID]*node),
+		cpus:         make(map[idset.ID]*cpu),
+		possibleCPUs: es.PossibleCPUs,
+		presentCPUs:  es.PresentCPUs,
+		onlineCPUs:   es.OnlineCPUs,
+		isolatedCPUs: es.IsolatedCPUs,
+		coreKindCPUs: es.CoreKindCPUs,
+		minThreads:   es.MinThreads,
+		maxThreads:   es.MaxThreads,
+	}
+
+	for _, ep := range es.Packages {
+		sys.packages[ep.ID] = &cpuPackage{
+			id:              ep.ID,
+			cpus:            ep.CPUs,
+			nodes:           ep.Nodes,
+			dies:            ep.Dies,
+			dieCPUs:         ep.DieCPUs,
+			dieNodes:        ep.DieNodes,
+			clusterCPUs:     ep.ClusterCPUs,
+			logicalClusters: ep.LogicalClusters,
+			ccxCPUs:         ep.CCXCPUs,
+		}
+	}
+
+	for _, en := range es.Nodes {
+		sys.nodes[en.ID] = &node{
+			path:       en.Path,
+			id:         en.ID,
+			pkg:        en.Package,
+			die:        en.Die,
+			cpus:       en.CPUs,
+			memoryType: en.MemoryType,
+			normalMem:  en.NormalMem,
+			distance:   en.Distance,
+			initiators: en.Initiators,
+		}
+	}
+
+	caches := make([]*Cache, 0, len(es.Caches))
+	for _, ec := range es.Caches {
+		caches = append(caches, sys.saveCache(&Cache{
+			id:    ec.ID,
+			level: ec.Level,
+			kind:  ec.Kind,
+			size:  ec.Size,
+			cpus:  ec.CPUs,
+		}))
+	}
+
+	for _, ec := range es.CPUs {
+		c := &cpu{
+			path:     ec.Path,
+			id:       ec.ID,
+			pkg:      ec.Package,
+			die:      ec.Die,
+			cluster:  ec.Cluster,
+			node:     ec.Node,
+			core:     ec.Core,
+			threads:  ec.Threads,
+			baseFreq: ec.BaseFreq,
+			freq:     CPUFreq{min: ec.MinFreq, max: ec.MaxFreq},
+			epp:      ec.EPP,
+			online:   ec.Online,
+			isolated: ec.Isolated,
+			sstClos:  ec.SstClos,
+			coreKind: ec.CoreKind,
+		}
+		for _, idx := range ec.Caches {
+			if idx < 0 || idx >= len(caches) {
+				return nil, sysfsError("topology", "CPU #%d: invalid cache index %d", ec.ID, idx)
+			}
+			c.caches = append(c.caches, caches[idx])
+		}
+		sys.cpus[ec.ID] = c
+	}
+
+	if (sys.flags & DiscoverSst) != 0 {
+		if err := sys.discoverSst(); err != nil {
+			sys.Warn("%v", err)
+		}
+	}
+
+	return sys, nil
+}
+
+// topologyFingerprint returns a fingerprint of the hardware topology of
+// the system with sysfs mounted at path.
+func topologyFingerprint(path string) (string, error) {
+	var b strings.Builder
+	for _, entry := range fingerprintEntries {
+		data, err := os.ReadFile(filepath.Join(path, entry))
+		switch {
+		case os.IsNotExist(err):
+			data = []byte("-")
+		case err != nil:
+			return "", err
+		}
+		b.WriteString(entry + "=" + strings.TrimSpace(string(data)) + "\n")
+	}
+	return checksum([]byte(b.String())), nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}